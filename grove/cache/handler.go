@@ -15,14 +15,18 @@ package cache
 */
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/plugin"
 
 	"github.com/apache/incubator-trafficcontrol/grove/remap"
@@ -55,7 +59,6 @@ func (h *HandlerPointer) Set(newHandler *Handler) {
 
 type Handler struct {
 	remapper        remap.HTTPRequestRemapper
-	getter          thread.Getter
 	ruleThrottlers  map[string]thread.Throttler // doesn't need threadsafe keys, because it's never added to or deleted after creation. TODO fix for hot rule reloading
 	scheme          string
 	port            string
@@ -69,7 +72,11 @@ type Handler struct {
 	httpConns       *web.ConnMap
 	httpsConns      *web.ConnMap
 	interfaceName   string
-	requestID       uint64 // Atomic - DO NOT access or modify without atomic operations
+	// maxCacheableBytes is the maximum size of an object body to cache. Larger bodies are streamed to clients without being cached. If 0, bodies of any size are cached.
+	maxCacheableBytes uint64
+	// warmer runs cache warming jobs and prefetches manifest segments. It's shared by every Handler.
+	warmer    *Warmer
+	requestID uint64 // Atomic - DO NOT access or modify without atomic operations
 	// keyThrottlers     Throttlers
 	// nocacheThrottlers Throttlers
 }
@@ -97,6 +104,10 @@ type Handler struct {
 // Example: Origin limit is 10,000, key limit is 1, the uncacheable limit is 1,000.
 // Then, 2,000 requests come in for the same URL, simultaneously. They are all within the Origin limit, so they are all allowed to proceed to the key limiter. Then, the first request is allowed to make an actual request to the origin, while the other 1,999 wait at the key limiter.
 //
+// The maxCacheableBytes parameter is the maximum size of an object body to cache. Larger bodies are streamed to clients from the parent without being cached. If it's 0, bodies of any size are cached.
+//
+// The warmer runs the warm jobs started by the http_warm plugin, and the segment prefetches of rules with prefetch_segments. It should be created once, and given to every Handler, so jobs and indexed manifests outlive config reloads.
+//
 // The connectionClose parameter determines whether to send a `Connection: close` header. This is primarily designed for maintenance, to drain the cache of incoming requestors. This overrides rule-specific `connection-close: false` configuration, under the assumption that draining a cache is a temporary maintenance operation, and if connectionClose is true on the service and false on some rules, those rules' configuration is probably a permament setting whereas the operator probably wants to drain all connections if the global setting is true. If it's necessary to leave connection close false on some rules, set all other rules' connectionClose to true and leave the global connectionClose unset.
 func NewHandler(
 	remapper remap.HTTPRequestRemapper,
@@ -112,6 +123,8 @@ func NewHandler(
 	httpConns *web.ConnMap,
 	httpsConns *web.ConnMap,
 	interfaceName string,
+	maxCacheableBytes uint64,
+	warmer *Warmer,
 ) *Handler {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -119,21 +132,22 @@ func NewHandler(
 	}
 
 	return &Handler{
-		remapper:        remapper,
-		getter:          thread.NewGetter(),
-		ruleThrottlers:  makeRuleThrottlers(remapper, ruleLimit),
-		strictRFC:       strictRFC,
-		scheme:          scheme,
-		port:            port,
-		hostname:        hostname,
-		stats:           stats,
-		conns:           conns,
-		connectionClose: connectionClose,
-		plugins:         plugins,
-		pluginContext:   pluginContext,
-		httpConns:       httpConns,
-		httpsConns:      httpsConns,
-		interfaceName:   interfaceName,
+		remapper:          remapper,
+		ruleThrottlers:    makeRuleThrottlers(remapper, ruleLimit),
+		strictRFC:         strictRFC,
+		scheme:            scheme,
+		port:              port,
+		hostname:          hostname,
+		stats:             stats,
+		conns:             conns,
+		connectionClose:   connectionClose,
+		plugins:           plugins,
+		pluginContext:     pluginContext,
+		httpConns:         httpConns,
+		httpsConns:        httpsConns,
+		interfaceName:     interfaceName,
+		maxCacheableBytes: maxCacheableBytes,
+		warmer:            warmer,
 		// keyThrottlers:     NewThrottlers(keyLimit),
 		// nocacheThrottlers: NewThrottlers(nocacheLimit),
 	}
@@ -166,7 +180,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reqID := atomic.AddUint64(&h.requestID, 1)
 	pluginContext := copyPluginContext(h.pluginContext) // must give each request a copy, because they can modify in parallel
 	srvrData := cachedata.SrvrData{h.hostname, h.port, h.scheme}
-	onReqData := plugin.OnRequestData{W: w, R: r, Stats: h.stats, StatRules: h.remapper.StatRules(), HTTPConns: h.httpConns, HTTPSConns: h.httpsConns, InterfaceName: h.interfaceName, SrvrData: srvrData, RequestID: reqID}
+	onReqData := plugin.OnRequestData{W: w, R: r, Stats: h.stats, StatRules: h.remapper.StatRules(), HTTPConns: h.httpConns, HTTPSConns: h.httpsConns, InterfaceName: h.interfaceName, SrvrData: srvrData, RequestID: reqID, Warmer: h}
 	stop := h.plugins.OnRequest(h.remapper.PluginCfg(), pluginContext, onReqData)
 	if stop {
 		return
@@ -193,13 +207,15 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	clientIP, _ := web.GetClientIPPort(r)
 
 	toFQDN := ""
-	pluginCfg := map[string]interface{}{}
+	ruleName := ""
+	pluginCfg := h.remapper.PluginCfg() // requests without a rule get the global plugins, such as for logging
 	if remappingProducer != nil {
 		toFQDN = remappingProducer.FirstFQDN()
+		ruleName = remappingProducer.Name()
 		pluginCfg = remappingProducer.PluginCfg()
 	}
 
-	reqData := cachedata.ReqData{r, conn, clientIP, reqTime, toFQDN}
+	reqData := cachedata.ReqData{r, conn, clientIP, reqTime, toFQDN, ruleName}
 	responder := NewResponder(w, pluginCfg, pluginContext, srvrData, reqData, h.plugins, h.stats, reqID)
 
 	if err != nil {
@@ -211,6 +227,14 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			log.Debugf("IP %v not allowed (reqid %v)\n", r.RemoteAddr, reqID)
 			*responder.ResponseCode = http.StatusForbidden
 		default:
+			if rateLimitErr, ok := err.(*remap.RateLimitedError); ok {
+				log.Debugf("client %v rate limited (reqid %v)\n", r.RemoteAddr, reqID)
+				w.Header().Set("Retry-After", strconv.Itoa(rateLimitErr.RetryAfterSeconds()))
+				*responder.ResponseCode = http.StatusTooManyRequests
+				responder.RateLimited = true // the origin wasn't requested, so this isn't a connect failure
+				responder.Do()
+				return
+			}
 			log.Debugf("request error: %v (reqid %v)\n", err, reqID)
 		}
 		responder.OriginConnectFailed = true
@@ -218,6 +242,22 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	onRemapData := plugin.OnRemapData{R: r, RemapRule: ruleName, Code: responder.ResponseCode, RequestID: reqID, SrvrData: srvrData}
+	if stop := h.plugins.OnRemap(remappingProducer.PluginCfg(), pluginContext, onRemapData); stop {
+		responder.Do()
+		return
+	}
+	remappingProducer.Rewritten(r, h.scheme)
+
+	if r.Method == web.MethodPurge {
+		h.purge(r, remappingProducer, responder, reqID)
+		return
+	}
+
+	if n := remappingProducer.PrefetchSegments(); n > 0 && r.Method == http.MethodGet {
+		defer h.followAhead(remap.RequestURI(r, h.scheme), n, reqID) // after the response, so manifests are cached
+	}
+
 	reqCacheControl := web.ParseCacheControl(reqHeader)
 	log.Debugf("Serve got Cache-Control %+v (reqid %v)\n", reqCacheControl, reqID)
 
@@ -228,12 +268,19 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	cache := remappingProducer.Cache()
 
 	var reqHost *string
+	bodyReader := (*web.StreamReader)(nil)
 	cacheObj, ok := cache.Get(cacheKey)
+	if ok && cacheObj.IsVaryMarker() {
+		retrier.VaryHeaders = cacheObj.VaryHeaders
+		cacheKey = remapdata.VariantCacheKey(cacheKey, reqHeader, cacheObj.VaryHeaders)
+		log.Debugf("cache.Handler.ServeHTTP: response varies on %v, getting variant '%v' (reqid %v)\n", cacheObj.VaryHeaders, cacheKey, reqID)
+		cacheObj, ok = cache.Get(cacheKey)
+	}
 	if !ok {
 		log.Debugf("cache.Handler.ServeHTTP: '%v' not in cache (reqid %v)\n", cacheKey, reqID)
 		beforeParentRequestData := plugin.BeforeParentRequestData{Req: r, RemapRule: remappingProducer.Name()}
 		h.plugins.OnBeforeParentRequest(remappingProducer.PluginCfg(), pluginContext, beforeParentRequestData)
-		cacheObj, reqHost, err = retrier.Get(r, nil)
+		cacheObj, bodyReader, reqHost, err = retrier.Get(r, nil)
 		if err != nil {
 			log.Errorf("retrying get error (in uncached): %v (reqid %v)\n", err, reqID)
 			responder.OriginConnectFailed = true
@@ -245,35 +292,55 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		streamPtr := io.Reader(nil)
+		if bodyReader != nil {
+			defer bodyReader.Close()
+			streamPtr = bodyReader
+		}
+
 		responder.OriginCode = cacheObj.OriginCode
 		// create new pointers, so plugins don't modify the cacheObj
 		codePtr, hdrsPtr, bodyPtr := cacheObj.Code, cacheObj.RespHeaders, cacheObj.Body
-		responder.SetResponse(&codePtr, &hdrsPtr, &bodyPtr, connectionClose)
+		responder.SetResponse(&codePtr, &hdrsPtr, &bodyPtr, &streamPtr, connectionClose)
 		responder.OriginReqSuccess = true
 		responder.ProxyStr = cacheObj.ProxyURL
 		if reqHost != nil {
 			responder.ToFQDN = *reqHost
 		}
-		beforeRespData := plugin.BeforeRespondData{Req: r, CacheObj: cacheObj, Code: &codePtr, Hdr: &hdrsPtr, Body: &bodyPtr, RemapRule: remappingProducer.Name()}
+		beforeRespData := plugin.BeforeRespondData{Req: r, CacheObj: cacheObj, Code: &codePtr, Hdr: &hdrsPtr, Body: &bodyPtr, Stream: &streamPtr, RemapRule: remappingProducer.Name(), Cache: cache, CacheKey: cacheKey}
 		h.plugins.OnBeforeRespond(remappingProducer.PluginCfg(), pluginContext, beforeRespData)
 		responder.Do()
 		return
 	}
 
 	reqHeaders := r.Header
-	canReuseStored := remap.CanReuseStored(reqHeaders, cacheObj.RespHeaders, reqCacheControl, cacheObj.RespCacheControl, cacheObj.ReqHeaders, cacheObj.ReqRespTime, cacheObj.RespRespTime, h.strictRFC)
+	canReuseStored := remap.CanReuseStored(reqHeaders, cacheObj.Code, cacheObj.RespHeaders, reqCacheControl, cacheObj.RespCacheControl, cacheObj.ReqHeaders, cacheObj.ReqRespTime, cacheObj.RespRespTime, h.strictRFC, remappingProducer.CacheOverrides())
+
+	invalidated := canReuseStored != remapdata.ReuseCannot && remappingProducer.Invalidated(r, cacheObj.ReqRespTime)
+	if invalidated {
+		log.Debugf("cache.Handler.ServeHTTP: '%v' invalidated by a revalidate rule (reqid %v)\n", cacheKey, reqID)
+		canReuseStored = remapdata.ReuseMustRevalidate
+	}
 
 	if canReuseStored != remapdata.ReuseCan { // run the BeforeParentRequest hook for revalidations / ReuseCannot
 		beforeParentRequestData := plugin.BeforeParentRequestData{Req: r, RemapRule: remappingProducer.Name()}
 		h.plugins.OnBeforeParentRequest(remappingProducer.PluginCfg(), pluginContext, beforeParentRequestData)
 	}
 
+	respCacheControl, _ := remap.OverrideCacheControl(cacheObj.Code, cacheObj.RespHeaders, cacheObj.RespCacheControl, remappingProducer.CacheOverrides())
+	if !invalidated && (canReuseStored == remapdata.ReuseMustRevalidate || canReuseStored == remapdata.ReuseMustRevalidateCanStale) && remap.CanStaleWhileRevalidate(cacheObj.RespHeaders, reqCacheControl, respCacheControl, cacheObj.ReqRespTime, cacheObj.RespRespTime, remappingProducer.StaleWhileRevalidate(), h.strictRFC) {
+		log.Debugf("cache.Handler.ServeHTTP: '%v' stale while revalidate, serving stale and revalidating asynchronously (reqid %v)\n", cacheKey, reqID)
+		revalidateAsync(retrier, r, cacheObj, cacheKey, reqID)
+		canReuseStored = remapdata.ReuseCan
+	}
+
+	oldCacheObj := cacheObj
 	switch canReuseStored {
 	case remapdata.ReuseCan:
 		log.Debugf("cache.Handler.ServeHTTP: '%v' cache hit! (reqid %v)\n", cacheKey, reqID)
 	case remapdata.ReuseCannot:
 		log.Debugf("cache.Handler.ServeHTTP: '%v' can't reuse (reqid %v)\n", cacheKey, reqID)
-		cacheObj, reqHost, err = retrier.Get(r, nil)
+		cacheObj, bodyReader, reqHost, err = retrier.Get(r, nil)
 		if err != nil {
 			log.Errorf("retrying get error (in reuse-cannot): %v (reqid %v)\n", err, reqID)
 			responder.Do()
@@ -281,26 +348,44 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	case remapdata.ReuseMustRevalidate:
 		log.Debugf("cache.Handler.ServeHTTP: '%v' must revalidate (reqid %v)\n", cacheKey, reqID)
-		cacheObj, reqHost, err = retrier.Get(r, cacheObj)
+		cacheObj, bodyReader, reqHost, err = retrier.Get(r, cacheObj)
 		if err != nil {
-			log.Errorf("retrying get error: %v (reqid %v)\n", err, reqID)
-			responder.Do()
-			return
+			if !canStaleIfError(oldCacheObj, reqCacheControl, remappingProducer.StaleIfError(), remappingProducer.CacheOverrides()) {
+				log.Errorf("retrying get error: %v (reqid %v)\n", err, reqID)
+				responder.Do()
+				return
+			}
+			log.Errorf("retrying get error - serving stale as allowed by stale-if-error: %v (reqid %v)\n", err, reqID)
+			cacheObj = oldCacheObj
 		}
 	case remapdata.ReuseMustRevalidateCanStale:
 		log.Debugf("cache.Handler.ServeHTTP: '%v' must revalidate (but allowed stale) (reqid %v)\n", cacheKey, reqID)
-		oldCacheObj := cacheObj
-		cacheObj, reqHost, err = retrier.Get(r, cacheObj)
+		cacheObj, bodyReader, reqHost, err = retrier.Get(r, cacheObj)
 		if err != nil {
 			log.Errorf("retrying get error - serving stale as allowed: %v (reqid %v)\n", err, reqID)
 			cacheObj = oldCacheObj
 		}
 	}
+	if cacheObj != oldCacheObj && remap.IsStaleIfErrorCode(cacheObj.Code) && (canReuseStored == remapdata.ReuseMustRevalidate || canReuseStored == remapdata.ReuseMustRevalidateCanStale) && canStaleIfError(oldCacheObj, reqCacheControl, remappingProducer.StaleIfError(), remappingProducer.CacheOverrides()) {
+		log.Errorf("cache.Handler.ServeHTTP: '%v' revalidating got %v - serving stale as allowed by stale-if-error (reqid %v)\n", cacheKey, cacheObj.Code, reqID)
+		if bodyReader != nil {
+			bodyReader.Close()
+			bodyReader = nil
+		}
+		cacheObj = oldCacheObj
+		addToCache(cache, cacheKey, reqHeader, cacheObj, reqID) // the error may have replaced the stale object in the cache
+	}
 	log.Debugf("cache.Handler.ServeHTTP: '%v' responding with %v (reqid %v)\n", cacheKey, cacheObj.Code, reqID)
 
+	streamPtr := io.Reader(nil)
+	if bodyReader != nil {
+		defer bodyReader.Close()
+		streamPtr = bodyReader
+	}
+
 	// create new pointers, so plugins don't modify the cacheObj
 	codePtr, hdrsPtr, bodyPtr := cacheObj.Code, cacheObj.RespHeaders, cacheObj.Body
-	responder.SetResponse(&codePtr, &hdrsPtr, &bodyPtr, connectionClose)
+	responder.SetResponse(&codePtr, &hdrsPtr, &bodyPtr, &streamPtr, connectionClose)
 	responder.OriginReqSuccess = true
 	responder.Reuse = canReuseStored
 	responder.OriginCode = cacheObj.OriginCode
@@ -309,7 +394,68 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if reqHost != nil {
 		responder.ToFQDN = *reqHost
 	}
-	beforeRespData := plugin.BeforeRespondData{Req: r, CacheObj: cacheObj, Code: &codePtr, Hdr: &hdrsPtr, Body: &bodyPtr, RemapRule: remappingProducer.Name()}
+	beforeRespData := plugin.BeforeRespondData{Req: r, CacheObj: cacheObj, Code: &codePtr, Hdr: &hdrsPtr, Body: &bodyPtr, Stream: &streamPtr, RemapRule: remappingProducer.Name(), Cache: cache, CacheKey: cacheKey}
 	h.plugins.OnBeforeRespond(remappingProducer.PluginCfg(), pluginContext, beforeRespData)
 	responder.Do()
 }
+
+// canStaleIfError returns whether the given stale object may be served when revalidating it fails, per its stale-if-error, the request's, or the rule's default. Its staleness includes the rule's cache overrides.
+func canStaleIfError(cacheObj *cacheobj.CacheObj, reqCacheControl web.CacheControl, ruleDefault time.Duration, overrides *remapdata.CacheOverrides) bool {
+	respCacheControl, _ := remap.OverrideCacheControl(cacheObj.Code, cacheObj.RespHeaders, cacheObj.RespCacheControl, overrides)
+	return remap.CanStaleIfError(cacheObj.RespHeaders, reqCacheControl, respCacheControl, cacheObj.ReqRespTime, cacheObj.RespRespTime, ruleDefault)
+}
+
+// revalidateAsync revalidates the given stale object in the background, for stale-while-revalidate, while the stale object is served to the client. The revalidated object is cached by the retrier, and its body is never read.
+// The request is copied, because the client request isn't valid after the handler returns.
+func revalidateAsync(retrier *Retrier, r *http.Request, cacheObj *cacheobj.CacheObj, cacheKey string, reqID uint64) {
+	r = r.WithContext(context.Background())
+	r.Header = web.CopyHeader(r.Header)
+	go func() {
+		_, bodyReader, _, err := retrier.Get(r, cacheObj)
+		if bodyReader != nil {
+			bodyReader.Close() // a retained stream continues to be filled and cached without readers
+		}
+		if err != nil {
+			log.Errorf("cache.Handler revalidating '%v' asynchronously: %v (reqid %v)\n", cacheKey, err, reqID)
+		}
+	}()
+}
+
+// purge handles a PURGE request, removing the object for the requested URL from the rule's cache, including all its variants if it varies. The client must be allowed by the stats ACL, in addition to the rule's ACL. Responds 200 if the object was cached, or 404 if it wasn't.
+func (h *Handler) purge(r *http.Request, remappingProducer *remap.RemappingProducer, responder *Responder, reqID uint64) {
+	ip, err := web.GetIP(r)
+	if err != nil {
+		log.Errorf("purge getting client IP: %v (reqid %v)\n", err, reqID)
+		*responder.ResponseCode = http.StatusInternalServerError
+		responder.Do()
+		return
+	}
+	if !h.remapper.StatRules().Allowed(ip) {
+		log.Debugf("purge IP %v not allowed (reqid %v)\n", ip, reqID)
+		*responder.ResponseCode = http.StatusForbidden
+		responder.Do()
+		return
+	}
+
+	cacheKey := remappingProducer.MethodCacheKey(http.MethodGet) // HEAD shares the GET key, and other methods aren't cached
+	cache := remappingProducer.Cache()
+	code := http.StatusNotFound
+	if marker, ok := cache.Peek(cacheKey); ok && marker.IsVaryMarker() {
+		variantPrefix := cacheKey + remapdata.VariantKeySeparator
+		for _, key := range cache.Keys() {
+			if strings.HasPrefix(key, variantPrefix) && cache.Remove(key) {
+				log.Infof("purge '%v' variant '%v' (reqid %v)\n", cacheKey, key, reqID)
+			}
+		}
+	}
+	if cache.Remove(cacheKey) {
+		code = http.StatusOK
+	}
+	log.Infof("purge '%v' from rule %v: %v (reqid %v)\n", cacheKey, remappingProducer.Name(), code, reqID)
+
+	hdr := http.Header{"Content-Type": {"text/plain"}}
+	body := []byte(http.StatusText(code))
+	stream := io.Reader(nil)
+	responder.SetResponse(&code, &hdr, &body, &stream, false)
+	responder.Do()
+}
@@ -0,0 +1,291 @@
+package cache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// isManifest returns whether the given URL path is an HLS or DASH manifest, by its extension.
+func isManifest(urlPath string) bool {
+	ext := strings.ToLower(path.Ext(urlPath))
+	return ext == ".m3u8" || ext == ".mpd"
+}
+
+// parseManifest returns the playlists and segments of the given HLS or DASH manifest, resolved against the manifest URL. HLS master playlists have playlists, and media playlists have a single list of segments. DASH manifests have a list of segments for each representation, from its SegmentList, or its SegmentTemplate with a SegmentTimeline. Each list of segments is in playback order, starting with any initialization segment.
+func parseManifest(manifestURL *url.URL, body []byte) ([]string, [][]string, error) {
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("#EXTM3U")) {
+		playlists, segments := parseHLS(manifestURL, body)
+		return playlists, segments, nil
+	}
+	if bytes.HasPrefix(body, []byte("<")) {
+		segments, err := parseDASH(manifestURL, body)
+		return nil, segments, err
+	}
+	return nil, nil, errors.New("unknown manifest format")
+}
+
+// parseHLS returns the variant and rendition playlists of an HLS master playlist, or the segments of a media playlist.
+func parseHLS(manifestURL *url.URL, body []byte) ([]string, [][]string) {
+	playlists := []string{}
+	segments := []string{}
+	nextIsPlaylist := false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			nextIsPlaylist = true
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:") || strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+			if uri, ok := hlsAttr(line, "URI"); ok {
+				playlists = appendResolved(playlists, manifestURL, uri)
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if uri, ok := hlsAttr(line, "URI"); ok {
+				segments = appendResolved(segments, manifestURL, uri)
+			}
+		case strings.HasPrefix(line, "#"):
+		case nextIsPlaylist:
+			playlists = appendResolved(playlists, manifestURL, line)
+			nextIsPlaylist = false
+		default:
+			segments = appendResolved(segments, manifestURL, line)
+		}
+	}
+	if len(segments) == 0 {
+		return playlists, nil
+	}
+	return playlists, [][]string{segments}
+}
+
+// hlsAttr returns the value of the given attribute of an HLS tag line, such as the URI of `#EXT-X-MAP:URI="init.mp4"`.
+func hlsAttr(line string, name string) (string, bool) {
+	i := strings.Index(line, ":")
+	if i == -1 {
+		return "", false
+	}
+	attrs := line[i+1:]
+	for attrs != "" {
+		eq := strings.Index(attrs, "=")
+		if eq == -1 {
+			return "", false
+		}
+		key := strings.TrimSpace(attrs[:eq])
+		attrs = attrs[eq+1:]
+		val := ""
+		if strings.HasPrefix(attrs, `"`) {
+			end := strings.Index(attrs[1:], `"`)
+			if end == -1 {
+				return "", false
+			}
+			val, attrs = attrs[1:end+1], attrs[end+2:]
+		} else if comma := strings.Index(attrs, ","); comma != -1 {
+			val, attrs = attrs[:comma], attrs[comma:]
+		} else {
+			val, attrs = attrs, ""
+		}
+		if key == name {
+			return val, true
+		}
+		attrs = strings.TrimPrefix(attrs, ",")
+	}
+	return "", false
+}
+
+// appendResolved appends the given URI, resolved against the base URL, to urls. Invalid URIs are skipped.
+func appendResolved(urls []string, base *url.URL, uri string) []string {
+	resolved, err := base.Parse(uri)
+	if err != nil {
+		return urls
+	}
+	return append(urls, resolved.String())
+}
+
+type mpd struct {
+	BaseURL string      `xml:"BaseURL"`
+	Periods []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	BaseURL        string             `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       string              `xml:"bandwidth,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string        `xml:"media,attr"`
+	Initialization string        `xml:"initialization,attr"`
+	StartNumber    *int          `xml:"startNumber,attr"`
+	Timeline       []mpdTimeline `xml:"SegmentTimeline>S"`
+}
+
+type mpdTimeline struct {
+	T *uint64 `xml:"t,attr"`
+	D uint64  `xml:"d,attr"`
+	R int     `xml:"r,attr"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// parseDASH returns the segments of each representation of a DASH manifest. Representations whose SegmentTemplate has no SegmentTimeline are skipped, because their segments can't be listed without the period durations.
+func parseDASH(manifestURL *url.URL, body []byte) ([][]string, error) {
+	manifest := mpd{}
+	if err := xml.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing DASH manifest: %v", err)
+	}
+	segments := [][]string{}
+	mpdBase := resolveBase(manifestURL, manifest.BaseURL)
+	for _, period := range manifest.Periods {
+		periodBase := resolveBase(mpdBase, period.BaseURL)
+		for _, set := range period.AdaptationSets {
+			setBase := resolveBase(periodBase, set.BaseURL)
+			for _, rep := range set.Representations {
+				repBase := resolveBase(setBase, rep.BaseURL)
+				list := rep.SegmentList
+				if list == nil {
+					list = set.SegmentList
+				}
+				tmpl := rep.SegmentTemplate
+				if tmpl == nil {
+					tmpl = set.SegmentTemplate
+				}
+				repSegments := []string{}
+				if list != nil {
+					if list.Initialization != nil && list.Initialization.SourceURL != "" {
+						repSegments = appendResolved(repSegments, repBase, list.Initialization.SourceURL)
+					}
+					for _, segmentURL := range list.SegmentURLs {
+						repSegments = appendResolved(repSegments, repBase, segmentURL.Media)
+					}
+				} else if tmpl != nil && len(tmpl.Timeline) > 0 {
+					repSegments = templateSegments(repSegments, repBase, tmpl, rep)
+				}
+				if len(repSegments) > 0 {
+					segments = append(segments, repSegments)
+				}
+			}
+		}
+	}
+	return segments, nil
+}
+
+// templateSegments appends the initialization and media segments of the given representation's SegmentTemplate, numbered and timed by its SegmentTimeline.
+func templateSegments(segments []string, base *url.URL, tmpl *mpdSegmentTemplate, rep mpdRepresentation) []string {
+	if tmpl.Initialization != "" {
+		segments = appendResolved(segments, base, expandTemplate(tmpl.Initialization, rep, 0, 0))
+	}
+	if tmpl.Media == "" {
+		return segments
+	}
+	number := 1
+	if tmpl.StartNumber != nil {
+		number = *tmpl.StartNumber
+	}
+	t := uint64(0)
+	for _, s := range tmpl.Timeline {
+		if s.T != nil {
+			t = *s.T
+		}
+		for i := 0; i <= s.R; i++ {
+			segments = appendResolved(segments, base, expandTemplate(tmpl.Media, rep, number, t))
+			number++
+			t += s.D
+		}
+	}
+	return segments
+}
+
+// expandTemplate returns the given SegmentTemplate URL, with its $RepresentationID$, $Bandwidth$, $Number$, and $Time$ identifiers replaced. Number and Time may have a printf width format, such as `$Number%05d$`.
+func expandTemplate(tmpl string, rep mpdRepresentation, number int, t uint64) string {
+	buf := bytes.Buffer{}
+	for {
+		start := strings.Index(tmpl, "$")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(tmpl[start+1:], "$")
+		if end == -1 {
+			break
+		}
+		end += start + 1
+		buf.WriteString(tmpl[:start])
+		ident := tmpl[start+1 : end]
+		tmpl = tmpl[end+1:]
+
+		format := "%d"
+		if i := strings.Index(ident, "%"); i != -1 {
+			ident, format = ident[:i], ident[i:]
+		}
+		switch ident {
+		case "":
+			buf.WriteString("$")
+		case "RepresentationID":
+			buf.WriteString(rep.ID)
+		case "Bandwidth":
+			buf.WriteString(rep.Bandwidth)
+		case "Number":
+			buf.WriteString(fmt.Sprintf(format, number))
+		case "Time":
+			buf.WriteString(fmt.Sprintf(format, t))
+		default:
+			buf.WriteString("$" + ident + "$")
+		}
+	}
+	buf.WriteString(tmpl)
+	return buf.String()
+}
+
+// resolveBase returns the given DASH BaseURL resolved against the base URL, or the base URL if the BaseURL is empty or invalid.
+func resolveBase(base *url.URL, baseURL string) *url.URL {
+	baseURL = strings.TrimSpace(baseURL)
+	if baseURL == "" {
+		return base
+	}
+	resolved, err := base.Parse(baseURL)
+	if err != nil {
+		return base
+	}
+	return resolved
+}
@@ -0,0 +1,142 @@
+package cache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestParseManifestHLS(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aud",NAME="en",URI="audio/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2"
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2560000
+http://other.example.net/high/index.m3u8
+`
+	u, _ := url.Parse("http://video.example.net/movie/master.m3u8?token=abc")
+	playlists, segments, err := parseManifest(u, []byte(master))
+	if err != nil {
+		t.Fatalf("parsing master playlist: %v", err)
+	}
+	expectedPlaylists := []string{"http://video.example.net/movie/audio/en.m3u8", "http://video.example.net/movie/low/index.m3u8", "http://other.example.net/high/index.m3u8"}
+	if !reflect.DeepEqual(playlists, expectedPlaylists) {
+		t.Errorf("master playlists expected %v, actual %v", expectedPlaylists, playlists)
+	}
+	if len(segments) != 0 {
+		t.Errorf("master segments expected none, actual %v", segments)
+	}
+
+	media := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:10.0,
+seg1.m4s
+#EXTINF:10.0,
+/movie/low/seg2.m4s?x=1
+#EXT-X-ENDLIST
+`
+	u, _ = url.Parse("http://video.example.net/movie/low/index.m3u8")
+	playlists, segments, err = parseManifest(u, []byte(media))
+	if err != nil {
+		t.Fatalf("parsing media playlist: %v", err)
+	}
+	expectedSegments := [][]string{{"http://video.example.net/movie/low/init.mp4", "http://video.example.net/movie/low/seg1.m4s", "http://video.example.net/movie/low/seg2.m4s?x=1"}}
+	if len(playlists) != 0 || !reflect.DeepEqual(segments, expectedSegments) {
+		t.Errorf("media playlist expected no playlists and segments %v, actual %v %v", expectedSegments, playlists, segments)
+	}
+}
+
+func TestParseManifestDASH(t *testing.T) {
+	mpd := `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static">
+  <BaseURL>media/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="$RepresentationID$/seg-$Number%03d$.m4s" initialization="$RepresentationID$/init.mp4" startNumber="5">
+        <SegmentTimeline>
+          <S t="0" d="90000" r="1"/>
+          <S d="45000"/>
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="v1" bandwidth="1000000"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="a1" bandwidth="64000">
+        <SegmentTemplate media="a/$Time$.m4s">
+          <SegmentTimeline><S t="100" d="10" r="1"/></SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+      <Representation id="a2">
+        <SegmentList>
+          <Initialization sourceURL="a2/init.mp4"/>
+          <SegmentURL media="a2/1.m4s"/>
+          <SegmentURL media="a2/2.m4s"/>
+        </SegmentList>
+      </Representation>
+      <Representation id="a3">
+        <SegmentTemplate media="a3/$Number$.m4s" duration="10"/>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	u, _ := url.Parse("http://video.example.net/movie/manifest.mpd")
+	_, segments, err := parseManifest(u, []byte(mpd))
+	if err != nil {
+		t.Fatalf("parsing DASH manifest: %v", err)
+	}
+	base := "http://video.example.net/movie/media/"
+	expected := [][]string{
+		{base + "v1/init.mp4", base + "v1/seg-005.m4s", base + "v1/seg-006.m4s", base + "v1/seg-007.m4s"},
+		{base + "a/100.m4s", base + "a/110.m4s"},
+		{base + "a2/init.mp4", base + "a2/1.m4s", base + "a2/2.m4s"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("DASH segments expected %v, actual %v", expected, segments)
+	}
+}
+
+func TestParseManifestUnknown(t *testing.T) {
+	u, _ := url.Parse("http://video.example.net/movie/manifest.mpd")
+	if _, _, err := parseManifest(u, []byte("not a manifest")); err == nil {
+		t.Errorf("parsing unknown manifest expected error, actual nil")
+	}
+}
+
+func TestSegmentIndexNext(t *testing.T) {
+	x := newSegmentIndex()
+	x.set("http://video.example.net/a.m3u8", [][]string{{"http://video.example.net/1.ts", "http://video.example.net/2.ts", "http://video.example.net/3.ts", "http://video.example.net/4.ts"}})
+
+	if next := x.next("http://video.example.net/1.ts?token=abc", 2); !reflect.DeepEqual(next, []string{"http://video.example.net/2.ts", "http://video.example.net/3.ts"}) {
+		t.Errorf("next expected 2.ts and 3.ts, actual %v", next)
+	}
+	if next := x.next("http://video.example.net/2.ts", 2); !reflect.DeepEqual(next, []string{"http://video.example.net/4.ts"}) {
+		t.Errorf("next while prefetching 3.ts expected 4.ts, actual %v", next)
+	}
+	x.done("http://video.example.net/3.ts")
+	if next := x.next("http://video.example.net/2.ts", 1); !reflect.DeepEqual(next, []string{"http://video.example.net/3.ts"}) {
+		t.Errorf("next after prefetching 3.ts expected 3.ts, actual %v", next)
+	}
+	if next := x.next("http://video.example.net/other.ts", 2); next != nil {
+		t.Errorf("next of unindexed segment expected nil, actual %v", next)
+	}
+
+	x.set("http://video.example.net/a.m3u8", [][]string{{"http://video.example.net/5.ts"}})
+	if next := x.next("http://video.example.net/1.ts", 2); next != nil {
+		t.Errorf("next of segment which left the manifest expected nil, actual %v", next)
+	}
+}
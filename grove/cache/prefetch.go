@@ -0,0 +1,153 @@
+package cache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// MaxIndexedManifests is the number of manifests whose segments are kept for prefetching. When a new manifest is indexed, an arbitrary manifest is forgotten.
+const MaxIndexedManifests = 10000
+
+// segmentIndex maps segments to the segments after them, in the manifests which list them, so the segments after a requested segment can be prefetched. Segments are keyed by their URL without the query string, which may differ between clients.
+type segmentIndex struct {
+	m         sync.Mutex
+	manifests map[string][][]string
+	segments  map[string]segmentPos
+	// prefetching is the set of segments being prefetched, so segments requested by many clients at once are only prefetched once.
+	prefetching map[string]struct{}
+}
+
+// segmentPos is the position of a segment in its manifest, the index of its list and of the segment in the list.
+type segmentPos struct {
+	manifest string
+	list     int
+	i        int
+}
+
+func newSegmentIndex() *segmentIndex {
+	return &segmentIndex{
+		manifests:   map[string][][]string{},
+		segments:    map[string]segmentPos{},
+		prefetching: map[string]struct{}{},
+	}
+}
+
+// segmentKey returns the index key of the given URL, without its query string.
+func segmentKey(uri string) string {
+	if i := strings.Index(uri, "?"); i != -1 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// set replaces the segments of the given manifest. Live manifests are indexed again each time they're requested, so segments which have left the manifest are forgotten.
+func (x *segmentIndex) set(manifest string, segments [][]string) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	x.remove(manifest)
+	if len(x.manifests) >= MaxIndexedManifests {
+		for oldManifest := range x.manifests {
+			x.remove(oldManifest)
+			break
+		}
+	}
+	x.manifests[manifest] = segments
+	for listI, list := range segments {
+		for i, segment := range list {
+			x.segments[segmentKey(segment)] = segmentPos{manifest: manifest, list: listI, i: i}
+		}
+	}
+}
+
+// remove forgets the given manifest and its segments. It must be called with the lock held.
+func (x *segmentIndex) remove(manifest string) {
+	for _, list := range x.manifests[manifest] {
+		for _, segment := range list {
+			if pos, ok := x.segments[segmentKey(segment)]; ok && pos.manifest == manifest {
+				delete(x.segments, segmentKey(segment))
+			}
+		}
+	}
+	delete(x.manifests, manifest)
+}
+
+// next returns up to n segments after the given segment, which aren't already being prefetched, and marks them as being prefetched. Returns nil if the segment isn't in an indexed manifest.
+func (x *segmentIndex) next(segment string, n int) []string {
+	x.m.Lock()
+	defer x.m.Unlock()
+	pos, ok := x.segments[segmentKey(segment)]
+	if !ok {
+		return nil
+	}
+	list := x.manifests[pos.manifest][pos.list]
+	next := []string{}
+	for i := pos.i + 1; i < len(list) && i <= pos.i+n; i++ {
+		if _, ok := x.prefetching[list[i]]; ok {
+			continue
+		}
+		x.prefetching[list[i]] = struct{}{}
+		next = append(next, list[i])
+	}
+	return next
+}
+
+// done unmarks the given segment as being prefetched.
+func (x *segmentIndex) done(segment string) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	delete(x.prefetching, segment)
+}
+
+// followAhead indexes the segments of the given URI if it's a manifest, or prefetches the n segments after it if it's an indexed segment, in the background. It's called after a client request for the URI is served, so the manifest is read from the cache. Manifests which weren't cached, such as uncacheable live playlists, aren't indexed, rather than requesting them from the parent again.
+func (h *Handler) followAhead(uri string, n int, reqID uint64) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	if isManifest(u.Path) {
+		go func() {
+			body, ok := h.cachedBody(uri)
+			if !ok {
+				log.Debugf("prefetch manifest '%v' not cached, not indexing (reqid %v)\n", uri, reqID)
+				return
+			}
+			_, segments, err := parseManifest(u, body)
+			if err != nil {
+				log.Debugf("prefetch parsing manifest '%v': %v (reqid %v)\n", uri, err, reqID)
+				return
+			}
+			h.warmer.index.set(segmentKey(uri), segments)
+		}()
+		return
+	}
+
+	next := h.warmer.index.next(uri, n)
+	if len(next) == 0 {
+		return
+	}
+	go func() {
+		for _, segment := range next {
+			if _, _, err := h.warm(segment, false); err != nil {
+				log.Debugf("prefetch '%v' after '%v': %v (reqid %v)\n", segment, uri, err, reqID)
+			}
+			h.warmer.index.done(segment)
+		}
+	}()
+}
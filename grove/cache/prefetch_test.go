@@ -0,0 +1,104 @@
+package cache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/memcache"
+	"github.com/apache/incubator-trafficcontrol/grove/remap"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+)
+
+const prefetchTestManifest = "http://video.example.net/live/index.m3u8"
+
+// newPrefetchTestHandler returns a Handler with a single rule for video.example.net, whose cache is the given cache.
+func newPrefetchTestHandler(cache icache.Cache) *Handler {
+	rule := remapdata.RemapRule{
+		RemapRuleBase: remapdata.RemapRuleBase{Name: "video", From: "http://video.example.net"},
+		To:            []remapdata.RemapRuleTo{{RemapRuleToBase: remapdata.RemapRuleToBase{URL: "http://origin.example.net"}}},
+		Cache:         cache,
+	}
+	remapper := remap.NewHTTPRequestRemapper([]remapdata.RemapRule{rule}, nil, &remapdata.RemapRulesStats{})
+	return &Handler{remapper: remapper, warmer: NewWarmer()}
+}
+
+func addPrefetchTestObj(t *testing.T, h *Handler, cache icache.Cache, uri string, code int, body string) {
+	r, _ := http.NewRequest(http.MethodGet, uri, nil)
+	r.RequestURI = r.URL.RequestURI()
+	remappingProducer, err := h.remapper.UncheckedRemappingProducer(r, r.URL.Scheme)
+	if err != nil {
+		t.Fatalf("remapping '%v': %v", uri, err)
+	}
+	now := time.Now()
+	cache.Add(remappingProducer.CacheKey(), cacheobj.New(nil, []byte(body), code, code, "", http.Header{}, now, now, now, now))
+}
+
+func (x *segmentIndex) indexed(manifest string) bool {
+	x.m.Lock()
+	defer x.m.Unlock()
+	_, ok := x.manifests[manifest]
+	return ok
+}
+
+func TestCachedBody(t *testing.T) {
+	cache := memcache.New(1024*1024, nil)
+	h := newPrefetchTestHandler(cache)
+
+	if _, ok := h.cachedBody(prefetchTestManifest); ok {
+		t.Errorf("cachedBody of uncached object expected not cached, actual cached")
+	}
+	if _, ok := h.cachedBody("http://nomatch.example.net/index.m3u8"); ok {
+		t.Errorf("cachedBody of URL matching no rule expected not cached, actual cached")
+	}
+
+	addPrefetchTestObj(t, h, cache, prefetchTestManifest, http.StatusOK, "#EXTM3U\n")
+	if body, ok := h.cachedBody(prefetchTestManifest); !ok || string(body) != "#EXTM3U\n" {
+		t.Errorf("cachedBody of cached object expected '#EXTM3U\\n' true, actual '%s' %v", body, ok)
+	}
+
+	addPrefetchTestObj(t, h, cache, prefetchTestManifest, http.StatusNotFound, "Not Found")
+	if _, ok := h.cachedBody(prefetchTestManifest); ok {
+		t.Errorf("cachedBody of cached error expected not cached, actual cached")
+	}
+}
+
+func TestFollowAheadManifest(t *testing.T) {
+	cache := memcache.New(1024*1024, nil)
+	h := newPrefetchTestHandler(cache)
+	addPrefetchTestObj(t, h, cache, prefetchTestManifest, http.StatusOK, "#EXTM3U\n#EXTINF:10,\nseg0.ts\n#EXTINF:10,\nseg1.ts\n")
+
+	h.followAhead(prefetchTestManifest, 1, 0)
+	for start := time.Now(); !h.warmer.index.indexed(prefetchTestManifest); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("followAhead of cached manifest expected indexed, actual not indexed")
+		}
+	}
+}
+
+func TestFollowAheadManifestNotCached(t *testing.T) {
+	h := newPrefetchTestHandler(memcache.New(1024*1024, nil))
+
+	// The manifest isn't cached, e.g. it was uncacheable. It mustn't be requested from the parent again, which this Handler can't do, so it isn't indexed.
+	h.followAhead(prefetchTestManifest, 1, 0)
+	time.Sleep(50 * time.Millisecond)
+	if h.warmer.index.indexed(prefetchTestManifest) {
+		t.Errorf("followAhead of uncached manifest expected not indexed, actual indexed")
+	}
+}
@@ -15,6 +15,7 @@ package cache
 */
 
 import (
+	"io"
 	"net/http"
 	"time"
 
@@ -37,6 +38,8 @@ type Responder struct {
 	Stats         stat.Stats
 	F             RespondFunc
 	ResponseCode  *int
+	// RateLimited is whether the request was rejected by a rate limit. See cachedata.RespData.
+	RateLimited bool
 	cachedata.ParentRespData
 	cachedata.SrvrData
 	cachedata.ReqData
@@ -79,11 +82,16 @@ func NewResponder(w http.ResponseWriter, pluginCfg map[string]interface{}, plugi
 }
 
 // SetResponse is a helper which sets the RespondFunc of r to `web.Respond` with the given code, headers, body, and connectionClose. Note it takes a pointer to the headers and body, which may be modified after calling this but before the Do() sends the response.
-func (r *Responder) SetResponse(code *int, hdrs *http.Header, body *[]byte, connectionClose bool) {
+// If the stream is not nil when the response is sent, the body is copied from it with `web.RespondStream`, and the body is ignored.
+func (r *Responder) SetResponse(code *int, hdrs *http.Header, body *[]byte, stream *io.Reader, connectionClose bool) {
 	r.ResponseCode = code
 	r.F = func() (uint64, error) {
 		if r.Req.Method == http.MethodHead {
 			*body = nil
+			*stream = nil
+		}
+		if *stream != nil {
+			return web.RespondStream(r.W, *code, *hdrs, *stream, connectionClose)
 		}
 		return web.Respond(r.W, *code, *hdrs, *body, connectionClose)
 	}
@@ -100,8 +108,13 @@ func (r *Responder) Do() {
 	}
 	web.TryFlush(r.W) // TODO remove? Let plugins do it, if they need to?
 
+	bytesRead := uint64(0)
+	if r.Conn != nil {
+		bytesRead, bytesSent = r.Conn.TakeBytes() // the conn's bytes are more accurate than the body written, and include headers
+	}
+
 	respSuccess := err != nil
-	respData := cachedata.RespData{*r.ResponseCode, bytesSent, respSuccess, isCacheHit(r.Reuse, r.OriginCode)}
+	respData := cachedata.RespData{RespCode: *r.ResponseCode, BytesRead: bytesRead, BytesWritten: bytesSent, RespSuccess: respSuccess, CacheHit: isCacheHit(r.Reuse, r.OriginCode), RateLimited: r.RateLimited}
 	arData := plugin.AfterRespondData{W: r.W, Stats: r.Stats, ReqData: r.ReqData, SrvrData: r.SrvrData, ParentRespData: r.ParentRespData, RespData: respData, RequestID: r.RequestID}
 	r.Plugins.OnAfterRespond(r.PluginCfg, r.PluginContext, arData)
 }
@@ -16,13 +16,16 @@ package cache
 
 import (
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/remap"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
 	"github.com/apache/incubator-trafficcontrol/grove/thread"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
 
@@ -38,6 +41,8 @@ type Retrier struct {
 	ReqCacheControl   web.CacheControl
 	RemappingProducer *remap.RemappingProducer
 	ReqID             uint64
+	// VaryHeaders are the request headers the cached object varies on, if the request's cache key has a vary marker. If non-nil, requests are made and collapsed by the variant key of the request, rather than the remapping CacheKey.
+	VaryHeaders []string
 }
 
 func NewRetrier(h *Handler, reqHdr http.Header, reqTime time.Time, reqCacheControl web.CacheControl, remappingProducer *remap.RemappingProducer, reqID uint64) *Retrier {
@@ -52,24 +57,56 @@ func NewRetrier(h *Handler, reqHdr http.Header, reqTime time.Time, reqCacheContr
 
 // Get takes the HTTP request and the cached object if there is one, and makes a new request, retrying according to its RemappingProducer. If no cached object exists, pass a nil obj.
 // Along with the cacheobj.CacheObj, a string pointer to the request hostname used to fetch the cacheobj.CacheObj is returned.
-func (r *Retrier) Get(req *http.Request, obj *cacheobj.CacheObj) (*cacheobj.CacheObj, *string, error) {
+// If the returned object's body is streaming from the parent, a reader of the stream is also returned, which must be closed. Otherwise, the returned reader is nil, and the object's Body is complete.
+func (r *Retrier) Get(req *http.Request, obj *cacheobj.CacheObj) (*cacheobj.CacheObj, *web.StreamReader, *string, error) {
+	bodyReader := (*web.StreamReader)(nil)
 	retryGetFunc := func(remapping remap.Remapping, retryFailures bool, obj *cacheobj.CacheObj) *cacheobj.CacheObj {
+		canShare := func(cacheObj *cacheobj.CacheObj) bool {
+			return cacheObj.Shareable() && remap.CanShare(remapping.Request.Method, r.ReqHdr, cacheObj, remapping.CacheOverrides)
+		}
 		// return true for Revalidate, and issue revalidate requests separately.
 		canReuse := func(cacheObj *cacheobj.CacheObj) bool {
-			return remap.CanReuse(r.ReqHdr, r.ReqCacheControl, cacheObj, r.H.strictRFC, true)
+			return canShare(cacheObj) && remap.CanReuse(r.ReqHdr, r.ReqCacheControl, cacheObj, r.H.strictRFC, true, remapping.CacheOverrides)
+		}
+		cacheKey := remapping.CacheKey
+		if r.VaryHeaders != nil {
+			cacheKey = remapdata.VariantCacheKey(cacheKey, r.ReqHdr, r.VaryHeaders)
 		}
 		getAndCache := func() *cacheobj.CacheObj {
-			return GetAndCache(remapping.Request, remapping.ProxyURL, remapping.CacheKey, remapping.Name, remapping.Request.Header, r.ReqTime, r.H.strictRFC, remapping.Cache, r.H.ruleThrottlers[remapping.Name], obj, remapping.Timeout, retryFailures, remapping.RetryNum, remapping.RetryCodes, remapping.Transport, r.ReqID)
+			return GetAndCache(remapping.Request, remapping.ProxyURL, cacheKey, remapping.Name, remapping.Request.Header, r.ReqTime, r.H.strictRFC, remapping.Cache, r.H.ruleThrottlers[remapping.Name], obj, remapping.Timeout, retryFailures, remapping.RetryNum, remapping.RetryCodes, remapping.Transport, r.H.maxCacheableBytes, remapping.CacheOverrides, r.ReqID)
+		}
+		gotObj, getReqID := r.RemappingProducer.Getter().Get(cacheKey, getAndCache, canReuse, canShare, r.ReqID)
+		if getReqID == r.ReqID {
+			// only the requestor which actually made the parent request records its result, so collapsed requests don't count it multiple times
+			r.RemappingProducer.ParentResult(remapping, isFailure(gotObj, remapping.RetryCodes))
+		}
+
+		if gotObj.Streaming() {
+			streamReader, ok := gotObj.StreamReader()
+			if !ok {
+				// Another requestor's stream stopped retaining and discarded bytes, after we were given it. Make our own request.
+				log.Debugf("Retrier.Get %v stream no longer readable, requesting (reqid %v)\n", cacheKey, r.ReqID)
+				gotObj = getAndCache()
+				streamReader, _ = gotObj.StreamReader()
+			}
+			if bodyReader != nil {
+				bodyReader.Close() // should never happen, failures aren't streamed
+			}
+			bodyReader = streamReader
 		}
-		gotObj, getReqID := r.H.getter.Get(remapping.CacheKey, getAndCache, canReuse, r.ReqID)
 
 		req := remapping.Request
-		log.Debugf("Retrier.Get Y URI %v %v %v remapping.CacheKey %v rule %v parent %v code %v headers %+v len(body) %v getterid %v (reqid %v)\n", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), remapping.CacheKey, remapping.Name, remapping.ProxyURL, gotObj.Code, gotObj.RespHeaders, len(gotObj.Body), getReqID, r.ReqID)
+		log.Debugf("Retrier.Get Y URI %v %v %v cacheKey %v rule %v parent %v code %v headers %+v len(body) %v streaming %v getterid %v (reqid %v)\n", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), cacheKey, remapping.Name, remapping.ProxyURL, gotObj.Code, gotObj.RespHeaders, len(gotObj.Body), gotObj.Streaming(), getReqID, r.ReqID)
 
 		return gotObj
 	}
 
-	return retryingGet(retryGetFunc, req, r.RemappingProducer, obj)
+	gotObj, reqHost, err := retryingGet(retryGetFunc, req, r.RemappingProducer, obj)
+	if err != nil && bodyReader != nil {
+		bodyReader.Close()
+		bodyReader = nil
+	}
+	return gotObj, bodyReader, reqHost, err
 }
 
 // retryingGet takes a function, and retries failures up to the RemappingProducer RetryNum limit. On failure, it creates a new remapping. The func f should use `remapping` to make its request. If it hits failures up to the limit, it returns the last received cacheobj.CacheObj
@@ -102,6 +139,9 @@ func isFailure(o *cacheobj.CacheObj, retryCodes map[int]struct{}) bool {
 const ModifiedSinceHdr = "If-Modified-Since"
 
 // GetAndCache makes a client request for the given `http.Request` and caches it if `CanCache`.
+// If the response varies on request headers, it's cached at the variant key of the request's values of them, and a vary marker is cached at the base key. See addToCache.
+// The object is returned as soon as the parent response headers are received, and the body is streamed from the parent, unless it's a failure, in which case the body is read before returning. Once the stream is complete, the complete object is cached, if it's cacheable and no larger than maxCacheableBytes. If maxCacheableBytes is 0, objects of any size are cached.
+// The rule throttler is held until the body has been completely read from the parent, not just until the object is returned.
 // THe `ruleThrottler` may be nil, in which case the request will be unthrottled.
 func GetAndCache(
 	req *http.Request,
@@ -119,10 +159,29 @@ func GetAndCache(
 	retryNum int,
 	retryCodes map[int]struct{},
 	transport *http.Transport,
+	maxCacheableBytes uint64,
+	cacheOverrides *remapdata.CacheOverrides,
 	reqID uint64,
 ) *cacheobj.CacheObj {
+	canCache := func(code int, respHeader http.Header) bool {
+		if !remap.CanCache(req.Method, reqHeader, code, respHeader, strictRFC, cacheOverrides) {
+			return false
+		}
+		if code == http.StatusPartialContent {
+			// Range is removed from parent requests, and ranges are served from the full object. A partial response is only a fraction of the object at this key, so it must never be cached.
+			log.Errorf("GetAndCache %v parent returned a partial response, not caching (reqid %v)\n", cacheKey, reqID)
+			return false
+		}
+		return true
+	}
+	// setFreshness sets the freshness of an object about to be cached, for the cache inspector. It must only be called on objects which aren't shared yet.
+	setFreshness := func(obj *cacheobj.CacheObj) {
+		obj.FreshnessLifetime, obj.CacheOverrides = remap.FreshnessLifetime(obj.Code, obj.RespHeaders, obj.RespCacheControl, cacheOverrides)
+	}
+
 	// TODO this is awkward, with 'revalidateObj' indicating whether the request is a Revalidate. Should Getting and Caching be split up? How?
-	get := func() *cacheobj.CacheObj {
+	// get sends the object on objChan as soon as it's created, which for streamed bodies is before the body is read. It must send exactly once.
+	get := func(objChan chan<- *cacheobj.CacheObj) {
 		// TODO figure out why respReqTime isn't used by rules
 		log.Debugf("GetAndCache calling request %v %v %v %v %v (reqid %v)\n", req.Method, req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), req.Header, reqID)
 		// TODO Verify overriding the passed reqTime is the right thing to do
@@ -135,17 +194,36 @@ func GetAndCache(
 		} else {
 			req.Header.Del(ModifiedSinceHdr)
 		}
-		respCode, respHeader, respBody, reqTime, reqRespTime, err := web.Request(transport, req)
-		log.Debugf("GetAndCache web.Request URI %v %v %v cacheKey %v rule %v parent %v error %v reval %v code %v len(body) %v (reqid %v)\n", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), cacheKey, remapName, proxyURLStr, err, revalidateObj != nil, respCode, len(respBody), reqID)
+		respCode, respHeader, respBodyReader, reqTime, reqRespTime, err := web.RequestStream(transport, req)
+		log.Debugf("GetAndCache web.RequestStream URI %v %v %v cacheKey %v rule %v parent %v error %v reval %v code %v (reqid %v)\n", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), cacheKey, remapName, proxyURLStr, err, revalidateObj != nil, respCode, reqID)
 
-		if err != nil {
+		connectFailure := func(err error) *cacheobj.CacheObj {
 			log.Errorf("Parent error for URI %v %v %v cacheKey %v rule %v parent %v error %v (reqid %v)\n", req.URL.Scheme, req.URL.Host, req.URL.EscapedPath(), cacheKey, remapName, proxyURLStr, err, reqID)
 			code := CodeConnectFailure
 			body := []byte(http.StatusText(code))
 			return cacheobj.New(reqHeader, body, code, code, proxyURLStr, respHeader, reqTime, reqRespTime, reqRespTime, time.Time{})
 		}
-		if _, ok := retryCodes[respCode]; ok && !cacheFailure {
-			return cacheobj.New(reqHeader, respBody, respCode, respCode, proxyURLStr, respHeader, reqTime, reqRespTime, reqRespTime, time.Time{})
+
+		if err != nil {
+			objChan <- connectFailure(err)
+			return
+		}
+		defer respBodyReader.Close()
+
+		// Failures are read completely, because the retrier discards them and requests the next parent, so nothing would read a stream.
+		respBody := []byte(nil)
+		bodyRead := false
+		_, isRetryCode := retryCodes[respCode]
+		if isRetryCode || respCode == CodeConnectFailure {
+			bodyRead = true
+			if respBody, err = ioutil.ReadAll(respBodyReader); err != nil {
+				objChan <- connectFailure(errors.New("reading response body: " + err.Error()))
+				return
+			}
+			if isRetryCode && !cacheFailure {
+				objChan <- cacheobj.New(reqHeader, respBody, respCode, respCode, proxyURLStr, respHeader, reqTime, reqRespTime, reqRespTime, time.Time{})
+				return
+			}
 		}
 
 		log.Debugf("GetAndCache request returned %v headers %+v (reqid %v)\n", respCode, respHeader, reqID)
@@ -160,21 +238,13 @@ func GetAndCache(
 			lastModified = respRespTime
 		}
 
-		obj := (*cacheobj.CacheObj)(nil)
-		log.Debugf("h.cache.Add %v (reqid %v)\n", cacheKey, reqID)
 		log.Debugf("GetAndCache respCode %v (reqid %v)\n", respCode, reqID)
-		if revalidateObj == nil || respCode != http.StatusNotModified {
-			log.Debugf("GetAndCache new %v (reqid %v)\n", cacheKey, reqID)
-			obj = cacheobj.New(reqHeader, respBody, respCode, respCode, proxyURLStr, respHeader, reqTime, reqRespTime, respRespTime, lastModified)
-			if !remap.CanCache(req.Method, reqHeader, respCode, respHeader, strictRFC) {
-				return obj // return without caching
-			}
-		} else {
+		if revalidateObj != nil && respCode == http.StatusNotModified {
 			log.Debugf("GetAndCache revalidating %v len(revalidateObj.Body) %v (reqid %v)\n", cacheKey, len(revalidateObj.Body), reqID)
 			// must copy, because this cache object may be concurrently read by other goroutines
 			newRespHeader := web.CopyHeader(revalidateObj.RespHeaders)
 			newRespHeader.Set("Date", respHeader.Get("Date"))
-			obj = &cacheobj.CacheObj{
+			obj := &cacheobj.CacheObj{
 				Body:             revalidateObj.Body,
 				ReqHeaders:       revalidateObj.ReqHeaders,
 				RespHeaders:      newRespHeader,
@@ -188,16 +258,70 @@ func GetAndCache(
 				LastModified:     revalidateObj.LastModified,
 				Size:             revalidateObj.Size,
 			}
+			setFreshness(obj)
+			addToCache(cache, cacheKey, reqHeader, obj, reqID) // TODO store pointer?
+			objChan <- obj
+			return
+		}
+
+		if bodyRead {
+			log.Debugf("GetAndCache new %v (reqid %v)\n", cacheKey, reqID)
+			obj := cacheobj.New(reqHeader, respBody, respCode, respCode, proxyURLStr, respHeader, reqTime, reqRespTime, respRespTime, lastModified)
+			if canCache(respCode, respHeader) {
+				setFreshness(obj)
+				addToCache(cache, cacheKey, reqHeader, obj, reqID)
+			}
+			objChan <- obj
+			return
 		}
-		cache.Add(cacheKey, obj) // TODO store pointer?
-		return obj
+
+		log.Debugf("GetAndCache new streaming %v (reqid %v)\n", cacheKey, reqID)
+		contentLength, err := strconv.ParseInt(respHeader.Get("Content-Length"), 10, 64)
+		if err != nil {
+			contentLength = -1
+		}
+		stream := web.NewStreamBuffer(maxCacheableBytes, contentLength)
+		obj := cacheobj.NewStreaming(reqHeader, stream, respCode, respCode, proxyURLStr, respHeader, reqTime, reqRespTime, respRespTime, lastModified)
+		defer obj.FinishStream()
+		objChan <- obj
+
+		if bytesRead, err := stream.Fill(respBodyReader); err != nil {
+			log.Errorf("GetAndCache %v reading parent response body after %v bytes, not caching: %v (reqid %v)\n", cacheKey, bytesRead, err, reqID)
+			return
+		}
+		body, ok := stream.Bytes()
+		if !ok {
+			log.Debugf("GetAndCache %v larger than max cacheable %v bytes, not caching (reqid %v)\n", cacheKey, maxCacheableBytes, reqID)
+			return
+		}
+		if !canCache(respCode, respHeader) {
+			return
+		}
+		cacheObj := obj.WithBody(body)
+		setFreshness(cacheObj)
+		addToCache(cache, cacheKey, reqHeader, cacheObj, reqID)
 	}
 
-	c := (*cacheobj.CacheObj)(nil)
 	if ruleThrottler == nil {
 		log.Errorf("rule %v not in ruleThrottlers map. Requesting with no origin limit! (reqid %v)\n", remapName, reqID)
 		ruleThrottler = thread.NewNoThrottler()
 	}
-	ruleThrottler.Throttle(func() { c = get() })
-	return c
+	objChan := make(chan *cacheobj.CacheObj, 1)
+	go ruleThrottler.Throttle(func() { get(objChan) })
+	return <-objChan
+}
+
+// addToCache adds the given object to the cache. If the object varies on request headers, it's added at the variant key of the given request header values, and a vary marker listing the headers is added at the base key, so requests for the key can find their variant. The given key may be a base key or a variant key.
+func addToCache(cache icache.Cache, cacheKey string, reqHeader http.Header, obj *cacheobj.CacheObj, reqID uint64) {
+	baseKey := remapdata.CacheKeyBase(cacheKey)
+	varyHeaders, _ := web.ParseVary(obj.RespHeaders)
+	if len(varyHeaders) == 0 {
+		log.Debugf("h.cache.Add %v len(body) %v (reqid %v)\n", baseKey, len(obj.Body), reqID)
+		cache.Add(baseKey, obj)
+		return
+	}
+	variantKey := remapdata.VariantCacheKey(baseKey, reqHeader, varyHeaders)
+	log.Debugf("h.cache.Add %v len(body) %v vary marker %v (reqid %v)\n", variantKey, len(obj.Body), baseKey, reqID)
+	cache.Add(baseKey, cacheobj.NewVaryMarker(varyHeaders, obj.ReqRespTime))
+	cache.Add(variantKey, obj)
 }
@@ -0,0 +1,290 @@
+package cache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+	"github.com/apache/incubator-trafficcontrol/grove/plugin"
+	"github.com/apache/incubator-trafficcontrol/grove/remap"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// MaxWarmJobs is the number of warm jobs whose progress is kept. When a new job is started, the oldest finished job is forgotten.
+const MaxWarmJobs = 100
+
+// MaxWarmJobErrors is the number of errors kept in a warm job's progress. Further errors are only counted.
+const MaxWarmJobErrors = 100
+
+// Warmer runs warm jobs and prefetches manifest segments. It's created once, and shared by every Handler, so jobs outlive config reloads.
+type Warmer struct {
+	m      sync.Mutex
+	jobs   []*cachedata.WarmJob
+	nextID uint64
+	index  *segmentIndex
+}
+
+func NewWarmer() *Warmer {
+	return &Warmer{index: newSegmentIndex()}
+}
+
+// newJob creates and returns a new job, forgetting the oldest finished job if there are MaxWarmJobs.
+func (w *Warmer) newJob(total int) *cachedata.WarmJob {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if len(w.jobs) >= MaxWarmJobs {
+		for i, job := range w.jobs {
+			if job.Complete {
+				w.jobs = append(w.jobs[:i], w.jobs[i+1:]...)
+				break
+			}
+		}
+	}
+	w.nextID++
+	job := &cachedata.WarmJob{ID: w.nextID, Started: time.Now(), Total: uint64(total), Errors: []string{}}
+	w.jobs = append(w.jobs, job)
+	return job
+}
+
+// addTotal adds to the number of URLs of the job, as manifests are listed.
+func (w *Warmer) addTotal(job *cachedata.WarmJob, n int) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	job.Total += uint64(n)
+}
+
+// done records the result of warming one URL of the job.
+func (w *Warmer) done(job *cachedata.WarmJob, uri string, hit bool, err error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	job.Done++
+	switch {
+	case err != nil:
+		job.Failed++
+		if len(job.Errors) < MaxWarmJobErrors {
+			job.Errors = append(job.Errors, uri+": "+err.Error())
+		}
+	case hit:
+		job.Hits++
+	default:
+		job.Fetched++
+	}
+}
+
+func (w *Warmer) finish(job *cachedata.WarmJob) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	job.Complete = true
+	job.Finished = time.Now()
+}
+
+// Jobs returns a copy of the progress of every kept job, oldest first.
+func (w *Warmer) Jobs() []cachedata.WarmJob {
+	w.m.Lock()
+	defer w.m.Unlock()
+	jobs := make([]cachedata.WarmJob, 0, len(w.jobs))
+	for _, job := range w.jobs {
+		jobs = append(jobs, copyWarmJob(job))
+	}
+	return jobs
+}
+
+// Job returns a copy of the progress of the job with the given ID, and whether it exists.
+func (w *Warmer) Job(id uint64) (cachedata.WarmJob, bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for _, job := range w.jobs {
+		if job.ID == id {
+			return copyWarmJob(job), true
+		}
+	}
+	return cachedata.WarmJob{}, false
+}
+
+func copyWarmJob(job *cachedata.WarmJob) cachedata.WarmJob {
+	cp := *job
+	cp.Errors = append([]string{}, job.Errors...)
+	return cp
+}
+
+// StartWarmJob starts a job warming the given URLs and manifests, and returns its progress. The request's concurrency must be positive. It implements plugin.Warmer, for the http_warm plugin.
+func (h *Handler) StartWarmJob(warmReq cachedata.WarmReq) cachedata.WarmJob {
+	job := h.warmer.newJob(len(warmReq.URLs) + len(warmReq.Manifests))
+	log.Infof("warm job %v started with %v urls and %v manifests\n", job.ID, len(warmReq.URLs), len(warmReq.Manifests))
+	go h.runWarmJob(job, warmReq)
+	jobCopy, _ := h.warmer.Job(job.ID)
+	return jobCopy
+}
+
+// WarmJobs returns the progress of every kept warm job, oldest first. It implements plugin.Warmer.
+func (h *Handler) WarmJobs() []cachedata.WarmJob { return h.warmer.Jobs() }
+
+// WarmJob returns the progress of the warm job with the given ID, and whether it exists. It implements plugin.Warmer.
+func (h *Handler) WarmJob(id uint64) (cachedata.WarmJob, bool) { return h.warmer.Job(id) }
+
+// runWarmJob warms the URLs and manifests of the job, with the job's concurrency. The segments of each manifest are warmed after the manifest is fetched and parsed.
+func (h *Handler) runWarmJob(job *cachedata.WarmJob, warmReq cachedata.WarmReq) {
+	uris := make(chan string)
+	wg := sync.WaitGroup{}
+	for i := 0; i < warmReq.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range uris {
+				hit, _, err := h.warm(uri, false)
+				h.warmer.done(job, uri, hit, err)
+			}
+		}()
+	}
+	for _, uri := range warmReq.URLs {
+		uris <- uri
+	}
+	for _, manifest := range warmReq.Manifests {
+		h.warmManifest(job, manifest, uris, true)
+	}
+	close(uris)
+	wg.Wait()
+	h.warmer.finish(job)
+	finished, _ := h.warmer.Job(job.ID)
+	log.Infof("warm job %v finished: %v hits, %v fetched, %v failed\n", finished.ID, finished.Hits, finished.Fetched, finished.Failed)
+}
+
+// warmManifest fetches the given manifest, and sends its segments to be warmed. If followPlaylists, the playlists of an HLS master playlist are warmed as manifests too.
+func (h *Handler) warmManifest(job *cachedata.WarmJob, manifest string, uris chan<- string, followPlaylists bool) {
+	hit, body, err := h.warm(manifest, true)
+	playlists, segments := []string(nil), [][]string(nil)
+	if err == nil {
+		manifestURL, _ := url.Parse(manifest) // warm already parsed it
+		playlists, segments, err = parseManifest(manifestURL, body)
+	}
+	h.warmer.done(job, manifest, hit, err)
+	if err != nil {
+		return
+	}
+	for _, list := range segments {
+		h.warmer.addTotal(job, len(list))
+		for _, segment := range list {
+			uris <- segment
+		}
+	}
+	if !followPlaylists {
+		return
+	}
+	h.warmer.addTotal(job, len(playlists))
+	for _, playlist := range playlists {
+		h.warmManifest(job, playlist, uris, false)
+	}
+}
+
+// warm requests the given URL through the cache, as a client GET would, caching it if it isn't already cached and fresh. It's requested from the parent of the rule matching the URL, without checking the rule's ACL or rate limits, because warm requests are made by Grove itself.
+// Returns whether the object was already cached and fresh, and its body if wantBody. Responses with error codes are returned as errors.
+func (h *Handler) warm(uri string, wantBody bool) (bool, []byte, error) {
+	reqID := atomic.AddUint64(&h.requestID, 1)
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false, nil, fmt.Errorf("parsing URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false, nil, errors.New("URL must be http or https, with a host")
+	}
+	r, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return false, nil, fmt.Errorf("creating request: %v", err)
+	}
+	r.RequestURI = u.RequestURI()
+
+	remappingProducer, err := h.remapper.UncheckedRemappingProducer(r, u.Scheme)
+	if err != nil {
+		return false, nil, err
+	}
+
+	reqTime := time.Now()
+	reqCacheControl := web.ParseCacheControl(r.Header)
+	retrier := NewRetrier(h, r.Header, reqTime, reqCacheControl, remappingProducer, reqID)
+	cache := remappingProducer.Cache()
+	cacheKey := remappingProducer.CacheKey()
+	cacheObj, ok := cache.Get(cacheKey)
+	if ok && cacheObj.IsVaryMarker() {
+		retrier.VaryHeaders = cacheObj.VaryHeaders
+		cacheKey = remapdata.VariantCacheKey(cacheKey, r.Header, cacheObj.VaryHeaders)
+		cacheObj, ok = cache.Get(cacheKey)
+	}
+	if !ok {
+		cacheObj = nil
+	} else if remap.CanReuseStored(r.Header, cacheObj.Code, cacheObj.RespHeaders, reqCacheControl, cacheObj.RespCacheControl, cacheObj.ReqHeaders, cacheObj.ReqRespTime, cacheObj.RespRespTime, h.strictRFC, remappingProducer.CacheOverrides()) == remapdata.ReuseCan && !remappingProducer.Invalidated(r, cacheObj.ReqRespTime) {
+		log.Debugf("warm '%v' already cached (reqid %v)\n", cacheKey, reqID)
+		return true, cacheObj.Body, nil
+	}
+
+	beforeParentRequestData := plugin.BeforeParentRequestData{Req: r, RemapRule: remappingProducer.Name()}
+	h.plugins.OnBeforeParentRequest(remappingProducer.PluginCfg(), copyPluginContext(h.pluginContext), beforeParentRequestData)
+	gotObj, bodyReader, _, err := retrier.Get(r, cacheObj)
+	if err != nil {
+		return false, nil, err
+	}
+	body := gotObj.Body
+	if bodyReader != nil {
+		// read the stream, so the object is cached before the URL is reported warmed
+		defer bodyReader.Close()
+		if wantBody {
+			body, err = ioutil.ReadAll(bodyReader)
+		} else {
+			_, err = io.Copy(ioutil.Discard, bodyReader)
+		}
+		if err != nil {
+			return false, nil, fmt.Errorf("reading parent response: %v", err)
+		}
+	}
+	if gotObj.Code >= 400 {
+		return false, nil, fmt.Errorf("parent responded %v", gotObj.Code)
+	}
+	log.Debugf("warm '%v' fetched %v (reqid %v)\n", cacheKey, gotObj.Code, reqID)
+	return false, body, nil
+}
+
+// cachedBody returns the body of the object cached for a client GET of the given URL, and whether a successful response is cached. It doesn't check whether the object is fresh, and never requests it from the parent.
+func (h *Handler) cachedBody(uri string) ([]byte, bool) {
+	r, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, false
+	}
+	r.RequestURI = r.URL.RequestURI()
+	remappingProducer, err := h.remapper.UncheckedRemappingProducer(r, r.URL.Scheme)
+	if err != nil {
+		return nil, false
+	}
+	cache := remappingProducer.Cache()
+	cacheKey := remappingProducer.CacheKey()
+	cacheObj, ok := cache.Peek(cacheKey)
+	if ok && cacheObj.IsVaryMarker() {
+		cacheObj, ok = cache.Peek(remapdata.VariantCacheKey(cacheKey, r.Header, cacheObj.VaryHeaders))
+	}
+	if !ok || cacheObj.Code != http.StatusOK {
+		return nil, false
+	}
+	return cacheObj.Body, true
+}
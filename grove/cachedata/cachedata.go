@@ -48,11 +48,17 @@ type ReqData struct {
 	ClientIP string
 	ReqTime  time.Time
 	ToFQDN   string
+	// RemapRule is the name of the remap rule matching the request, or empty if no rule matched.
+	RemapRule string
 }
 
 type RespData struct {
-	RespCode     int
+	RespCode int
+	// BytesRead and BytesWritten are the bytes of the request and response on the client conn. See web.InterceptConn.
+	BytesRead    uint64
 	BytesWritten uint64
 	RespSuccess  bool
 	CacheHit     bool
+	// RateLimited is whether the request was rejected by a rate limit, without requesting the origin.
+	RateLimited bool
 }
@@ -0,0 +1,40 @@
+package cachedata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"time"
+)
+
+// WarmReq is the JSON body of a request to start a warm job. URLs are fetched into the cache, as a client GET of each would. Manifests are HLS or DASH manifests, which are fetched along with every segment they list, and the media playlists of HLS master playlists.
+type WarmReq struct {
+	URLs        []string `json:"urls"`
+	Manifests   []string `json:"manifests"`
+	Concurrency int      `json:"concurrency"`
+}
+
+// WarmJob is the progress of a warm job. Total grows as manifests are fetched and their segments listed. Each URL is either a Hit, which was already cached and fresh, Fetched, or Failed.
+type WarmJob struct {
+	ID       uint64    `json:"id"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Complete bool      `json:"complete"`
+	Total    uint64    `json:"total"`
+	Done     uint64    `json:"done"`
+	Hits     uint64    `json:"hits"`
+	Fetched  uint64    `json:"fetched"`
+	Failed   uint64    `json:"failed"`
+	Errors   []string  `json:"errors"`
+}
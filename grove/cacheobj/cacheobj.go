@@ -34,6 +34,15 @@ type CacheObj struct {
 	RespRespTime     time.Time // the origin server's Date time when the object was sent
 	LastModified     time.Time // the origin LastModified if it exists, or Date if it doesn't
 	Size             uint64
+	// FreshnessLifetime is the object's freshness lifetime when it was cached, including its remap rule's cache overrides, and CacheOverrides are the names of the overrides which changed it. They're informational, for the cache inspector; reuse is always evaluated with the rule's current overrides.
+	FreshnessLifetime time.Duration
+	CacheOverrides    []string
+	// VaryHeaders is non-nil for vary markers, which are cached at the key of a parent response that varies on these request headers, in place of the response. Markers have no body; the response is cached at the remapdata.VariantCacheKey of the request's values of these headers.
+	VaryHeaders []string
+	// stream is the body being read from the parent, for objects returned to requestors before the parent response completes. It's unexported, so it's never encoded by disk caches; objects are only added to caches with their complete Body.
+	stream *web.StreamBuffer
+	// filled is closed when the stream is complete, after the complete object is cached, if it's cacheable.
+	filled chan struct{}
 }
 
 // ComputeSize computes the size of the given CacheObj. This computation is expensive, as the headers must be iterated over. Thus, the size should be computed once and stored, not computed on-the-fly for every new request for the cached object.
@@ -61,3 +70,59 @@ func New(reqHeader http.Header, bytes []byte, code int, originCode int, proxyURL
 	obj.Size = obj.ComputeSize()
 	return obj
 }
+
+// NewVaryMarker creates a marker object, indicating the object at its key varies on the given request headers, and its variants are cached at their variant keys. The reqRespTime is the time the varying response was received.
+func NewVaryMarker(varyHeaders []string, reqRespTime time.Time) *CacheObj {
+	return &CacheObj{VaryHeaders: varyHeaders, ReqTime: reqRespTime, ReqRespTime: reqRespTime}
+}
+
+// IsVaryMarker returns whether the object is a vary marker, rather than a response. See NewVaryMarker.
+func (c *CacheObj) IsVaryMarker() bool {
+	return c.VaryHeaders != nil
+}
+
+// NewStreaming creates a CacheObj whose body is read from the given stream, as it's written from the parent. The Body is nil, and the Size is 0, until the complete object is created with WithBody.
+func NewStreaming(reqHeader http.Header, stream *web.StreamBuffer, code int, originCode int, proxyURL string, respHeader http.Header, reqTime time.Time, reqRespTime time.Time, respRespTime time.Time, lastModified time.Time) *CacheObj {
+	obj := New(reqHeader, nil, code, originCode, proxyURL, respHeader, reqTime, reqRespTime, respRespTime, lastModified)
+	obj.stream = stream
+	obj.filled = make(chan struct{})
+	return obj
+}
+
+// Streaming returns whether the object's body is a stream, which must be read with StreamReader, rather than the Body.
+func (c *CacheObj) Streaming() bool {
+	return c.stream != nil
+}
+
+// Shareable returns whether the object may be used by requestors other than the one which requested it. Objects with complete bodies are always shareable. Streaming objects are only shareable while their stream retains the entire body.
+func (c *CacheObj) Shareable() bool {
+	return c.stream == nil || c.stream.Retaining()
+}
+
+// StreamReader returns a new reader of the streaming body from the beginning, and whether a reader could be created. The reader must be closed. This returns false if the object isn't streaming, or the stream has discarded bytes.
+func (c *CacheObj) StreamReader() (*web.StreamReader, bool) {
+	if c.stream == nil {
+		return nil, false
+	}
+	return c.stream.NewReader()
+}
+
+// Filled returns a chan which is closed when a streaming object's body is complete, and it's been cached if it's cacheable. For objects which aren't streaming, this returns nil.
+func (c *CacheObj) Filled() <-chan struct{} {
+	return c.filled
+}
+
+// FinishStream marks a streaming object's body complete, closing the chan returned by Filled. This must be called exactly once, by the writer of the stream.
+func (c *CacheObj) FinishStream() {
+	close(c.filled)
+}
+
+// WithBody returns a copy of a streaming object with the given complete body, suitable for caching.
+func (c *CacheObj) WithBody(body []byte) *CacheObj {
+	obj := *c
+	obj.Body = body
+	obj.stream = nil
+	obj.filled = nil
+	obj.Size = obj.ComputeSize()
+	return &obj
+}
@@ -58,6 +58,18 @@ type Config struct {
 	CacheFiles           map[string][]CacheFile `json:"cache_files"`
 	// FileMemBytes is the amount of memory to use as an LRU in front of each name in CacheFiles, that is, each named group of files. E.g. if there are 10 files, the amount of memory used will be 10*FileMemBytes+CacheSizeBytes.
 	FileMemBytes int `json:"file_mem_bytes"`
+	// FileLRUSyncMS is how often to persist the LRU order of each cache file to the file, so the least recently used objects are still evicted first after a restart. If 0, the order isn't persisted, and objects are restored in arbitrary order.
+	FileLRUSyncMS int `json:"file_lru_sync_ms"`
+	// CachePolicies is the eviction policy of each cache, by name in CacheFiles. The empty name is the memory cache used by rules without a cache name. The policy of each named group of files is also used by its memory cache. Caches without a policy use LRU.
+	CachePolicies map[string]CachePolicy `json:"cache_policies"`
+	// MemCacheShards is the number of independently locked shards of each memory cache, which reduces lock contention on many cores. If 0 or 1, each memory cache has a single lock.
+	MemCacheShards int `json:"mem_cache_shards"`
+	// MaxCacheableObjectBytes is the maximum size of an object body to cache. Larger objects are streamed from the parent to the client, without being cached. If 0, objects of any size are cached.
+	MaxCacheableObjectBytes uint64 `json:"max_cacheable_object_bytes"`
+	// MaxConnsPerClientIP is the maximum number of concurrent client connections from each IP, to the HTTP and HTTPS ports combined. Connections over the limit are closed as soon as they're accepted. If 0, connections are unlimited.
+	MaxConnsPerClientIP int `json:"max_connections_per_client_ip"`
+	// PIDFile is the file the process ID is written to on startup, so other programs, such as grovetccfg, may signal the process to reload its config. If empty, no PID file is written.
+	PIDFile string `json:"pid_file"`
 }
 
 type CacheFile struct {
@@ -65,6 +77,14 @@ type CacheFile struct {
 	Bytes uint64 `json:"size_bytes"`
 }
 
+// CachePolicy is the eviction policy of a cache.
+type CachePolicy struct {
+	// Eviction is the eviction policy, one of "lru", "lfu", or "arc". Defaults to "lru".
+	Eviction string `json:"eviction"`
+	// Admission is the admission filter, "tinylfu" or empty to admit all objects.
+	Admission string `json:"admission"`
+}
+
 func (c Config) ErrorLog() log.LogLocation {
 	return log.LogLocation(c.LogLocationError)
 }
@@ -105,6 +125,7 @@ var DefaultConfig = Config{
 	ServerWriteTimeoutMS:   3 * MSPerSec,
 	ServerReadTimeoutMS:    3 * MSPerSec,
 	FileMemBytes:           bytesPerMebibyte * 100,
+	FileLRUSyncMS:          60 * MSPerSec,
 }
 
 // LoadConfig loads the given config file. If an empty string is passed, the default config is returned.
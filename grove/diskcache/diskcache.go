@@ -16,12 +16,16 @@ package diskcache
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/lru"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
@@ -33,12 +37,29 @@ type DiskCache struct {
 	db           *bolt.DB
 	sizeBytes    uint64
 	maxSizeBytes uint64
-	lru          *lru.LRU
+	policy       lru.Policy
+	hits         uint64 // atomic
+	misses       uint64 // atomic
+	// lruSyncInterval is how often the policy's order is persisted to the LRUBucketName bucket. If 0, it's never persisted.
+	lruSyncInterval time.Duration
+	stopLRUSync     chan struct{}
+	lruSyncDone     chan struct{}
+	lruSyncStarted  int32
+	closeOnce       sync.Once
 }
 
 const BucketName = "b"
 
-func New(path string, cacheSizeBytes uint64) (*DiskCache, error) {
+// LRUBucketName is the bucket the LRU order is persisted to. Each key is an object key, and its value is the key's big-endian uint64 position in the LRU, where 0 is the least recently used.
+// With eviction policies other than LRU, the order is the policy's eviction order, and other state, such as LFU frequencies, isn't persisted.
+// It is only a hint for ordering the LRU on restart: objects in BucketName without a position are restored as the least recently used, and positions of keys not in BucketName are ignored, so a crash between syncs never orphans or resurrects objects.
+const LRUBucketName = "lru"
+
+// New opens the DiskCache at the given path, with the given eviction policy, persisting its order every lruSyncInterval. If policy is nil, LRU is used.
+func New(path string, cacheSizeBytes uint64, lruSyncInterval time.Duration, policy lru.Policy) (*DiskCache, error) {
+	if policy == nil {
+		policy = lru.NewLRU()
+	}
 	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
 		return nil, errors.New("opening database '" + path + "': " + err.Error())
@@ -54,28 +75,117 @@ func New(path string, cacheSizeBytes uint64) (*DiskCache, error) {
 		return nil, errors.New("creating bucket for database '" + path + "': " + err.Error())
 	}
 
-	return &DiskCache{db: db, maxSizeBytes: cacheSizeBytes, lru: lru.NewLRU(), sizeBytes: 0}, nil
+	return &DiskCache{db: db, maxSizeBytes: cacheSizeBytes, policy: policy, sizeBytes: 0, lruSyncInterval: lruSyncInterval, stopLRUSync: make(chan struct{}), lruSyncDone: make(chan struct{})}, nil
 }
 
-// ResetAfterRestart rebuilds the LRU with an arbirtrary order and sets sizeBytes. This seems crazy, but it is better than doing nothing, sice gc is based on the LRU and sizeBytes. In the future, we may want to periodically sync the LRU to disk, but we'll still need to iterate over all keys in the disk DB to avoid orphaning objects.
-// Note: this assumes the LRU is empty. Don't run twice
+// ResetAfterRestart rebuilds the LRU and sets sizeBytes from the objects on disk, in the background. The LRU is ordered by the order last persisted by syncLRU; objects without a persisted position, e.g. those added after the last sync before a crash, are the least recently used. All keys in the disk DB are iterated, to avoid orphaning objects.
+// After the LRU is rebuilt, its order is persisted every lruSyncInterval, until Close. Syncing doesn't start until the rebuild is done, so a partial LRU never overwrites the persisted order.
+// Note: this must be called exactly once.
 func (c *DiskCache) ResetAfterRestart() {
-	go c.db.View(func(tx *bolt.Tx) error {
-		log.Infof("Starting cache recovery from disk for: %s... ", c.db.Path())
-		size := 0
-		b := tx.Bucket([]byte(BucketName))
+	atomic.StoreInt32(&c.lruSyncStarted, 1)
+	go func() {
+		c.restoreLRU()
+		c.syncLRULoop()
+	}()
+}
 
+// restoreLRU adds all objects on disk to the LRU, in their persisted order.
+func (c *DiskCache) restoreLRU() {
+	log.Infof("Starting cache recovery from disk for: %s... ", c.db.Path())
+	type keySize struct {
+		key  string
+		size uint64
+		pos  uint64
+		has  bool
+	}
+	keys := []keySize{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketName))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		lruBucket := tx.Bucket([]byte(LRUBucketName)) // may be nil, if the LRU was never synced
 		cursor := b.Cursor()
-
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			c.lru.Add(string(k), uint64(len(v)))
-			size += len(v)
+			ks := keySize{key: string(k), size: uint64(len(v))}
+			if lruBucket != nil {
+				if posBytes := lruBucket.Get(k); len(posBytes) == 8 {
+					ks.pos = binary.BigEndian.Uint64(posBytes)
+					ks.has = true
+				}
+			}
+			keys = append(keys, ks)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorln("DiskCache.restoreLRU reading '" + c.db.Path() + "': " + err.Error())
+	}
+
+	// sort newest first, with unpositioned keys last, because they're added oldest-last
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].has != keys[j].has {
+			return keys[i].has
+		}
+		return keys[i].pos > keys[j].pos
+	})
+
+	size := uint64(0)
+	for _, ks := range keys {
+		if c.policy.AddOldest(ks.key, ks.size) {
+			size += ks.size
+		}
+	}
+
+	atomic.AddUint64(&c.sizeBytes, size)
+	log.Infof("Cache recovery from disk for %s done (%d bytes). ", c.db.Path(), c.Size())
+}
+
+// syncLRULoop persists the LRU order every lruSyncInterval, until Close.
+func (c *DiskCache) syncLRULoop() {
+	defer close(c.lruSyncDone)
+	if c.lruSyncInterval <= 0 {
+		<-c.stopLRUSync
+		return
+	}
+	ticker := time.NewTicker(c.lruSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopLRUSync:
+			c.syncLRU()
+			return
+		case <-ticker.C:
+			c.syncLRU()
 		}
+	}
+}
 
-		atomic.AddUint64(&c.sizeBytes, uint64(size))
-		log.Infof("Cache recovery from disk for %s done (%d bytes). ", c.db.Path(), c.sizeBytes)
+// syncLRU persists the current LRU order to the LRUBucketName bucket, replacing the previous order.
+func (c *DiskCache) syncLRU() {
+	keys := c.policy.Keys()
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(LRUBucketName)); err != nil && err != bolt.ErrBucketNotFound {
+			return errors.New("deleting bucket: " + err.Error())
+		}
+		b, err := tx.CreateBucket([]byte(LRUBucketName))
+		if err != nil {
+			return errors.New("creating bucket: " + err.Error())
+		}
+		for i, key := range keys {
+			posBytes := make([]byte, 8)
+			binary.BigEndian.PutUint64(posBytes, uint64(i))
+			if err := b.Put([]byte(key), posBytes); err != nil {
+				return errors.New("putting '" + key + "': " + err.Error())
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		log.Errorln("DiskCache.syncLRU persisting LRU order to '" + c.db.Path() + "': " + err.Error())
+		return
+	}
+	log.Debugf("DiskCache.syncLRU persisted %v keys to '%v'\n", len(keys), c.db.Path())
 }
 
 // Add takes a key and value to add. Returns whether an eviction occurred
@@ -106,9 +216,9 @@ func (c *DiskCache) Add(key string, val *cacheobj.CacheObj) bool {
 		return eviction
 	}
 
-	c.lru.Add(key, uint64(len(valBytes)))
+	oldSizeBytes := c.policy.Add(key, uint64(len(valBytes)))
 
-	newSizeBytes := atomic.AddUint64(&c.sizeBytes, uint64(len(valBytes)))
+	newSizeBytes := atomic.AddUint64(&c.sizeBytes, uint64(len(valBytes))-oldSizeBytes) // replacing an object replaces its size; unsigned overflow wraps to subtract if it shrank
 	if newSizeBytes > c.maxSizeBytes {
 		go c.gc(newSizeBytes)
 	}
@@ -122,7 +232,7 @@ func (c *DiskCache) Add(key string, val *cacheobj.CacheObj) bool {
 func (c *DiskCache) gc(cacheSizeBytes uint64) {
 	for cacheSizeBytes > c.maxSizeBytes {
 		log.Debugf("DiskCache.gc cacheSizeBytes %+v > c.maxSizeBytes %+v\n", cacheSizeBytes, c.maxSizeBytes)
-		key, sizeBytes, exists := c.lru.RemoveOldest() // TODO change lru to use strings
+		key, sizeBytes, exists := c.policy.RemoveOldest() // TODO change lru to use strings
 		if !exists {
 			// should never happen
 			log.Errorf("sizeBytes %v > %v maxSizeBytes, but LRU is empty!? Setting cache size to 0!\n", cacheSizeBytes, c.maxSizeBytes)
@@ -152,11 +262,12 @@ func (c *DiskCache) gc(cacheSizeBytes uint64) {
 func (c *DiskCache) Get(key string) (*cacheobj.CacheObj, bool) {
 	val, found := c.Peek(key)
 	if found {
-		// TODO JvD check to see if val.Size is good to use here.
-		c.lru.Add(key, val.Size) // TODO directly call c.ll.MoveToFront
+		c.policy.Touch(key)
+		atomic.AddUint64(&c.hits, 1)
 		log.Debugln("DiskCache.Get getting '" + key + "' from cache and updating LRU")
 		return val, true
 	}
+	atomic.AddUint64(&c.misses, 1)
 	return nil, false
 
 }
@@ -187,7 +298,9 @@ func (c *DiskCache) Peek(key string) (*cacheobj.CacheObj, bool) {
 	buf := bytes.NewBuffer(valBytes)
 	val := cacheobj.CacheObj{}
 	if err := gob.NewDecoder(buf).Decode(&val); err != nil {
-		log.Errorln("DiskCache.Peek decoding '" + key + "' from cache: " + err.Error())
+		// The record is corrupt, and will never decode. Remove it, so it's fetched and cached again, rather than failing on every request.
+		log.Errorln("DiskCache.Peek decoding '" + key + "' from cache, removing: " + err.Error())
+		c.Remove(key)
 		return nil, false
 	}
 
@@ -195,19 +308,58 @@ func (c *DiskCache) Peek(key string) (*cacheobj.CacheObj, bool) {
 	return &val, true
 }
 
+// Remove removes the key from the cache, returning whether it existed.
+func (c *DiskCache) Remove(key string) bool {
+	existed := false
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketName))
+		if b == nil {
+			return errors.New("bucket does not exist")
+		}
+		existed = b.Get([]byte(key)) != nil
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		log.Errorln("DiskCache.Remove removing '" + key + "' from cache: " + err.Error())
+		return false
+	}
+	if sizeBytes, ok := c.policy.Remove(key); ok {
+		atomic.AddUint64(&c.sizeBytes, ^uint64(sizeBytes-1)) // subtract sizeBytes
+	}
+	return existed
+}
+
 func (c *DiskCache) Size() uint64 {
 	return atomic.LoadUint64(&c.sizeBytes)
 }
 
+// Close persists the LRU order, if it's being synced, and closes the disk DB.
 func (c *DiskCache) Close() {
-	c.db.Close()
+	c.closeOnce.Do(func() {
+		close(c.stopLRUSync)
+		if atomic.LoadInt32(&c.lruSyncStarted) == 1 {
+			select {
+			case <-c.lruSyncDone:
+			case <-time.After(closeSyncTimeout):
+				log.Errorln("DiskCache.Close timed out waiting for the LRU to be restored and persisted for '" + c.db.Path() + "', closing")
+			}
+		}
+		c.db.Close()
+	})
 }
 
+// closeSyncTimeout is how long Close waits for the final LRU sync, or for an unfinished restore, before closing without it.
+const closeSyncTimeout = 10 * time.Second
+
 func (c *DiskCache) Keys() []string {
-	return c.lru.Keys()
+	return c.policy.Keys()
 
 }
 
 func (c *DiskCache) Capacity() uint64 {
 	return c.maxSizeBytes
 }
+
+func (c *DiskCache) PolicyStats() icache.PolicyStats {
+	return icache.PolicyStats{Policy: c.policy.Name(), Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+}
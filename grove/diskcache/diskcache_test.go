@@ -0,0 +1,116 @@
+package diskcache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+func newTestObj(body string) *cacheobj.CacheObj {
+	now := time.Now()
+	return cacheobj.New(http.Header{}, []byte(body), http.StatusOK, http.StatusOK, "", http.Header{}, now, now, now, now)
+}
+
+// openRestored opens the DiskCache at the given path, and waits for its LRU to be restored with the given number of keys.
+func openRestored(t *testing.T, path string, numKeys int) *DiskCache {
+	c, err := New(path, 1024*1024, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("New error expected nil, actual %v", err)
+	}
+	c.ResetAfterRestart()
+	for i := 0; i < 100 && len(c.Keys()) < numKeys; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c
+}
+
+func TestDiskCacheRestoreLRUOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grove-diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.db")
+
+	c := openRestored(t, path, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Add(key, newTestObj("body of "+key))
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("Get expected found, actual not found")
+	}
+	size := c.Size()
+	c.Close() // persists the order
+
+	c = openRestored(t, path, 3)
+	defer c.Close()
+	if expected, actual := []string{"b", "c", "a"}, c.Keys(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("restored LRU order expected %v, actual %v", expected, actual)
+	}
+	if actual := c.Size(); actual != size {
+		t.Errorf("restored size expected %v, actual %v", size, actual)
+	}
+
+	c.Add("d", newTestObj("body of d replaced"))
+	c.Add("d", newTestObj("body of d"))
+	c.Remove("d")
+	if actual := c.Size(); actual != size {
+		t.Errorf("size after replacing and removing object expected %v, actual %v", size, actual)
+	}
+}
+
+func TestDiskCacheRemovesCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grove-diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := openRestored(t, filepath.Join(dir, "cache.db"), 0)
+	defer c.Close()
+	c.Add("a", newTestObj("body of a"))
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(BucketName)).Put([]byte("a"), []byte("not a gob"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Get corrupt object expected not found, actual found")
+	}
+	if keys := c.Keys(); len(keys) != 0 {
+		t.Errorf("keys after getting corrupt object expected none, actual %v", keys)
+	}
+	if actual := c.Size(); actual != 0 {
+		t.Errorf("size after getting corrupt object expected 0, actual %v", actual)
+	}
+	c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(BucketName)).Get([]byte("a")); v != nil {
+			t.Errorf("corrupt object expected removed from disk, actual %q", v)
+		}
+		return nil
+	})
+}
@@ -16,9 +16,12 @@ package diskcache
 
 import (
 	"errors"
+	"time"
 
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/config"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/lru"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
 
@@ -28,10 +31,15 @@ import (
 // MultiDiskCache is a disk cache using multiple files. It exists primarily to allow caching across multiple physical disks, but may be used for other purposes. For example, it may be more performant to use multiple files, or it may be advantageous to keep each remap rule in its own file. Keys are evenly distributed across the given files via consistent hashing.
 type MultiDiskCache []*DiskCache
 
-func NewMulti(files []config.CacheFile) (*MultiDiskCache, error) {
+// NewMulti creates a MultiDiskCache of the given files, restoring each file's LRU, and persisting it every lruSyncInterval. See DiskCache.ResetAfterRestart. Each file has its own instance of the given eviction policy.
+func NewMulti(files []config.CacheFile, lruSyncInterval time.Duration, policy config.CachePolicy) (*MultiDiskCache, error) {
 	caches := make([]*DiskCache, len(files), len(files))
 	for i, file := range files {
-		cache, err := New(file.Path, file.Bytes)
+		filePolicy, err := lru.NewPolicy(policy.Eviction, policy.Admission)
+		if err != nil {
+			return nil, errors.New("creating disk cache '" + file.Path + "' policy: " + err.Error())
+		}
+		cache, err := New(file.Path, file.Bytes, lruSyncInterval, filePolicy)
 		if err != nil {
 			return nil, errors.New("creating disk cache '" + file.Path + "': " + err.Error())
 		}
@@ -66,6 +74,12 @@ func (c *MultiDiskCache) Peek(key string) (*cacheobj.CacheObj, bool) {
 	return (*c)[i].Peek(key)
 }
 
+func (c *MultiDiskCache) Remove(key string) bool {
+	i := c.keyIdx(key)
+	log.Debugf("MultiDiskCache.Remove key '%+v' mapped to %+v\n", key, i)
+	return (*c)[i].Remove(key)
+}
+
 func (c *MultiDiskCache) Size() uint64 {
 	sum := uint64(0)
 	for _, cache := range *c {
@@ -96,3 +110,15 @@ func (c *MultiDiskCache) Capacity() uint64 {
 	}
 	return sum
 }
+
+// PolicyStats returns the policy of the files, and the sum of their hits and misses.
+func (c *MultiDiskCache) PolicyStats() icache.PolicyStats {
+	stats := icache.PolicyStats{}
+	for _, cache := range *c {
+		cacheStats := cache.PolicyStats()
+		stats.Policy = cacheStats.Policy
+		stats.Hits += cacheStats.Hits
+		stats.Misses += cacheStats.Misses
+	}
+	return stats
+}
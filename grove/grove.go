@@ -20,6 +20,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -29,6 +30,7 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -40,6 +42,7 @@ import (
 	"github.com/apache/incubator-trafficcontrol/grove/config"
 	"github.com/apache/incubator-trafficcontrol/grove/diskcache"
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/lru"
 	"github.com/apache/incubator-trafficcontrol/grove/memcache"
 	"github.com/apache/incubator-trafficcontrol/grove/plugin"
 	"github.com/apache/incubator-trafficcontrol/grove/remap"
@@ -81,7 +84,7 @@ func main() {
 	}
 	log.Init(eventW, errW, warnW, infoW, debugW)
 
-	caches, err := createCaches(cfg.CacheFiles, uint64(cfg.FileMemBytes), uint64(cfg.CacheSizeBytes))
+	caches, err := createCaches(cfg.CacheFiles, uint64(cfg.FileMemBytes), uint64(cfg.CacheSizeBytes), time.Duration(cfg.FileLRUSyncMS)*time.Millisecond, cfg.CachePolicies, cfg.MemCacheShards)
 	if err != nil {
 		log.Errorln("starting service: creating caches: " + err.Error())
 		os.Exit(1)
@@ -99,20 +102,18 @@ func main() {
 		log.Errorf("starting service: loading remap rules: %v\n", err)
 		os.Exit(1)
 	}
+	remapper.StartHealthChecks()
 
-	certs, err := loadCerts(remapper.Rules())
-	if err != nil {
-		log.Errorf("starting service: loading certificates: %v\n", err)
-		os.Exit(1)
-	}
-	defaultCert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
-	if err != nil {
-		log.Errorf("starting service: loading default certificate: %v\n", err)
-		os.Exit(1)
+	certs := (*web.CertStore)(nil)
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		if certs, err = loadCertStore(remapper.Rules(), cfg); err != nil {
+			log.Errorf("starting service: loading certificates: %v\n", err)
+			os.Exit(1)
+		}
 	}
-	certs = append(certs, defaultCert)
 
-	httpListener, httpConns, httpConnStateCallback, err := web.InterceptListen("tcp", fmt.Sprintf(":%d", cfg.Port))
+	connLimiter := web.NewConnLimiter(cfg.MaxConnsPerClientIP)
+	httpListener, httpConns, httpConnStateCallback, err := web.InterceptListen("tcp", fmt.Sprintf(":%d", cfg.Port), connLimiter)
 	if err != nil {
 		log.Errorf("creating HTTP listener %v: %v\n", cfg.Port, err)
 		os.Exit(1)
@@ -124,14 +125,17 @@ func main() {
 	httpsConnStateCallback := (func(net.Conn, http.ConnState))(nil)
 	tlsConfig := (*tls.Config)(nil)
 	if cfg.CertFile != "" && cfg.KeyFile != "" {
-		if httpsListener, httpsConns, httpsConnStateCallback, tlsConfig, err = web.InterceptListenTLS("tcp", fmt.Sprintf(":%d", cfg.HTTPSPort), certs); err != nil {
+		if httpsListener, httpsConns, httpsConnStateCallback, tlsConfig, err = web.InterceptListenTLS("tcp", fmt.Sprintf(":%d", cfg.HTTPSPort), certs, connLimiter); err != nil {
 			log.Errorf("creating HTTPS listener %v: %v\n", cfg.HTTPSPort, err)
 			return
 		}
 	}
 
 	// TODO pass total size for all file groups?
-	stats := stat.New(remapper.Rules(), caches, uint64(cfg.CacheSizeBytes), httpConns, httpsConns, Version)
+	statsSystem := stat.NewStatsSystem(Version)
+	stats := stat.New(remapper.Rules(), caches, uint64(cfg.CacheSizeBytes), httpConns, httpsConns, statsSystem)
+
+	warmer := cache.NewWarmer()
 
 	buildHandler := func(scheme string, port string, conns *web.ConnMap, stats stat.Stats, pluginContext map[string]*interface{}) *cache.HandlerPointer {
 		return cache.NewHandlerPointer(cache.NewHandler(
@@ -148,6 +152,8 @@ func main() {
 			httpConns,
 			httpsConns,
 			cfg.InterfaceName,
+			cfg.MaxCacheableObjectBytes,
+			warmer,
 		))
 	}
 
@@ -171,6 +177,8 @@ func main() {
 
 	reloadConfig := func() {
 		log.Infoln("reloading config")
+		statsSystem.AddConfigReloadRequests()
+		statsSystem.SetLastReloadRequest(time.Now())
 		err := error(nil)
 		oldCfg := cfg
 		cfg, err = config.LoadConfig(*configFileName)
@@ -200,25 +208,36 @@ func main() {
 			remapper = oldRemapper
 			return
 		}
+		oldRemapper.StopHealthChecks()
+		remapper.StartHealthChecks()
+
+		if cfg.CertFile != "" && cfg.KeyFile != "" {
+			if certs == nil {
+				certs, err = loadCertStore(remapper.Rules(), cfg)
+			} else {
+				err = reloadCertStore(certs, remapper.Rules(), cfg)
+			}
+			if err != nil {
+				log.Errorln("reloading config: failed to load certificates, keeping existing certificates: " + err.Error())
+			}
+		}
+
+		connLimiter.SetMax(cfg.MaxConnsPerClientIP)
 
 		if cfg.Port != oldCfg.Port {
-			if httpListener, httpConns, httpConnStateCallback, err = web.InterceptListen("tcp", fmt.Sprintf(":%d", cfg.Port)); err != nil {
+			if httpListener, httpConns, httpConnStateCallback, err = web.InterceptListen("tcp", fmt.Sprintf(":%d", cfg.Port), connLimiter); err != nil {
 				log.Errorf("reloading config: creating HTTP listener %v: %v\n", cfg.Port, err)
 				return
 			}
 		}
 
-		if (cfg.CertFile != oldCfg.CertFile || cfg.KeyFile != oldCfg.KeyFile) && cfg.HTTPSPort != oldCfg.HTTPSPort {
-			log.Warnln("config certificate changed, but port did not. Cannot recreate listener on same port without stopping the service. Restart the service to load the new certificate.")
-		}
-
-		if cfg.HTTPSPort != oldCfg.HTTPSPort {
-			if httpsListener, httpsConns, httpsConnStateCallback, tlsConfig, err = web.InterceptListenTLS("tcp", fmt.Sprintf(":%d", cfg.HTTPSPort), certs); err != nil {
+		if certs != nil && (httpsListener == nil || cfg.HTTPSPort != oldCfg.HTTPSPort) {
+			if httpsListener, httpsConns, httpsConnStateCallback, tlsConfig, err = web.InterceptListenTLS("tcp", fmt.Sprintf(":%d", cfg.HTTPSPort), certs, connLimiter); err != nil {
 				log.Errorf("creating HTTPS listener %v: %v\n", cfg.HTTPSPort, err)
 			}
 		}
 
-		stats = stat.New(remapper.Rules(), caches, uint64(cfg.CacheSizeBytes), httpConns, httpsConns, Version) // TODO copy stats from old stats object?
+		stats = stat.New(remapper.Rules(), caches, uint64(cfg.CacheSizeBytes), httpConns, httpsConns, statsSystem) // TODO copy stats from old stats object?
 
 		httpCacheHandler := cache.NewHandler(
 			remapper,
@@ -234,6 +253,8 @@ func main() {
 			httpConns,
 			httpsConns,
 			cfg.InterfaceName,
+			cfg.MaxCacheableObjectBytes,
+			warmer,
 		)
 		httpHandler.Set(httpCacheHandler)
 
@@ -251,8 +272,12 @@ func main() {
 			httpConns,
 			httpsConns,
 			cfg.InterfaceName,
+			cfg.MaxCacheableObjectBytes,
+			warmer,
 		)
 		httpsHandler.Set(httpsCacheHandler)
+		statsSystem.AddConfigReload()
+		statsSystem.SetLastReload(time.Now())
 
 		if cfg.Port != oldCfg.Port {
 			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
@@ -290,9 +315,34 @@ func main() {
 	if *pprof {
 		profile()
 	}
+
+	// The PID file is written once Grove is serving, so it never names a process which failed to start, and removed when Grove is stopped, so it never names a process which has since exited.
+	if cfg.PIDFile != "" {
+		removeOnSignal(cfg.PIDFile, os.Interrupt, syscall.SIGTERM)
+		if err := ioutil.WriteFile(cfg.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			log.Errorln("starting service: writing pid file: " + err.Error())
+			os.Exit(1)
+		}
+	}
 	signalReloader(unix.SIGHUP, reloadConfig)
 }
 
+// removeOnSignal removes the given file when any of the given signals is received, and then terminates the process as that signal would have.
+func removeOnSignal(path string, sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		sig := <-c
+		if err := os.Remove(path); err != nil {
+			log.Errorf("removing '%v' on signal %v: %v\n", path, sig, err)
+		}
+		signal.Reset(sigs...)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+	}()
+}
+
 func profile() {
 	go func() {
 		count := 0
@@ -372,22 +422,79 @@ func loadCerts(rules []remapdata.RemapRule) ([]tls.Certificate, error) {
 	return certs, nil
 }
 
-// createCaches creates the caches specified in the config. The nameFiles is the map of names to groups of files, nameMemBytes is the amount of memory to use for each named group, and memCacheBytes is the amount of memory to use for the default memory cache.
-func createCaches(nameFiles map[string][]config.CacheFile, nameMemBytes uint64, memCacheBytes uint64) (map[string]icache.Cache, error) {
+// loadCertStore loads the certificates of the given rules, and the default certificate of the config, into a new CertStore.
+func loadCertStore(rules []remapdata.RemapRule, cfg config.Config) (*web.CertStore, error) {
+	certs, defaultCert, err := loadAllCerts(rules, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return web.NewCertStore(certs, defaultCert)
+}
+
+// reloadCertStore loads the certificates of the given rules, and the default certificate of the config, into the given CertStore. New HTTPS connections get the new certificates, and existing connections are unaffected. If any certificate fails to load, the CertStore is unchanged.
+func reloadCertStore(store *web.CertStore, rules []remapdata.RemapRule, cfg config.Config) error {
+	certs, defaultCert, err := loadAllCerts(rules, cfg)
+	if err != nil {
+		return err
+	}
+	return store.Set(certs, defaultCert)
+}
+
+func loadAllCerts(rules []remapdata.RemapRule, cfg config.Config) ([]tls.Certificate, tls.Certificate, error) {
+	certs, err := loadCerts(rules)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	defaultCert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, tls.Certificate{}, errors.New("loading default certificate: " + err.Error())
+	}
+	return certs, defaultCert, nil
+}
+
+// createCaches creates the caches specified in the config. The nameFiles is the map of names to groups of files, nameMemBytes is the amount of memory to use for each named group, memCacheBytes is the amount of memory to use for the default memory cache, fileLRUSyncInterval is how often to persist the LRU order of each file, policies is the eviction policy of each name, and memShards is the number of shards of each memory cache.
+func createCaches(nameFiles map[string][]config.CacheFile, nameMemBytes uint64, memCacheBytes uint64, fileLRUSyncInterval time.Duration, policies map[string]config.CachePolicy, memShards int) (map[string]icache.Cache, error) {
+	for name := range policies {
+		if _, ok := nameFiles[name]; !ok && name != "" {
+			return nil, errors.New("cache policy for cache '" + name + "', which isn't in cache_files")
+		}
+	}
+
 	caches := map[string]icache.Cache{}
-	caches[""] = memcache.New(memCacheBytes) // default empty names to the mem cache
+	memCache, err := newMemCache(memCacheBytes, policies[""], memShards)
+	if err != nil {
+		return nil, errors.New("creating memory cache policy: " + err.Error())
+	}
+	caches[""] = memCache // default empty names to the mem cache
 
 	for name, files := range nameFiles {
-		multiDiskCache, err := diskcache.NewMulti(files)
+		multiDiskCache, err := diskcache.NewMulti(files, fileLRUSyncInterval, policies[name])
 		if err != nil {
 			return nil, errors.New("creating cache '" + name + "': " + err.Error())
 		}
-		caches[name] = tiercache.New(memcache.New(nameMemBytes), multiDiskCache)
+		nameMemCache, err := newMemCache(nameMemBytes, policies[name], memShards)
+		if err != nil {
+			return nil, errors.New("creating cache '" + name + "' memory policy: " + err.Error())
+		}
+		caches[name] = tiercache.New(nameMemCache, multiDiskCache)
 	}
 
 	return caches, nil
 }
 
+// newMemCache creates a memory cache of the given size and policy. If shards is greater than 1, the cache is a ShardedMemCache, with the policy in each shard.
+func newMemCache(bytes uint64, policy config.CachePolicy, shards int) (icache.Cache, error) {
+	newPolicy := func() (lru.Policy, error) { return lru.NewPolicy(policy.Eviction, policy.Admission) }
+	if shards > 1 {
+		return memcache.NewSharded(bytes, shards, newPolicy)
+	}
+	memPolicy, err := newPolicy()
+	if err != nil {
+		return nil, err
+	}
+	return memcache.New(bytes, memPolicy), nil
+}
+
 func cachesChanged(oldCfg, newCfg config.Config) bool {
 	return oldCfg.FileMemBytes == newCfg.FileMemBytes &&
 		oldCfg.CacheSizeBytes != newCfg.CacheSizeBytes &&
@@ -0,0 +1,154 @@
+package main
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+const diffNoValue = "(none)"
+
+// DiffRemapRules returns the human-readable differences between the old and new remap rules JSON. Rules are matched by name, and their "to" parents are compared without regard to order. Added rules are prefixed with "+", removed rules with "-", and changed global or rule values with "~", followed by the old and new values. If oldJSON is empty, as when no remap file exists yet, all rules are reported as added.
+func DiffRemapRules(oldJSON []byte, newJSON []byte) ([]string, error) {
+	oldRules, oldGlobal, err := decodeDiffRules(oldJSON)
+	if err != nil {
+		return nil, errors.New("decoding old rules: " + err.Error())
+	}
+	newRules, newGlobal, err := decodeDiffRules(newJSON)
+	if err != nil {
+		return nil, errors.New("decoding new rules: " + err.Error())
+	}
+
+	diff := []string{}
+	for _, key := range diffKeys(oldGlobal, newGlobal) {
+		diff = append(diff, "~ global "+key+": "+diffValue(oldGlobal, key)+" -> "+diffValue(newGlobal, key))
+	}
+
+	names := map[string]struct{}{}
+	for name := range oldRules {
+		names[name] = struct{}{}
+	}
+	for name := range newRules {
+		names[name] = struct{}{}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		oldRule, inOld := oldRules[name]
+		newRule, inNew := newRules[name]
+		switch {
+		case !inOld:
+			diff = append(diff, "+ rule "+name)
+		case !inNew:
+			diff = append(diff, "- rule "+name)
+		default:
+			for _, key := range diffKeys(oldRule, newRule) {
+				diff = append(diff, "~ rule "+name+" "+key+": "+diffValue(oldRule, key)+" -> "+diffValue(newRule, key))
+			}
+		}
+	}
+	return diff, nil
+}
+
+// decodeDiffRules decodes the given remap rules JSON into its rules by name, and its global values other than the rules.
+func decodeDiffRules(bts []byte) (map[string]map[string]interface{}, map[string]interface{}, error) {
+	if len(bts) == 0 {
+		return map[string]map[string]interface{}{}, map[string]interface{}{}, nil
+	}
+	global := map[string]interface{}{}
+	if err := json.Unmarshal(bts, &global); err != nil {
+		return nil, nil, err
+	}
+	rulesI := global["rules"]
+	delete(global, "rules")
+
+	rules := map[string]map[string]interface{}{}
+	rulesArr, _ := rulesI.([]interface{})
+	for i, ruleI := range rulesArr {
+		rule, ok := ruleI.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("rule %v is not an object", i)
+		}
+		name, _ := rule["name"].(string)
+		if _, ok := rules[name]; ok {
+			return nil, nil, errors.New("duplicate rule name '" + name + "'")
+		}
+		if to, ok := rule["to"].([]interface{}); ok {
+			sortDiffValues(to) // parents are unordered, so a reordering isn't a change
+		}
+		rules[name] = rule
+	}
+	return rules, global, nil
+}
+
+// diffKeys returns the sorted keys whose values differ between a and b, including keys only in one of them.
+func diffKeys(a map[string]interface{}, b map[string]interface{}) []string {
+	keys := []string{}
+	for key, av := range a {
+		if bv, ok := b[key]; !ok || !reflect.DeepEqual(av, bv) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffValue returns the compact JSON of the given key's value, or diffNoValue if the key doesn't exist.
+func diffValue(m map[string]interface{}, key string) string {
+	v, ok := m[key]
+	if !ok {
+		return diffNoValue
+	}
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bts)
+}
+
+// sortDiffValues sorts the given values by their compact JSON.
+func sortDiffValues(vals []interface{}) {
+	keys := make([]string, len(vals))
+	for i, v := range vals {
+		bts, _ := json.Marshal(v) // values were decoded from JSON, so they always encode
+		keys[i] = string(bts)
+	}
+	sort.Sort(diffValuesByKey{keys: keys, vals: vals})
+}
+
+type diffValuesByKey struct {
+	keys []string
+	vals []interface{}
+}
+
+func (s diffValuesByKey) Len() int           { return len(s.keys) }
+func (s diffValuesByKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s diffValuesByKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.vals[i], s.vals[j] = s.vals[j], s.vals[i]
+}
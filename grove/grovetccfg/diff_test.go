@@ -0,0 +1,112 @@
+package main
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRemapRules(t *testing.T) {
+	oldJSON := []byte(`{
+  "retry_num": 5,
+  "timeout_ms": 5000,
+  "rules": [
+    {"name": "removed", "from": "http://removed.example.net"},
+    {"name": "same", "from": "http://same.example.net", "to": [{"url": "http://origin.example.net", "proxy_url": "http://mid0.example.net"}]},
+    {"name": "changed", "from": "http://changed.example.net", "retry_num": 5}
+  ]
+}`)
+	newJSON := []byte(`{
+  "retry_num": 3,
+  "timeout_ms": 5000,
+  "stats": {"allow": ["127.0.0.1/32"]},
+  "rules": [
+    {"name": "same", "from": "http://same.example.net", "to": [{"url": "http://origin.example.net", "proxy_url": "http://mid0.example.net"}]},
+    {"name": "changed", "from": "http://changed.example.net", "retry_num": 3, "timeout_ms": 1000},
+    {"name": "added", "from": "http://added.example.net"}
+  ]
+}`)
+
+	diff, err := DiffRemapRules(oldJSON, newJSON)
+	if err != nil {
+		t.Fatalf("DiffRemapRules: %v", err)
+	}
+	expected := []string{
+		`~ global retry_num: 5 -> 3`,
+		`~ global stats: (none) -> {"allow":["127.0.0.1/32"]}`,
+		`+ rule added`,
+		`~ rule changed retry_num: 5 -> 3`,
+		`~ rule changed timeout_ms: (none) -> 1000`,
+		`- rule removed`,
+	}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Errorf("DiffRemapRules expected %q, actual %q", expected, diff)
+	}
+}
+
+func TestDiffRemapRulesEmptyOld(t *testing.T) {
+	newJSON := []byte(`{"retry_num": 5, "rules": [{"name": "b"}, {"name": "a"}]}`)
+	diff, err := DiffRemapRules(nil, newJSON)
+	if err != nil {
+		t.Fatalf("DiffRemapRules: %v", err)
+	}
+	expected := []string{`~ global retry_num: (none) -> 5`, `+ rule a`, `+ rule b`}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Errorf("DiffRemapRules expected %q, actual %q", expected, diff)
+	}
+}
+
+func TestDiffRemapRulesUnchanged(t *testing.T) {
+	rulesJSON := []byte(`{"retry_num": 5, "rules": [{"name": "a", "from": "http://a.example.net"}]}`)
+	diff, err := DiffRemapRules(rulesJSON, rulesJSON)
+	if err != nil {
+		t.Fatalf("DiffRemapRules: %v", err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("DiffRemapRules of identical rules expected no differences, actual %q", diff)
+	}
+}
+
+func TestDiffRemapRulesParentOrder(t *testing.T) {
+	oldJSON := []byte(`{"rules": [{"name": "a", "to": [{"proxy_url": "http://mid0.example.net"}, {"proxy_url": "http://mid1.example.net"}]}]}`)
+	reordered := []byte(`{"rules": [{"name": "a", "to": [{"proxy_url": "http://mid1.example.net"}, {"proxy_url": "http://mid0.example.net"}]}]}`)
+	diff, err := DiffRemapRules(oldJSON, reordered)
+	if err != nil {
+		t.Fatalf("DiffRemapRules: %v", err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("DiffRemapRules of reordered parents expected no differences, actual %q", diff)
+	}
+
+	changed := []byte(`{"rules": [{"name": "a", "to": [{"proxy_url": "http://mid1.example.net"}, {"proxy_url": "http://mid2.example.net"}]}]}`)
+	diff, err = DiffRemapRules(oldJSON, changed)
+	if err != nil {
+		t.Fatalf("DiffRemapRules: %v", err)
+	}
+	expected := []string{`~ rule a to: [{"proxy_url":"http://mid0.example.net"},{"proxy_url":"http://mid1.example.net"}] -> [{"proxy_url":"http://mid1.example.net"},{"proxy_url":"http://mid2.example.net"}]`}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Errorf("DiffRemapRules expected %q, actual %q", expected, diff)
+	}
+}
+
+func TestDiffRemapRulesInvalid(t *testing.T) {
+	if _, err := DiffRemapRules([]byte(`{"rules": [`), []byte(`{}`)); err == nil {
+		t.Errorf("DiffRemapRules of invalid old JSON expected error, actual nil")
+	}
+	if _, err := DiffRemapRules([]byte(`{}`), []byte(`{"rules": [{"name": "a"}, {"name": "a"}]}`)); err == nil {
+		t.Errorf("DiffRemapRules of duplicate rule names expected error, actual nil")
+	}
+}
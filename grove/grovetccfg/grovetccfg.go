@@ -15,6 +15,7 @@ package main
 */
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
@@ -28,8 +29,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-tc"
@@ -37,6 +41,8 @@ import (
 	to "github.com/apache/incubator-trafficcontrol/traffic_ops/client"
 
 	"github.com/apache/incubator-trafficcontrol/grove/config"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/plugin"
 	"github.com/apache/incubator-trafficcontrol/grove/remap"
 	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
@@ -50,6 +56,7 @@ const UserAgent = "grove-tc-cfg/" + Version
 const TrafficOpsTimeout = time.Second * 90
 const DefaultCertificateDir = "/etc/grove/ssl"
 const GroveConfigPath = "/etc/grove/grove.cfg"
+const DefaultPollInterval = time.Minute
 
 func AvailableStatuses() map[string]struct{} {
 	return map[string]struct{}{
@@ -58,12 +65,74 @@ func AvailableStatuses() map[string]struct{} {
 	}
 }
 
-func GetRemapPath() (string, error) {
+// GetGroveConfig loads the Grove config file at GroveConfigPath.
+func GetGroveConfig() (config.Config, error) {
 	cfg, err := config.LoadConfig(GroveConfigPath)
 	if err != nil {
-		return "", errors.New("loading Grove config file: " + err.Error())
+		return config.Config{}, errors.New("loading Grove config file: " + err.Error())
 	}
-	return cfg.RemapRulesFile, nil
+	return cfg, nil
+}
+
+// ValidateRemapFile loads the remap rules file at the given path the same way Grove does, returning any error Grove would fail to load it with. The caches and transport aren't used, only checked to exist, so none are created.
+func ValidateRemapFile(path string, cfg config.Config) error {
+	caches := map[string]icache.Cache{"": nil}
+	for name := range cfg.CacheFiles {
+		caches[name] = nil
+	}
+	transport := remap.NewRemappingTransport(
+		time.Duration(cfg.ReqTimeoutMS)*time.Millisecond,
+		time.Duration(cfg.ReqKeepAliveMS)*time.Millisecond,
+		cfg.ReqMaxIdleConns,
+		time.Duration(cfg.ReqIdleConnTimeoutMS)*time.Millisecond,
+	)
+	if _, _, _, err := remap.LoadRemapRules(path, plugin.Get().LoadFuncs(), caches, transport); err != nil {
+		return errors.New("loading remap rules: " + err.Error())
+	}
+	return nil
+}
+
+// ReloadGrove signals the Grove process whose ID is in the given PID file to reload its config. If pidFile is empty, the grove service is reloaded instead.
+func ReloadGrove(pidFile string) error {
+	if pidFile == "" {
+		if err := exec.Command("service", "grove", "reload").Run(); err != nil {
+			return errors.New("reloading grove service: " + err.Error())
+		}
+		return nil
+	}
+	bts, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return errors.New("reading PID file: " + err.Error())
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bts)))
+	if err != nil {
+		return errors.New("parsing PID file '" + pidFile + "': " + err.Error())
+	}
+	if err := checkGroveProcess(pid); err != nil {
+		return errors.New("PID file '" + pidFile + "' is stale: " + err.Error())
+	}
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		return errors.New("signalling Grove process " + strconv.Itoa(pid) + ": " + err.Error())
+	}
+	return nil
+}
+
+// checkGroveProcess returns an error if the given PID isn't a running Grove process, as when Grove was killed without removing its PID file, and the PID may have been reused by another program, which SIGHUP would terminate. Where /proc doesn't exist, only that the process is running is checked.
+func checkGroveProcess(pid int) error {
+	if pid <= 0 {
+		return errors.New("invalid PID " + strconv.Itoa(pid)) // kill(2) signals process groups for these
+	}
+	if err := syscall.Kill(pid, 0); err != nil && err != syscall.EPERM {
+		return errors.New("process " + strconv.Itoa(pid) + " not running: " + err.Error())
+	}
+	comm, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	if err != nil {
+		return nil
+	}
+	if name := strings.TrimSpace(string(comm)); !strings.Contains(name, "grove") {
+		return errors.New("process " + strconv.Itoa(pid) + " is '" + name + "', not grove")
+	}
+	return nil
 }
 
 // CopyAndGzipFile reads the src file, gzips the contents, and writes the result to dst.
@@ -132,14 +201,20 @@ func WriteNewFile(path string, bts []byte) error {
 	return nil
 }
 
-// WriteAndBackup creates a backup of the existing file at the given path, then writes the given bytes to the path. The write is fail-safe on operating systems with atomic file rename (Linux is).
-func WriteAndBackup(path string, bts []byte) error {
-	if err := BackupFile(path); err != nil {
-		return errors.New("backing up file: " + err.Error())
-	}
+// WriteAndBackup writes the given bytes to a new file beside the given path, validates the new file with the given func if it isn't nil, creates a backup of the existing file, then moves the new file to the path. If validation fails, the new file is removed and the existing file is untouched. The write is fail-safe on operating systems with atomic file rename (Linux is).
+func WriteAndBackup(path string, bts []byte, validate func(path string) error) error {
 	if err := WriteNewFile(path, bts); err != nil {
 		return errors.New("writing new file: " + err.Error())
 	}
+	if validate != nil {
+		if err := validate(NewFilename(path)); err != nil {
+			os.Remove(NewFilename(path))
+			return errors.New("validating new file: " + err.Error())
+		}
+	}
+	if err := BackupFile(path); err != nil {
+		return errors.New("backing up file: " + err.Error())
+	}
 	if err := os.Rename(NewFilename(path), path); err != nil {
 		return errors.New("copying new file to real location: " + err.Error())
 	}
@@ -177,141 +252,267 @@ func main() {
 	pretty := flag.Bool("pretty", false, "Whether to pretty-print output")
 	ignoreUpdateFlag := flag.Bool("ignore-update-flag", false, "Whether to fetch and apply the config, without checking or updating the Traffic Ops Update Pending flag")
 	host := flag.String("host", "", "The hostname of the server whose config to generate")
-	// api := flag.String("api", "1.2", "API version. Determines whether to use /api/1.3/configs/ or older, less efficient 1.2 APIs")
+	api := flag.String("api", "1.3", "API version. Determines whether to use the 1.3 /servers/{host}/configs/cache endpoint, or the older, less efficient 1.2 APIs")
 	toInsecure := flag.Bool("insecure", false, "Whether to allow invalid certificates with Traffic Ops")
 	certDir := flag.String("certdir", DefaultCertificateDir, "Directory to save certificates to")
+	daemon := flag.Bool("daemon", false, "Whether to run continuously, checking Traffic Ops for updates every interval, rather than once")
+	interval := flag.Duration("interval", DefaultPollInterval, "How often to check Traffic Ops for updates, in daemon mode")
+	pidFile := flag.String("pidfile", "", "The Grove PID file, used to signal Grove to reload. If empty, the Grove config pid_file is used. If that is also empty, 'service grove reload' is run")
 	flag.Parse()
 
-	useCache := false
-	toc, _, err := to.LoginWithAgent(*toURL, *toUser, *toPass, *toInsecure, UserAgent, useCache, TrafficOpsTimeout)
-	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error connecting to Traffic Ops: " + err.Error())
+	if err := checkAPIVersion(*api); err != nil {
+		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error: " + err.Error())
 		os.Exit(1)
 	}
 
-	revalPendingStatus := false
-	if !*ignoreUpdateFlag {
-		needsUpdate := false
-		needsUpdate, revalPendingStatus, err = hasUpdatePending(toc, *host)
+	update := func() (int, error) {
+		useCache := false
+		toc, _, err := to.LoginWithAgent(*toURL, *toUser, *toPass, *toInsecure, UserAgent, useCache, TrafficOpsTimeout)
+		if err != nil {
+			return 1, errors.New("connecting to Traffic Ops: " + err.Error())
+		}
+		return updateConfig(toc, *host, *api, *certDir, *pretty, *ignoreUpdateFlag, *pidFile)
+	}
+
+	if !*daemon {
+		code, err := update()
+		if err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error " + err.Error())
+		}
+		os.Exit(code)
+	}
+
+	for {
+		// errors are logged and retried at the next interval; the daemon only stops when killed.
+		if _, err := update(); err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error " + err.Error())
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// updateConfig checks the host's update pending flag, and if set, generates the host's remap rules, and if they differ from the current rules, prints the differences, validates the new rules, swaps them in, and reloads Grove. Returns the program exit code and any error: 1 if the config wasn't updated, 2 if it was updated but Grove couldn't be reloaded, and 3 if Grove was reloaded but the update pending flag couldn't be cleared.
+func updateConfig(toc *to.Session, host string, api string, certDir string, pretty bool, ignoreUpdateFlag bool, pidFile string) (int, error) {
+	if !ignoreUpdateFlag {
+		needsUpdate, revalPendingStatus, err := hasUpdatePending(toc, host)
 		if err != nil {
-			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error checking Traffic Ops update pending: " + err.Error())
-			os.Exit(1)
+			return 1, errors.New("checking Traffic Ops update pending: " + err.Error())
 		}
-		if !needsUpdate {
-			os.Exit(0) // if no error and no update necessary, return success and print nothing
+		if !needsUpdate && !revalPendingStatus {
+			return 0, nil // if no error and no update necessary, return success and print nothing
 		}
 	}
 
-	rules := remap.RemapRules{}
-	// if *api == "1.3" {
-	// 	rules, err = createRulesNewAPI(toc, *host, *certDir)
-	// } else {
-	rules, err = createRulesOldAPI(toc, *host, *certDir) // TODO remove once 1.3 / traffic_ops_golang is deployed to production.
-	// }
+	rules, err := createRules(toc, host, api, certDir)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error creating rules: " + err.Error())
-		os.Exit(1)
+		return 1, errors.New("creating rules: " + err.Error())
 	}
 
 	jsonRules, err := remap.RemapRulesToJSON(rules)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error creating JSON Remap Rules: " + err.Error())
-		os.Exit(1)
+		return 1, errors.New("creating JSON Remap Rules: " + err.Error())
 	}
 
 	bts := []byte{}
-	if *pretty {
+	if pretty {
 		bts, err = json.MarshalIndent(jsonRules, "", "  ")
 	} else {
 		bts, err = json.Marshal(jsonRules)
 	}
-
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error marshalling rules JSON: " + err.Error())
-		os.Exit(1)
+		return 1, errors.New("marshalling rules JSON: " + err.Error())
 	}
 
 	// TODO add app/option to print config to stdout
 
-	remapPath, err := GetRemapPath()
+	cfg, err := GetGroveConfig()
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting remap config path: " + err.Error())
-		os.Exit(1)
+		return 1, errors.New("getting Grove config: " + err.Error())
+	}
+	remapPath := cfg.RemapRulesFile
+	if pidFile == "" {
+		pidFile = cfg.PIDFile
 	}
 
-	if err := WriteAndBackup(remapPath, bts); err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error writing new config file: " + err.Error())
-		os.Exit(1)
+	oldBts, err := ioutil.ReadFile(remapPath)
+	if err != nil && !os.IsNotExist(err) {
+		return 1, errors.New("reading current remap config: " + err.Error())
 	}
 
-	if err := exec.Command("service", "grove", "reload").Run(); err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error restarting grove service (but successfully updated config file): " + err.Error())
-		os.Exit(2)
+	if bytes.Equal(oldBts, bts) {
+		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Remap config unchanged, not reloading")
+	} else {
+		if diff, err := DiffRemapRules(oldBts, bts); err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error diffing remap config (continuing with update): " + err.Error())
+		} else {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Remap config changes:")
+			for _, line := range diff {
+				fmt.Println(time.Now().Format(time.RFC3339Nano) + " " + line)
+			}
+		}
+
+		validate := func(path string) error { return ValidateRemapFile(path, cfg) }
+		if err := WriteAndBackup(remapPath, bts, validate); err != nil {
+			return 1, errors.New("writing new config file: " + err.Error())
+		}
+
+		if err := ReloadGrove(pidFile); err != nil {
+			return 2, errors.New("reloading Grove (but successfully updated config file): " + err.Error())
+		}
 	}
 
-	if !*ignoreUpdateFlag {
-		if err := clearUpdatePending(toc, *host, revalPendingStatus); err != nil {
-			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error clearing update pending flag in Traffic Ops (but successfully updated config): " + err.Error())
-			os.Exit(3)
+	if !ignoreUpdateFlag {
+		if err := clearUpdatePending(toc, host, false); err != nil { // the revalidations were applied with the config
+			return 3, errors.New("clearing update pending flag in Traffic Ops (but successfully updated config): " + err.Error())
 		}
 	}
+	return 0, nil
+}
+
+// checkAPIVersion returns an error if the given Traffic Ops API version isn't one rules can be created from.
+func checkAPIVersion(api string) error {
+	if api != "1.2" && api != "1.3" {
+		return errors.New("unsupported API version '" + api + "', must be 1.2 or 1.3")
+	}
+	return nil
+}
 
-	os.Exit(0)
+// createRules creates the host's rules from the endpoints of the given Traffic Ops API version, which must be valid per checkAPIVersion.
+func createRules(toc *to.Session, host string, api string, certDir string) (remap.RemapRules, error) {
+	if api == "1.2" {
+		return createRulesOldAPI(toc, host, certDir) // TODO remove once 1.3 / traffic_ops_golang is deployed to production.
+	}
+	return createRulesNewAPI(toc, host, certDir)
 }
 
 func createRulesOldAPI(toc *to.Session, host string, certDir string) (remap.RemapRules, error) {
 	cachegroupsArr, err := toc.CacheGroups()
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops Cachegroups: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Cachegroups: " + err.Error())
 	}
 	cachegroups := makeCachegroupsNameMap(cachegroupsArr)
 
 	serversArr, err := toc.Servers()
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops Servers: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Servers: " + err.Error())
 	}
 	servers := makeServersHostnameMap(serversArr)
 
 	hostServer, ok := servers[host]
 	if !ok {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error: host '" + host + "' not in Servers\n")
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("host '" + host + "' not in Servers")
 	}
 
 	deliveryservices, err := toc.DeliveryServicesByServer(hostServer.ID)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops Deliveryservices: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Deliveryservices: " + err.Error())
 	}
 
 	deliveryserviceRegexArr, err := toc.DeliveryServiceRegexes()
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops Deliveryservice Regexes: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Deliveryservice Regexes: " + err.Error())
 	}
 	deliveryserviceRegexes := makeDeliveryserviceRegexMap(deliveryserviceRegexArr)
 
 	cdnsArr, err := toc.CDNs()
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops CDNs: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops CDNs: " + err.Error())
 	}
 	cdns := makeCDNMap(cdnsArr)
 
 	serverParameters, err := toc.Parameters(hostServer.Profile)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting Traffic Ops Parameters for host '" + host + "' profile '" + hostServer.Profile + "': " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Parameters for host '" + host + "' profile '" + hostServer.Profile + "': " + err.Error())
 	}
 
 	parents, err := getParents(host, servers, cachegroups)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting '" + host + "' parents: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting '" + host + "' parents: " + err.Error())
 	}
 
+	jobs, _, err := toc.GetJobs()
+	if err != nil {
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Jobs: " + err.Error())
+	}
+
+	return createRulesFromData(toc, hostServer, deliveryservices, parents, getSiblings(hostServer, servers), deliveryserviceRegexes, cdns, serverParameters, jobs, certDir)
+}
+
+// createRulesNewAPI creates the host's rules from the Traffic Ops 1.3 cache config endpoint, which returns only the host's data, rather than every server, delivery service, and parameter in the CDN.
+func createRulesNewAPI(toc *to.Session, host string, certDir string) (remap.RemapRules, error) {
+	cacheCfg, _, err := toc.GetCacheConfig(host)
+	if err != nil {
+		return remap.RemapRules{}, errors.New("getting Traffic Ops Cache Config: " + err.Error())
+	}
+
+	hostServer := cacheConfigServerToServer(cacheCfg.Server)
+
+	cdns := map[string]tcv13.CDN{hostServer.CDNName: {Name: hostServer.CDNName, DomainName: cacheCfg.CDNDomain}}
+	deliveryservices := make([]tc.DeliveryService, 0, len(cacheCfg.DeliveryServices))
+	deliveryserviceRegexes := make(map[string][]tc.DeliveryServiceRegex, len(cacheCfg.DeliveryServices))
+	for _, ds := range cacheCfg.DeliveryServices {
+		deliveryservices = append(deliveryservices, tc.DeliveryService{
+			ID:                ds.ID,
+			XMLID:             ds.XMLID,
+			Type:              ds.Type,
+			CDNName:           ds.CDNName,
+			Protocol:          ds.Protocol,
+			QStringIgnore:     ds.QStringIgnore,
+			OrgServerFQDN:     ds.OrgServerFQDN,
+			DSCP:              ds.DSCP,
+			EdgeHeaderRewrite: ds.EdgeHeaderRewrite,
+			RemapText:         ds.RemapText,
+			SigningAlgorithm:  ds.SigningAlgorithm,
+		})
+		deliveryserviceRegexes[ds.XMLID] = ds.Regexes
+		cdns[ds.CDNName] = tcv13.CDN{Name: ds.CDNName, DomainName: ds.CDNDomain}
+	}
+
+	serverParameters := make([]tc.Parameter, 0, len(cacheCfg.Parameters))
+	for _, param := range cacheCfg.Parameters {
+		serverParameters = append(serverParameters, tc.Parameter{Name: param.Name, ConfigFile: param.ConfigFile, Value: param.Value})
+	}
+
+	return createRulesFromData(toc, hostServer, deliveryservices, cacheConfigServersToServers(cacheCfg.Parents), cacheConfigServersToServers(cacheCfg.Siblings), deliveryserviceRegexes, cdns, serverParameters, cacheCfg.Jobs, certDir)
+}
+
+func cacheConfigServerToServer(s tcv13.CacheConfigServer) tc.Server {
+	return tc.Server{
+		ID:         s.ID,
+		HostName:   s.HostName,
+		DomainName: s.DomainName,
+		TCPPort:    s.TCPPort,
+		IPAddress:  s.IPAddress,
+		IP6Address: s.IP6Address,
+		Status:     s.Status,
+		Cachegroup: s.Cachegroup,
+		Type:       s.Type,
+		Profile:    s.Profile,
+		CDNName:    s.CDNName,
+	}
+}
+
+func cacheConfigServersToServers(servers []tcv13.CacheConfigServer) []tc.Server {
+	tcServers := make([]tc.Server, 0, len(servers))
+	for _, s := range servers {
+		tcServers = append(tcServers, cacheConfigServerToServer(s))
+	}
+	return tcServers
+}
+
+// createRulesFromData creates the host server's rules from its Traffic Ops data, however it was fetched. Parents and siblings not in the host's CDN, or not available, are ignored. Certificates and URL signing keys are fetched from Traffic Ops, because they're only served by their own endpoints.
+func createRulesFromData(
+	toc *to.Session,
+	hostServer tc.Server,
+	deliveryservices []tc.DeliveryService,
+	parents []tc.Server,
+	siblings []tc.Server,
+	deliveryserviceRegexes map[string][]tc.DeliveryServiceRegex,
+	cdns map[string]tcv13.CDN,
+	serverParameters []tc.Parameter,
+	jobs []tc.Job,
+	certDir string,
+) (remap.RemapRules, error) {
 	sameCDN := func(s tc.Server) bool {
 		return s.CDNName == hostServer.CDNName
 	}
@@ -328,135 +529,237 @@ func createRulesOldAPI(toc *to.Session, host string, certDir string) (remap.Rema
 
 	cdnSSLKeys, err := toc.CDNSSLKeys(hostServer.CDNName)
 	if err != nil {
-		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting '" + hostServer.CDNName + "' SSL keys: " + err.Error())
-		os.Exit(1)
+		return remap.RemapRules{}, errors.New("getting '" + hostServer.CDNName + "' SSL keys: " + err.Error())
 	}
 	dsCerts := makeDSCertMap(cdnSSLKeys)
 
-	return createRulesOld(host, deliveryservices, parents, deliveryserviceRegexes, cdns, serverParameters, dsCerts, certDir)
-}
-
-// func createRulesNewAPI(toc *to.Session, host string, certDir string) (remap.RemapRules, error) {
-// 	cacheCfg, err := toc.CacheConfig(host)
-// 	if err != nil {
-// 		fmt.Printf("Error getting Traffic Ops Cache Config: %v\n", err)
-// 		os.Exit(1)
-// 	}
-
-// 	rules := []remapdata.RemapRule{}
-
-// 	allowedIPs, err := makeAllowIP(cacheCfg.AllowIP)
-// 	if err != nil {
-// 		return remap.RemapRules{}, fmt.Errorf("creating allowed IPs: %v", err)
-// 	}
-
-// 	cdnSSLKeys, err := toc.CDNSSLKeys(cacheCfg.CDN)
-// 	if err != nil {
-// 		fmt.Printf("Error getting %v SSL keys: %v\n", cacheCfg.CDN, err)
-// 		os.Exit(1)
-// 	}
-// 	dsCerts := makeDSCertMap(cdnSSLKeys)
-
-// 	weight := DefaultRuleWeight
-// 	retryNum := DefaultRetryNum
-// 	timeout := DefaultTimeout
-// 	parentSelection := DefaultRuleParentSelection
-
-// 	for _, ds := range cacheCfg.DeliveryServices {
-// 		protocol := ds.Protocol
-// 		queryStringRule, err := getQueryStringRule(ds.QueryStringIgnore)
-// 		if err != nil {
-// 			return remap.RemapRules{}, fmt.Errorf("getting deliveryservice %v Query String Rule: %v", ds.XMLID, err)
-// 		}
-
-// 		protocolStrs := []ProtocolStr{}
-// 		switch protocol {
-// 		case ProtocolHTTP:
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "http", To: "http"})
-// 		case ProtocolHTTPS:
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "https", To: "https"})
-// 		case ProtocolHTTPAndHTTPS:
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "http", To: "http"})
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "https", To: "https"})
-// 		case ProtocolHTTPToHTTPS:
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "http", To: "https"})
-// 			protocolStrs = append(protocolStrs, ProtocolStr{From: "https", To: "https"})
-// 		}
-
-// 		cert, hasCert := dsCerts[ds.XMLID]
-// 		// DEBUG
-// 		// if protocol != ProtocolHTTP {
-// 		// 	if !hasCert {
-// 		// 		fmt.Fprint(os.Stderr, "HTTPS delivery service: "+ds.XMLID+" has no certificate!\n")
-// 		// 	} else if err := createCertificateFiles(cert, certDir); err != nil {
-// 		// 		fmt.Fprint(os.Stderr, "HTTPS delivery service "+ds.XMLID+" failed to create certificate: "+err.Error()+"\n")
-// 		// 	}
-// 		// }
-
-// 		dsType := strings.ToLower(ds.Type)
-// 		if !strings.HasPrefix(dsType, "http") && !strings.HasPrefix(dsType, "dns") {
-// 			fmt.Printf("createRules skipping deliveryservice %v - unknown type %v", ds.XMLID, ds.Type)
-// 			continue
-// 		}
-
-// 		for _, protocolStr := range protocolStrs {
-// 			for _, dsRegex := range ds.Regexes {
-// 				rule := remapdata.RemapRule{}
-// 				pattern, patternLiteralRegex := trimLiteralRegex(dsRegex)
-// 				rule.Name = fmt.Sprintf("%s.%s.%s.%s", ds.XMLID, protocolStr.From, protocolStr.To, pattern)
-// 				rule.From = buildFrom(protocolStr.From, pattern, patternLiteralRegex, host, dsType, cacheCfg.Domain)
-
-// 				if protocolStr.From == "https" && hasCert {
-// 					rule.CertificateFile = getCertFileName(cert, certDir)
-// 					rule.CertificateKeyFile = getCertKeyFileName(cert, certDir)
-// 					// fmt.Fprintf(os.Stderr, "HTTPS delivery service: "+ds.XMLID+" certificate %+v\n", cert)
-// 				}
-
-// 				for _, parent := range cacheCfg.Parents {
-// 					to, proxyURLStr := buildToNew(parent, protocolStr.To, ds.OriginFQDN, dsType)
-// 					proxyURL, err := url.Parse(proxyURLStr)
-// 					if err != nil {
-// 						return remap.RemapRules{}, fmt.Errorf("error parsing deliveryservice %v parent %v proxy_url: %v", ds.XMLID, parent.Host, proxyURLStr)
-// 					}
-
-// 					ruleTo := remapdata.RemapRuleTo{
-// 						RemapRuleToBase: remapdata.RemapRuleToBase{
-// 							URL:      to,
-// 							Weight:   &weight,
-// 							RetryNum: &retryNum,
-// 						},
-// 						ProxyURL:   proxyURL,
-// 						RetryCodes: DefaultRetryCodes(),
-// 						Timeout:    &timeout,
-// 					}
-// 					rule.To = append(rule.To, ruleTo)
-// 					// TODO get from TO?
-// 					rule.RetryNum = &retryNum
-// 					rule.Timeout = &timeout
-// 					rule.RetryCodes = DefaultRetryCodes()
-// 					rule.QueryString = queryStringRule
-// 					rule.DSCP = ds.DSCP
-// 					if err != nil {
-// 						return remap.RemapRules{}, err
-// 					}
-// 					rule.ConnectionClose = DefaultRuleConnectionClose
-// 					rule.ParentSelection = &parentSelection
-// 				}
-// 				rules = append(rules, rule)
-// 			}
-// 		}
-// 	}
-
-// 	remapRules := remap.RemapRules{
-// 		Rules:           rules,
-// 		RetryCodes:      DefaultRetryCodes(),
-// 		Timeout:         &timeout,
-// 		ParentSelection: &parentSelection,
-// 		Stats:           remap.RemapRulesStats{Allow: allowedIPs},
-// 	}
-
-// 	return remapRules, nil
-// }
+	dsSigningCfgs := getURLSigningCfgs(toc, deliveryservices)
+
+	dsRevalRules := makeRevalidateRules(jobs, deliveryservices, getMaxRevalDuration(serverParameters), time.Now())
+
+	rules, err := createRulesOld(hostServer.HostName, deliveryservices, parents, deliveryserviceRegexes, cdns, serverParameters, dsCerts, dsSigningCfgs, dsRevalRules, certDir)
+	if err != nil {
+		return rules, err
+	}
+	if siblingLookup(serverParameters) {
+		siblings = filterParents(siblings, sameCDN)
+		siblings = filterParents(siblings, serverAvailable)
+		if rules.Siblings = makeSiblings(hostServer, siblings); rules.Siblings != nil {
+			if err := allowSiblings(rules.Rules, siblings); err != nil {
+				return rules, fmt.Errorf("allowing siblings: %v", err)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// SiblingLookupParam is the grove.cfg profile parameter which, if "true", makes the server request cache misses from the other caches of its cachegroup, before its parents.
+const SiblingLookupParam = "sibling_lookup"
+
+// siblingLookup returns whether the server's profile enables sibling lookup.
+func siblingLookup(params []tc.Parameter) bool {
+	for _, param := range params {
+		if param.Name == SiblingLookupParam && param.ConfigFile == "grove.cfg" {
+			return strings.ToLower(strings.TrimSpace(param.Value)) == "true"
+		}
+	}
+	return false
+}
+
+// getSiblings returns the servers in the given server's cachegroup with the same type, including the server itself.
+func getSiblings(hostServer tc.Server, servers map[string]tc.Server) []tc.Server {
+	siblings := []tc.Server{}
+	for _, server := range servers {
+		if server.Cachegroup == hostServer.Cachegroup && server.Type == hostServer.Type {
+			siblings = append(siblings, server)
+		}
+	}
+	sortServers(siblings)
+	return siblings
+}
+
+// allowSiblings adds the siblings' IPs to the allowed networks of the rules with ACLs, because siblings request objects from each other on behalf of their clients.
+func allowSiblings(rules []remapdata.RemapRule, siblings []tc.Server) error {
+	ips := []string{}
+	for _, sibling := range siblings {
+		if sibling.IPAddress != "" {
+			ips = append(ips, sibling.IPAddress)
+		}
+		if sibling.IP6Address != "" {
+			ips = append(ips, strings.SplitN(sibling.IP6Address, "/", 2)[0]) // Traffic Ops IPv6 addresses may include the network prefix length
+		}
+	}
+	siblingNets, err := makeAllowIP(ips)
+	if err != nil {
+		return err
+	}
+	for i := range rules {
+		if len(rules[i].Allow) > 0 {
+			rules[i].Allow = append(append([]*net.IPNet(nil), rules[i].Allow...), siblingNets...)
+		}
+	}
+	return nil
+}
+
+// makeSiblings returns the sibling config of the host server, with the given siblings as peers. Peers are requested over HTTP, so only HTTP rules use them. Returns nil if the host has no other siblings.
+func makeSiblings(hostServer tc.Server, siblings []tc.Server) *remapdata.Siblings {
+	serverURL := func(s tc.Server) string {
+		return "http://" + s.HostName + "." + s.DomainName + ":" + strconv.Itoa(s.TCPPort)
+	}
+	peers := []string{}
+	hasSelf := false
+	for _, sibling := range siblings {
+		peers = append(peers, serverURL(sibling))
+		hasSelf = hasSelf || sibling.HostName == hostServer.HostName
+	}
+	if !hasSelf {
+		peers = append(peers, serverURL(hostServer)) // the host itself may not be available, but must be in its own ring
+	}
+	if len(peers) < 2 {
+		return nil
+	}
+	sort.Strings(peers)
+	return &remapdata.Siblings{Self: serverURL(hostServer), Peers: peers}
+}
+
+// DefaultMaxRevalDurationDays is the maximum invalidation TTL, if the server's profile has no maxRevalDurationDays parameter.
+const DefaultMaxRevalDurationDays = 90
+
+// MinRevalTTL is the minimum invalidation TTL. Jobs with smaller TTLs are extended to it, as Traffic Ops does for ATS.
+const MinRevalTTL = time.Hour
+
+var revalTTLRegex = regexp.MustCompile(`TTL:(\d+)h`)
+
+// getMaxRevalDuration returns the maximum invalidation TTL, from the maxRevalDurationDays regex_revalidate.config parameter, or DefaultMaxRevalDurationDays.
+func getMaxRevalDuration(params []tc.Parameter) time.Duration {
+	for _, param := range params {
+		if param.Name != "maxRevalDurationDays" || param.ConfigFile != "regex_revalidate.config" {
+			continue
+		}
+		days, err := strconv.Atoi(param.Value)
+		if err != nil || days <= 0 {
+			fmt.Fprint(os.Stderr, time.Now().Format(time.RFC3339Nano)+" maxRevalDurationDays parameter '"+param.Value+"' invalid, using default\n")
+			break
+		}
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return DefaultMaxRevalDurationDays * 24 * time.Hour
+}
+
+// makeRevalidateRules returns the revalidate rules of the given delivery services, by XMLID, from the active invalidation jobs. Like the ATS regex_revalidate.config Traffic Ops generates, job TTLs are clamped between MinRevalTTL and maxTTL, and if multiple jobs have the same regex, the longest lasting is used.
+// Job asset URLs are regexes of the origin URL, but Grove matches the request path and query, so the scheme and host are removed, and the regex is anchored to the start of the path.
+func makeRevalidateRules(jobs []tc.Job, dses []tc.DeliveryService, maxTTL time.Duration, now time.Time) map[string][]remapdata.RevalidateRule {
+	dsXMLIDs := map[string]struct{}{}
+	for _, ds := range dses {
+		dsXMLIDs[ds.XMLID] = struct{}{}
+	}
+
+	dsRegexRules := map[string]map[string]remapdata.RevalidateRule{}
+	for _, job := range jobs {
+		if job.Keyword != tc.JobKeywordPurge {
+			continue
+		}
+		if _, ok := dsXMLIDs[job.DeliveryService]; !ok {
+			continue // not assigned to this server
+		}
+		ttlMatch := revalTTLRegex.FindStringSubmatch(job.Parameters)
+		if ttlMatch == nil {
+			continue
+		}
+		ttlHours, err := strconv.Atoi(ttlMatch[1])
+		if err != nil {
+			continue
+		}
+		ttl := time.Duration(ttlHours) * time.Hour
+		if ttl < MinRevalTTL {
+			ttl = MinRevalTTL
+		} else if ttl > maxTTL {
+			ttl = maxTTL
+		}
+		start, err := parseJobTime(job.StartTime)
+		if err != nil {
+			fmt.Fprint(os.Stderr, time.Now().Format(time.RFC3339Nano)+" job "+strconv.Itoa(job.ID)+" start time '"+job.StartTime+"' invalid, skipping: "+err.Error()+"\n")
+			continue
+		}
+		expires := start.Add(ttl)
+		if expires.Before(now) {
+			continue
+		}
+		regex := job.AssetURL
+		if schemePos := strings.Index(regex, "://"); schemePos != -1 {
+			regex = regex[schemePos+len("://"):]
+			if pathPos := strings.Index(regex, "/"); pathPos != -1 {
+				regex = regex[pathPos:]
+			} else {
+				regex = "/"
+			}
+		}
+		regex = "^" + strings.TrimPrefix(regex, "^")
+
+		if dsRegexRules[job.DeliveryService] == nil {
+			dsRegexRules[job.DeliveryService] = map[string]remapdata.RevalidateRule{}
+		}
+		if old, ok := dsRegexRules[job.DeliveryService][regex]; ok && !expires.After(old.Expires) {
+			continue
+		}
+		dsRegexRules[job.DeliveryService][regex] = remapdata.RevalidateRule{Regex: regex, Start: start, Expires: expires}
+	}
+
+	dsRules := make(map[string][]remapdata.RevalidateRule, len(dsRegexRules))
+	for xmlID, regexRules := range dsRegexRules {
+		for _, rule := range regexRules {
+			dsRules[xmlID] = append(dsRules[xmlID], rule)
+		}
+		// sorted, so the rules file is unchanged unless the jobs are. Regexes are unique per delivery service.
+		rules := dsRules[xmlID]
+		sort.Slice(rules, func(i, j int) bool { return rules[i].Regex < rules[j].Regex })
+	}
+	return dsRules
+}
+
+// parseJobTime parses a job start time, which Traffic Ops returns as a Postgres timestamp.
+func parseJobTime(s string) (time.Time, error) {
+	err := error(nil)
+	for _, layout := range []string{"2006-01-02 15:04:05-07", "2006-01-02 15:04:05.999999-07", "2006-01-02 15:04:05", time.RFC3339Nano} {
+		t := time.Time{}
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// URLSigningCfg is the config of the url_signing plugin, which validates signed URLs.
+type URLSigningCfg struct {
+	URLSigKeys     tc.DeliveryServiceURLSigKeys `json:"url_sig_keys,omitempty"`
+	URISigningKeys json.RawMessage              `json:"uri_signing_keys,omitempty"`
+}
+
+// getURLSigningCfgs returns the url_signing plugin config of each delivery service with a signing algorithm, by XMLID. If a delivery service's keys can't be fetched, its config has no keys, so all its requests are rejected, rather than served without validation.
+func getURLSigningCfgs(toc *to.Session, dses []tc.DeliveryService) map[string]URLSigningCfg {
+	cfgs := map[string]URLSigningCfg{}
+	for _, ds := range dses {
+		cfg := URLSigningCfg{}
+		switch ds.SigningAlgorithm {
+		case tc.SigningAlgorithmURLSig:
+			keys, _, err := toc.GetDeliveryServiceURLSigKeys(ds.XMLID)
+			if err != nil {
+				fmt.Fprint(os.Stderr, time.Now().Format(time.RFC3339Nano)+" Error getting delivery service '"+ds.XMLID+"' url_sig keys, all requests will be rejected: "+err.Error()+"\n")
+			}
+			cfg.URLSigKeys = keys
+		case tc.SigningAlgorithmURISigning:
+			keys, _, err := toc.GetDeliveryServiceURISigningKeys(ds.XMLID)
+			if err != nil {
+				fmt.Fprint(os.Stderr, time.Now().Format(time.RFC3339Nano)+" Error getting delivery service '"+ds.XMLID+"' URI signing keys, all requests will be rejected: "+err.Error()+"\n")
+			}
+			cfg.URISigningKeys = keys
+		default:
+			continue
+		}
+		cfgs[ds.XMLID] = cfg
+	}
+	return cfgs
+}
 
 func makeServersHostnameMap(servers []tc.Server) map[string]tc.Server {
 	m := map[string]tc.Server{}
@@ -482,14 +785,6 @@ func makeDeliveryservicesXMLIDMap(dses []tc.DeliveryService) map[string]tc.Deliv
 	return m
 }
 
-func makeDeliveryservicesIDMap(dses []tc.DeliveryService) map[int]tc.DeliveryService {
-	m := map[int]tc.DeliveryService{}
-	for _, ds := range dses {
-		m[ds.ID] = ds
-	}
-	return m
-}
-
 func makeDeliveryserviceRegexMap(dsrs []tc.DeliveryServiceRegexes) map[string][]tc.DeliveryServiceRegex {
 	m := map[string][]tc.DeliveryServiceRegex{}
 	for _, dsr := range dsrs {
@@ -514,27 +809,6 @@ func makeDSCertMap(sslKeys []tcv13.CDNSSLKeys) map[string]tcv13.CDNSSLKeys {
 	return m
 }
 
-func getServerDeliveryservices(hostname string, servers map[string]tc.Server, dssrvs []tc.DeliveryServiceServer, dses []tc.DeliveryService) ([]tc.DeliveryService, error) {
-	server, ok := servers[hostname]
-	if !ok {
-		return nil, fmt.Errorf("server %v not found in Traffic Ops Servers", hostname)
-	}
-	serverID := server.ID
-	dsByID := makeDeliveryservicesIDMap(dses)
-	serverDses := []tc.DeliveryService{}
-	for _, dssrv := range dssrvs {
-		if dssrv.Server != serverID {
-			continue
-		}
-		ds, ok := dsByID[dssrv.DeliveryService]
-		if !ok {
-			return nil, fmt.Errorf("delivery service ID %v not found in Traffic Ops DeliveryServices", dssrv.DeliveryService)
-		}
-		serverDses = append(serverDses, ds)
-	}
-	return serverDses, nil
-}
-
 func getParents(hostname string, servers map[string]tc.Server, cachegroups map[string]tcv13.CacheGroup) ([]tc.Server, error) {
 	server, ok := servers[hostname]
 	if !ok {
@@ -552,9 +826,15 @@ func getParents(hostname string, servers map[string]tc.Server, cachegroups map[s
 			parents = append(parents, server)
 		}
 	}
+	sortServers(parents)
 	return parents, nil
 }
 
+// sortServers sorts the given servers by host name, so rules built from servers collected from a map are the same every time.
+func sortServers(servers []tc.Server) {
+	sort.Slice(servers, func(i, j int) bool { return servers[i].HostName < servers[j].HostName })
+}
+
 func filterParents(parents []tc.Server, include func(tc.Server) bool) []tc.Server {
 	newParents := []tc.Server{}
 	for _, parent := range parents {
@@ -585,6 +865,89 @@ func trimLiteralRegex(s string) (string, bool) {
 	return s, false
 }
 
+// RegexTypeHost, RegexTypePath, and RegexTypeHeader are the Traffic Ops delivery service regex types.
+const (
+	RegexTypeHost   = "HOST_REGEXP"
+	RegexTypePath   = "PATH_REGEXP"
+	RegexTypeHeader = "HEADER_REGEXP"
+)
+
+// RegexSet is a delivery service match set, the regexes with the same set number, all of which a request must match.
+type RegexSet struct {
+	SetNumber int
+	Host      string
+	Path      string
+	Header    string
+}
+
+// makeRegexSets returns the match sets of the given delivery service regexes, ordered by set number.
+func makeRegexSets(regexes []tc.DeliveryServiceRegex) []RegexSet {
+	sets := map[int]*RegexSet{}
+	for _, regex := range regexes {
+		set, ok := sets[regex.SetNumber]
+		if !ok {
+			set = &RegexSet{SetNumber: regex.SetNumber}
+			sets[regex.SetNumber] = set
+		}
+		switch regex.Type {
+		case RegexTypeHost:
+			set.Host = regex.Pattern
+		case RegexTypePath:
+			set.Path = regex.Pattern
+		case RegexTypeHeader:
+			set.Header = regex.Pattern
+		}
+	}
+	sorted := make([]RegexSet, 0, len(sets))
+	for _, set := range sets {
+		sorted = append(sorted, *set)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].SetNumber < sorted[j].SetNumber })
+	return sorted
+}
+
+// unescapeLiteralHost returns the host matched by the given host regex, and whether the regex matches only that literal host, such as `www\.example\.net`.
+func unescapeLiteralHost(pattern string) (string, bool) {
+	host := strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+	if host == "" || strings.ContainsAny(strings.Replace(host, `\.`, "", -1), `.\+*?()|[]{}^$`) {
+		return "", false
+	}
+	return strings.Replace(host, `\.`, ".", -1), true
+}
+
+// setRuleMatch sets the From of the rule to match the given regex set. Sets of only a host regex which matches a literal host, or the `.*\.foo\..*` delivery service form, are matched by a literal From prefix. Others are matched by the rule's regexes. Header regexes must be of the form `Name: regex`.
+func setRuleMatch(rule *remapdata.RemapRule, set RegexSet, protocol string, hostname string, dsType string, cdnDomain string) error {
+	pattern, patternLiteralRegex := trimLiteralRegex(set.Host)
+	literalHost := ""
+	if patternLiteralRegex {
+		literalHost = strings.TrimPrefix(buildFrom(protocol, pattern, patternLiteralRegex, hostname, dsType, cdnDomain), protocol+"://")
+	} else if host, ok := unescapeLiteralHost(set.Host); ok {
+		literalHost = host
+	}
+
+	if set.Path == "" && set.Header == "" && literalHost != "" {
+		rule.From = protocol + "://" + literalHost
+		return nil
+	}
+
+	rule.From = protocol + "://"
+	rule.HostRegex = set.Host
+	if literalHost != "" {
+		rule.HostRegex = "^" + regexp.QuoteMeta(literalHost) + "$"
+	}
+	rule.PathRegex = set.Path
+	if set.Header != "" {
+		colon := strings.Index(set.Header, ":")
+		if colon == -1 {
+			return errors.New("header regex '" + set.Header + "' not of the form 'Name: regex'")
+		}
+		rule.Header = strings.TrimSpace(set.Header[:colon])
+		rule.HeaderRegex = strings.TrimSpace(set.Header[colon+1:])
+	}
+	rule.Name += "." + strconv.Itoa(set.SetNumber)
+	return rule.CompileRegexes()
+}
+
 // buildFrom builds the remap "from" URI prefix. It assumes ttype is a delivery service type HTTP or DNS, behavior is undefined for any other ttype.
 func buildFrom(protocol string, pattern string, patternLiteralRegex bool, host string, dsType string, cdnDomain string) string {
 	if !patternLiteralRegex {
@@ -620,17 +983,6 @@ func buildTo(parentServer tc.Server, protocol string, originURI string, dsType s
 	return to, proxy
 }
 
-// // buildToNew returns the to URL, and the Proxy URL (if any)
-// func buildToNew(parent tc.CacheConfigParent, protocol string, originURI string, dsType string) (string, string) {
-// 	// TODO add port?
-// 	to := originURI
-// 	proxy := ""
-// 	if !dsTypeSkipsMid(dsType) {
-// 		proxy = "http://" + parent.Host + "." + parent.Domain + ":" + strconv.FormatUint(uint64(parent.Port), 10)
-// 	}
-// 	return to, proxy
-// }
-
 const DeliveryServiceQueryStringCacheAndRemap = 0
 const DeliveryServiceQueryStringNoCacheRemap = 1
 const DeliveryServiceQueryStringNoCacheNoRemap = 2
@@ -696,6 +1048,8 @@ func createRulesOld(
 	cdns map[string]tcv13.CDN,
 	hostParams []tc.Parameter,
 	dsCerts map[string]tcv13.CDNSSLKeys,
+	dsSigningCfgs map[string]URLSigningCfg,
+	dsRevalRules map[string][]remapdata.RevalidateRule,
 	certDir string,
 ) (remap.RemapRules, error) {
 	rules := []remapdata.RemapRule{}
@@ -759,6 +1113,11 @@ func createRulesOld(
 			fmt.Println(time.Now().Format(time.RFC3339Nano) + " createRules skipping deliveryservice '" + ds.XMLID + "' - unsupported ACL " + ds.RemapText)
 			continue
 		}
+		cacheKeyPolicy, hashKeyPolicy, err := makeCacheKeyPolicies(ds.RemapText)
+		if err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " createRules skipping deliveryservice '" + ds.XMLID + "' - " + err.Error())
+			continue
+		}
 
 		for _, protocolStr := range protocolStrs {
 			regexes, ok := dsRegexes[ds.XMLID]
@@ -766,11 +1125,18 @@ func createRulesOld(
 				return remap.RemapRules{}, fmt.Errorf("deliveryservice '%v' has no regexes", ds.XMLID)
 			}
 
-			for _, dsRegex := range regexes {
+			for _, regexSet := range makeRegexSets(regexes) {
+				if regexSet.Host == "" {
+					fmt.Println(time.Now().Format(time.RFC3339Nano) + " createRules skipping deliveryservice '" + ds.XMLID + "' regex set " + strconv.Itoa(regexSet.SetNumber) + " - no host regex")
+					continue
+				}
 				rule := remapdata.RemapRule{}
-				pattern, patternLiteralRegex := trimLiteralRegex(dsRegex.Pattern)
+				pattern, _ := trimLiteralRegex(regexSet.Host)
 				rule.Name = fmt.Sprintf("%s.%s.%s.%s", ds.XMLID, protocolStr.From, protocolStr.To, pattern)
-				rule.From = buildFrom(protocolStr.From, pattern, patternLiteralRegex, hostname, dsType, cdn.DomainName)
+				if err := setRuleMatch(&rule, regexSet, protocolStr.From, hostname, dsType, cdn.DomainName); err != nil {
+					fmt.Println(time.Now().Format(time.RFC3339Nano) + " createRules skipping deliveryservice '" + ds.XMLID + "' regex set " + strconv.Itoa(regexSet.SetNumber) + " - " + err.Error())
+					continue
+				}
 
 				if protocolStr.From == "https" && hasCert {
 					rule.CertificateFile = getCertFileName(cert, certDir)
@@ -779,6 +1145,7 @@ func createRulesOld(
 				}
 
 				rule.PluginsShared = map[string]json.RawMessage{}
+				rule.Revalidate = dsRevalRules[ds.XMLID]
 				for _, parent := range parents {
 					to, proxyURLStr := buildTo(parent, protocolStr.To, ds.OrgServerFQDN, dsType)
 					proxyURL, err := url.Parse(proxyURLStr)
@@ -802,6 +1169,8 @@ func createRulesOld(
 					rule.Timeout = &timeout
 					rule.RetryCodes = DefaultRetryCodes()
 					rule.QueryString = queryStringRule
+					rule.CacheKeyPolicy = cacheKeyPolicy
+					rule.ConsistentHashKeyPolicy = hashKeyPolicy
 					rule.DSCP = ds.DSCP
 					rule.ConnectionClose = DefaultRuleConnectionClose
 					rule.ParentSelection = &parentSelection
@@ -809,6 +1178,9 @@ func createRulesOld(
 					rule.Plugins = map[string]interface{}{}
 					rule.Plugins["modify_headers"] = toClientHeaders
 					rule.Plugins["modify_parent_request_headers"] = toOriginHeaders
+					if signingCfg, ok := dsSigningCfgs[ds.XMLID]; ok {
+						rule.Plugins["url_signing"] = signingCfg
+					}
 					remapTextJSON, err := json.Marshal(ds.RemapText)
 					if err != nil {
 						return remap.RemapRules{}, fmt.Errorf("parsing deliveryservice '%v' remap text '%v' marshalling JSON: %v", ds.XMLID, ds.RemapText, err)
@@ -920,6 +1292,74 @@ func makeACL(remapTxt string) ([]*net.IPNet, error) {
 	return allow, nil
 }
 
+// makeCacheKeyPolicies creates the cache key and consistent hash key policies of the ATS cachekey plugin instances in the given delivery service remap text, such as `@plugin=cachekey.so @pparam=--include-params=a,b @pparam=--sort-params=true`. Instances with `--key-type=parent_selection_url` create the consistent hash key policy, and others the cache key policy. Returns nil policies if the remap text has no cachekey plugin.
+func makeCacheKeyPolicies(remapTxt string) (*remapdata.CacheKeyPolicy, *remapdata.CacheKeyPolicy, error) {
+	cacheKey := (*remapdata.CacheKeyPolicy)(nil)
+	hashKey := (*remapdata.CacheKeyPolicy)(nil)
+	policy := (*remapdata.CacheKeyPolicy)(nil)
+	isHashKey := false
+	finish := func() {
+		if policy == nil {
+			return
+		}
+		if isHashKey {
+			hashKey = policy
+		} else {
+			cacheKey = policy
+		}
+		policy = nil
+		isHashKey = false
+	}
+	for _, field := range strings.Fields(remapTxt) {
+		if strings.HasPrefix(field, "@plugin=") {
+			finish()
+			if strings.TrimPrefix(field, "@plugin=") == "cachekey.so" {
+				policy = &remapdata.CacheKeyPolicy{}
+			}
+			continue
+		}
+		if policy == nil || !strings.HasPrefix(field, "@pparam=") {
+			continue
+		}
+		param := strings.TrimPrefix(field, "@pparam=")
+		name, val := param, ""
+		if i := strings.Index(param, "="); i != -1 {
+			name, val = param[:i], param[i+1:]
+		}
+		list := []string{}
+		for _, s := range strings.Split(val, ",") {
+			if s != "" {
+				list = append(list, s)
+			}
+		}
+		switch name {
+		case "--include-params":
+			policy.IncludeParams = append(policy.IncludeParams, list...)
+		case "--exclude-params":
+			policy.ExcludeParams = append(policy.ExcludeParams, list...)
+		case "--sort-params":
+			policy.SortParams = val == "" || val == "true"
+		case "--include-headers":
+			policy.IncludeHeaders = append(policy.IncludeHeaders, list...)
+		case "--include-cookies":
+			policy.IncludeCookies = append(policy.IncludeCookies, list...)
+		case "--key-type":
+			switch val {
+			case "cache_key":
+				isHashKey = false
+			case "parent_selection_url":
+				isHashKey = true
+			default:
+				return nil, nil, errors.New("unknown cachekey key type '" + val + "'")
+			}
+		default:
+			return nil, nil, errors.New("unsupported cachekey parameter '" + name + "'")
+		}
+	}
+	finish()
+	return cacheKey, hashKey, nil
+}
+
 // makeModHdrs is a pretty nasty hack to take the very ATS/TrafficControl specific config stuff from Traffic Ops and turn it into header manipulation rules for grove.
 // Returns the client header modifications, the origin header modifications, and any error.
 func makeModHdrs(edgeHRW string, remapTXT string) (web.ModHdrs, web.ModHdrs, error) {
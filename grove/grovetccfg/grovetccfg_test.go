@@ -0,0 +1,307 @@
+package main
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc"
+	tcv13 "github.com/apache/incubator-trafficcontrol/lib/go-tc/v13"
+	to "github.com/apache/incubator-trafficcontrol/traffic_ops/client"
+)
+
+// fakeTrafficOps serves the given responses by path, wrapped in a "response" object, and records the paths requested. Other paths are Not Found.
+type fakeTrafficOps struct {
+	responses map[string]interface{}
+	paths     []string
+	m         sync.Mutex
+}
+
+func (f *fakeTrafficOps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	f.m.Unlock()
+	resp, ok := f.responses[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"response": resp})
+}
+
+func newFakeTrafficOps(responses map[string]interface{}) (*fakeTrafficOps, *httptest.Server, *to.Session) {
+	f := &fakeTrafficOps{responses: responses}
+	srv := httptest.NewServer(f)
+	return f, srv, to.NewSession("user", "pass", srv.URL, UserAgent, srv.Client(), false)
+}
+
+func testCacheConfig() tcv13.CacheConfig {
+	return tcv13.CacheConfig{
+		Server:     tcv13.CacheConfigServer{ID: 1, HostName: "edge0", DomainName: "example.net", TCPPort: 80, Status: "REPORTED", Cachegroup: "edge-cg", Type: "EDGE", Profile: "EDGE_GROVE", CDNName: "cdn0"},
+		CDNDomain:  "cdn.example.net",
+		Parameters: []tcv13.CacheConfigParameter{},
+		Parents: []tcv13.CacheConfigServer{
+			{ID: 2, HostName: "mid0", DomainName: "example.net", TCPPort: 80, Status: "REPORTED", Cachegroup: "mid-cg", Type: "MID", Profile: "MID_GROVE", CDNName: "cdn0"},
+			{ID: 3, HostName: "mid1", DomainName: "example.net", TCPPort: 80, Status: "OFFLINE", Cachegroup: "mid-cg", Type: "MID", Profile: "MID_GROVE", CDNName: "cdn0"},
+			{ID: 4, HostName: "mid2", DomainName: "example.net", TCPPort: 80, Status: "REPORTED", Cachegroup: "mid-cg", Type: "MID", Profile: "MID_GROVE", CDNName: "cdn1"},
+		},
+		Siblings: []tcv13.CacheConfigServer{},
+		DeliveryServices: []tcv13.CacheConfigDeliveryService{{
+			ID:            5,
+			XMLID:         "ds0",
+			Type:          "HTTP",
+			CDNName:       "cdn0",
+			CDNDomain:     "cdn.example.net",
+			Protocol:      ProtocolHTTP,
+			OrgServerFQDN: "http://origin.example.net",
+			Regexes:       []tc.DeliveryServiceRegex{{Type: "HOST_REGEXP", Pattern: `.*\.ds0\..*`}},
+		}},
+		Jobs: []tc.Job{},
+	}
+}
+
+func TestCacheConfigServerToServer(t *testing.T) {
+	s := tcv13.CacheConfigServer{ID: 1, HostName: "edge0", DomainName: "example.net", TCPPort: 8080, IPAddress: "192.0.2.1", IP6Address: "2001:db8::1", Status: "REPORTED", Cachegroup: "edge-cg", Type: "EDGE", Profile: "EDGE_GROVE", CDNName: "cdn0"}
+	expected := tc.Server{ID: 1, HostName: "edge0", DomainName: "example.net", TCPPort: 8080, IPAddress: "192.0.2.1", IP6Address: "2001:db8::1", Status: "REPORTED", Cachegroup: "edge-cg", Type: "EDGE", Profile: "EDGE_GROVE", CDNName: "cdn0"}
+	if actual := cacheConfigServerToServer(s); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("cacheConfigServerToServer expected %+v, actual %+v", expected, actual)
+	}
+
+	if actual := cacheConfigServersToServers([]tcv13.CacheConfigServer{s, s}); len(actual) != 2 || !reflect.DeepEqual(expected, actual[1]) {
+		t.Errorf("cacheConfigServersToServers expected [%+v %+v], actual %+v", expected, expected, actual)
+	}
+}
+
+func TestCreateRulesNewAPI(t *testing.T) {
+	_, srv, toc := newFakeTrafficOps(map[string]interface{}{
+		"/api/1.3/servers/edge0/configs/cache": testCacheConfig(),
+		"/api/1.2/cdns/name/cdn0/sslkeys":      []tcv13.CDNSSLKeys{},
+	})
+	defer srv.Close()
+
+	rules, err := createRulesNewAPI(toc, "edge0", "")
+	if err != nil {
+		t.Fatalf("createRulesNewAPI: %v", err)
+	}
+	if len(rules.Rules) != 1 {
+		t.Fatalf("createRulesNewAPI expected 1 rule, actual %+v", rules.Rules)
+	}
+	rule := rules.Rules[0]
+	if expected := "http://edge0.ds0.cdn.example.net"; rule.From != expected {
+		t.Errorf("createRulesNewAPI expected from '%v', actual '%v'", expected, rule.From)
+	}
+	// the offline parent, and the parent in another CDN, aren't parents.
+	if len(rule.To) != 1 {
+		t.Fatalf("createRulesNewAPI expected 1 parent, actual %+v", rule.To)
+	}
+	if expected := "http://origin.example.net"; rule.To[0].URL != expected {
+		t.Errorf("createRulesNewAPI expected to '%v', actual '%v'", expected, rule.To[0].URL)
+	}
+	if expected := "http://mid0.example.net:80"; rule.To[0].ProxyURL == nil || rule.To[0].ProxyURL.String() != expected {
+		t.Errorf("createRulesNewAPI expected proxy '%v', actual '%v'", expected, rule.To[0].ProxyURL)
+	}
+}
+
+func TestCreateRulesNewAPIServerNotFound(t *testing.T) {
+	_, srv, toc := newFakeTrafficOps(map[string]interface{}{})
+	defer srv.Close()
+
+	if _, err := createRulesNewAPI(toc, "edge0", ""); err == nil {
+		t.Errorf("createRulesNewAPI of nonexistent server expected error, actual nil")
+	}
+}
+
+func TestCheckAPIVersion(t *testing.T) {
+	for _, api := range []string{"1.2", "1.3"} {
+		if err := checkAPIVersion(api); err != nil {
+			t.Errorf("checkAPIVersion '%v' expected nil, actual %v", api, err)
+		}
+	}
+	for _, api := range []string{"", "1.1", "1.4", "v1.3"} {
+		if err := checkAPIVersion(api); err == nil {
+			t.Errorf("checkAPIVersion '%v' expected error, actual nil", api)
+		}
+	}
+}
+
+func TestCreateRulesAPIVersion(t *testing.T) {
+	expectedFirstPaths := map[string]string{
+		"1.2": "/api/1.2/cachegroups.json",
+		"1.3": "/api/1.3/servers/edge0/configs/cache",
+	}
+	for api, expected := range expectedFirstPaths {
+		f, srv, toc := newFakeTrafficOps(map[string]interface{}{})
+		createRules(toc, "edge0", api, "") // errors, because the fake has no data; only the endpoint requested matters.
+		srv.Close()
+		if len(f.paths) == 0 || f.paths[0] != expected {
+			t.Errorf("createRules API '%v' expected first request '%v', actual %v", api, expected, f.paths)
+		}
+	}
+}
+
+func TestGetParentsSiblingsSorted(t *testing.T) {
+	servers := map[string]tc.Server{}
+	for _, name := range []string{"edge2", "mid3", "edge0", "mid1", "mid0", "edge1", "mid2"} {
+		s := tc.Server{HostName: name, Cachegroup: "edge-cg", Type: "EDGE"}
+		if name[:3] == "mid" {
+			s.Cachegroup, s.Type = "mid-cg", "MID"
+		}
+		servers[name] = s
+	}
+	cachegroups := map[string]tcv13.CacheGroup{"edge-cg": {Name: "edge-cg", ParentName: "mid-cg"}}
+
+	hostNames := func(servers []tc.Server) []string {
+		names := []string{}
+		for _, s := range servers {
+			names = append(names, s.HostName)
+		}
+		return names
+	}
+
+	parents, err := getParents("edge0", servers, cachegroups)
+	if err != nil {
+		t.Fatalf("getParents: %v", err)
+	}
+	if expected, actual := []string{"mid0", "mid1", "mid2", "mid3"}, hostNames(parents); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("getParents expected %v, actual %v", expected, actual)
+	}
+	if expected, actual := []string{"edge0", "edge1", "edge2"}, hostNames(getSiblings(servers["edge0"], servers)); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("getSiblings expected %v, actual %v", expected, actual)
+	}
+}
+
+func TestMakeRevalidateRulesSorted(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	start := now.Add(-time.Hour).Format(time.RFC3339Nano)
+	jobs := []tc.Job{}
+	for i, path := range []string{"/d", "/a", "/c", "/e", "/b"} {
+		jobs = append(jobs, tc.Job{ID: i, AssetURL: "http://origin.example.net" + path, DeliveryService: "ds0", Keyword: tc.JobKeywordPurge, Parameters: "TTL:48h", StartTime: start})
+	}
+	dses := []tc.DeliveryService{{XMLID: "ds0"}}
+
+	for i := 0; i < 10; i++ {
+		rules := makeRevalidateRules(jobs, dses, 72*time.Hour, now)["ds0"]
+		regexes := []string{}
+		for _, rule := range rules {
+			regexes = append(regexes, rule.Regex)
+		}
+		if expected := []string{"^/a", "^/b", "^/c", "^/d", "^/e"}; !reflect.DeepEqual(expected, regexes) {
+			t.Fatalf("makeRevalidateRules expected %v, actual %v", expected, regexes)
+		}
+	}
+}
+
+func TestWriteAndBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grovetccfg")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "remap.json")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	validated := ""
+	validate := func(p string) error {
+		validated = p
+		return nil
+	}
+	if err := WriteAndBackup(path, []byte("new"), validate); err != nil {
+		t.Fatalf("WriteAndBackup: %v", err)
+	}
+	if validated != NewFilename(path) {
+		t.Errorf("WriteAndBackup expected to validate '%v', actual '%v'", NewFilename(path), validated)
+	}
+	if bts, err := ioutil.ReadFile(path); err != nil || string(bts) != "new" {
+		t.Errorf("WriteAndBackup expected file 'new', actual '%s' error %v", bts, err)
+	}
+	if backups, err := ioutil.ReadDir(filepath.Join(dir, "remap_history")); err != nil || len(backups) != 1 {
+		t.Errorf("WriteAndBackup expected 1 backup, actual %v error %v", len(backups), err)
+	}
+}
+
+func TestWriteAndBackupInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grovetccfg")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "remap.json")
+	if err := ioutil.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+
+	validate := func(p string) error { return errors.New("invalid") }
+	if err := WriteAndBackup(path, []byte("new"), validate); err == nil {
+		t.Errorf("WriteAndBackup with failed validation expected error, actual nil")
+	}
+	if bts, err := ioutil.ReadFile(path); err != nil || string(bts) != "old" {
+		t.Errorf("WriteAndBackup with failed validation expected existing file 'old', actual '%s' error %v", bts, err)
+	}
+	if _, err := os.Stat(NewFilename(path)); !os.IsNotExist(err) {
+		t.Errorf("WriteAndBackup with failed validation expected new file removed, actual stat error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "remap_history")); !os.IsNotExist(err) {
+		t.Errorf("WriteAndBackup with failed validation expected no backup, actual stat error %v", err)
+	}
+}
+
+func TestCheckGroveProcess(t *testing.T) {
+	// the test binary is grovetccfg.test, so it looks like Grove.
+	if err := checkGroveProcess(os.Getpid()); err != nil {
+		t.Errorf("checkGroveProcess of running process expected nil, actual %v", err)
+	}
+	for _, pid := range []int{0, -1} {
+		if err := checkGroveProcess(pid); err == nil {
+			t.Errorf("checkGroveProcess %v expected error, actual nil", pid)
+		}
+	}
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("running process: %v", err)
+	}
+	if err := checkGroveProcess(exited.Process.Pid); err == nil {
+		t.Errorf("checkGroveProcess of exited process expected error, actual nil")
+	}
+
+	other := exec.Command("sleep", "10")
+	if err := other.Start(); err != nil {
+		t.Fatalf("starting process: %v", err)
+	}
+	defer func() {
+		other.Process.Kill()
+		other.Wait()
+	}()
+	if _, err := os.Stat("/proc/" + strconv.Itoa(other.Process.Pid) + "/comm"); err != nil {
+		t.Skip("no /proc, can't check the program of a running process")
+	}
+	if err := checkGroveProcess(other.Process.Pid); err == nil {
+		t.Errorf("checkGroveProcess of non-Grove process expected error, actual nil")
+	}
+}
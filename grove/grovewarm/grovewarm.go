@@ -0,0 +1,154 @@
+package main
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// grovewarm warms a Grove cache, by starting a warm job with the given URLs and manifests, and printing its progress until it completes.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+	"github.com/apache/incubator-trafficcontrol/grove/plugin"
+)
+
+// RequestTimeout is the timeout of requests to Grove to start a job and get its progress.
+const RequestTimeout = 30 * time.Second
+
+func main() {
+	groveURL := flag.String("grove", "http://localhost", "The URL of the Grove server, without a path")
+	file := flag.String("file", "", "A file of URLs to warm, one per line, or - for stdin")
+	manifests := flag.String("manifests", "", "A comma-separated list of HLS or DASH manifest URLs, to warm along with their segments")
+	concurrency := flag.Int("concurrency", plugin.DefaultWarmConcurrency, "The number of URLs to fetch at once")
+	poll := flag.Duration("poll", time.Second, "The interval to print the job's progress")
+	noWait := flag.Bool("no-wait", false, "Whether to exit after starting the job, without waiting for it to complete")
+	flag.Parse()
+
+	warmReq := cachedata.WarmReq{URLs: flag.Args(), Concurrency: *concurrency}
+	if *manifests != "" {
+		warmReq.Manifests = strings.Split(*manifests, ",")
+	}
+	if *file != "" {
+		urls, err := readURLs(*file)
+		if err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error reading URLs: " + err.Error())
+			os.Exit(1)
+		}
+		warmReq.URLs = append(warmReq.URLs, urls...)
+	}
+	if len(warmReq.URLs) == 0 && len(warmReq.Manifests) == 0 {
+		fmt.Println("Usage: grovewarm [-grove url] [-file path] [-manifests urls] [url...]")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	client := &http.Client{Timeout: RequestTimeout}
+	endpoint := strings.TrimSuffix(*groveURL, "/") + plugin.WarmEndpoint
+	job, err := startJob(client, endpoint, warmReq)
+	if err != nil {
+		fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error starting warm job: " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("%s started job %v with %v URLs\n", time.Now().Format(time.RFC3339Nano), job.ID, job.Total)
+	if *noWait {
+		os.Exit(0)
+	}
+
+	for !job.Complete {
+		time.Sleep(*poll)
+		if job, err = getJob(client, endpoint, job.ID); err != nil {
+			fmt.Println(time.Now().Format(time.RFC3339Nano) + " Error getting warm job progress: " + err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%s warmed %v/%v: %v hits, %v fetched, %v failed\n", time.Now().Format(time.RFC3339Nano), job.Done, job.Total, job.Hits, job.Fetched, job.Failed)
+	}
+	for _, jobErr := range job.Errors {
+		fmt.Println("failed " + jobErr)
+	}
+	if job.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// readURLs returns the URLs in the given file, or stdin if it's -, one per line. Blank lines and lines starting with # are skipped.
+func readURLs(path string) ([]string, error) {
+	r := io.Reader(os.Stdin)
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	urls := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+func startJob(client *http.Client, endpoint string, warmReq cachedata.WarmReq) (cachedata.WarmJob, error) {
+	body, err := json.Marshal(warmReq)
+	if err != nil {
+		return cachedata.WarmJob{}, errors.New("marshalling request: " + err.Error())
+	}
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return cachedata.WarmJob{}, err
+	}
+	return readJob(resp, http.StatusAccepted)
+}
+
+func getJob(client *http.Client, endpoint string, id uint64) (cachedata.WarmJob, error) {
+	resp, err := client.Get(endpoint + "?id=" + strconv.FormatUint(id, 10))
+	if err != nil {
+		return cachedata.WarmJob{}, err
+	}
+	return readJob(resp, http.StatusOK)
+}
+
+// readJob reads and closes the given response, and returns its job if it has the expected code.
+func readJob(resp *http.Response, expectedCode int) (cachedata.WarmJob, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return cachedata.WarmJob{}, errors.New("reading response: " + err.Error())
+	}
+	if resp.StatusCode != expectedCode {
+		return cachedata.WarmJob{}, fmt.Errorf("grove responded %v: %s", resp.StatusCode, body)
+	}
+	job := cachedata.WarmJob{}
+	if err := json.Unmarshal(body, &job); err != nil {
+		return cachedata.WarmJob{}, errors.New("parsing response: " + err.Error())
+	}
+	return job, nil
+}
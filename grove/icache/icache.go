@@ -25,7 +25,26 @@ type Cache interface {
 	Capacity() uint64
 	Get(key string) (*cacheobj.CacheObj, bool)
 	Peek(key string) (*cacheobj.CacheObj, bool)
+	// Remove removes the key from the cache, returning whether it existed.
+	Remove(key string) bool
 	Keys() []string
 	Size() uint64
 	Close()
+	// PolicyStats returns the name of the cache's eviction policy, and the hits and misses of Get.
+	PolicyStats() PolicyStats
+}
+
+// PolicyStats are the statistics of a cache's eviction policy, for comparing the hit ratios of policies.
+type PolicyStats struct {
+	Policy string
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRatio returns the ratio of Gets which were hits, or 0 if there were none.
+func (s PolicyStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
 }
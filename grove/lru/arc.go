@@ -0,0 +1,218 @@
+package lru
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ARC is a Policy implementing the Adaptive Replacement Cache of Megiddo and Modha. Keys used once are kept in a recency list t1, and keys used more than once in a frequency list t2. Evicted keys are remembered in the ghost lists b1 and b2, and a use of a ghost key adapts the target size of t1, so the policy balances recency and frequency for the workload.
+// Because the cache size is in bytes, and enforced by the cache rather than the policy, the target and ghost list sizes are in keys, relative to the number of keys currently cached.
+type ARC struct {
+	t1    *list.List
+	t2    *list.List
+	b1    *list.List
+	b2    *list.List
+	elems map[string]*list.Element
+	// p is the target number of keys in t1.
+	p int
+	m sync.Mutex
+}
+
+type arcObj struct {
+	key  string
+	size uint64
+	list *list.List
+}
+
+func NewARC() *ARC {
+	return &ARC{t1: list.New(), t2: list.New(), b1: list.New(), b2: list.New(), elems: map[string]*list.Element{}}
+}
+
+func (c *ARC) Add(key string, size uint64) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.elems[key]
+	if !ok {
+		c.elems[key] = c.push(c.t1, &arcObj{key: key, size: size})
+		return 0
+	}
+	obj := elem.Value.(*arcObj)
+	switch obj.list {
+	case c.t1, c.t2:
+		oldSize := obj.size
+		obj.size = size
+		c.moveTo(elem, c.t2)
+		return oldSize
+	case c.b1:
+		c.p = minInt(c.p+maxInt(c.b2.Len()/maxInt(c.b1.Len(), 1), 1), c.resident())
+	case c.b2:
+		c.p = maxInt(c.p-maxInt(c.b1.Len()/maxInt(c.b2.Len(), 1), 1), 0)
+	}
+	// ghost hit: the key was evicted, but would have been used again. Re-add it as frequent.
+	obj.size = size
+	c.moveTo(elem, c.t2)
+	return 0
+}
+
+func (c *ARC) AddOldest(key string, size uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if elem, ok := c.elems[key]; ok {
+		if obj := elem.Value.(*arcObj); obj.list == c.t1 || obj.list == c.t2 {
+			return false
+		}
+		c.remove(elem)
+	}
+	obj := &arcObj{key: key, size: size, list: c.t1}
+	c.elems[key] = c.t1.PushBack(obj)
+	return true
+}
+
+func (c *ARC) Touch(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.elems[key]
+	if !ok {
+		return false
+	}
+	if obj := elem.Value.(*arcObj); obj.list != c.t1 && obj.list != c.t2 {
+		return false // ghosts aren't cached
+	}
+	c.moveTo(elem, c.t2)
+	return true
+}
+
+func (c *ARC) Oldest() (string, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	l := c.replaceList()
+	if l == nil {
+		return "", false
+	}
+	return l.Back().Value.(*arcObj).key, true
+}
+
+func (c *ARC) RemoveOldest() (string, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	l := c.replaceList()
+	if l == nil {
+		return "", 0, false
+	}
+	elem := l.Back()
+	obj := elem.Value.(*arcObj)
+	ghost := c.b1
+	if l == c.t2 {
+		ghost = c.b2
+	}
+	c.moveTo(elem, ghost)
+	c.trimGhosts()
+	return obj.key, obj.size, true
+}
+
+func (c *ARC) Remove(key string) (uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.elems[key]
+	if !ok {
+		return 0, false
+	}
+	obj := elem.Value.(*arcObj)
+	c.remove(elem)
+	if obj.list != c.t1 && obj.list != c.t2 {
+		return 0, false
+	}
+	return obj.size, true
+}
+
+// Keys returns the cached keys, the recent keys from oldest to newest, then the frequent keys from oldest to newest.
+func (c *ARC) Keys() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	keys := make([]string, 0, c.resident())
+	for _, l := range []*list.List{c.t1, c.t2} {
+		for e := l.Back(); e != nil; e = e.Prev() {
+			keys = append(keys, e.Value.(*arcObj).key)
+		}
+	}
+	return keys
+}
+
+func (c *ARC) Name() string { return PolicyARC }
+
+// replaceList returns the list to evict from, per the ARC REPLACE subroutine, or nil if nothing is cached. Must be called with the mutex locked.
+func (c *ARC) replaceList() *list.List {
+	switch {
+	case c.t1.Len() > 0 && (c.t1.Len() > c.p || c.t2.Len() == 0):
+		return c.t1
+	case c.t2.Len() > 0:
+		return c.t2
+	case c.t1.Len() > 0:
+		return c.t1
+	}
+	return nil
+}
+
+// trimGhosts removes the oldest ghosts, until each ghost list is no larger than the number of cached keys. Must be called with the mutex locked.
+func (c *ARC) trimGhosts() {
+	max := maxInt(c.resident(), 1)
+	for _, l := range []*list.List{c.b1, c.b2} {
+		for l.Len() > max {
+			c.remove(l.Back())
+		}
+	}
+}
+
+func (c *ARC) resident() int { return c.t1.Len() + c.t2.Len() }
+
+// push pushes the given object to the front of the given list. Must be called with the mutex locked.
+func (c *ARC) push(l *list.List, obj *arcObj) *list.Element {
+	obj.list = l
+	return l.PushFront(obj)
+}
+
+// moveTo moves the given element to the front of the given list. Must be called with the mutex locked.
+func (c *ARC) moveTo(elem *list.Element, l *list.List) {
+	obj := elem.Value.(*arcObj)
+	if obj.list == l {
+		l.MoveToFront(elem)
+		return
+	}
+	obj.list.Remove(elem)
+	c.elems[obj.key] = c.push(l, obj)
+}
+
+// remove removes the given element from its list, and the key map. Must be called with the mutex locked.
+func (c *ARC) remove(elem *list.Element) {
+	obj := elem.Value.(*arcObj)
+	obj.list.Remove(elem)
+	delete(c.elems, obj.key)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
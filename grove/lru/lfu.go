@@ -0,0 +1,172 @@
+package lru
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// LFU is a Policy evicting the least frequently used key. Keys used equally often are evicted least recently used first.
+// Frequencies are counted from when a key is added, so a key which was hot and has gone cold is only evicted after keys used less often in total.
+type LFU struct {
+	h     lfuHeap
+	elems map[string]*lfuObj
+	// seq is the count of uses, used to order keys with the same frequency by recency.
+	seq int64
+	// oldestSeq is decremented for each AddOldest, so restored keys are older than any used key.
+	oldestSeq int64
+	m         sync.Mutex
+}
+
+type lfuObj struct {
+	key   string
+	size  uint64
+	freq  uint64
+	seq   int64
+	index int
+}
+
+func NewLFU() *LFU {
+	return &LFU{elems: map[string]*lfuObj{}}
+}
+
+func (c *LFU) Add(key string, size uint64) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.seq++
+	if obj, ok := c.elems[key]; ok {
+		oldSize := obj.size
+		obj.size = size
+		c.use(obj)
+		return oldSize
+	}
+	obj := &lfuObj{key: key, size: size, freq: 1, seq: c.seq}
+	c.elems[key] = obj
+	heap.Push(&c.h, obj)
+	return 0
+}
+
+func (c *LFU) AddOldest(key string, size uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.elems[key]; ok {
+		return false
+	}
+	c.oldestSeq--
+	obj := &lfuObj{key: key, size: size, freq: 0, seq: c.oldestSeq}
+	c.elems[key] = obj
+	heap.Push(&c.h, obj)
+	return true
+}
+
+func (c *LFU) Touch(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	obj, ok := c.elems[key]
+	if !ok {
+		return false
+	}
+	c.seq++
+	c.use(obj)
+	return true
+}
+
+// use increments the frequency of the given object, and reorders the heap. Must be called with the mutex locked, after incrementing c.seq.
+func (c *LFU) use(obj *lfuObj) {
+	obj.freq++
+	obj.seq = c.seq
+	heap.Fix(&c.h, obj.index)
+}
+
+func (c *LFU) Oldest() (string, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.h) == 0 {
+		return "", false
+	}
+	return c.h[0].key, true
+}
+
+func (c *LFU) RemoveOldest() (string, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.h) == 0 {
+		return "", 0, false
+	}
+	obj := heap.Pop(&c.h).(*lfuObj)
+	delete(c.elems, obj.key)
+	return obj.key, obj.size, true
+}
+
+func (c *LFU) Remove(key string) (uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	obj, ok := c.elems[key]
+	if !ok {
+		return 0, false
+	}
+	heap.Remove(&c.h, obj.index)
+	delete(c.elems, key)
+	return obj.size, true
+}
+
+// Keys returns the keys, from the least to the most frequently used. This sorts all keys, and should not be called frequently.
+func (c *LFU) Keys() []string {
+	c.m.Lock()
+	objs := make(lfuHeap, len(c.h))
+	for i, obj := range c.h {
+		objCopy := *obj // copy, so sorting doesn't race with uses
+		objs[i] = &objCopy
+	}
+	c.m.Unlock()
+	sort.Slice(objs, func(i, j int) bool { return objs.Less(i, j) })
+	keys := make([]string, len(objs))
+	for i, obj := range objs {
+		keys[i] = obj.key
+	}
+	return keys
+}
+
+func (c *LFU) Name() string { return PolicyLFU }
+
+// lfuHeap is a min-heap of objects by frequency, then recency. It implements heap.Interface.
+type lfuHeap []*lfuObj
+
+func (h lfuHeap) Len() int { return len(h) }
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap) Push(x interface{}) {
+	obj := x.(*lfuObj)
+	obj.index = len(*h)
+	*h = append(*h, obj)
+}
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	obj := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return obj
+}
@@ -19,6 +19,7 @@ import (
 	"sync"
 )
 
+// LRU is a Policy evicting the least recently used key.
 type LRU struct {
 	l      *list.List
 	lElems map[string]*list.Element
@@ -48,6 +49,41 @@ func (c *LRU) Add(key string, size uint64) uint64 {
 	return 0
 }
 
+// AddOldest adds the key to the LRU as the least recently used, with the given size, if it doesn't already exist. Returns whether the key was added.
+// This is used to restore a persisted order, after keys may have been added by use: restored keys are older than any key added since, so they're added newest first, each behind the last.
+func (c *LRU) AddOldest(key string, size uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.lElems[key]; ok {
+		return false
+	}
+	c.lElems[key] = c.l.PushBack(&listObj{key, size})
+	return true
+}
+
+// Touch marks the key as the most recently used, without changing its size. Returns whether the key existed.
+func (c *LRU) Touch(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.lElems[key]
+	if !ok {
+		return false
+	}
+	c.l.MoveToFront(elem)
+	return true
+}
+
+// Oldest returns the least recently used key, without removing it, and true if the LRU is nonempty; else false.
+func (c *LRU) Oldest() (string, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	elem := c.l.Back()
+	if elem == nil {
+		return "", false
+	}
+	return elem.Value.(*listObj).key, true
+}
+
 // RemoveOldest returns the key, size, and true if the LRU is nonempty; else false.
 func (c *LRU) RemoveOldest() (string, uint64, bool) {
 	c.m.Lock()
@@ -63,7 +99,20 @@ func (c *LRU) RemoveOldest() (string, uint64, bool) {
 	return obj.key, obj.size, true
 }
 
-// Keys returns a string array of the keys
+// Remove removes the key from the LRU. Returns the size of the removed key, and true if it existed; else false.
+func (c *LRU) Remove(key string) (uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	elem, ok := c.lElems[key]
+	if !ok {
+		return 0, false
+	}
+	c.l.Remove(elem)
+	delete(c.lElems, key)
+	return elem.Value.(*listObj).size, true
+}
+
+// Keys returns a string array of the keys, from the least to the most recently used.
 func (c *LRU) Keys() []string {
 	c.m.RLock()
 	defer c.m.RUnlock()
@@ -74,3 +123,5 @@ func (c *LRU) Keys() []string {
 	}
 	return arr
 }
+
+func (c *LRU) Name() string { return PolicyLRU }
@@ -0,0 +1,71 @@
+package lru
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"strings"
+)
+
+// Policy is a cache eviction policy. It tracks the keys in a cache and their sizes, and chooses which key to evict next. The cache itself enforces its size, by calling RemoveOldest until it's small enough.
+// Implementations must be threadsafe.
+type Policy interface {
+	// Add adds the key with the given size, or updates its size if it exists, and marks it used. Returns the old size, or 0 if the key didn't exist.
+	Add(key string, size uint64) uint64
+	// AddOldest adds the key with the given size as the next to evict, if it doesn't exist, for restoring a persisted order. Returns whether the key was added.
+	AddOldest(key string, size uint64) bool
+	// Touch marks the key used, without changing its size. Returns whether the key existed.
+	Touch(key string) bool
+	// Oldest returns the key RemoveOldest would remove, without removing it, and true if the policy is nonempty; else false.
+	Oldest() (string, bool)
+	// RemoveOldest removes the key to evict next. Returns its key, size, and true if the policy is nonempty; else false.
+	RemoveOldest() (string, uint64, bool)
+	// Remove removes the key. Returns the size of the removed key, and true if it existed; else false.
+	Remove(key string) (uint64, bool)
+	// Keys returns the keys, approximately from the next to be evicted to the last.
+	Keys() []string
+	// Name returns the name of the policy, as given to NewPolicy.
+	Name() string
+}
+
+const PolicyLRU = "lru"
+const PolicyLFU = "lfu"
+const PolicyARC = "arc"
+
+const AdmissionNone = ""
+const AdmissionTinyLFU = "tinylfu"
+
+// NewPolicy returns a new Policy with the given eviction policy and admission filter. An empty eviction policy is LRU, and an empty admission filter admits all keys.
+func NewPolicy(eviction string, admission string) (Policy, error) {
+	policy := Policy(nil)
+	switch strings.ToLower(eviction) {
+	case PolicyLRU, "":
+		policy = NewLRU()
+	case PolicyLFU:
+		policy = NewLFU()
+	case PolicyARC:
+		policy = NewARC()
+	default:
+		return nil, errors.New("unknown eviction policy '" + eviction + "'")
+	}
+	switch strings.ToLower(admission) {
+	case AdmissionNone:
+	case AdmissionTinyLFU:
+		policy = NewTinyLFU(policy)
+	default:
+		return nil, errors.New("unknown admission filter '" + admission + "'")
+	}
+	return policy, nil
+}
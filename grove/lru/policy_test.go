@@ -0,0 +1,169 @@
+package lru
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := map[[2]string]string{
+		{"", ""}:           PolicyLRU,
+		{"LRU", ""}:        PolicyLRU,
+		{"lfu", ""}:        PolicyLFU,
+		{"arc", ""}:        PolicyARC,
+		{"arc", "tinylfu"}: "tinylfu-arc",
+	}
+	for args, expected := range tests {
+		policy, err := NewPolicy(args[0], args[1])
+		if err != nil {
+			t.Errorf("NewPolicy(%q, %q) error expected nil, actual %v", args[0], args[1], err)
+			continue
+		}
+		if actual := policy.Name(); actual != expected {
+			t.Errorf("NewPolicy(%q, %q) name expected %v, actual %v", args[0], args[1], expected, actual)
+		}
+	}
+	if _, err := NewPolicy("fifo", ""); err == nil {
+		t.Errorf("NewPolicy unknown eviction error expected, actual nil")
+	}
+	if _, err := NewPolicy("lru", "bloom"); err == nil {
+		t.Errorf("NewPolicy unknown admission error expected, actual nil")
+	}
+}
+
+// testPolicyBasics tests the behavior common to all policies: sizes, removal, and evicting everything exactly once.
+func testPolicyBasics(t *testing.T, policy Policy) {
+	if oldSize := policy.Add("a", 1); oldSize != 0 {
+		t.Errorf("%v Add new key old size expected 0, actual %v", policy.Name(), oldSize)
+	}
+	policy.Add("b", 2)
+	policy.Add("c", 3)
+	if oldSize := policy.Add("a", 10); oldSize != 1 {
+		t.Errorf("%v Add existing key old size expected 1, actual %v", policy.Name(), oldSize)
+	}
+	if !policy.Touch("b") {
+		t.Errorf("%v Touch existing key expected true, actual false", policy.Name())
+	}
+	if policy.Touch("z") {
+		t.Errorf("%v Touch missing key expected false, actual true", policy.Name())
+	}
+	if size, ok := policy.Remove("c"); !ok || size != 3 {
+		t.Errorf("%v Remove expected 3 true, actual %v %v", policy.Name(), size, ok)
+	}
+	if !policy.AddOldest("d", 4) {
+		t.Errorf("%v AddOldest new key expected true, actual false", policy.Name())
+	}
+	if policy.AddOldest("a", 4) {
+		t.Errorf("%v AddOldest existing key expected false, actual true", policy.Name())
+	}
+	if keys := policy.Keys(); len(keys) != 3 {
+		t.Errorf("%v Keys expected 3, actual %v", policy.Name(), keys)
+	}
+
+	sizes := map[string]uint64{}
+	for {
+		oldest, oldestOK := policy.Oldest()
+		key, size, ok := policy.RemoveOldest()
+		if oldestOK != ok || oldest != key {
+			t.Errorf("%v Oldest expected %v %v, actual %v %v", policy.Name(), key, ok, oldest, oldestOK)
+		}
+		if !ok {
+			break
+		}
+		if _, ok := sizes[key]; ok {
+			t.Fatalf("%v RemoveOldest returned key '%v' twice", policy.Name(), key)
+		}
+		sizes[key] = size
+	}
+	if expected := map[string]uint64{"a": 10, "b": 2, "d": 4}; fmt.Sprint(sizes) != fmt.Sprint(expected) {
+		t.Errorf("%v evicted expected %v, actual %v", policy.Name(), expected, sizes)
+	}
+}
+
+func TestPolicyBasics(t *testing.T) {
+	testPolicyBasics(t, NewLRU())
+	testPolicyBasics(t, NewLFU())
+	testPolicyBasics(t, NewARC())
+	testPolicyBasics(t, NewTinyLFU(NewLRU()))
+	testPolicyBasics(t, NewTinyLFU(NewARC()))
+}
+
+func TestLFU(t *testing.T) {
+	c := NewLFU()
+	c.Add("a", 1)
+	c.Add("b", 1)
+	c.Add("c", 1)
+	c.Touch("a")
+	c.Touch("a")
+	c.Touch("c")
+	c.AddOldest("d", 1)
+	for _, expected := range []string{"d", "b", "c", "a"} {
+		if key, _, _ := c.RemoveOldest(); key != expected {
+			t.Errorf("LFU RemoveOldest expected %v, actual %v", expected, key)
+		}
+	}
+}
+
+// TestScanResistance tests that a scan of keys used once evicts the hot keys with LRU, but not with LFU, ARC, or TinyLFU.
+func TestScanResistance(t *testing.T) {
+	const hot = 10
+	const cacheKeys = 20
+	const scanKeys = 15 // each round uses more keys than fit in the cache
+	hitRatio := func(policy Policy) float64 {
+		keys := map[string]struct{}{}
+		get := func(key string) bool {
+			if _, ok := keys[key]; ok {
+				policy.Touch(key)
+				return true
+			}
+			policy.Add(key, 1)
+			keys[key] = struct{}{}
+			for len(keys) > cacheKeys {
+				evicted, _, _ := policy.RemoveOldest()
+				delete(keys, evicted)
+			}
+			return false
+		}
+		for i := 0; i < 2*hot; i++ {
+			get(fmt.Sprintf("hot%v", i%hot)) // warm up, so the hot keys have been used more than once
+		}
+		hits, gets := 0, 0
+		scan := 0
+		for round := 0; round < 100; round++ {
+			for i := 0; i < hot; i++ {
+				if get(fmt.Sprintf("hot%v", i)) {
+					hits++
+				}
+				gets++
+			}
+			for i := 0; i < scanKeys; i++ {
+				get(fmt.Sprintf("scan%v", scan)) // scans are never hits, so aren't counted
+				scan++
+			}
+		}
+		return float64(hits) / float64(gets)
+	}
+
+	if ratio := hitRatio(NewLRU()); ratio > 0.1 {
+		t.Errorf("LRU scan hit ratio expected ~0, actual %v", ratio)
+	}
+	for _, policy := range []Policy{NewLFU(), NewARC(), NewTinyLFU(NewLRU())} {
+		if ratio := hitRatio(policy); ratio < 0.9 {
+			t.Errorf("%v scan hit ratio expected > 0.9, actual %v", policy.Name(), ratio)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package lru
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// TinyLFU is a Policy filtering admission to another policy, by the approximate frequency of keys' recent use, per Einziger, Friedman, and Manes.
+// New keys are added to a probation LRU, not the main policy. A key is admitted to the main policy when it's used again, or when it would be evicted but is used more often than the main policy's next eviction, which is then evicted instead. Thus, keys used once, such as by a scan, are evicted before the hot keys in the main policy.
+type TinyLFU struct {
+	main      Policy
+	probation *LRU
+	// admitted is whether each key is in the main policy, rather than probation.
+	admitted map[string]bool
+	sketch   *countMinSketch
+	m        sync.Mutex
+}
+
+func NewTinyLFU(main Policy) *TinyLFU {
+	return &TinyLFU{main: main, probation: NewLRU(), admitted: map[string]bool{}, sketch: newCountMinSketch(sketchWidth)}
+}
+
+func (c *TinyLFU) Add(key string, size uint64) uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.sketch.increment(key)
+	admitted, ok := c.admitted[key]
+	switch {
+	case !ok:
+		c.admitted[key] = false
+		return c.probation.Add(key, size)
+	case admitted:
+		return c.main.Add(key, size)
+	}
+	oldSize, _ := c.probation.Remove(key)
+	c.admit(key, size)
+	return oldSize
+}
+
+// AddOldest adds the key to the main policy, because restored keys were already admitted.
+func (c *TinyLFU) AddOldest(key string, size uint64) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.admitted[key]; ok {
+		return false
+	}
+	c.admitted[key] = true
+	return c.main.AddOldest(key, size)
+}
+
+func (c *TinyLFU) Touch(key string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	admitted, ok := c.admitted[key]
+	if !ok {
+		return false
+	}
+	c.sketch.increment(key)
+	if admitted {
+		return c.main.Touch(key)
+	}
+	size, _ := c.probation.Remove(key)
+	c.admit(key, size)
+	return true
+}
+
+func (c *TinyLFU) Oldest() (string, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	candidate, victim, evictVictim, ok := c.next()
+	if !ok {
+		return "", false
+	}
+	if evictVictim {
+		return victim, true
+	}
+	return candidate, true
+}
+
+func (c *TinyLFU) RemoveOldest() (string, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	candidate, _, evictVictim, ok := c.next()
+	if !ok {
+		return "", 0, false
+	}
+	if !evictVictim {
+		key, size, _ := c.probation.RemoveOldest()
+		delete(c.admitted, key)
+		return key, size, true
+	}
+	key, size, _ := c.main.RemoveOldest()
+	delete(c.admitted, key)
+	if candidateSize, ok := c.probation.Remove(candidate); ok {
+		c.admit(candidate, candidateSize)
+	}
+	return key, size, true
+}
+
+func (c *TinyLFU) Remove(key string) (uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	admitted, ok := c.admitted[key]
+	if !ok {
+		return 0, false
+	}
+	delete(c.admitted, key)
+	if admitted {
+		return c.main.Remove(key)
+	}
+	return c.probation.Remove(key)
+}
+
+// Keys returns the keys on probation, from the least recently used, then the keys of the main policy.
+func (c *TinyLFU) Keys() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append(c.probation.Keys(), c.main.Keys()...)
+}
+
+func (c *TinyLFU) Name() string { return AdmissionTinyLFU + "-" + c.main.Name() }
+
+// next returns the oldest key on probation, and the main policy's next eviction, and whether the main policy's key should be evicted. If there are no keys on probation, the main policy's key is evicted. Returns false if there are no keys. Must be called with the mutex locked.
+func (c *TinyLFU) next() (string, string, bool, bool) {
+	candidate, candidateOK := c.probation.Oldest()
+	victim, victimOK := c.main.Oldest()
+	switch {
+	case !candidateOK && !victimOK:
+		return "", "", false, false
+	case !candidateOK:
+		return "", victim, true, true
+	case !victimOK:
+		return candidate, "", false, true
+	}
+	return candidate, victim, c.sketch.estimate(candidate) > c.sketch.estimate(victim), true
+}
+
+// admit moves the key from probation to the main policy. The key must already have been removed from probation. Must be called with the mutex locked.
+func (c *TinyLFU) admit(key string, size uint64) {
+	c.admitted[key] = true
+	c.main.Add(key, size)
+}
+
+// sketchWidth is the number of counters in each row of the sketch. Counters are bytes, so the sketch uses sketchDepth*sketchWidth bytes.
+const sketchWidth = 1 << 16
+const sketchDepth = 4
+
+// countMinSketch estimates the frequency of keys, in a fixed amount of memory. Estimates may be too high, but never too low.
+// After sampleSize increments, all counters are halved, so estimates are of recent frequency, and keys which have gone cold age out.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	increments uint64
+	sampleSize uint64
+}
+
+// newCountMinSketch returns a new sketch with the given width, which must be a power of 2.
+func newCountMinSketch(width int) *countMinSketch {
+	s := &countMinSketch{mask: uint64(width - 1), sampleSize: uint64(width) * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes returns the index of the key's counter in each row, by double hashing.
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	idxs := [sketchDepth]uint64{}
+	for i := range idxs {
+		idxs[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+	return idxs
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < 255 {
+			s.rows[i][idx]++
+		}
+	}
+	s.increments++
+	if s.increments >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	min := uint8(255)
+	for i, idx := range s.indexes(key) {
+		if s.rows[i][idx] < min {
+			min = s.rows[i][idx]
+		}
+	}
+	return min
+}
+
+// reset halves all counters.
+func (s *countMinSketch) reset() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	s.increments /= 2
+}
@@ -19,26 +19,33 @@ import (
 	"sync/atomic"
 
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/lru"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
 )
 
-// MemCache is a threadsafe memory cache with a soft byte limit, enforced via LRU.
+// MemCache is a threadsafe memory cache with a soft byte limit, enforced via an eviction policy, by default LRU.
 type MemCache struct {
-	lru          *lru.LRU                      // threadsafe.
+	policy       lru.Policy                    // threadsafe.
 	cache        map[string]*cacheobj.CacheObj // mutexed: MUST NOT access without locking cacheM. TODO test performance of sync.Map
 	cacheM       sync.RWMutex                  // TODO test performance of one mutex for lru+cache
 	sizeBytes    uint64                        // atomic: MUST NOT access without sync.atomic
 	maxSizeBytes uint64                        // constant: MUST NOT be modified after creation
 	gcChan       chan<- uint64
+	hits         uint64 // atomic
+	misses       uint64 // atomic
 }
 
-func New(bytes uint64) *MemCache {
+// New creates a new MemCache with the given capacity and eviction policy. If policy is nil, LRU is used.
+func New(bytes uint64, policy lru.Policy) *MemCache {
 	log.Errorf("MemCache.New: creating cache with %d capacity.", bytes)
+	if policy == nil {
+		policy = lru.NewLRU()
+	}
 	gcChan := make(chan uint64, 1)
 	c := &MemCache{
-		lru:          lru.NewLRU(),
+		policy:       policy,
 		cache:        map[string]*cacheobj.CacheObj{},
 		maxSizeBytes: bytes,
 		gcChan:       gcChan,
@@ -51,9 +58,14 @@ func (c *MemCache) Get(key string) (*cacheobj.CacheObj, bool) {
 	c.cacheM.RLock()
 	obj, ok := c.cache[key]
 	if ok {
-		c.lru.Add(key, obj.Size) // TODO directly call c.ll.MoveToFront
+		c.policy.Touch(key)
 	}
 	c.cacheM.RUnlock()
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
 	return obj, ok
 }
 
@@ -68,7 +80,7 @@ func (c *MemCache) Add(key string, val *cacheobj.CacheObj) bool {
 	c.cacheM.Lock()
 	c.cache[key] = val
 	c.cacheM.Unlock()
-	oldSize := c.lru.Add(key, val.Size)
+	oldSize := c.policy.Add(key, val.Size)
 	sizeChange := val.Size - oldSize
 	if sizeChange == 0 {
 		return false
@@ -81,6 +93,18 @@ func (c *MemCache) Add(key string, val *cacheobj.CacheObj) bool {
 	return false // TODO remove eviction from interface; it's unnecessary and expensive
 }
 
+// Remove removes the key from the cache, returning whether it existed.
+func (c *MemCache) Remove(key string) bool {
+	c.cacheM.Lock()
+	_, ok := c.cache[key]
+	delete(c.cache, key)
+	c.cacheM.Unlock()
+	if sizeBytes, inLRU := c.policy.Remove(key); inLRU {
+		atomic.AddUint64(&c.sizeBytes, ^uint64(sizeBytes-1)) // subtract sizeBytes
+	}
+	return ok
+}
+
 func (c *MemCache) Size() uint64 { return atomic.LoadUint64(&c.sizeBytes) }
 func (c *MemCache) Close()       {}
 
@@ -103,7 +127,7 @@ func (c *MemCache) gcManager(gcChan <-chan uint64) {
 func (c *MemCache) gc(cacheSizeBytes uint64) {
 	for cacheSizeBytes > c.maxSizeBytes {
 		log.Debugf("MemCache.gc cacheSizeBytes %+v > c.maxSizeBytes %+v\n", cacheSizeBytes, c.maxSizeBytes)
-		key, sizeBytes, exists := c.lru.RemoveOldest() // TODO change lru to use strings
+		key, sizeBytes, exists := c.policy.RemoveOldest() // TODO change lru to use strings
 		if !exists {
 			// should never happen
 			log.Errorf("MemCache.gc sizeBytes %v > %v maxSizeBytes, but LRU is empty!? Setting cache size to 0!\n", cacheSizeBytes, c.maxSizeBytes)
@@ -121,9 +145,13 @@ func (c *MemCache) gc(cacheSizeBytes uint64) {
 }
 
 func (c *MemCache) Keys() []string {
-	return c.lru.Keys()
+	return c.policy.Keys()
 }
 
 func (c *MemCache) Capacity() uint64 {
 	return c.maxSizeBytes
 }
+
+func (c *MemCache) PolicyStats() icache.PolicyStats {
+	return icache.PolicyStats{Policy: c.policy.Name(), Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+}
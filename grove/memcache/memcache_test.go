@@ -0,0 +1,163 @@
+package memcache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/lru"
+)
+
+func newTestSharded(t testing.TB, bytes uint64, shards int) *ShardedMemCache {
+	c, err := NewSharded(bytes, shards, nil)
+	if err != nil {
+		t.Fatalf("creating sharded cache: %v", err)
+	}
+	return c
+}
+
+func TestShardedMemCache(t *testing.T) {
+	c := newTestSharded(t, 1000, 4)
+	keys := []string{}
+	for i := 0; i < 10; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		c.Add(key, &cacheobj.CacheObj{Size: 10})
+	}
+	if size := c.Size(); size != 100 {
+		t.Errorf("size expected 100, actual %v", size)
+	}
+	for _, key := range keys {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("get '%v' expected cached, actual not cached", key)
+		}
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Errorf("get 'missing' expected not cached, actual cached")
+	}
+	if stats := c.PolicyStats(); stats.Policy != lru.PolicyLRU || stats.Hits != 10 || stats.Misses != 1 {
+		t.Errorf("policy stats expected lru with 10 hits and 1 miss, actual %+v", stats)
+	}
+
+	actualKeys := c.Keys()
+	sort.Strings(actualKeys)
+	if len(actualKeys) != len(keys) {
+		t.Errorf("keys expected %v, actual %v", keys, actualKeys)
+	}
+
+	c.Add("key0", &cacheobj.CacheObj{Size: 30})
+	if size := c.Size(); size != 120 {
+		t.Errorf("size after replacing expected 120, actual %v", size)
+	}
+	if !c.Remove("key0") {
+		t.Errorf("remove 'key0' expected true, actual false")
+	}
+	if c.Remove("key0") {
+		t.Errorf("remove removed 'key0' expected false, actual true")
+	}
+	if size := c.Size(); size != 90 {
+		t.Errorf("size after removing expected 90, actual %v", size)
+	}
+}
+
+func TestShardedMemCacheEviction(t *testing.T) {
+	const shards = 4
+	c := newTestSharded(t, 100, shards)
+	for i := 0; i < 100; i++ {
+		c.Add("key"+strconv.Itoa(i), &cacheobj.CacheObj{Size: 10})
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Size() > c.Capacity() && time.Now().Before(deadline) {
+		runtime.Gosched()
+	}
+	if size := c.Size(); size > c.Capacity() {
+		t.Fatalf("size expected at most %v after eviction, actual %v", c.Capacity(), size)
+	}
+	shardSizes := uint64(0)
+	for _, s := range c.shards {
+		shardSizes += atomic.LoadUint64(&s.sizeBytes)
+	}
+	if shardSizes != c.Size() {
+		t.Errorf("shard sizes expected to sum to the cache size %v, actual %v", c.Size(), shardSizes)
+	}
+	if _, ok := c.Peek("key99"); !ok {
+		t.Errorf("most recently added key expected cached, actual evicted")
+	}
+}
+
+func TestShardedMemCachePolicyError(t *testing.T) {
+	newPolicy := func() (lru.Policy, error) { return lru.NewPolicy("nonexistent", "") }
+	if _, err := NewSharded(100, 4, newPolicy); err == nil {
+		t.Errorf("creating sharded cache with an invalid policy expected error, actual nil")
+	}
+}
+
+const benchCacheBytes = 1024 * 1024 * 1024
+const benchKeys = 10000
+
+// benchmarkParallel benchmarks concurrent requests for benchKeys keys, on GOMAXPROCS goroutines. Every addEvery'th request is an Add, and the rest are Gets, which all hit. If addEvery is 0, every request is a Get.
+func benchmarkParallel(b *testing.B, c icache.Cache, addEvery int) {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "GET:http://origin.example.net/objects/" + strconv.Itoa(i)
+		c.Add(keys[i], &cacheobj.CacheObj{Size: 1024})
+	}
+	goroutines := uint64(0)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddUint64(&goroutines, 1)) * benchKeys / 7 // start each goroutine at a different key
+		for pb.Next() {
+			key := keys[(i*7919)%len(keys)] // spread each goroutine's requests over the keys
+			if addEvery > 0 && i%addEvery == 0 {
+				c.Add(key, &cacheobj.CacheObj{Size: 1024})
+			} else {
+				c.Get(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMemCacheParallelGet(b *testing.B) {
+	benchmarkParallel(b, New(benchCacheBytes, nil), 0)
+}
+
+func BenchmarkShardedMemCacheParallelGet(b *testing.B) {
+	benchmarkParallel(b, newTestSharded(b, benchCacheBytes, 64), 0)
+}
+
+func BenchmarkMemCacheParallelMixed(b *testing.B) {
+	benchmarkParallel(b, New(benchCacheBytes, nil), 10)
+}
+
+func BenchmarkShardedMemCacheParallelMixed(b *testing.B) {
+	benchmarkParallel(b, newTestSharded(b, benchCacheBytes, 64), 10)
+}
+
+func BenchmarkShardedMemCacheParallelGetShards(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64, 256} {
+		b.Run(strconv.Itoa(shards), func(b *testing.B) {
+			benchmarkParallel(b, newTestSharded(b, benchCacheBytes, shards), 0)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package memcache
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/lru"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// ShardedMemCache is a threadsafe memory cache like MemCache, whose keys are split among independently locked shards, each with its own eviction policy and size, so concurrent requests for different keys rarely contend for the same lock. The byte limit is global: when the cache exceeds it, objects are evicted from the largest shard, by that shard's policy.
+type ShardedMemCache struct {
+	sizeBytes    uint64 // atomic: MUST NOT access without sync.atomic
+	maxSizeBytes uint64 // constant: MUST NOT be modified after creation
+	shards       []*memShard
+	gcChan       chan<- uint64
+}
+
+// memShard is one shard of a ShardedMemCache.
+type memShard struct {
+	sizeBytes uint64 // atomic
+	// hits and misses are counted per shard, so concurrent Gets don't contend for one counter.
+	hits   uint64                        // atomic
+	misses uint64                        // atomic
+	policy lru.Policy                    // threadsafe.
+	cache  map[string]*cacheobj.CacheObj // mutexed: MUST NOT access without locking cacheM.
+	cacheM sync.RWMutex
+}
+
+// NewSharded creates a new ShardedMemCache with the given capacity, number of shards, and eviction policy of each shard. If newPolicy is nil, each shard uses LRU.
+func NewSharded(bytes uint64, shards int, newPolicy func() (lru.Policy, error)) (*ShardedMemCache, error) {
+	log.Infof("MemCache.NewSharded: creating cache with %d capacity and %d shards.\n", bytes, shards)
+	if shards < 1 {
+		shards = 1
+	}
+	if newPolicy == nil {
+		newPolicy = func() (lru.Policy, error) { return lru.NewLRU(), nil }
+	}
+	gcChan := make(chan uint64, 1)
+	c := &ShardedMemCache{
+		maxSizeBytes: bytes,
+		shards:       make([]*memShard, shards),
+		gcChan:       gcChan,
+	}
+	for i := range c.shards {
+		policy, err := newPolicy()
+		if err != nil {
+			return nil, err
+		}
+		c.shards[i] = &memShard{policy: policy, cache: map[string]*cacheobj.CacheObj{}}
+	}
+	go c.gcManager(gcChan)
+	return c, nil
+}
+
+// shard returns the shard of the given key, by its FNV-1a hash. The hash is computed inline, rather than with hash/fnv, to avoid allocating on every request.
+func (c *ShardedMemCache) shard(key string) *memShard {
+	const offset32 = 2166136261
+	const prime32 = 16777619
+	hash := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= prime32
+	}
+	return c.shards[hash%uint32(len(c.shards))]
+}
+
+func (c *ShardedMemCache) Get(key string) (*cacheobj.CacheObj, bool) {
+	s := c.shard(key)
+	s.cacheM.RLock()
+	obj, ok := s.cache[key]
+	if ok {
+		s.policy.Touch(key)
+	}
+	s.cacheM.RUnlock()
+	if ok {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	return obj, ok
+}
+
+func (c *ShardedMemCache) Peek(key string) (*cacheobj.CacheObj, bool) {
+	s := c.shard(key)
+	s.cacheM.RLock()
+	obj, ok := s.cache[key]
+	s.cacheM.RUnlock()
+	return obj, ok
+}
+
+func (c *ShardedMemCache) Add(key string, val *cacheobj.CacheObj) bool {
+	s := c.shard(key)
+	s.cacheM.Lock()
+	s.cache[key] = val
+	s.cacheM.Unlock()
+	oldSize := s.policy.Add(key, val.Size)
+	sizeChange := val.Size - oldSize
+	if sizeChange == 0 {
+		return false
+	}
+	atomic.AddUint64(&s.sizeBytes, sizeChange)
+	newSizeBytes := atomic.AddUint64(&c.sizeBytes, sizeChange)
+	if newSizeBytes <= c.maxSizeBytes {
+		return false
+	}
+	c.doGC(newSizeBytes)
+	return false
+}
+
+// Remove removes the key from the cache, returning whether it existed.
+func (c *ShardedMemCache) Remove(key string) bool {
+	s := c.shard(key)
+	s.cacheM.Lock()
+	_, ok := s.cache[key]
+	delete(s.cache, key)
+	s.cacheM.Unlock()
+	if sizeBytes, inLRU := s.policy.Remove(key); inLRU {
+		atomic.AddUint64(&s.sizeBytes, ^uint64(sizeBytes-1)) // subtract sizeBytes
+		atomic.AddUint64(&c.sizeBytes, ^uint64(sizeBytes-1))
+	}
+	return ok
+}
+
+func (c *ShardedMemCache) Size() uint64 { return atomic.LoadUint64(&c.sizeBytes) }
+func (c *ShardedMemCache) Close()       {}
+
+// doGC kicks off garbage collection if it isn't already. Does not block.
+func (c *ShardedMemCache) doGC(cacheSizeBytes uint64) {
+	select {
+	case c.gcChan <- cacheSizeBytes:
+	default: // don't block if GC is already running
+	}
+}
+
+// gcManager is the garbage collection manager function, designed to be run in a goroutine. Never returns.
+func (c *ShardedMemCache) gcManager(gcChan <-chan uint64) {
+	for cacheSizeBytes := range gcChan {
+		c.gc(cacheSizeBytes)
+	}
+}
+
+// gc evicts objects from the largest shard, until the cache size is under the max. Evicting from the largest shard keeps the shards near the same size, so each shard's policy evicts from a fair share of the cache. This should be called in a singleton manager goroutine, so only one goroutine is ever doing garbage collection at any time.
+func (c *ShardedMemCache) gc(cacheSizeBytes uint64) {
+	for cacheSizeBytes > c.maxSizeBytes {
+		log.Debugf("MemCache.gc cacheSizeBytes %+v > c.maxSizeBytes %+v\n", cacheSizeBytes, c.maxSizeBytes)
+		s := c.largestShard()
+		key, sizeBytes, exists := s.policy.RemoveOldest()
+		if !exists {
+			// should never happen
+			log.Errorf("MemCache.gc sizeBytes %v > %v maxSizeBytes, but the largest shard is empty!? Setting cache size to 0!\n", cacheSizeBytes, c.maxSizeBytes)
+			atomic.StoreUint64(&c.sizeBytes, 0)
+			return
+		}
+
+		log.Debugf("MemCache.gc deleting key '%v'\n", key)
+		s.cacheM.Lock()
+		delete(s.cache, key)
+		s.cacheM.Unlock()
+
+		atomic.AddUint64(&s.sizeBytes, ^uint64(sizeBytes-1)) // subtract sizeBytes
+		cacheSizeBytes = atomic.AddUint64(&c.sizeBytes, ^uint64(sizeBytes-1))
+	}
+}
+
+func (c *ShardedMemCache) largestShard() *memShard {
+	largest := c.shards[0]
+	largestSize := atomic.LoadUint64(&largest.sizeBytes)
+	for _, s := range c.shards[1:] {
+		if size := atomic.LoadUint64(&s.sizeBytes); size > largestSize {
+			largest, largestSize = s, size
+		}
+	}
+	return largest
+}
+
+// Keys returns the keys of every shard. Each shard's keys are approximately in eviction order, but the shards are not ordered with each other.
+func (c *ShardedMemCache) Keys() []string {
+	keys := []string{}
+	for _, s := range c.shards {
+		keys = append(keys, s.policy.Keys()...)
+	}
+	return keys
+}
+
+func (c *ShardedMemCache) Capacity() uint64 {
+	return c.maxSizeBytes
+}
+
+func (c *ShardedMemCache) PolicyStats() icache.PolicyStats {
+	stats := icache.PolicyStats{Policy: c.shards[0].policy.Name()}
+	for _, s := range c.shards {
+		stats.Hits += atomic.LoadUint64(&s.hits)
+		stats.Misses += atomic.LoadUint64(&s.misses)
+	}
+	return stats
+}
@@ -0,0 +1,428 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// access_log writes an access log line for each response, in a configurable template, JSON, or W3C extended format. It may be configured globally, or per rule, and a rule's config replaces the global config.
+// Log files are reopened on SIGUSR1, so they may be rotated by moving them and then signalling Grove.
+func init() {
+	AddPlugin(20000, Funcs{load: accessLogLoad, startup: accessLogStartup, afterRespond: accessLogAfterRespond})
+}
+
+const (
+	AccessLogFormatTemplate = "template"
+	AccessLogFormatJSON     = "json"
+	AccessLogFormatW3C      = "w3c"
+)
+
+// AccessLogLocationEvent is the default location, which writes to the event log, along with the ats_log plugin.
+const AccessLogLocationEvent = "event"
+
+// AccessLogReqHeaderPrefix and AccessLogRespHeaderPrefix prefix fields which are the value of a request or response header, for example `req_header:Referer`.
+const AccessLogReqHeaderPrefix = "req_header:"
+const AccessLogRespHeaderPrefix = "resp_header:"
+
+const DefaultAccessLogTemplate = `%{time_iso} %{client_ip} %{remap} "%{method} %{url} %{protocol}" %{status} %{bytes_in} %{bytes_out} %{cache_status} %{origin_status} %{duration_ms} "%{user_agent}" %{request_id}`
+
+var DefaultAccessLogFields = []string{"time_iso", "client_ip", "remap", "method", "url", "protocol", "status", "bytes_in", "bytes_out", "cache_status", "origin_status", "duration_ms", "user_agent", "request_id"}
+
+type accessLogConfig struct {
+	// Format is template, json, or w3c. Defaults to template.
+	Format string `json:"format"`
+	// Template is the line of the template format, with fields as `%{name}`.
+	Template string `json:"template"`
+	// Fields are the fields of the json and w3c formats.
+	Fields []string `json:"fields"`
+	// Location is the file to log to, or stdout, stderr, or event for the event log. Defaults to event.
+	Location string `json:"location"`
+	// SampleRate is the fraction of requests to log, between 0 and 1. Defaults to 1.
+	SampleRate *float64 `json:"sample_rate"`
+	// Disabled disables logging, for example for a rule, when the global config logs.
+	Disabled bool `json:"disabled"`
+
+	// template is the compiled Template, of alternating literals and fields, for the template format.
+	template []accessLogSegment
+	// fields are the compiled Fields, for the json and w3c formats.
+	fields []accessLogField
+}
+
+// accessLogSegment is part of a template, which is either literal text, or a field if get is not nil.
+type accessLogSegment struct {
+	literal string
+	field   accessLogField
+}
+
+type accessLogField struct {
+	name string
+	get  accessLogGetter
+}
+
+// accessLogGetter returns the value of a field, which is a string, int64, uint64, float64, or bool.
+type accessLogGetter func(d *AfterRespondData, now time.Time) interface{}
+
+var accessLogGetters = map[string]accessLogGetter{
+	"timestamp": func(d *AfterRespondData, now time.Time) interface{} {
+		return float64(now.UnixNano()/int64(time.Millisecond)) / 1000
+	},
+	"time_iso": func(d *AfterRespondData, now time.Time) interface{} {
+		return now.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	},
+	"date":      func(d *AfterRespondData, now time.Time) interface{} { return now.UTC().Format("2006-01-02") },
+	"time":      func(d *AfterRespondData, now time.Time) interface{} { return now.UTC().Format("15:04:05.000") },
+	"client_ip": func(d *AfterRespondData, now time.Time) interface{} { return d.ClientIP },
+	"method":    func(d *AfterRespondData, now time.Time) interface{} { return d.Req.Method },
+	"scheme":    func(d *AfterRespondData, now time.Time) interface{} { return d.Scheme },
+	"host":      func(d *AfterRespondData, now time.Time) interface{} { return d.Req.Host },
+	"path":      func(d *AfterRespondData, now time.Time) interface{} { return d.Req.URL.Path },
+	"query":     func(d *AfterRespondData, now time.Time) interface{} { return d.Req.URL.RawQuery },
+	"url": func(d *AfterRespondData, now time.Time) interface{} {
+		return d.Scheme + "://" + d.Req.Host + d.Req.RequestURI
+	},
+	"protocol":      func(d *AfterRespondData, now time.Time) interface{} { return d.Req.Proto },
+	"status":        func(d *AfterRespondData, now time.Time) interface{} { return int64(d.RespCode) },
+	"origin_status": func(d *AfterRespondData, now time.Time) interface{} { return int64(d.OriginCode) },
+	"bytes_in":      func(d *AfterRespondData, now time.Time) interface{} { return d.BytesRead },
+	"bytes_out":     func(d *AfterRespondData, now time.Time) interface{} { return d.BytesWritten },
+	"origin_bytes":  func(d *AfterRespondData, now time.Time) interface{} { return d.OriginBytes },
+	"duration_ms": func(d *AfterRespondData, now time.Time) interface{} {
+		return int64(now.Sub(d.ReqTime) / time.Millisecond)
+	},
+	"duration_us": func(d *AfterRespondData, now time.Time) interface{} {
+		return int64(now.Sub(d.ReqTime) / time.Microsecond)
+	},
+	"cache_hit": func(d *AfterRespondData, now time.Time) interface{} { return d.CacheHit },
+	"cache_status": func(d *AfterRespondData, now time.Time) interface{} {
+		return getRespCacheHitStr(d)
+	},
+	"remap":           func(d *AfterRespondData, now time.Time) interface{} { return d.RemapRule },
+	"parent_fqdn":     func(d *AfterRespondData, now time.Time) interface{} { return d.ToFQDN },
+	"parent":          func(d *AfterRespondData, now time.Time) interface{} { return d.ProxyStr },
+	"server_hostname": func(d *AfterRespondData, now time.Time) interface{} { return d.Hostname },
+	"server_port":     func(d *AfterRespondData, now time.Time) interface{} { return d.Port },
+	"user_agent":      func(d *AfterRespondData, now time.Time) interface{} { return d.Req.UserAgent() },
+	"request_id":      func(d *AfterRespondData, now time.Time) interface{} { return d.RequestID },
+}
+
+// getAccessLogField returns the field with the given name, which is either in accessLogGetters, or a request or response header.
+func getAccessLogField(name string) (accessLogField, error) {
+	if get, ok := accessLogGetters[name]; ok {
+		return accessLogField{name: name, get: get}, nil
+	}
+	if strings.HasPrefix(name, AccessLogReqHeaderPrefix) && len(name) > len(AccessLogReqHeaderPrefix) {
+		hdr := name[len(AccessLogReqHeaderPrefix):]
+		return accessLogField{name: name, get: func(d *AfterRespondData, now time.Time) interface{} { return d.Req.Header.Get(hdr) }}, nil
+	}
+	if strings.HasPrefix(name, AccessLogRespHeaderPrefix) && len(name) > len(AccessLogRespHeaderPrefix) {
+		hdr := name[len(AccessLogRespHeaderPrefix):]
+		return accessLogField{name: name, get: func(d *AfterRespondData, now time.Time) interface{} {
+			if d.W == nil {
+				return ""
+			}
+			return d.W.Header().Get(hdr)
+		}}, nil
+	}
+	return accessLogField{}, errors.New("unknown field '" + name + "'")
+}
+
+// compileAccessLogTemplate compiles a template, whose fields are `%{name}`. A literal `%` may be written as `%%`.
+func compileAccessLogTemplate(template string) ([]accessLogSegment, error) {
+	segments := []accessLogSegment{}
+	literal := ""
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			literal += template[i : i+1]
+			continue
+		}
+		if strings.HasPrefix(template[i:], "%%") {
+			literal += "%"
+			i++
+			continue
+		}
+		if !strings.HasPrefix(template[i:], "%{") {
+			return nil, errors.New("'%' at " + strconv.Itoa(i) + " must be '%{field}' or '%%'")
+		}
+		end := strings.Index(template[i:], "}")
+		if end == -1 {
+			return nil, errors.New("unterminated field at " + strconv.Itoa(i))
+		}
+		field, err := getAccessLogField(template[i+2 : i+end])
+		if err != nil {
+			return nil, err
+		}
+		if literal != "" {
+			segments = append(segments, accessLogSegment{literal: literal})
+			literal = ""
+		}
+		segments = append(segments, accessLogSegment{field: field})
+		i += end
+	}
+	if literal != "" {
+		segments = append(segments, accessLogSegment{literal: literal})
+	}
+	return segments, nil
+}
+
+func accessLogLoad(b json.RawMessage) interface{} {
+	cfg := accessLogConfig{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Errorln("access_log loading config, unmarshalling JSON: " + err.Error())
+		return nil
+	}
+	if cfg.Disabled {
+		return &cfg
+	}
+	if cfg.Location == "" {
+		cfg.Location = AccessLogLocationEvent
+	}
+	if cfg.SampleRate != nil && (*cfg.SampleRate < 0 || *cfg.SampleRate > 1) {
+		log.Errorf("access_log loading config: sample_rate %v must be between 0 and 1\n", *cfg.SampleRate)
+		return nil
+	}
+
+	switch cfg.Format {
+	case "", AccessLogFormatTemplate:
+		cfg.Format = AccessLogFormatTemplate
+		if cfg.Template == "" {
+			cfg.Template = DefaultAccessLogTemplate
+		}
+		template, err := compileAccessLogTemplate(cfg.Template)
+		if err != nil {
+			log.Errorln("access_log loading config: template: " + err.Error())
+			return nil
+		}
+		cfg.template = template
+	case AccessLogFormatJSON, AccessLogFormatW3C:
+		if len(cfg.Fields) == 0 {
+			cfg.Fields = DefaultAccessLogFields
+		}
+		for _, name := range cfg.Fields {
+			field, err := getAccessLogField(name)
+			if err != nil {
+				log.Errorln("access_log loading config: fields: " + err.Error())
+				return nil
+			}
+			cfg.fields = append(cfg.fields, field)
+		}
+	default:
+		log.Errorln("access_log loading config: unknown format '" + cfg.Format + "'")
+		return nil
+	}
+	log.Debugf("access_log load success: %+v\n", cfg)
+	return &cfg
+}
+
+func accessLogStartup(icfg interface{}, d StartupData) {
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGUSR1)
+		for range c {
+			log.Infoln("access_log received SIGUSR1, reopening log files")
+			accessLogFiles.reopen()
+		}
+	}()
+}
+
+func accessLogAfterRespond(icfg interface{}, d AfterRespondData) {
+	if icfg == nil {
+		return
+	}
+	cfg, ok := icfg.(*accessLogConfig)
+	if !ok {
+		log.Errorf("access_log config '%v' type '%T' expected *accessLogConfig\n", icfg, icfg)
+		return
+	}
+	if cfg.Disabled {
+		return
+	}
+	if cfg.SampleRate != nil && rand.Float64() >= *cfg.SampleRate {
+		return
+	}
+	line := cfg.line(&d, time.Now())
+	if cfg.Location == AccessLogLocationEvent {
+		log.EventRaw(line)
+		return
+	}
+	if err := accessLogFiles.write(cfg.Location, cfg.header(), line); err != nil {
+		log.Errorln("access_log writing to '" + cfg.Location + "': " + err.Error())
+	}
+}
+
+// line returns the log line of the response, including the newline.
+func (cfg *accessLogConfig) line(d *AfterRespondData, now time.Time) string {
+	b := &bytes.Buffer{}
+	switch cfg.Format {
+	case AccessLogFormatJSON:
+		b.WriteString("{")
+		for i, field := range cfg.fields {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			name, _ := json.Marshal(field.name)
+			val, _ := json.Marshal(field.get(d, now))
+			b.Write(name)
+			b.WriteString(":")
+			b.Write(val)
+		}
+		b.WriteString("}")
+	case AccessLogFormatW3C:
+		for i, field := range cfg.fields {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString(accessLogW3CValue(accessLogText(field.get(d, now))))
+		}
+	default:
+		for _, segment := range cfg.template {
+			if segment.field.get == nil {
+				b.WriteString(segment.literal)
+				continue
+			}
+			val := accessLogText(segment.field.get(d, now))
+			if val == "" {
+				val = "-"
+			}
+			b.WriteString(accessLogEscaper.Replace(val))
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// header returns the header to write when a log file is created, which is the W3C directives for the w3c format, and empty otherwise.
+func (cfg *accessLogConfig) header() string {
+	if cfg.Format != AccessLogFormatW3C {
+		return ""
+	}
+	return "#Version: 1.0\n#Fields: " + strings.Join(cfg.Fields, " ") + "\n"
+}
+
+// accessLogEscaper escapes values in text formats, so values can't inject quotes or lines into the log.
+var accessLogEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func accessLogText(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// accessLogW3CValue returns the W3C extended log format value, which is `-` if empty, and quoted if it contains whitespace or quotes.
+func accessLogW3CValue(val string) string {
+	if val == "" {
+		return "-"
+	}
+	if !strings.ContainsAny(val, " \t\r\n\"") {
+		return val
+	}
+	return `"` + strings.NewReplacer(`"`, `""`, "\n", " ", "\r", " ", "\t", " ").Replace(val) + `"`
+}
+
+// accessLogFiles are the open log files, shared by all rules logging to the same location.
+var accessLogFiles = &accessLogFileSet{files: map[string]io.Writer{}}
+
+// accessLogFileSet is a set of log files, by location, which are opened when they're first written, and may be reopened for log rotation.
+type accessLogFileSet struct {
+	files map[string]io.Writer
+	m     sync.RWMutex
+}
+
+// write writes the line to the file at the location, opening it if it isn't open. If the file is created, the header is written first.
+func (s *accessLogFileSet) write(location string, header string, line string) error {
+	s.m.RLock()
+	w, ok := s.files[location]
+	if ok {
+		_, err := io.WriteString(w, line)
+		s.m.RUnlock()
+		return err
+	}
+	s.m.RUnlock()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if w, ok = s.files[location]; !ok {
+		var err error
+		if w, err = openAccessLog(location, header); err != nil {
+			return err
+		}
+		s.files[location] = w
+	}
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// reopen closes all files, so they're reopened, and created if they were moved, on the next write.
+func (s *accessLogFileSet) reopen() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for location, w := range s.files {
+		if f, ok := w.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+			if err := f.Close(); err != nil {
+				log.Errorln("access_log closing '" + location + "': " + err.Error())
+			}
+		}
+	}
+	s.files = map[string]io.Writer{}
+}
+
+func openAccessLog(location string, header string) (io.Writer, error) {
+	switch location {
+	case log.LogLocationStdout:
+		return os.Stdout, nil
+	case log.LogLocationStderr:
+		return os.Stderr, nil
+	case log.LogLocationNull:
+		return ioutil.Discard, nil
+	}
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if header == "" {
+		return f, nil
+	}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		if _, err := io.WriteString(f, header); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
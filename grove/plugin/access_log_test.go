@@ -0,0 +1,114 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+)
+
+func testAccessLogData(now time.Time) AfterRespondData {
+	r := httptest.NewRequest("GET", "/foo?a=b", nil)
+	r.Host = "foo.example.net"
+	r.Header.Set("User-Agent", `curl "quoted"`)
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "text/plain")
+	return AfterRespondData{
+		W:              w,
+		RequestID:      42,
+		ReqData:        cachedata.ReqData{Req: r, ClientIP: "192.0.2.1", ReqTime: now.Add(-1500 * time.Microsecond), RemapRule: "foo"},
+		SrvrData:       cachedata.SrvrData{Scheme: "http"},
+		ParentRespData: cachedata.ParentRespData{OriginCode: 200},
+		RespData:       cachedata.RespData{RespCode: 200, BytesRead: 80, BytesWritten: 1234, CacheHit: true},
+	}
+}
+
+func TestAccessLogLine(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	d := testAccessLogData(now)
+
+	tests := []struct {
+		cfg      string
+		expected string
+	}{
+		{`{}`, `2018-01-02T03:04:05.006Z 192.0.2.1 foo "GET http://foo.example.net/foo?a=b HTTP/1.1" 200 80 1234 TCP_HIT 200 1 "curl \"quoted\"" 42` + "\n"},
+		{`{"template": "%{host} %{path} %{query} %{cache_hit} %{duration_us}us 100%% %{resp_header:Content-Type} %{req_header:X-Missing}"}`, "foo.example.net /foo a=b true 1500us 100% text/plain -\n"},
+		{`{"format": "json", "fields": ["remap", "status", "bytes_out", "cache_hit", "timestamp", "req_header:User-Agent"]}`, `{"remap":"foo","status":200,"bytes_out":1234,"cache_hit":true,"timestamp":1514862245.006,"req_header:User-Agent":"curl \"quoted\""}` + "\n"},
+		{`{"format": "w3c", "fields": ["date", "time", "client_ip", "user_agent", "parent"]}`, `2018-01-02 03:04:05.006 192.0.2.1 "curl ""quoted""" -` + "\n"},
+	}
+	for _, test := range tests {
+		cfg, ok := accessLogLoad([]byte(test.cfg)).(*accessLogConfig)
+		if !ok {
+			t.Errorf("access_log load '%v' expected config, actual nil", test.cfg)
+			continue
+		}
+		if actual := cfg.line(&d, now); actual != test.expected {
+			t.Errorf("access_log '%v' line expected:\n%v\nactual:\n%v", test.cfg, test.expected, actual)
+		}
+	}
+
+	limited := testAccessLogData(now)
+	limited.RespData = cachedata.RespData{RespCode: 429, RateLimited: true}
+	limited.ParentRespData = cachedata.ParentRespData{}
+	cfg := accessLogLoad([]byte(`{"template": "%{status} %{cache_status}"}`)).(*accessLogConfig)
+	if expected, actual := "429 ERR_RATE_LIMITED\n", cfg.line(&limited, now); actual != expected {
+		t.Errorf("access_log rate limited line expected:\n%v\nactual:\n%v", expected, actual)
+	}
+
+	for _, cfg := range []string{`{"template": "%{nonexistent}"}`, `{"template": "%d"}`, `{"template": "%{status"}`, `{"format": "xml"}`, `{"format": "json", "fields": ["req_header:"]}`, `{"sample_rate": 2}`} {
+		if actual := accessLogLoad([]byte(cfg)); actual != nil {
+			t.Errorf("access_log load invalid '%v' expected nil, actual %+v", cfg, actual)
+		}
+	}
+}
+
+func TestAccessLogReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grove_access_log")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	rotated := filepath.Join(dir, "access.log.1")
+	files := &accessLogFileSet{files: map[string]io.Writer{}}
+	defer files.reopen()
+
+	header := "#Fields: status\n"
+	if err := files.write(path, header, "a\n"); err != nil {
+		t.Fatalf("write error expected nil, actual %v", err)
+	}
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatalf("renaming log: %v", err)
+	}
+	files.write(path, header, "b\n") // still written to the moved file, until reopened
+	files.reopen()
+	files.write(path, header, "c\n")
+
+	for file, expected := range map[string]string{rotated: header + "a\nb\n", path: header + "c\n"} {
+		actual, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("reading '%v': %v", file, err)
+		} else if string(actual) != expected {
+			t.Errorf("log '%v' expected '%v', actual '%v'", file, expected, string(actual))
+		}
+	}
+}
@@ -19,8 +19,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/apache/incubator-trafficcontrol/grove/web"
-
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
 )
 
@@ -32,7 +30,7 @@ func init() {
 
 func atsLog(icfg interface{}, d AfterRespondData) {
 	now := time.Now()
-	bytesSent := web.TryGetBytesWritten(d.W, d.Conn, d.BytesWritten)
+	bytesSent := d.BytesWritten
 
 	proxyHierarchyStr, proxyNameStr := getParentStrings(d.RespCode, d.CacheHit, d.ProxyStr, d.ToFQDN)
 
@@ -54,7 +52,7 @@ func atsLog(icfg interface{}, d AfterRespondData) {
 		d.OriginBytes,
 		d.RespSuccess,
 		d.OriginReqSuccess,
-		getCacheHitStr(d.CacheHit, d.OriginConnectFailed),
+		getRespCacheHitStr(&d),
 		proxyHierarchyStr,
 		proxyNameStr,
 		d.Req.UserAgent(),
@@ -79,6 +77,14 @@ func getParentStrings(code int, hit bool, proxyStr string, toFQDN string) (strin
 	return "EMPTY", "-"
 }
 
+// getRespCacheHitStr returns the event log string for whether the response was a cache hit, or ERR_RATE_LIMITED if the request was rejected by a rate limit.
+func getRespCacheHitStr(d *AfterRespondData) string {
+	if d.RateLimited {
+		return "ERR_RATE_LIMITED"
+	}
+	return getCacheHitStr(d.CacheHit, d.OriginConnectFailed)
+}
+
 // getCacheHitStr returns the event log string for whether the request was a cache hit. For a request not in the cache, pass `ReuseCannot` to indicate a cache miss.
 func getCacheHitStr(hit bool, originConnectFailed bool) string {
 	if originConnectFailed {
@@ -0,0 +1,223 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// compress compresses cacheable responses which the parent didn't, for clients which accept it. The compressed representation is cached at an encoding variant of the object's key, alongside the identity object, so each object is only compressed once.
+// It runs after if_modified_since, so Not Modified responses aren't compressed, and before range_request. Requests with a Range are never compressed, so ranges are always of the identity representation.
+func init() {
+	AddPlugin(5500, Funcs{load: compressLoad, beforeRespond: compressBeforeRespond})
+}
+
+// compressEncoders are the content codings which may be configured, and their encoders.
+var compressEncoders = map[string]func(w io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	"br":   func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+}
+
+const DefaultCompressMinBytes = 1024
+
+var DefaultCompressContentTypes = []string{"text/*", "application/javascript", "application/json", "application/xml", "image/svg+xml"}
+
+type compressConfig struct {
+	// ContentTypes are the media types to compress. Types may end in `/*` to match all subtypes.
+	ContentTypes []string `json:"content_types"`
+	// MinBytes is the minimum body size to compress. Smaller bodies are sent as-is.
+	MinBytes *uint64 `json:"min_bytes"`
+	// Encodings are the content codings to compress with, in order of preference. Defaults to gzip.
+	Encodings []string `json:"encodings"`
+}
+
+func compressLoad(b json.RawMessage) interface{} {
+	cfg := compressConfig{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Errorln("compress loading config, unmarshalling JSON: " + err.Error())
+		return nil
+	}
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressContentTypes
+	}
+	if cfg.MinBytes == nil {
+		minBytes := uint64(DefaultCompressMinBytes)
+		cfg.MinBytes = &minBytes
+	}
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = []string{"gzip"}
+	}
+	encodings := make([]string, 0, len(cfg.Encodings))
+	for _, encoding := range cfg.Encodings {
+		encoding = strings.ToLower(encoding)
+		if _, ok := compressEncoders[encoding]; !ok {
+			log.Errorf("compress loading config: unsupported encoding '%v', ignoring\n", encoding)
+			continue
+		}
+		encodings = append(encodings, encoding)
+	}
+	cfg.Encodings = encodings
+	log.Debugf("compress load success: %+v\n", cfg)
+	return &cfg
+}
+
+func compressBeforeRespond(icfg interface{}, d BeforeRespondData) {
+	if icfg == nil {
+		return
+	}
+	cfg, ok := icfg.(*compressConfig)
+	if !ok {
+		log.Errorf("compress config '%v' type '%T' expected *compressConfig\n", icfg, icfg)
+		return
+	}
+	if *d.Code != http.StatusOK || (d.Req.Method != http.MethodGet && d.Req.Method != http.MethodHead) || d.Req.Header.Get("Range") != "" || d.CacheObj == nil {
+		return
+	}
+	hdr := *d.Hdr
+	if hdr.Get("Content-Encoding") != "" && !strings.EqualFold(hdr.Get("Content-Encoding"), "identity") {
+		return // the parent already encoded it
+	}
+	if _, ok := web.ParseCacheControl(hdr)["no-transform"]; ok {
+		return // RFC7234§5.2.2.4
+	}
+	if !cfg.compressible(hdr.Get("Content-Type")) {
+		return
+	}
+	if *d.Stream == nil && uint64(len(*d.Body)) < *cfg.MinBytes {
+		return
+	}
+
+	*d.Hdr = web.CopyHeader(hdr)
+	addVary(*d.Hdr, "Accept-Encoding") // the identity response is also a variant, for downstream caches
+
+	if *d.Stream != nil {
+		// The body isn't complete yet, e.g. a cache miss. It's sent as-is, and compressed on the next request, once it's cached. It still varies, so downstream caches don't serve it for requests which would get the compressed representation.
+		return
+	}
+
+	encoding := cfg.encoding(d.Req.Header.Get("Accept-Encoding"))
+	if encoding == "" || d.Cache == nil {
+		return
+	}
+
+	identityKey := d.CacheKey
+	if varyHeaders, _ := web.ParseVary(d.CacheObj.RespHeaders); len(varyHeaders) > 0 {
+		identityKey = remapdata.VariantCacheKey(d.CacheKey, d.Req.Header, varyHeaders)
+	}
+	encodedKey := remapdata.EncodingCacheKey(identityKey, encoding)
+
+	encodedObj, ok := d.Cache.Get(encodedKey)
+	if !ok || !sameObject(encodedObj, d.CacheObj) {
+		body, err := compressBody(encoding, *d.Body)
+		if err != nil {
+			log.Errorf("compress '%v' with %v: %v\n", identityKey, encoding, err)
+			return
+		}
+		encodedObj = compressedObject(d.CacheObj, *d.Hdr, encoding, body)
+		// Only cache the compressed representation of objects which are cached, so uncacheable responses aren't stored.
+		if identityObj, ok := d.Cache.Peek(identityKey); ok && sameObject(identityObj, d.CacheObj) {
+			log.Debugf("compress caching '%v' len(body) %v compressed %v\n", encodedKey, len(*d.Body), len(body))
+			d.Cache.Add(encodedKey, encodedObj)
+		}
+	}
+
+	*d.Hdr, *d.Body = web.CopyHeader(encodedObj.RespHeaders), encodedObj.Body
+}
+
+// compressible returns whether the given Content-Type is in the config's content types.
+func (cfg *compressConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cfg.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// encoding returns the config's most preferred encoding the given Accept-Encoding accepts, or the empty string if it accepts none of them.
+func (cfg *compressConfig) encoding(acceptEncoding string) string {
+	for _, encoding := range cfg.Encodings {
+		if web.AcceptsEncoding(acceptEncoding, encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := compressEncoders[encoding](buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressedObject returns a copy of the given identity object, with the given compressed body, and the given identity response headers modified for the encoding.
+func compressedObject(identity *cacheobj.CacheObj, identityHdr http.Header, encoding string, body []byte) *cacheobj.CacheObj {
+	hdr := web.CopyHeader(identityHdr)
+	hdr.Set("Content-Encoding", encoding)
+	hdr.Set("Content-Length", strconv.Itoa(len(body)))
+	if etag := hdr.Get("ETag"); strings.HasSuffix(etag, `"`) {
+		hdr.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+encoding+`"`) // representations with different encodings must have different entity tags, per RFC7232§2.3.3
+	}
+	obj := *identity
+	obj.Body = body
+	obj.RespHeaders = hdr
+	obj.Size = obj.ComputeSize()
+	return &obj
+}
+
+// sameObject returns whether the given objects were received from the parent in the same response, so a cached representation of an object is never served for a newer object.
+func sameObject(a *cacheobj.CacheObj, b *cacheobj.CacheObj) bool {
+	return a.ReqRespTime.Equal(b.ReqRespTime) && a.RespRespTime.Equal(b.RespRespTime)
+}
+
+// addVary adds the given header name to the Vary of the given response header, if it isn't already there.
+func addVary(hdr http.Header, name string) {
+	varyHeaders, varyAll := web.ParseVary(hdr)
+	if varyAll {
+		return
+	}
+	for _, varyHeader := range varyHeaders {
+		if varyHeader == http.CanonicalHeaderKey(name) {
+			return
+		}
+	}
+	hdr.Add("Vary", name)
+}
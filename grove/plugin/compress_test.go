@@ -0,0 +1,269 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/memcache"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+)
+
+const compressTestKey = "GET:http://foo.example.net/foo.js"
+
+var compressTestBody = []byte(strings.Repeat("function foo() { return 'foo'; }\n", 64))
+
+// compressTest is the data of a response the compress plugin is run on, and its results.
+type compressTest struct {
+	Cache  icache.Cache
+	Req    *http.Request
+	Obj    *cacheobj.CacheObj
+	Code   int
+	Hdr    http.Header
+	Body   []byte
+	Stream io.Reader
+}
+
+// newCompressTest returns a compressTest of a compressible 200 response to a GET accepting the given encoding, whose object is cached.
+func newCompressTest(acceptEncoding string) *compressTest {
+	hdr := http.Header{"Content-Type": {"application/javascript"}, "Etag": {`"foo"`}}
+	now := time.Now()
+	obj := cacheobj.New(nil, compressTestBody, http.StatusOK, http.StatusOK, "", hdr, now, now, now, now)
+	cache := memcache.New(1024*1024, nil)
+	cache.Add(compressTestKey, obj)
+
+	req := httptest.NewRequest(http.MethodGet, "http://foo.example.net/foo.js", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	return &compressTest{Cache: cache, Req: req, Obj: obj, Code: http.StatusOK, Hdr: hdr, Body: compressTestBody}
+}
+
+func (ct *compressTest) run(t *testing.T, cfgJSON string) {
+	cfg := compressLoad(json.RawMessage(cfgJSON))
+	if cfg == nil {
+		t.Fatalf("compressLoad '%v' expected config, actual nil", cfgJSON)
+	}
+	compressBeforeRespond(cfg, BeforeRespondData{
+		Req:      ct.Req,
+		CacheObj: ct.Obj,
+		Code:     &ct.Code,
+		Hdr:      &ct.Hdr,
+		Body:     &ct.Body,
+		Stream:   &ct.Stream,
+		Cache:    ct.Cache,
+		CacheKey: compressTestKey,
+	})
+}
+
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	r := io.Reader(nil)
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("reading gzip: %v", err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		t.Fatalf("decompress unknown encoding '%v'", encoding)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decompressing %v: %v", encoding, err)
+	}
+	return decoded
+}
+
+func TestCompressEncoding(t *testing.T) {
+	cfg := compressLoad(json.RawMessage(`{"encodings": ["br", "gzip", "compress"]}`)).(*compressConfig)
+	if expected := []string{"br", "gzip"}; strings.Join(cfg.Encodings, ",") != strings.Join(expected, ",") {
+		t.Errorf("compressLoad expected unsupported encodings ignored %v, actual %v", expected, cfg.Encodings)
+	}
+
+	expected := map[string]string{
+		"":                      "",
+		"identity":              "",
+		"gzip":                  "gzip",
+		"x-gzip":                "gzip",
+		"gzip, br":              "br",
+		"gzip;q=0.5, br;q=0.1":  "br", // the config's preference, not the client's, picks among accepted encodings
+		"br;q=0, gzip":          "gzip",
+		"br;q=0, gzip;q=0":      "",
+		"*":                     "br",
+		"*, br;q=0":             "gzip",
+		"*;q=0, gzip":           "gzip",
+		"deflate, GZIP;q=1.000": "gzip",
+	}
+	for acceptEncoding, expectedEncoding := range expected {
+		if actual := cfg.encoding(acceptEncoding); actual != expectedEncoding {
+			t.Errorf("encoding of Accept-Encoding '%v' expected '%v', actual '%v'", acceptEncoding, expectedEncoding, actual)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip"} {
+		ct := newCompressTest(encoding)
+		ct.run(t, `{"min_bytes": 10, "encodings": ["br", "gzip"]}`)
+
+		if actual := ct.Hdr.Get("Content-Encoding"); actual != encoding {
+			t.Fatalf("compress expected Content-Encoding '%v', actual '%v'", encoding, actual)
+		}
+		if decoded := decompress(t, encoding, ct.Body); !bytes.Equal(decoded, compressTestBody) {
+			t.Errorf("compress %v expected body to decompress to the identity body, actual '%s'", encoding, decoded)
+		}
+		if expected, actual := `"foo-`+encoding+`"`, ct.Hdr.Get("ETag"); expected != actual {
+			t.Errorf("compress %v expected ETag %v, actual %v", encoding, expected, actual)
+		}
+		if actual := ct.Hdr.Get("Vary"); actual != "Accept-Encoding" {
+			t.Errorf("compress %v expected Vary 'Accept-Encoding', actual '%v'", encoding, actual)
+		}
+		if ct.Obj.RespHeaders.Get("Content-Encoding") != "" {
+			t.Errorf("compress %v expected identity object headers unmodified, actual %v", encoding, ct.Obj.RespHeaders)
+		}
+
+		encodedKey := remapdata.EncodingCacheKey(compressTestKey, encoding)
+		encodedObj, ok := ct.Cache.Get(encodedKey)
+		if !ok {
+			t.Fatalf("compress %v expected encoded object cached at '%v', actual not cached", encoding, encodedKey)
+		}
+		if !bytes.Equal(encodedObj.Body, ct.Body) {
+			t.Errorf("compress %v expected cached encoded body to be the body sent", encoding)
+		}
+
+		// The cached encoded object is served, rather than compressing again.
+		encodedObj.Body = []byte("cached")
+		reused := newCompressTest(encoding)
+		reused.Cache, reused.Obj = ct.Cache, ct.Obj
+		reused.run(t, `{"min_bytes": 10, "encodings": ["br", "gzip"]}`)
+		if string(reused.Body) != "cached" {
+			t.Errorf("compress %v expected cached encoded object reused, actual compressed again", encoding)
+		}
+	}
+}
+
+func TestCompressRefetched(t *testing.T) {
+	ct := newCompressTest("gzip")
+	ct.run(t, `{"min_bytes": 10}`)
+
+	// A newer response from the parent replaces the identity object. Its stale encoded object mustn't be served.
+	refetched := newCompressTest("gzip")
+	refetched.Cache = ct.Cache
+	refetched.Obj.ReqRespTime = refetched.Obj.ReqRespTime.Add(time.Second)
+	refetched.Obj.Body = []byte(strings.Repeat("refetched ", 64))
+	refetched.Body = refetched.Obj.Body
+	refetched.Cache.Add(compressTestKey, refetched.Obj)
+	refetched.run(t, `{"min_bytes": 10}`)
+
+	if decoded := decompress(t, "gzip", refetched.Body); !bytes.Equal(decoded, refetched.Obj.Body) {
+		t.Errorf("compress of refetched object expected the new body, actual '%s'", decoded)
+	}
+}
+
+func TestCompressUncachedNotStored(t *testing.T) {
+	ct := newCompressTest("gzip")
+	ct.Cache = memcache.New(1024*1024, nil) // the identity object isn't in the cache, e.g. it was uncacheable
+	ct.run(t, `{"min_bytes": 10}`)
+
+	if ct.Hdr.Get("Content-Encoding") != "gzip" {
+		t.Errorf("compress of uncached object expected Content-Encoding 'gzip', actual '%v'", ct.Hdr.Get("Content-Encoding"))
+	}
+	if keys := ct.Cache.Keys(); len(keys) != 0 {
+		t.Errorf("compress of uncached object expected nothing cached, actual %v", keys)
+	}
+}
+
+func TestCompressSkipped(t *testing.T) {
+	tests := map[string]struct {
+		cfg    string
+		modify func(ct *compressTest)
+		vary   bool
+	}{
+		"not accepted": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Req.Header.Set("Accept-Encoding", "gzip;q=0") },
+			vary:   true, // the identity response is still a variant
+		},
+		"below min_bytes": {
+			cfg:    `{"min_bytes": 1000000}`,
+			modify: func(ct *compressTest) {},
+		},
+		"no-transform": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Hdr.Set("Cache-Control", "max-age=60, no-transform") },
+		},
+		"range": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Req.Header.Set("Range", "bytes=0-10") },
+		},
+		"parent encoded": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Hdr.Set("Content-Encoding", "deflate") },
+		},
+		"content type": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Hdr.Set("Content-Type", "image/png") },
+		},
+		"not ok": {
+			cfg:    `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) { ct.Code = http.StatusNotModified },
+		},
+		"streaming": {
+			cfg: `{"min_bytes": 10}`,
+			modify: func(ct *compressTest) {
+				ct.Stream = bytes.NewReader(compressTestBody)
+				ct.Body = nil
+			},
+			vary: true, // it'll be compressed once cached, so downstream caches must not serve it to every client
+		},
+	}
+
+	for name, test := range tests {
+		ct := newCompressTest("gzip")
+		test.modify(ct)
+		encoding := ct.Hdr.Get("Content-Encoding")
+		body := ct.Body
+		ct.run(t, test.cfg)
+
+		if actual := ct.Hdr.Get("Content-Encoding"); actual != encoding {
+			t.Errorf("compress %v expected Content-Encoding '%v', actual '%v'", name, encoding, actual)
+		}
+		if !bytes.Equal(ct.Body, body) {
+			t.Errorf("compress %v expected body unmodified, actual modified", name)
+		}
+		if hasVary := ct.Hdr.Get("Vary") == "Accept-Encoding"; hasVary != test.vary {
+			t.Errorf("compress %v expected Vary Accept-Encoding %v, actual %v", name, test.vary, hasVary)
+		}
+		if keys := ct.Cache.Keys(); len(keys) != 1 {
+			t.Errorf("compress %v expected only the identity object cached, actual %v", name, keys)
+		}
+	}
+}
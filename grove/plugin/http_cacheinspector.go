@@ -23,6 +23,7 @@ import (
 	"strings"
 
 	"code.cloudfoundry.org/bytefmt"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
 	"time"
 
@@ -103,7 +104,19 @@ func cacheinspect(icfg interface{}, d OnRequestData) bool {
 	if keyArr, showKey := qstringOptions["key"]; showKey {
 		hLine := fmt.Sprintf("Key: %s cache: \"%s\"\n\n", keyArr[0], cacheToDisplay)
 		w.Write([]byte(hLine))
-		if cacheObject, ok := d.Stats.CachePeek(keyArr[0], cacheToDisplay); ok {
+		if cacheObject, ok := d.Stats.CachePeek(keyArr[0], cacheToDisplay); ok && cacheObject.IsVaryMarker() {
+			w.Write([]byte(fmt.Sprintf("  Vary:                         %s\n\n", strings.Join(cacheObject.VaryHeaders, ", "))))
+			w.Write([]byte("  Variants:\n"))
+			variantPrefix := keyArr[0] + remapdata.VariantKeySeparator
+			for _, key := range d.Stats.CacheKeys(cacheToDisplay) {
+				if strings.HasPrefix(key, variantPrefix) {
+					w.Write([]byte(fmt.Sprintf("    %s\n", inspectKeyLink(req.Host, key, cacheToDisplay))))
+				}
+			}
+		} else if ok {
+			if baseKey := remapdata.CacheKeyBase(keyArr[0]); baseKey != keyArr[0] {
+				w.Write([]byte(fmt.Sprintf("  Variant of:                   %s\n\n", inspectKeyLink(req.Host, baseKey, cacheToDisplay))))
+			}
 			for k, v := range cacheObject.ReqHeaders {
 				w.Write([]byte(fmt.Sprintf("  > %s: %s\n", k, strings.Join(v, ","))))
 			}
@@ -119,6 +132,8 @@ func cacheinspect(icfg interface{}, d OnRequestData) bool {
 			w.Write([]byte(fmt.Sprintf("  ReqRespTime:                  %v\n", cacheObject.ReqRespTime)))
 			w.Write([]byte(fmt.Sprintf("  RespRespTime:                 %v\n", cacheObject.RespRespTime)))
 			w.Write([]byte(fmt.Sprintf("  LastModified:                 %v\n", cacheObject.LastModified)))
+			w.Write([]byte(fmt.Sprintf("  FreshnessLifetime:            %v\n", cacheObject.FreshnessLifetime)))
+			w.Write([]byte(fmt.Sprintf("  CacheOverrides:               %s\n", strings.Join(cacheObject.CacheOverrides, ", "))))
 		} else {
 			w.Write([]byte("Not Found"))
 		}
@@ -177,8 +192,12 @@ func cacheinspect(icfg interface{}, d OnRequestData) bool {
 
 				cacheObject, _ := d.Stats.CachePeek(key, cName)
 				age := time.Now().Sub(cacheObject.ReqRespTime)
-				w.Write([]byte(fmt.Sprintf("     %05d\t%d\t%s\t%-20v\t<a href=\"http://%s%s?key=%s&cache=%s\">%s</a>\n",
-					i, cacheObject.Code, bytefmt.ByteSize(cacheObject.Size), age, req.Host, CacheStatsEndpoint, url.QueryEscape(key), cName, key)))
+				code := strconv.Itoa(cacheObject.Code)
+				if cacheObject.IsVaryMarker() {
+					code = "vary"
+				}
+				w.Write([]byte(fmt.Sprintf("     %05d\t%s\t%s\t%-20v\t%s\n",
+					i, code, bytefmt.ByteSize(cacheObject.Size), age, inspectKeyLink(req.Host, key, cName))))
 			}
 
 		}
@@ -192,3 +211,8 @@ func cacheinspect(icfg interface{}, d OnRequestData) bool {
 
 	return true
 }
+
+// inspectKeyLink returns an HTML link to the details page of the given key.
+func inspectKeyLink(host string, key string, cacheName string) string {
+	return fmt.Sprintf("<a href=\"http://%s%s?key=%s&cache=%s\">%s</a>", host, CacheStatsEndpoint, url.QueryEscape(key), cacheName, key)
+}
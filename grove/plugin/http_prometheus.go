@@ -0,0 +1,341 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/stat"
+	"github.com/apache/incubator-trafficcontrol/grove/thread"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// http_prometheus serves the stats in the Prometheus text exposition format, for scraping by Prometheus and other OpenMetrics collectors, along with histograms of request latency per remap rule.
+func init() {
+	AddPlugin(10000, Funcs{load: prometheusLoad, startup: prometheusStartup, onRequest: prometheusOnRequest, afterRespond: prometheusAfterRespond})
+}
+
+const PrometheusEndpoint = "/_metrics"
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultPrometheusLatencyBuckets are the upper bounds of the latency histogram buckets, in seconds.
+var DefaultPrometheusLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type prometheusConfig struct {
+	// LatencyBuckets are the upper bounds of the latency histogram buckets, in seconds. This may only be set globally.
+	LatencyBuckets []float64 `json:"latency_buckets"`
+}
+
+func prometheusLoad(b json.RawMessage) interface{} {
+	cfg := prometheusConfig{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Errorln("http_prometheus loading config, unmarshalling JSON: " + err.Error())
+		return nil
+	}
+	log.Debugf("http_prometheus load success: %+v\n", cfg)
+	return &cfg
+}
+
+// prometheusContext holds the latency histograms. It's created on startup, and shared by all requests, and kept across config reloads.
+type prometheusContext struct {
+	buckets    []float64
+	histograms map[string]*latencyHistogram
+	m          sync.RWMutex
+}
+
+func prometheusStartup(icfg interface{}, d StartupData) {
+	buckets := DefaultPrometheusLatencyBuckets
+	if cfg, ok := icfg.(*prometheusConfig); ok && len(cfg.LatencyBuckets) > 0 {
+		buckets = append([]float64(nil), cfg.LatencyBuckets...)
+		sort.Float64s(buckets)
+	}
+	*d.Context = &prometheusContext{buckets: buckets, histograms: map[string]*latencyHistogram{}}
+}
+
+// histogram returns the histogram of the given remap rule, creating it if it doesn't exist.
+func (c *prometheusContext) histogram(rule string) *latencyHistogram {
+	c.m.RLock()
+	h, ok := c.histograms[rule]
+	c.m.RUnlock()
+	if ok {
+		return h
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if h, ok := c.histograms[rule]; ok {
+		return h
+	}
+	h = newLatencyHistogram(c.buckets)
+	c.histograms[rule] = h
+	return h
+}
+
+// snapshot returns the histograms, by remap rule.
+func (c *prometheusContext) snapshot() map[string]*latencyHistogram {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	histograms := make(map[string]*latencyHistogram, len(c.histograms))
+	for rule, h := range c.histograms {
+		histograms[rule] = h
+	}
+	return histograms
+}
+
+func prometheusAfterRespond(icfg interface{}, d AfterRespondData) {
+	ctx, ok := (*d.Context).(*prometheusContext)
+	if !ok {
+		log.Errorf("http_prometheus context '%v' type '%T' expected *prometheusContext\n", *d.Context, *d.Context)
+		return
+	}
+	name := statsRemapName(d)
+	if _, ok := d.Stats.Remap().Stats(name); !ok {
+		return // only rules in the stats are recorded, so clients can't create unbounded histograms with arbitrary hosts
+	}
+	ctx.histogram(name).observe(time.Since(d.ReqTime))
+}
+
+func prometheusOnRequest(icfg interface{}, d OnRequestData) bool {
+	if d.R.URL.Path != PrometheusEndpoint {
+		return false
+	}
+	log.Debugf("plugin onrequest http_prometheus calling\n")
+
+	w := d.W
+	ip, err := web.GetIP(d.R)
+	if err != nil {
+		code := http.StatusInternalServerError
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+		log.Errorln("http_prometheus failed to get IP: " + err.Error())
+		return true
+	}
+	if !d.StatRules.Allowed(ip) {
+		code := http.StatusForbidden
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+		log.Debugln("http_prometheus IP " + ip.String() + " FORBIDDEN")
+		return true
+	}
+
+	histograms := map[string]*latencyHistogram{}
+	if ctx, ok := (*d.Context).(*prometheusContext); ok {
+		histograms = ctx.snapshot()
+	}
+	buf := &bytes.Buffer{}
+	writePrometheusStats(buf, d.Stats, histograms)
+	w.Header().Set("Content-Type", PrometheusContentType)
+	w.Write(buf.Bytes())
+	return true
+}
+
+// writePrometheusStats writes the stats and latency histograms in the Prometheus text exposition format. Metrics with labels are sorted by label, so the output is stable between scrapes.
+func writePrometheusStats(w io.Writer, stats stat.Stats, histograms map[string]*latencyHistogram) {
+	system := stats.System()
+	writePrometheusHeader(w, "grove_info", "gauge", "The Grove version.")
+	fmt.Fprintf(w, "grove_info{version=%v} 1\n", prometheusLabelValue(system.Version()))
+	writePrometheusMetric(w, "grove_config_reload_requests_total", "counter", "Config reloads requested.", float64(system.ConfigReloadRequests()))
+	writePrometheusMetric(w, "grove_config_reloads_total", "counter", "Config reloads applied.", float64(system.ConfigReloads()))
+	writePrometheusMetric(w, "grove_config_last_reload_timestamp_seconds", "gauge", "The time of the last applied config reload.", prometheusUnixSeconds(system.LastReload()))
+	writePrometheusMetric(w, "grove_connections", "gauge", "Client connections currently active.", float64(stats.Connections()))
+	writePrometheusMetric(w, "grove_cache_hits_total", "counter", "Requests served from the cache.", float64(stats.CacheHits()))
+	writePrometheusMetric(w, "grove_cache_misses_total", "counter", "Requests not served from the cache.", float64(stats.CacheMisses()))
+
+	cacheNames := stats.CacheNames()
+	sort.Strings(cacheNames)
+	writePrometheusHeader(w, "grove_cache_size_bytes", "gauge", "The bytes in each cache.")
+	for _, name := range cacheNames {
+		if size, ok := stats.CacheSizeByName(name); ok {
+			fmt.Fprintf(w, "grove_cache_size_bytes{cache=%v} %v\n", prometheusLabelValue(prometheusCacheName(name)), size)
+		}
+	}
+	writePrometheusHeader(w, "grove_cache_capacity_bytes", "gauge", "The maximum bytes of each cache.")
+	for _, name := range cacheNames {
+		if capacity, ok := stats.CacheCapacityByName(name); ok {
+			fmt.Fprintf(w, "grove_cache_capacity_bytes{cache=%v} %v\n", prometheusLabelValue(prometheusCacheName(name)), capacity)
+		}
+	}
+
+	rules := stats.Remap().Rules()
+	sort.Strings(rules)
+	remapStats := make([]stat.StatsRemap, 0, len(rules))
+	remapLabels := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		if ruleStats, ok := stats.Remap().Stats(rule); ok {
+			remapStats = append(remapStats, ruleStats)
+			remapLabels = append(remapLabels, "remap="+prometheusLabelValue(rule))
+		}
+	}
+	remapCounters := []struct {
+		name string
+		help string
+		get  func(stat.StatsRemap) uint64
+	}{
+		{"grove_remap_in_bytes_total", "Bytes read from clients, per remap rule.", stat.StatsRemap.InBytes},
+		{"grove_remap_out_bytes_total", "Bytes written to clients, per remap rule.", stat.StatsRemap.OutBytes},
+		{"grove_remap_cache_hits_total", "Requests served from the cache, per remap rule.", stat.StatsRemap.CacheHits},
+		{"grove_remap_cache_misses_total", "Requests not served from the cache, per remap rule.", stat.StatsRemap.CacheMisses},
+	}
+	for _, counter := range remapCounters {
+		writePrometheusHeader(w, counter.name, "counter", counter.help)
+		for i, ruleStats := range remapStats {
+			fmt.Fprintf(w, "%v{%v} %v\n", counter.name, remapLabels[i], counter.get(ruleStats))
+		}
+	}
+	writePrometheusHeader(w, "grove_remap_responses_total", "counter", "Responses by status code class, per remap rule.")
+	for i, ruleStats := range remapStats {
+		classes := [...]uint64{ruleStats.Status2xx(), ruleStats.Status3xx(), ruleStats.Status4xx(), ruleStats.Status5xx()}
+		for class, count := range classes {
+			fmt.Fprintf(w, "grove_remap_responses_total{%v,code=\"%vxx\"} %v\n", remapLabels[i], class+2, count)
+		}
+	}
+
+	parentHealth := stats.ParentHealth()
+	healthRules := make([]string, 0, len(parentHealth))
+	for rule := range parentHealth {
+		healthRules = append(healthRules, rule)
+	}
+	sort.Strings(healthRules)
+	writePrometheusHeader(w, "grove_parent_healthy", "gauge", "Whether each parent passed its last health check, per rule name.")
+	for _, rule := range healthRules {
+		parents := make([]string, 0, len(parentHealth[rule]))
+		for parent := range parentHealth[rule] {
+			parents = append(parents, parent)
+		}
+		sort.Strings(parents)
+		for _, parent := range parents {
+			healthy := 0
+			if parentHealth[rule][parent] {
+				healthy = 1
+			}
+			fmt.Fprintf(w, "grove_parent_healthy{rule=%v,parent=%v} %v\n", prometheusLabelValue(rule), prometheusLabelValue(parent), healthy)
+		}
+	}
+
+	collapseStats := stats.CollapseStats()
+	collapseRules := make([]string, 0, len(collapseStats))
+	for rule := range collapseStats {
+		collapseRules = append(collapseRules, rule)
+	}
+	sort.Strings(collapseRules)
+	collapseMetrics := []struct {
+		name string
+		typ  string
+		help string
+		get  func(thread.GetterStats) uint64
+	}{
+		{"grove_collapse_authors_total", "counter", "Parent requests made for keys no other request was fetching, per rule name.", func(s thread.GetterStats) uint64 { return s.Authors }},
+		{"grove_collapse_waiters_total", "counter", "Requests which waited for another request's parent response, rather than making their own, per rule name.", func(s thread.GetterStats) uint64 { return s.Waiters }},
+		{"grove_collapse_max_waiters", "gauge", "The most requests which waited for a single parent response, per rule name.", func(s thread.GetterStats) uint64 { return s.MaxWaiters }},
+		{"grove_collapse_unusable_total", "counter", "Waiting requests which couldn't use the response they waited for, per rule name.", func(s thread.GetterStats) uint64 { return s.Unusable }},
+		{"grove_collapse_timeouts_total", "counter", "Waiting requests which exceeded the max wait, per rule name.", func(s thread.GetterStats) uint64 { return s.Timeouts }},
+	}
+	for _, metric := range collapseMetrics {
+		writePrometheusHeader(w, metric.name, metric.typ, metric.help)
+		for _, rule := range collapseRules {
+			fmt.Fprintf(w, "%v{rule=%v,mode=%v} %v\n", metric.name, prometheusLabelValue(rule), prometheusLabelValue(string(collapseStats[rule].Mode)), metric.get(collapseStats[rule]))
+		}
+	}
+
+	histogramRules := make([]string, 0, len(histograms))
+	for rule := range histograms {
+		histogramRules = append(histogramRules, rule)
+	}
+	sort.Strings(histogramRules)
+	writePrometheusHeader(w, "grove_request_duration_seconds", "histogram", "The time from receiving each request to finishing its response, per remap rule.")
+	for _, rule := range histogramRules {
+		histograms[rule].write(w, "grove_request_duration_seconds", "remap="+prometheusLabelValue(rule))
+	}
+}
+
+func writePrometheusHeader(w io.Writer, name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+func writePrometheusMetric(w io.Writer, name string, typ string, help string, val float64) {
+	writePrometheusHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%v %v\n", name, prometheusFloat(val))
+}
+
+// prometheusLabelValue returns the quoted label value, escaped per the Prometheus text format.
+func prometheusLabelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func prometheusFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func prometheusUnixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// prometheusCacheName returns the name of the cache for labels. The default memory cache is named `default`, as in the http_stats plugin.
+func prometheusCacheName(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// latencyHistogram is a histogram of durations, safe for concurrent use. Counts are per bucket, and made cumulative when written.
+type latencyHistogram struct {
+	buckets []float64 // upper bounds, in seconds
+	counts  []uint64  // accessed atomically, with the last count for durations above every bucket
+	sumNS   uint64
+}
+
+func newLatencyHistogram(buckets []float64) *latencyHistogram {
+	return &latencyHistogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	i := sort.SearchFloat64s(h.buckets, d.Seconds())
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sumNS, uint64(d))
+}
+
+// write writes the histogram's buckets, sum, and count, with the given labels, which must be formatted and not empty.
+// Observations may be concurrent with writing, so the count is the cumulative count of the buckets read, which is always consistent with the buckets.
+func (h *latencyHistogram) write(w io.Writer, name string, labels string) {
+	cumulative := uint64(0)
+	for i, le := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		fmt.Fprintf(w, "%v_bucket{%v,le=\"%v\"} %v\n", name, labels, prometheusFloat(le), cumulative)
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.buckets)])
+	fmt.Fprintf(w, "%v_bucket{%v,le=\"+Inf\"} %v\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%v_sum{%v} %v\n", name, labels, prometheusFloat(float64(atomic.LoadUint64(&h.sumNS))/float64(time.Second)))
+	fmt.Fprintf(w, "%v_count{%v} %v\n", name, labels, cumulative)
+}
@@ -0,0 +1,82 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/stat"
+	"github.com/apache/incubator-trafficcontrol/grove/thread"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram([]float64{0.1, 1})
+	h.observe(50 * time.Millisecond)
+	h.observe(100 * time.Millisecond) // bucket bounds are inclusive
+	h.observe(500 * time.Millisecond)
+	h.observe(2 * time.Second)
+
+	buf := &bytes.Buffer{}
+	h.write(buf, "d", `remap="a"`)
+	expected := `d_bucket{remap="a",le="0.1"} 2
+d_bucket{remap="a",le="1"} 3
+d_bucket{remap="a",le="+Inf"} 4
+d_sum{remap="a"} 2.65
+d_count{remap="a"} 4
+`
+	if actual := buf.String(); actual != expected {
+		t.Errorf("latency histogram expected:\n%v\nactual:\n%v", expected, actual)
+	}
+}
+
+func TestWritePrometheusStats(t *testing.T) {
+	rules := []remapdata.RemapRule{{RemapRuleBase: remapdata.RemapRuleBase{Name: "foo", From: "http://foo.example.net"}, Getter: thread.NewGetter()}}
+	system := stat.NewStatsSystem("1.2.3")
+	system.AddConfigReload()
+	stats := stat.New(rules, nil, 0, nil, nil, system)
+	stats.Write(nil, "foo.example.net", "192.0.2.1:1234", 200, 10, 100, true)
+	stats.Write(nil, "foo.example.net", "192.0.2.1:1234", 404, 20, 200, false)
+
+	h := newLatencyHistogram([]float64{1})
+	h.observe(time.Second / 2)
+
+	buf := &bytes.Buffer{}
+	writePrometheusStats(buf, stats, map[string]*latencyHistogram{`a"b`: h})
+	actual := buf.String()
+	for _, expected := range []string{
+		"# TYPE grove_remap_in_bytes_total counter\n",
+		`grove_info{version="1.2.3"} 1` + "\n",
+		"grove_config_reloads_total 1\n",
+		"grove_cache_hits_total 1\n",
+		"grove_cache_misses_total 1\n",
+		`grove_remap_in_bytes_total{remap="foo.example.net"} 30` + "\n",
+		`grove_remap_out_bytes_total{remap="foo.example.net"} 300` + "\n",
+		`grove_remap_responses_total{remap="foo.example.net",code="2xx"} 1` + "\n",
+		`grove_remap_responses_total{remap="foo.example.net",code="4xx"} 1` + "\n",
+		`grove_remap_responses_total{remap="foo.example.net",code="5xx"} 0` + "\n",
+		`grove_collapse_authors_total{rule="foo",mode="cacheable"} 0` + "\n",
+		"# TYPE grove_collapse_max_waiters gauge\n",
+		"# TYPE grove_request_duration_seconds histogram\n",
+		`grove_request_duration_seconds_bucket{remap="a\"b",le="1"} 1` + "\n",
+	} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("prometheus stats expected to contain '%v', actual:\n%v", strings.TrimSpace(expected), actual)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+func init() {
+	AddPlugin(10000, Funcs{onRequest: purge})
+}
+
+// PurgeEndpoint is the reserved path for invalidating cached objects by prefix or regular expression.
+const PurgeEndpoint = "/_purge"
+
+// PurgeResp is the JSON response of the purge endpoint, containing the keys removed from each cache.
+type PurgeResp struct {
+	Removed map[string][]string `json:"removed"`
+}
+
+// purge removes all objects whose key URLs match the `prefix` or `regex` query parameter, from the cache named by the `cache` parameter, or all caches if it's absent. Keys are matched without their method, for example `http://origin.example.net/foo`.
+// Requests must be POST or PURGE, and from an IP allowed by the stats ACL.
+func purge(icfg interface{}, d OnRequestData) bool {
+	if !strings.HasPrefix(d.R.URL.Path, PurgeEndpoint) {
+		log.Debugf("plugin onrequest http_purge returning, not in path '%v'\n", d.R.URL.Path)
+		return false
+	}
+
+	log.Debugf("plugin onrequest http_purge calling\n")
+
+	reqTime := time.Now()
+	w := d.W
+	req := d.R
+
+	respondErr := func(code int) bool {
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+		return true
+	}
+
+	ip, err := web.GetIP(req)
+	if err != nil {
+		log.Errorln("http_purge failed to get IP: " + ip.String())
+		return respondErr(http.StatusInternalServerError)
+	}
+	if !d.StatRules.Allowed(ip) {
+		log.Debugln("http_purge IP " + ip.String() + " FORBIDDEN")
+		return respondErr(http.StatusForbidden)
+	}
+	if req.Method != http.MethodPost && req.Method != web.MethodPurge {
+		w.Header().Set("Allow", http.MethodPost+", "+web.MethodPurge)
+		return respondErr(http.StatusMethodNotAllowed)
+	}
+
+	qry := req.URL.Query()
+	prefix := qry.Get("prefix")
+	regexStr := qry.Get("regex")
+	if (prefix == "") == (regexStr == "") {
+		log.Debugln("http_purge request must have exactly one of prefix or regex")
+		return respondErr(http.StatusBadRequest)
+	}
+
+	match := func(url string) bool { return strings.HasPrefix(url, prefix) }
+	if regexStr != "" {
+		regex, err := regexp.Compile(regexStr)
+		if err != nil {
+			log.Debugln("http_purge compiling regex '" + regexStr + "': " + err.Error())
+			return respondErr(http.StatusBadRequest)
+		}
+		match = regex.MatchString
+	}
+
+	cacheNames := d.Stats.CacheNames()
+	if cacheName, ok := qry["cache"]; ok {
+		exists := false
+		for _, name := range cacheNames {
+			exists = exists || name == cacheName[0]
+		}
+		if !exists {
+			log.Debugln("http_purge cache '" + cacheName[0] + "' not found")
+			return respondErr(http.StatusBadRequest)
+		}
+		cacheNames = []string{cacheName[0]}
+	}
+
+	resp := PurgeResp{Removed: map[string][]string{}}
+	for _, cacheName := range cacheNames {
+		removed := []string{}
+		for _, key := range d.Stats.CacheKeys(cacheName) {
+			if !match(remapdata.CacheKeyURL(key)) {
+				continue
+			}
+			if d.Stats.CacheRemove(key, cacheName) {
+				removed = append(removed, key)
+			}
+		}
+		resp.Removed[cacheName] = removed
+		log.Infof("http_purge removed %v objects from cache '%v' matching prefix '%v' regex '%v'\n", len(removed), cacheName, prefix, regexStr)
+	}
+
+	bytes, err := json.Marshal(resp)
+	if err != nil {
+		log.Errorln("http_purge marshalling JSON: " + err.Error())
+		return respondErr(http.StatusInternalServerError)
+	}
+
+	respCode := http.StatusOK
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(respCode)
+	w.Write(bytes)
+
+	clientIP, _ := web.GetClientIPPort(req)
+	now := time.Now()
+	log.EventRaw(atsEventLogStr(now, clientIP, d.Hostname, req.Host, d.Port, "-", d.Scheme, req.URL.String(), req.Method, req.Proto, respCode, now.Sub(reqTime), uint64(len(bytes)), 0, 0, true, true, getCacheHitStr(true, false), "-", "-", req.UserAgent(), req.Header.Get("X-Money-Trace"), d.RequestID))
+	return true
+}
@@ -0,0 +1,83 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/memcache"
+	"github.com/apache/incubator-trafficcontrol/grove/stat"
+)
+
+func purgeRequest(t *testing.T, stats stat.Stats, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, PurgeEndpoint+"?"+query, nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	if !purge(nil, OnRequestData{W: w, R: r, Stats: stats}) {
+		t.Fatalf("purge %v expected to handle request, actual not handled", query)
+	}
+	return w
+}
+
+func TestPurge(t *testing.T) {
+	cache := memcache.New(1024*1024, nil)
+	now := time.Now()
+	for _, key := range []string{"GET:http://foo.example.net/a", "GET:http://foo.example.net/b", "GET:http://bar.example.net/a"} {
+		cache.Add(key, cacheobj.New(nil, []byte("x"), http.StatusOK, http.StatusOK, "", http.Header{}, now, now, now, now))
+	}
+	stats := stat.New(nil, map[string]icache.Cache{"": cache}, 0, nil, nil, stat.NewStatsSystem(""))
+
+	w := purgeRequest(t, stats, "prefix=http://foo.example.net/&cache=")
+	if w.Code != http.StatusOK {
+		t.Fatalf("purge expected code %v, actual %v", http.StatusOK, w.Code)
+	}
+	resp := PurgeResp{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("purge response decoding: %v", err)
+	}
+	removed := map[string]struct{}{}
+	for _, key := range resp.Removed[""] {
+		removed[key] = struct{}{}
+	}
+	expected := map[string]struct{}{"GET:http://foo.example.net/a": {}, "GET:http://foo.example.net/b": {}}
+	if !reflect.DeepEqual(expected, removed) {
+		t.Errorf("purge expected removed %v, actual %v", expected, removed)
+	}
+	if keys := stats.CacheKeys(""); len(keys) != 1 || keys[0] != "GET:http://bar.example.net/a" {
+		t.Errorf("purge expected remaining keys [GET:http://bar.example.net/a], actual %v", keys)
+	}
+}
+
+func TestPurgeUnknownCache(t *testing.T) {
+	cache := memcache.New(1024*1024, nil)
+	stats := stat.New(nil, map[string]icache.Cache{"": cache}, 0, nil, nil, stat.NewStatsSystem(""))
+
+	if w := purgeRequest(t, stats, "prefix=http://foo.example.net/&cache=nope"); w.Code != http.StatusBadRequest {
+		t.Errorf("purge of unknown cache expected code %v, actual %v", http.StatusBadRequest, w.Code)
+	}
+	if keys := stats.CacheKeys("nope"); len(keys) != 0 {
+		t.Errorf("CacheKeys of unknown cache expected empty, actual %v", keys)
+	}
+	if stats.CacheRemove("GET:http://foo.example.net/a", "nope") {
+		t.Errorf("CacheRemove of unknown cache expected false, actual true")
+	}
+}
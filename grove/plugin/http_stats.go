@@ -121,6 +121,32 @@ func LoadRemapStats(stats stat.Stats, httpConns *web.ConnMap, httpsConns *web.Co
 		jsonStats["plugin.remap_stats."+ruleName+".cache_misses"] = statsRemap.CacheMisses()
 	}
 
+	for ruleName, parents := range stats.ParentHealth() {
+		for parent, healthy := range parents {
+			jsonStats["plugin.parent_health."+ruleName+"."+parent] = healthy
+		}
+	}
+
+	for cacheName, policyStats := range stats.CachePolicyStats() {
+		if cacheName == "" {
+			cacheName = "default"
+		}
+		jsonStats["plugin.cache_policy."+cacheName+".policy"] = policyStats.Policy
+		jsonStats["plugin.cache_policy."+cacheName+".hits"] = policyStats.Hits
+		jsonStats["plugin.cache_policy."+cacheName+".misses"] = policyStats.Misses
+		jsonStats["plugin.cache_policy."+cacheName+".hit_ratio"] = policyStats.HitRatio()
+	}
+
+	for ruleName, collapseStats := range stats.CollapseStats() {
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".mode"] = collapseStats.Mode
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".authors"] = collapseStats.Authors
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".waiters"] = collapseStats.Waiters
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".max_waiters"] = collapseStats.MaxWaiters
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".waiters_per_key"] = collapseStats.WaitersPerKey()
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".unusable"] = collapseStats.Unusable
+		jsonStats["plugin.collapsed_forwarding."+ruleName+".timeouts"] = collapseStats.Timeouts
+	}
+
 	jsonStats["proxy.process.http.current_client_connections"] = httpConns.Len() + httpsConns.Len()
 	jsonStats["proxy.process.http.cache_hits"] = stats.CacheHits()
 	jsonStats["proxy.process.http.cache_misses"] = stats.CacheMisses()
@@ -0,0 +1,197 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// http_warm serves the cache warming endpoint, which starts warm jobs and reports their progress. The jobs are run by the Handler, see OnRequestData.Warmer.
+func init() {
+	AddPlugin(10000, Funcs{load: warmLoad, onRequest: warm})
+}
+
+// WarmEndpoint is the reserved path for warming the cache with a list of URLs or manifests.
+const WarmEndpoint = "/_warm"
+
+// DefaultWarmConcurrency is the number of URLs of a warm job fetched at once, if the job doesn't specify it. Parent requests are additionally limited by each rule's concurrent_rule_requests.
+const DefaultWarmConcurrency = 4
+
+// MaxWarmConcurrency is the default maximum concurrency of a warm job. Jobs requesting more are rejected, so a single job can't start unbounded goroutines and parent requests.
+const MaxWarmConcurrency = 64
+
+// MaxWarmReqBytes is the maximum size of a warm request body.
+const MaxWarmReqBytes = 10 * 1024 * 1024
+
+type warmConfig struct {
+	// Disabled disables the endpoint. Requests to it are then remapped like any other request.
+	Disabled bool `json:"disabled"`
+	// Allow and Deny are the CIDR networks of clients allowed to warm. If both are empty, the stats ACL is used.
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// MaxConcurrency is the maximum concurrency of a job. Defaults to MaxWarmConcurrency.
+	MaxConcurrency int `json:"max_concurrency"`
+
+	acl *remapdata.RemapRulesStats
+}
+
+func warmLoad(b json.RawMessage) interface{} {
+	cfg := warmConfig{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Errorln("http_warm loading config, unmarshalling JSON: " + err.Error())
+		return nil
+	}
+	if len(cfg.Allow) > 0 || len(cfg.Deny) > 0 {
+		allow, err := warmIPNets(cfg.Allow)
+		if err != nil {
+			log.Errorln("http_warm loading config, parsing allow: " + err.Error())
+			return nil
+		}
+		deny, err := warmIPNets(cfg.Deny)
+		if err != nil {
+			log.Errorln("http_warm loading config, parsing deny: " + err.Error())
+			return nil
+		}
+		cfg.acl = &remapdata.RemapRulesStats{Allow: allow, Deny: deny}
+	}
+	if cfg.MaxConcurrency <= 0 {
+		cfg.MaxConcurrency = MaxWarmConcurrency
+	}
+	log.Debugf("http_warm load success: %+v\n", cfg)
+	return &cfg
+}
+
+func warmIPNets(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// warm handles a request to the WarmEndpoint. A POST with a cachedata.WarmReq body starts a job, and responds 202 with its cachedata.WarmJob. A GET responds with every kept job, or with the job of the `id` query parameter.
+// The client must be allowed by the config's ACL, or the stats ACL if the config has none. If the plugin isn't configured, the endpoint is enabled, with the stats ACL.
+func warm(icfg interface{}, d OnRequestData) bool {
+	if d.R.URL.Path != WarmEndpoint {
+		return false
+	}
+	cfg := &warmConfig{MaxConcurrency: MaxWarmConcurrency}
+	if icfg != nil {
+		ok := false
+		if cfg, ok = icfg.(*warmConfig); !ok {
+			log.Errorf("http_warm config '%v' type '%T' expected *warmConfig\n", icfg, icfg)
+			return false
+		}
+	}
+	if cfg.Disabled {
+		return false
+	}
+	if d.Warmer == nil {
+		log.Errorf("http_warm no warmer (reqid %v)\n", d.RequestID)
+		return false
+	}
+
+	w := d.W
+	respondErr := func(code int) bool {
+		w.WriteHeader(code)
+		w.Write([]byte(http.StatusText(code)))
+		return true
+	}
+	respondJSON := func(code int, v interface{}) bool {
+		bts, err := json.Marshal(v)
+		if err != nil {
+			log.Errorf("http_warm marshalling JSON: %v (reqid %v)\n", err, d.RequestID)
+			return respondErr(http.StatusInternalServerError)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(bts)
+		return true
+	}
+
+	ip, err := web.GetIP(d.R)
+	if err != nil {
+		log.Errorf("http_warm getting client IP: %v (reqid %v)\n", err, d.RequestID)
+		return respondErr(http.StatusInternalServerError)
+	}
+	acl := d.StatRules
+	if cfg.acl != nil {
+		acl = *cfg.acl
+	}
+	if !acl.Allowed(ip) {
+		log.Debugf("http_warm IP %v not allowed (reqid %v)\n", ip, d.RequestID)
+		return respondErr(http.StatusForbidden)
+	}
+
+	switch d.R.Method {
+	case http.MethodGet:
+		idStr := d.R.URL.Query().Get("id")
+		if idStr == "" {
+			return respondJSON(http.StatusOK, d.Warmer.WarmJobs())
+		}
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			return respondErr(http.StatusBadRequest)
+		}
+		job, ok := d.Warmer.WarmJob(id)
+		if !ok {
+			return respondErr(http.StatusNotFound)
+		}
+		return respondJSON(http.StatusOK, job)
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(io.LimitReader(d.R.Body, MaxWarmReqBytes))
+		if err != nil {
+			log.Errorf("http_warm reading request body: %v (reqid %v)\n", err, d.RequestID)
+			return respondErr(http.StatusBadRequest)
+		}
+		warmReq := cachedata.WarmReq{}
+		if err := json.Unmarshal(body, &warmReq); err != nil {
+			log.Debugf("http_warm parsing request body: %v (reqid %v)\n", err, d.RequestID)
+			return respondErr(http.StatusBadRequest)
+		}
+		if len(warmReq.URLs) == 0 && len(warmReq.Manifests) == 0 {
+			return respondErr(http.StatusBadRequest)
+		}
+		if warmReq.Concurrency > cfg.MaxConcurrency {
+			log.Debugf("http_warm concurrency %v greater than max %v (reqid %v)\n", warmReq.Concurrency, cfg.MaxConcurrency, d.RequestID)
+			return respondErr(http.StatusBadRequest)
+		}
+		if warmReq.Concurrency <= 0 {
+			warmReq.Concurrency = DefaultWarmConcurrency
+		}
+		job := d.Warmer.StartWarmJob(warmReq)
+		log.Infof("http_warm started job %v (reqid %v)\n", job.ID, d.RequestID)
+		return respondJSON(http.StatusAccepted, job)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		return respondErr(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,165 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+)
+
+// fakeWarmer records the jobs started, without warming anything.
+type fakeWarmer struct {
+	jobs []cachedata.WarmJob
+	reqs []cachedata.WarmReq
+}
+
+func (f *fakeWarmer) StartWarmJob(req cachedata.WarmReq) cachedata.WarmJob {
+	job := cachedata.WarmJob{ID: uint64(len(f.jobs) + 1), Total: uint64(len(req.URLs) + len(req.Manifests)), Errors: []string{}}
+	f.jobs = append(f.jobs, job)
+	f.reqs = append(f.reqs, req)
+	return job
+}
+
+func (f *fakeWarmer) WarmJobs() []cachedata.WarmJob { return f.jobs }
+
+func (f *fakeWarmer) WarmJob(id uint64) (cachedata.WarmJob, bool) {
+	if id == 0 || id > uint64(len(f.jobs)) {
+		return cachedata.WarmJob{}, false
+	}
+	return f.jobs[id-1], true
+}
+
+// warmRequest makes a request to the warm plugin, from 192.0.2.1, with a stats ACL only allowing 192.0.2.0/24. Returns whether the plugin handled it, and the response.
+func warmRequest(t *testing.T, cfgJSON string, warmer Warmer, method string, path string, body string) (bool, *httptest.ResponseRecorder) {
+	icfg := interface{}(nil)
+	if cfgJSON != "" {
+		icfg = warmLoad(json.RawMessage(cfgJSON))
+		if icfg == nil {
+			t.Fatalf("warmLoad '%v' expected config, actual nil", cfgJSON)
+		}
+	}
+	_, statsNet, _ := net.ParseCIDR("192.0.2.0/24")
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	handled := warm(icfg, OnRequestData{W: w, R: r, StatRules: remapdata.RemapRulesStats{Allow: []*net.IPNet{statsNet}}, Warmer: warmer})
+	return handled, w
+}
+
+func TestWarmStart(t *testing.T) {
+	warmer := &fakeWarmer{}
+	handled, w := warmRequest(t, "", warmer, http.MethodPost, WarmEndpoint, `{"urls": ["http://foo.example.net/a"], "manifests": ["http://foo.example.net/a.m3u8"]}`)
+	if !handled {
+		t.Fatalf("warm expected to handle request, actual not handled")
+	}
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("warm expected code %v, actual %v", http.StatusAccepted, w.Code)
+	}
+	job := cachedata.WarmJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("warm response decoding: %v", err)
+	}
+	if job.ID != 1 || job.Total != 2 {
+		t.Errorf("warm expected job 1 with total 2, actual %+v", job)
+	}
+	if len(warmer.reqs) != 1 || warmer.reqs[0].Concurrency != DefaultWarmConcurrency {
+		t.Errorf("warm expected job started with concurrency %v, actual %+v", DefaultWarmConcurrency, warmer.reqs)
+	}
+
+	if _, w := warmRequest(t, "", warmer, http.MethodGet, WarmEndpoint+"?id=1", ""); w.Code != http.StatusOK {
+		t.Errorf("warm GET of job expected code %v, actual %v", http.StatusOK, w.Code)
+	}
+	if _, w := warmRequest(t, "", warmer, http.MethodGet, WarmEndpoint+"?id=2", ""); w.Code != http.StatusNotFound {
+		t.Errorf("warm GET of nonexistent job expected code %v, actual %v", http.StatusNotFound, w.Code)
+	}
+	_, w = warmRequest(t, "", warmer, http.MethodGet, WarmEndpoint, "")
+	jobs := []cachedata.WarmJob{}
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil || len(jobs) != 1 {
+		t.Errorf("warm GET of jobs expected 1 job, actual %s error %v", w.Body.Bytes(), err)
+	}
+}
+
+func TestWarmBadRequest(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":                         `{"urls": [`,
+		"empty":                            `{}`,
+		"default max concurrency exceeded": `{"urls": ["http://foo.example.net/a"], "concurrency": 65}`,
+	}
+	for name, body := range tests {
+		warmer := &fakeWarmer{}
+		if _, w := warmRequest(t, "", warmer, http.MethodPost, WarmEndpoint, body); w.Code != http.StatusBadRequest {
+			t.Errorf("warm %v expected code %v, actual %v", name, http.StatusBadRequest, w.Code)
+		}
+		if len(warmer.jobs) != 0 {
+			t.Errorf("warm %v expected no job started, actual %+v", name, warmer.jobs)
+		}
+	}
+
+	if _, w := warmRequest(t, `{"max_concurrency": 8}`, &fakeWarmer{}, http.MethodPost, WarmEndpoint, `{"urls": ["http://foo.example.net/a"], "concurrency": 9}`); w.Code != http.StatusBadRequest {
+		t.Errorf("warm concurrency above configured max expected code %v, actual %v", http.StatusBadRequest, w.Code)
+	}
+	if _, w := warmRequest(t, `{"max_concurrency": 8}`, &fakeWarmer{}, http.MethodPost, WarmEndpoint, `{"urls": ["http://foo.example.net/a"], "concurrency": 8}`); w.Code != http.StatusAccepted {
+		t.Errorf("warm concurrency at configured max expected code %v, actual %v", http.StatusAccepted, w.Code)
+	}
+	if _, w := warmRequest(t, "", &fakeWarmer{}, http.MethodDelete, WarmEndpoint, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("warm DELETE expected code %v, actual %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func TestWarmACL(t *testing.T) {
+	body := `{"urls": ["http://foo.example.net/a"]}`
+	tests := []struct {
+		cfg  string
+		code int
+	}{
+		{cfg: "", code: http.StatusAccepted},                                // the stats ACL allows the client
+		{cfg: `{"allow": ["198.51.100.0/24"]}`, code: http.StatusForbidden}, // the config ACL replaces the stats ACL
+		{cfg: `{"allow": ["192.0.2.1/32"]}`, code: http.StatusAccepted},
+		{cfg: `{"deny": ["192.0.2.0/24"]}`, code: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		warmer := &fakeWarmer{}
+		if _, w := warmRequest(t, test.cfg, warmer, http.MethodPost, WarmEndpoint, body); w.Code != test.code {
+			t.Errorf("warm with config '%v' expected code %v, actual %v", test.cfg, test.code, w.Code)
+		}
+		if started := len(warmer.jobs) == 1; started != (test.code == http.StatusAccepted) {
+			t.Errorf("warm with config '%v' expected job started %v, actual %v", test.cfg, test.code == http.StatusAccepted, started)
+		}
+	}
+
+	if cfg := warmLoad(json.RawMessage(`{"allow": ["not a network"]}`)); cfg != nil {
+		t.Errorf("warmLoad of invalid allow expected nil, actual %+v", cfg)
+	}
+}
+
+func TestWarmNotHandled(t *testing.T) {
+	warmer := &fakeWarmer{}
+	if handled, _ := warmRequest(t, `{"disabled": true}`, warmer, http.MethodPost, WarmEndpoint, `{"urls": ["http://foo.example.net/a"]}`); handled {
+		t.Errorf("warm disabled expected not handled, actual handled")
+	}
+	if handled, _ := warmRequest(t, "", warmer, http.MethodGet, "/foo", ""); handled {
+		t.Errorf("warm of other path expected not handled, actual handled")
+	}
+	if len(warmer.jobs) != 0 {
+		t.Errorf("warm not handled expected no job started, actual %+v", warmer.jobs)
+	}
+}
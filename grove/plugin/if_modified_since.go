@@ -35,5 +35,5 @@ func ifModifiedSince(icfg interface{}, d BeforeRespondData) {
 	if d.CacheObj.LastModified.After(modifiedSince) {
 		return
 	}
-	*d.Code, *d.Hdr, *d.Body = http.StatusNotModified, nil, nil
+	*d.Code, *d.Hdr, *d.Body, *d.Stream = http.StatusNotModified, nil, nil, nil
 }
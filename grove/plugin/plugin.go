@@ -17,6 +17,7 @@ package plugin
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -28,6 +29,7 @@ import (
 	"github.com/apache/incubator-trafficcontrol/grove/cachedata"
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/config"
+	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
 	"github.com/apache/incubator-trafficcontrol/grove/stat"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
@@ -47,6 +49,7 @@ type Funcs struct {
 	load                LoadFunc
 	startup             StartupFunc
 	onRequest           OnRequestFunc
+	onRemap             OnRemapFunc
 	beforeParentRequest BeforeParentRequestFunc
 	beforeRespond       BeforeRespondFunc
 	afterRespond        AfterRespondFunc
@@ -69,6 +72,25 @@ type OnRequestData struct {
 	HTTPSConns    *web.ConnMap
 	RequestID     uint64
 	Context       *interface{}
+	// Warmer starts and reports cache warming jobs, which are run by the Handler.
+	Warmer Warmer
+	cachedata.SrvrData
+}
+
+// Warmer starts and reports cache warming jobs. It's implemented by the cache Handler, which plugins can't import.
+type Warmer interface {
+	StartWarmJob(req cachedata.WarmReq) cachedata.WarmJob
+	WarmJobs() []cachedata.WarmJob
+	WarmJob(id uint64) (cachedata.WarmJob, bool)
+}
+
+// OnRemapData is the data of a request which matched a remap rule, before the cache is checked. Plugins may modify the request, such as removing query parameters, and the cache key and parent request are made from the modified request. To reject the request, set the Code, and return true. The error response is sent, logged, and counted like any other.
+type OnRemapData struct {
+	R         *http.Request
+	RemapRule string
+	Code      *int
+	RequestID uint64
+	Context   *interface{}
 	cachedata.SrvrData
 }
 
@@ -78,17 +100,22 @@ type BeforeParentRequestData struct {
 	Context   *interface{}
 }
 
-// BeforeRespondData holds the data passed to plugins. The objects pointed to MAY NOT be modified, however, the location pointed to may be changed for the Code, Hdr, and Body. That iss, `*d.Hdr = myHdr` is ok, but `d.Hdr.Add("a", "b") is not.
+// BeforeRespondData holds the data passed to plugins. The objects pointed to MAY NOT be modified, however, the location pointed to may be changed for the Code, Hdr, Body, and Stream. That iss, `*d.Hdr = myHdr` is ok, but `d.Hdr.Add("a", "b") is not.
 // If that's confusing, recall `http.Header` is a map, therefore Hdr and Body are both pointers-to-pointers.
 type BeforeRespondData struct {
 	Req *http.Request
 	// CacheObj is the object to be cached, containing information about the origin request. The code, headers, and body should not be considered authoritative. Look at Code, Hdr, and Body instead, as the actual values about to be sent. Note CacheObj may be nil, if an error occurred (e.g. the Origin failed to respond).
-	CacheObj  *cacheobj.CacheObj
-	Code      *int
-	Hdr       *http.Header
-	Body      *[]byte
+	CacheObj *cacheobj.CacheObj
+	Code     *int
+	Hdr      *http.Header
+	Body     *[]byte
+	// Stream is the body being streamed from the parent. If `*d.Stream` is not nil, it is sent instead of Body, and Body is empty. Plugins which need the body must read it from the Stream, and then set `*d.Stream = nil` and `*d.Body` to what they read, or replace the Stream with a reader wrapping it.
+	Stream    *io.Reader
 	RemapRule string
-	Context   *interface{}
+	// Cache is the rule's cache, and CacheKey the key of the request in it, so plugins may cache representations derived from the CacheObj. The CacheKey may be the base key of an object which varies, see remapdata.VariantCacheKey. The Cache may be nil, if the request has no rule.
+	Cache    icache.Cache
+	CacheKey string
+	Context  *interface{}
 }
 
 type AfterRespondData struct {
@@ -105,6 +132,7 @@ type AfterRespondData struct {
 type LoadFunc func(json.RawMessage) interface{}
 type StartupFunc func(icfg interface{}, d StartupData)
 type OnRequestFunc func(icfg interface{}, d OnRequestData) bool
+type OnRemapFunc func(icfg interface{}, d OnRemapData) bool
 type BeforeParentRequestFunc func(icfg interface{}, d BeforeParentRequestData)
 type BeforeRespondFunc func(icfg interface{}, d BeforeRespondData)
 type AfterRespondFunc func(icfg interface{}, d AfterRespondData)
@@ -132,6 +160,7 @@ type Plugins interface {
 	LoadFuncs() map[string]LoadFunc
 	OnStartup(cfgs map[string]interface{}, context map[string]*interface{}, d StartupData)
 	OnRequest(cfgs map[string]interface{}, context map[string]*interface{}, d OnRequestData) bool
+	OnRemap(cfgs map[string]interface{}, context map[string]*interface{}, d OnRemapData) bool
 	OnBeforeParentRequest(cfgs map[string]interface{}, context map[string]*interface{}, d BeforeParentRequestData)
 	OnBeforeRespond(cfgs map[string]interface{}, context map[string]*interface{}, d BeforeRespondData)
 	OnAfterRespond(cfgs map[string]interface{}, context map[string]*interface{}, d AfterRespondData)
@@ -175,6 +204,20 @@ func (ps pluginsSlice) OnRequest(cfgs map[string]interface{}, context map[string
 	return false
 }
 
+// OnRemap returns a boolean whether to immediately stop processing the request, and respond with the Code. If a plugin returns true, this is immediately returned with no further plugins processed.
+func (ps pluginsSlice) OnRemap(cfgs map[string]interface{}, context map[string]*interface{}, d OnRemapData) bool {
+	for _, p := range ps {
+		if p.funcs.onRemap == nil {
+			continue
+		}
+		d.Context = context[p.name]
+		if stop := p.funcs.onRemap(cfgs[p.name], d); stop {
+			return true
+		}
+	}
+	return false
+}
+
 func (ps pluginsSlice) OnBeforeParentRequest(cfgs map[string]interface{}, context map[string]*interface{}, d BeforeParentRequestData) {
 	for _, p := range ps {
 		if p.funcs.beforeParentRequest == nil {
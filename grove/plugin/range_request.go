@@ -0,0 +1,139 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// rangeRequest serves RFC 7233 byte-range requests from full cached objects. Range headers are removed from parent requests, so the full object is always fetched and cached, and subsequent ranges of the same object are cache hits.
+// It runs after if_modified_since, because conditional headers are evaluated before Range, per RFC 7233§3.1.
+func init() {
+	AddPlugin(6000, Funcs{onRequest: rangeReqOnRequest, beforeParentRequest: rangeReqBeforeParent, beforeRespond: rangeReqBeforeRespond})
+}
+
+type rangeReqContext struct {
+	Range   string
+	IfRange string
+}
+
+func rangeReqOnRequest(icfg interface{}, d OnRequestData) bool {
+	if d.R.Method != http.MethodGet {
+		return false // Range is only defined for GET, per RFC 7233§3.1
+	}
+	rng := d.R.Header.Get("Range")
+	if rng == "" {
+		return false
+	}
+	*d.Context = &rangeReqContext{Range: rng, IfRange: d.R.Header.Get("If-Range")}
+	return false
+}
+
+func rangeReqBeforeParent(icfg interface{}, d BeforeParentRequestData) {
+	if d.Req.Header.Get("Range") == "" {
+		return
+	}
+	log.Debugf("range_request removing Range '%v' from parent request\n", d.Req.Header.Get("Range"))
+	d.Req.Header.Del("Range")
+	d.Req.Header.Del("If-Range")
+}
+
+func rangeReqBeforeRespond(icfg interface{}, d BeforeRespondData) {
+	if *d.Code != http.StatusOK || (d.Req.Method != http.MethodGet && d.Req.Method != http.MethodHead) {
+		return
+	}
+
+	*d.Hdr = web.CopyHeader(*d.Hdr)
+	if (*d.Hdr).Get("Accept-Ranges") == "" {
+		(*d.Hdr).Set("Accept-Ranges", "bytes")
+	}
+
+	if *d.Context == nil {
+		return
+	}
+	ctx, ok := (*d.Context).(*rangeReqContext)
+	if !ok {
+		log.Errorf("range_request context '%v' type '%T' expected *rangeReqContext\n", *d.Context, *d.Context)
+		return
+	}
+
+	if !web.IfRangeMatches(ctx.IfRange, *d.Hdr) {
+		return // the client's partial object is stale, send the full representation
+	}
+
+	size := int64(len(*d.Body))
+	if *d.Stream != nil {
+		contentLength, err := strconv.ParseInt((*d.Hdr).Get("Content-Length"), 10, 64)
+		if err != nil {
+			// The size isn't known until the stream is complete, so it must be read before the range can be served.
+			body, err := ioutil.ReadAll(*d.Stream)
+			if err != nil {
+				log.Errorf("range_request reading streaming body: %v\n", err)
+				*d.Code, *d.Body, *d.Stream = http.StatusBadGateway, nil, nil
+				return
+			}
+			*d.Body, *d.Stream = body, nil
+			contentLength = int64(len(body))
+		}
+		size = contentLength
+	}
+
+	ranges, err := web.ParseRange(ctx.Range, size)
+	if err == web.ErrRangeNotSatisfiable {
+		(*d.Hdr).Set("Content-Range", web.ContentRangeUnsatisfied(size))
+		(*d.Hdr).Del("Content-Length")
+		*d.Code, *d.Body, *d.Stream = http.StatusRequestedRangeNotSatisfiable, nil, nil
+		return
+	} else if err != nil {
+		log.Debugf("range_request ignoring Range '%v': %v\n", ctx.Range, err)
+		return
+	}
+
+	if *d.Stream != nil && len(ranges) > 1 {
+		// TODO stream multipart ranges, rather than reading the entire body
+		body, err := ioutil.ReadAll(*d.Stream)
+		if err != nil {
+			log.Errorf("range_request reading streaming body: %v\n", err)
+			*d.Code, *d.Body, *d.Stream = http.StatusBadGateway, nil, nil
+			return
+		}
+		*d.Body, *d.Stream = body, nil
+	}
+
+	contentLength := int64(0)
+	if len(ranges) == 1 {
+		rng := ranges[0]
+		(*d.Hdr).Set("Content-Range", rng.ContentRange(size))
+		if *d.Stream != nil {
+			*d.Stream = web.NewRangeReader(*d.Stream, rng)
+		} else {
+			*d.Body = (*d.Body)[rng.Start : rng.End+1]
+		}
+		contentLength = rng.Length()
+	} else {
+		body, contentType := web.MultipartByteRanges(*d.Body, ranges, (*d.Hdr).Get("Content-Type"))
+		(*d.Hdr).Set("Content-Type", contentType)
+		*d.Body = body
+		contentLength = int64(len(body))
+	}
+	(*d.Hdr).Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	*d.Code = http.StatusPartialContent
+}
@@ -19,5 +19,15 @@ func init() {
 }
 
 func recordStats(icfg interface{}, d AfterRespondData) {
-	d.Stats.Write(d.W, d.Conn, d.Req.Host, d.Req.RemoteAddr, d.RespCode, d.BytesWritten, d.CacheHit)
+	d.Stats.Write(d.W, statsRemapName(d), d.Req.RemoteAddr, d.RespCode, d.BytesRead, d.BytesWritten, d.CacheHit)
+}
+
+// statsRemapName returns the name of the request's remap stats. Stats are kept by the request host, except for rules matched by regexes, whose stats are kept by rule name.
+func statsRemapName(d AfterRespondData) string {
+	if _, ok := d.Stats.Remap().Stats(d.Req.Host); !ok && d.RemapRule != "" {
+		if _, ok := d.Stats.Remap().Stats(d.RemapRule); ok {
+			return d.RemapRule
+		}
+	}
+	return d.Req.Host
 }
@@ -0,0 +1,370 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/web"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// urlSigning validates signed URLs, for rules with url_sig keys or URI Signing keys, and rejects requests without a valid signature with a 403. The signature is removed from the request, so the cache key and parent request don't include it, and all clients share the same cached object.
+// It runs once the request is remapped, with the config of its rule, so the reserved endpoint plugins, which have their own ACL, aren't signed.
+func init() {
+	AddPlugin(15000, Funcs{load: urlSigningLoad, onRemap: urlSigningOnRemap})
+}
+
+// URISigningParam is the query parameter of the URI Signing JWT, per the IETF CDNI URI Signing draft.
+const URISigningParam = "URISigningPackage"
+
+// URLSigParams are the query parameters of an ATS url_sig signature. The signature S must be the last parameter, because the signed string is the query up to it.
+var URLSigParams = []string{"C", "E", "A", "K", "P", "S"}
+
+type urlSigningConfig struct {
+	// URLSigKeys are the ATS url_sig keys, by name, `key0` through `key15`, as stored in Traffic Ops.
+	URLSigKeys map[string]string `json:"url_sig_keys"`
+	// URISigningKeys are the URI Signing JSON Web Keys, by issuer, as stored in Traffic Ops.
+	URISigningKeys map[string]uriSigningKeyset `json:"uri_signing_keys"`
+}
+
+type uriSigningKeyset struct {
+	RenewalKID *string     `json:"renewal_kid"`
+	Keys       []uriSigJWK `json:"keys"`
+}
+
+// uriSigJWK is a JSON Web Key, per RFC 7517. Only symmetric `oct` keys and RSA public keys are supported.
+type uriSigJWK struct {
+	Alg string `json:"alg"`
+	KID string `json:"kid"`
+	Kty string `json:"kty"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func urlSigningLoad(b json.RawMessage) interface{} {
+	cfg := urlSigningConfig{}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Errorln("url_signing loading config, unmarshalling JSON: " + err.Error())
+		return nil
+	}
+	for issuer, keyset := range cfg.URISigningKeys {
+		if len(keyset.Keys) == 0 {
+			delete(cfg.URISigningKeys, issuer) // Traffic Ops returns an empty keyset as {"renewal_kid":null,"keys":null}
+		}
+	}
+	if len(cfg.URLSigKeys) == 0 && len(cfg.URISigningKeys) == 0 {
+		log.Errorln("url_signing loading config: no url_sig_keys or uri_signing_keys, all requests will be rejected")
+	}
+	log.Debugf("url_signing load success: %v url_sig keys, %v URI signing issuers\n", len(cfg.URLSigKeys), len(cfg.URISigningKeys))
+	return &cfg
+}
+
+func urlSigningOnRemap(icfg interface{}, d OnRemapData) bool {
+	if icfg == nil {
+		return false
+	}
+	cfg, ok := icfg.(*urlSigningConfig)
+	if !ok {
+		log.Errorf("url_signing config '%v' type '%T' expected *urlSigningConfig\n", icfg, icfg)
+		return false
+	}
+	if err := cfg.validate(d.R, d.Scheme, time.Now()); err != nil {
+		log.Debugf("url_signing rejecting %v%v: %v (reqid %v)\n", d.R.Host, d.R.RequestURI, err, d.RequestID)
+		*d.Code = http.StatusForbidden
+		return true
+	}
+	return false
+}
+
+// validate returns nil if the request has a valid url_sig signature or URI Signing token, and removes the signature from the request. Otherwise, returns the reason the request is invalid.
+func (cfg *urlSigningConfig) validate(r *http.Request, scheme string, now time.Time) error {
+	qry := r.URL.Query()
+	switch {
+	case qry.Get(URISigningParam) != "" && len(cfg.URISigningKeys) > 0:
+		stripQueryParams(r, []string{URISigningParam})
+		return cfg.validateURISigning(qry.Get(URISigningParam), r, scheme, now)
+	case qry.Get("S") != "" && len(cfg.URLSigKeys) > 0:
+		err := cfg.validateURLSig(r, now)
+		stripQueryParams(r, URLSigParams)
+		return err
+	}
+	return errors.New("no signature")
+}
+
+// validateURLSig validates an ATS url_sig signature. The signed string is the parts of the host and path selected by the P parameter, joined by `/` and followed by `?`, then the query up to and including `S=`.
+func (cfg *urlSigningConfig) validateURLSig(r *http.Request, now time.Time) error {
+	rawQuery := r.URL.RawQuery
+	sigPos := strings.Index("&"+rawQuery, "&S=")
+	if sigPos == -1 {
+		return errors.New("url_sig missing signature")
+	}
+	signedQuery := rawQuery[:sigPos+len("S=")]
+	qry, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return errors.New("url_sig malformed query: " + err.Error())
+	}
+
+	expiration, err := strconv.ParseInt(qry.Get("E"), 10, 64)
+	if err != nil {
+		return errors.New("url_sig malformed expiration '" + qry.Get("E") + "'")
+	}
+	if now.Unix() > expiration {
+		return errors.New("url_sig expired at " + time.Unix(expiration, 0).Format(time.RFC3339))
+	}
+	if clientIP := qry.Get("C"); clientIP != "" {
+		ip, err := web.GetIP(r)
+		if err != nil || ip.String() != clientIP {
+			return errors.New("url_sig client IP '" + clientIP + "' doesn't match request")
+		}
+	}
+
+	newHash := func() hash.Hash { return nil }
+	switch qry.Get("A") {
+	case "1":
+		newHash = sha1.New
+	case "2":
+		newHash = md5.New
+	default:
+		return errors.New("url_sig unsupported algorithm '" + qry.Get("A") + "'")
+	}
+	key, ok := cfg.URLSigKeys["key"+qry.Get("K")]
+	if !ok {
+		return errors.New("url_sig unknown key '" + qry.Get("K") + "'")
+	}
+
+	parts := qry.Get("P")
+	if parts == "" {
+		return errors.New("url_sig missing parts")
+	}
+	signed := ""
+	i := 0
+	for _, part := range strings.Split(r.Host+r.URL.EscapedPath(), "/") {
+		if part == "" {
+			continue
+		}
+		// the last char of P applies to all remaining parts
+		if use := parts[minInt(i, len(parts)-1)]; use == '1' {
+			signed += part + "/"
+		}
+		i++
+	}
+	signed = strings.TrimSuffix(signed, "/") + "?" + signedQuery
+
+	mac := hmac.New(newHash, []byte(key))
+	mac.Write([]byte(signed))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(qry.Get("S")))) != 1 {
+		return errors.New("url_sig signature mismatch")
+	}
+	return nil
+}
+
+type uriSigningHeader struct {
+	Alg string `json:"alg"`
+	KID string `json:"kid"`
+}
+
+type uriSigningClaims struct {
+	Iss    string   `json:"iss"`
+	Exp    *float64 `json:"exp"`
+	Nbf    *float64 `json:"nbf"`
+	CDNIUC string   `json:"cdniuc"`
+	CDNIV  *int     `json:"cdniv"`
+}
+
+// validateURISigning validates a URI Signing JWT. The token's issuer selects the keyset, and its key ID selects the key, so keys can be rotated by adding a key with a new ID before signing with it. Tokens without a key ID are tried with every key of the issuer.
+// The request must have already had the token removed, because the URI container claim is matched against the URI without it.
+func (cfg *urlSigningConfig) validateURISigning(token string, r *http.Request, scheme string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("uri_signing malformed token")
+	}
+	header := uriSigningHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return errors.New("uri_signing malformed header: " + err.Error())
+	}
+	claims := uriSigningClaims{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return errors.New("uri_signing malformed claims: " + err.Error())
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("uri_signing malformed signature: " + err.Error())
+	}
+
+	keyset, ok := cfg.URISigningKeys[claims.Iss]
+	if !ok {
+		return errors.New("uri_signing unknown issuer '" + claims.Iss + "'")
+	}
+	verified := false
+	for _, key := range keyset.Keys {
+		if header.KID != "" && key.KID != header.KID {
+			continue
+		}
+		if key.Alg != "" && key.Alg != header.Alg {
+			continue
+		}
+		if err := verifyJWS(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+			log.Debugln("uri_signing key '" + key.KID + "' failed: " + err.Error())
+			continue
+		}
+		verified = true
+		break
+	}
+	if !verified {
+		return errors.New("uri_signing no key of issuer '" + claims.Iss + "' id '" + header.KID + "' verified the signature")
+	}
+
+	if claims.CDNIV != nil && *claims.CDNIV != 1 {
+		return errors.New("uri_signing unsupported version " + strconv.Itoa(*claims.CDNIV))
+	}
+	if claims.Exp != nil && float64(now.Unix()) >= *claims.Exp {
+		return errors.New("uri_signing expired")
+	}
+	if claims.Nbf != nil && float64(now.Unix()) < *claims.Nbf {
+		return errors.New("uri_signing not yet valid")
+	}
+	if claims.CDNIUC != "" {
+		if err := matchURIContainer(claims.CDNIUC, scheme+"://"+r.Host+r.RequestURI); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchURIContainer returns nil if the URI matches the given cdniuc claim. Only `regex:` containers are supported.
+func matchURIContainer(container string, uri string) error {
+	if !strings.HasPrefix(container, "regex:") {
+		return errors.New("uri_signing unsupported URI container '" + container + "'")
+	}
+	re, err := regexp.Compile(strings.TrimPrefix(container, "regex:"))
+	if err != nil {
+		return errors.New("uri_signing malformed URI container regex: " + err.Error())
+	}
+	if !re.MatchString(uri) {
+		return errors.New("uri_signing URI '" + uri + "' doesn't match container '" + container + "'")
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifyJWS returns nil if sig is the signature of signed with the given key and JWS algorithm, per RFC 7518.
+func verifyJWS(alg string, key uriSigJWK, signed string, sig []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	if len(alg) != len("HS256") {
+		return errors.New("unsupported algorithm '" + alg + "'")
+	}
+	h, ok := hashes[alg[2:]]
+	if !ok {
+		return errors.New("unsupported algorithm '" + alg + "'")
+	}
+	switch alg[:2] {
+	case "HS":
+		if key.Kty != "oct" {
+			return errors.New("algorithm " + alg + " requires an oct key, not '" + key.Kty + "'")
+		}
+		secret, err := base64.RawURLEncoding.DecodeString(key.K)
+		if err != nil {
+			return errors.New("malformed key: " + err.Error())
+		}
+		newHash := map[crypto.Hash]func() hash.Hash{crypto.SHA256: sha256.New, crypto.SHA384: sha512.New384, crypto.SHA512: sha512.New}[h]
+		mac := hmac.New(newHash, secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case "RS":
+		if key.Kty != "RSA" {
+			return errors.New("algorithm " + alg + " requires an RSA key, not '" + key.Kty + "'")
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return errors.New("malformed key modulus: " + err.Error())
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return errors.New("malformed key exponent: " + err.Error())
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		hasher := h.New()
+		hasher.Write([]byte(signed))
+		return rsa.VerifyPKCS1v15(pub, h, hasher.Sum(nil), sig)
+	}
+	return errors.New("unsupported algorithm '" + alg + "'")
+}
+
+// stripQueryParams removes the given query parameters from the request, preserving the order and encoding of the others.
+func stripQueryParams(r *http.Request, names []string) {
+	strip := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		strip[name] = struct{}{}
+	}
+	params := strings.Split(r.URL.RawQuery, "&")
+	kept := make([]string, 0, len(params))
+	for _, param := range params {
+		name := param
+		if eqPos := strings.Index(param, "="); eqPos != -1 {
+			name = param[:eqPos]
+		}
+		if _, ok := strip[name]; ok || param == "" {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	r.URL.RawQuery = strings.Join(kept, "&")
+	if qPos := strings.Index(r.RequestURI, "?"); qPos != -1 {
+		r.RequestURI = r.RequestURI[:qPos]
+	}
+	if r.URL.RawQuery != "" {
+		r.RequestURI += "?" + r.URL.RawQuery
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
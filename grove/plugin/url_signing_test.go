@@ -0,0 +1,129 @@
+package plugin
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSig(t *testing.T) {
+	cfg := &urlSigningConfig{URLSigKeys: map[string]string{"key3": "secret"}}
+	now := time.Unix(1500000000, 0)
+
+	sign := func(signed string) string {
+		mac := hmac.New(md5.New, []byte("secret"))
+		mac.Write([]byte(signed))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	// P=101 signs the host and the last 2 path parts, but not the first
+	query := "C=192.0.2.1&E=" + strconv.FormatInt(now.Unix()+60, 10) + "&A=2&K=3&P=101&S="
+	sig := sign("origin.example.net/b/c.ts?" + query)
+
+	tests := map[string]bool{
+		"/a/b/c.ts?" + query + sig:                   true,
+		"/x/b/c.ts?" + query + sig:                   true, // unsigned part
+		"/a/b/d.ts?" + query + sig:                   false,
+		"/a/b/c.ts?" + query + "00" + sig[2:]:        false,
+		"/a/b/c.ts":                                  false,
+		"/a/b/c.ts?C=192.0.2.2" + query[11:] + sig:   false, // signature valid for another client
+		"/a/b/c.ts?E=1&A=2&K=3&P=101&S=" + sign("x"): false,
+	}
+	for uri, expected := range tests {
+		r := httptest.NewRequest("GET", uri, nil)
+		r.Host = "origin.example.net"
+		r.RemoteAddr = "192.0.2.1:12345"
+		err := cfg.validate(r, "http", now)
+		if (err == nil) != expected {
+			t.Errorf("url_sig validate '%v' expected valid %v, actual error %v", uri, expected, err)
+		}
+		if expected && r.RequestURI != "/a/b/c.ts" && r.RequestURI != "/x/b/c.ts" {
+			t.Errorf("url_sig validate '%v' expected signature removed, actual '%v'", uri, r.RequestURI)
+		}
+	}
+}
+
+func TestURISigning(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	cfg := &urlSigningConfig{URISigningKeys: map[string]uriSigningKeyset{
+		"issuer": {Keys: []uriSigJWK{
+			{Alg: "HS256", KID: "old", Kty: "oct", K: enc([]byte("old secret"))},
+			{Alg: "HS256", KID: "new", Kty: "oct", K: enc([]byte("new secret"))},
+		}},
+	}}
+	now := time.Unix(1500000000, 0)
+
+	token := func(kid string, secret string, claims string) string {
+		signed := enc([]byte(`{"alg":"HS256","kid":"`+kid+`"}`)) + "." + enc([]byte(claims))
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		return signed + "." + enc(mac.Sum(nil))
+	}
+	exp := strconv.FormatInt(now.Unix()+60, 10)
+	valid := `{"iss":"issuer","exp":` + exp + `,"cdniuc":"regex:^http://origin\\.example\\.net/a/.*"}`
+
+	tests := map[string]bool{
+		token("new", "new secret", valid):                                                                 true,
+		token("old", "old secret", valid):                                                                 true,
+		token("", "old secret", valid):                                                                    true, // no key ID tries all keys
+		token("new", "old secret", valid):                                                                 false,
+		token("gone", "new secret", valid):                                                                false,
+		token("new", "new secret", `{"iss":"other"}`):                                                     false,
+		token("new", "new secret", `{"iss":"issuer","exp":1}`):                                            false,
+		token("new", "new secret", `{"iss":"issuer","nbf":2e9}`):                                          false,
+		token("new", "new secret", `{"iss":"issuer","cdniuc":"regex:^http://origin\\.example\\.net/b/"}`): false,
+		"not.a.token": false,
+	}
+	for tok, expected := range tests {
+		r := httptest.NewRequest("GET", "/a/b.ts?x=1&"+URISigningParam+"="+tok+"&y=2", nil)
+		r.Host = "origin.example.net"
+		err := cfg.validate(r, "http", now)
+		if (err == nil) != expected {
+			t.Errorf("uri_signing validate '%v' expected valid %v, actual error %v", tok, expected, err)
+		}
+		if expected := "/a/b.ts?x=1&y=2"; r.RequestURI != expected || r.URL.RawQuery != "x=1&y=2" {
+			t.Errorf("uri_signing validate expected token removed '%v', actual '%v' query '%v'", expected, r.RequestURI, r.URL.RawQuery)
+		}
+	}
+}
+
+func TestURLSigningOnRemap(t *testing.T) {
+	cfg := urlSigningLoad([]byte(`{"url_sig_keys": {"key0": "secret"}}`))
+	if cfg == nil {
+		t.Fatalf("url_signing load expected config, actual nil")
+	}
+
+	code := http.StatusBadRequest
+	r := httptest.NewRequest("GET", "/a/b.ts", nil)
+	if stop := urlSigningOnRemap(cfg, OnRemapData{R: r, Code: &code}); !stop {
+		t.Errorf("url_signing unsigned request expected stop, actual continue")
+	}
+	if code != http.StatusForbidden {
+		t.Errorf("url_signing unsigned request expected code %v, actual %v", http.StatusForbidden, code)
+	}
+
+	code = http.StatusBadRequest
+	if stop := urlSigningOnRemap(nil, OnRemapData{R: r, Code: &code}); stop || code != http.StatusBadRequest {
+		t.Errorf("url_signing without config expected continue with code unchanged, actual stop %v code %v", stop, code)
+	}
+}
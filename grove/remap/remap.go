@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -29,6 +30,7 @@ import (
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/plugin"
 	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/thread"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
@@ -39,10 +41,15 @@ type HTTPRequestRemapper interface {
 	// Remap(r *http.Request, scheme string, failures int) Remapping
 	Rules() []remapdata.RemapRule
 	RemappingProducer(r *http.Request, scheme string) (*RemappingProducer, error)
+	// UncheckedRemappingProducer returns the RemappingProducer of the request without checking the client's IP against the rule's ACL or rate limits, for requests made by Grove itself, such as cache warming.
+	UncheckedRemappingProducer(r *http.Request, scheme string) (*RemappingProducer, error)
 	StatRules() remapdata.RemapRulesStats
 	PluginCfg() map[string]interface{} // global plugins, outside the individual remap rules
 	// PluginSharedCfg returns the plugins_shared, for every remap rule. This gives plugins a chance on startup to precompute data for each remap rule, store it in the Context, and save computation during requests.
 	PluginSharedCfg() map[string]map[string]json.RawMessage
+	// StartHealthChecks starts the background health checks of every rule with a health check. StopHealthChecks stops them, and must be called before the remapper is replaced, e.g. on config reload.
+	StartHealthChecks()
+	StopHealthChecks()
 }
 
 type simpleHTTPRequestRemapper struct {
@@ -57,6 +64,18 @@ func (hr simpleHTTPRequestRemapper) PluginSharedCfg() map[string]map[string]json
 	return hr.remapper.PluginSharedCfg()
 }
 
+func (hr simpleHTTPRequestRemapper) StartHealthChecks() {
+	for _, rule := range hr.remapper.Rules() {
+		rule.StartHealthCheck()
+	}
+}
+
+func (hr simpleHTTPRequestRemapper) StopHealthChecks() {
+	for _, rule := range hr.remapper.Rules() {
+		rule.StopHealthCheck()
+	}
+}
+
 // getFQDN returns the FQDN. It tries to get the FQDN from a Remap Rule. Remap Rules should always begin with the scheme, e.g. `http://`. If the given rule does not begin with a valid scheme, behavior is undefined.
 // TODO test
 func getFQDN(rule string) string {
@@ -96,6 +115,7 @@ func NewRemappingTransport(reqTimeout time.Duration, reqKeepAlive time.Duration,
 
 type Remapping struct {
 	Request         *http.Request
+	Parent          string // the URL of the rule's To parent the Request is made to
 	ProxyURL        *url.URL
 	Name            string
 	CacheKey        string
@@ -105,6 +125,7 @@ type Remapping struct {
 	RetryCodes      map[int]struct{}
 	Cache           icache.Cache
 	Transport       *http.Transport
+	CacheOverrides  *remapdata.CacheOverrides
 }
 
 // RemappingProducer takes an HTTP Request and returns a Remapping to be used for that request.
@@ -113,19 +134,62 @@ type RemappingProducer struct {
 	oldURI   string
 	rule     remapdata.RemapRule
 	cacheKey string
+	// reqHdr is the client request header, for the cache keys of other methods.
+	reqHdr   http.Header
 	failures int
+	// parents is the order of the rule's parents for this request, created on the first GetNext. It's nil for consistent hash rules.
+	parents []int
+	// siblingTried is whether the request has been remapped to a sibling. Each request tries its sibling at most once, before its parents.
+	siblingTried bool
+}
+
+func (p *RemappingProducer) CacheKey() string                    { return p.cacheKey }
+func (p *RemappingProducer) ConnectionClose() bool               { return p.rule.ConnectionClose }
+func (p *RemappingProducer) Name() string                        { return p.rule.Name }
+func (p *RemappingProducer) DSCP() int                           { return p.rule.DSCP }
+func (p *RemappingProducer) PluginCfg() map[string]interface{}   { return p.rule.Plugins }
+func (p *RemappingProducer) Cache() icache.Cache                 { return p.rule.Cache }
+func (p *RemappingProducer) StaleWhileRevalidate() time.Duration { return p.rule.StaleWhileRevalidate }
+func (p *RemappingProducer) StaleIfError() time.Duration         { return p.rule.StaleIfError }
+func (p *RemappingProducer) PrefetchSegments() int               { return p.rule.PrefetchSegments }
+func (p *RemappingProducer) CacheOverrides() *remapdata.CacheOverrides {
+	return p.rule.CacheOverrides
+}
+func (p *RemappingProducer) Getter() thread.Getter { return p.rule.Getter }
+
+// Invalidated returns whether a revalidate rule makes the cached object for the request, fetched from the parent at the given time, stale.
+func (p *RemappingProducer) Invalidated(r *http.Request, fetched time.Time) bool {
+	return p.rule.Invalidated(r.RequestURI, fetched, time.Now())
 }
-
-func (p *RemappingProducer) CacheKey() string                  { return p.cacheKey }
-func (p *RemappingProducer) ConnectionClose() bool             { return p.rule.ConnectionClose }
-func (p *RemappingProducer) Name() string                      { return p.rule.Name }
-func (p *RemappingProducer) DSCP() int                         { return p.rule.DSCP }
-func (p *RemappingProducer) PluginCfg() map[string]interface{} { return p.rule.Plugins }
-func (p *RemappingProducer) Cache() icache.Cache               { return p.rule.Cache }
 func (p *RemappingProducer) FirstFQDN() string {
 	// TODO verify To is not allowed to be constructed with < 1 element
-	return strings.TrimPrefix(strings.TrimPrefix(p.rule.To[0].URL, "http://"), "https://")
+	return strings.TrimPrefix(strings.TrimPrefix(p.rule.Expand(p.rule.To[0].URL), "http://"), "https://")
+}
+
+// Rewritten updates the request URI and cache key, if the request was modified after it was remapped, such as by a plugin removing a signature from the query. The modified request must still match the rule.
+func (p *RemappingProducer) Rewritten(r *http.Request, scheme string) {
+	uri := RequestURI(r, scheme)
+	if uri == p.oldURI {
+		return
+	}
+	p.oldURI = uri
+	p.cacheKey = p.rule.CacheKey(r.Method, uri, r.Header)
+}
+
+// MethodCacheKey returns the cache key of the request URI, for the given method rather than the request's method.
+func (p *RemappingProducer) MethodCacheKey(method string) string {
+	return p.rule.CacheKey(method, p.oldURI, p.reqHdr)
+}
+
+// ParentResult records whether the request to the given remapping's parent failed, so parents which fail repeatedly are marked down.
+func (p *RemappingProducer) ParentResult(remapping Remapping, failed bool) {
+	if failed {
+		p.rule.ParentStatus.Failed(remapping.Parent)
+	} else {
+		p.rule.ParentStatus.Succeeded(remapping.Parent)
+	}
 }
+
 func (p *RemappingProducer) ProxyStr() string {
 	if p.rule.To[0].ProxyURL != nil && p.rule.To[0].ProxyURL.Host != "" {
 		return p.rule.To[0].ProxyURL.Host
@@ -137,47 +201,98 @@ var ErrRuleNotFound = errors.New("remap rule not found")
 var ErrIPNotAllowed = errors.New("IP not allowed")
 var ErrNoMoreRetries = errors.New("retry num exceeded")
 
+// RateLimitedError is returned when a request exceeds a rate limit. RetryAfter is the time until the client's next request is allowed.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string { return "rate limit exceeded" }
+
+// RetryAfterSeconds returns the RetryAfter in whole seconds, rounded up, for a Retry-After header.
+func (e *RateLimitedError) RetryAfterSeconds() int {
+	secs := int(e.RetryAfter / time.Second)
+	if e.RetryAfter%time.Second != 0 || secs == 0 {
+		secs++
+	}
+	return secs
+}
+
 // RequestURI returns the URI of the given request. This must be used, because Go does not populate the scheme of requests that come in from clients.
 func RequestURI(r *http.Request, scheme string) string {
 	return scheme + "://" + r.Host + r.RequestURI
 }
 func (hr simpleHTTPRequestRemapper) RemappingProducer(r *http.Request, scheme string) (*RemappingProducer, error) {
+	return hr.remappingProducer(r, scheme, true)
+}
+
+func (hr simpleHTTPRequestRemapper) UncheckedRemappingProducer(r *http.Request, scheme string) (*RemappingProducer, error) {
+	return hr.remappingProducer(r, scheme, false)
+}
+
+// remappingProducer returns the RemappingProducer of the request, checking the client's IP against the rule's ACL and rate limits if checkClient.
+func (hr simpleHTTPRequestRemapper) remappingProducer(r *http.Request, scheme string, checkClient bool) (*RemappingProducer, error) {
 	uri := RequestURI(r, scheme)
-	rule, ok := hr.remapper.Remap(uri)
+	rule, ok := hr.remapper.Remap(r, uri)
 	if !ok {
 		return nil, ErrRuleNotFound
 	}
 
-	if ip, err := web.GetIP(r); err != nil {
-		return nil, fmt.Errorf("parsing client IP: %v", err)
-	} else if !rule.Allowed(ip) {
-		return nil, ErrIPNotAllowed
-	} else {
-		log.Debugf("Allowed %v\n", ip)
+	if checkClient {
+		if err := clientAllowed(rule, r); err != nil {
+			return nil, err
+		}
 	}
 
-	cacheKey := rule.CacheKey(r.Method, uri)
+	cacheKey := rule.CacheKey(r.Method, uri, r.Header)
 
 	return &RemappingProducer{
 		rule:     rule,
 		oldURI:   uri,
 		cacheKey: cacheKey,
+		reqHdr:   r.Header,
 	}, nil
 }
 
+// clientAllowed returns nil if the rule allows the request's client, or ErrIPNotAllowed or a RateLimitedError if it doesn't.
+func clientAllowed(rule remapdata.RemapRule, r *http.Request) error {
+	ip, err := web.GetIP(r)
+	if err != nil {
+		return fmt.Errorf("parsing client IP: %v", err)
+	}
+	if !rule.Allowed(ip) {
+		return ErrIPNotAllowed
+	}
+	log.Debugf("Allowed %v\n", ip)
+	if retryAfter, limited := rule.RateLimited(r, time.Now()); limited {
+		return &RateLimitedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
 // GetNext returns the remapping to use to request, whether retries are allowed (i.e. if this is the last retry), or any error
 func (p *RemappingProducer) GetNext(r *http.Request) (Remapping, bool, error) {
 	if *p.rule.RetryNum < p.failures {
 		return Remapping{}, false, ErrNoMoreRetries
 	}
 
-	newURI, proxyURL, transport := p.rule.URI(p.oldURI, r.URL.Path, r.URL.RawQuery, p.failures)
+	if p.failures == 0 && !p.siblingTried {
+		p.siblingTried = true
+		if sibling, ok := p.rule.Sibling(p.cacheKey, r.Header); ok {
+			return p.siblingRemapping(r, sibling)
+		}
+	}
+
+	if p.failures == 0 {
+		p.parents = p.rule.ParentOrder()
+	}
+	newURI, parent, proxyURL, transport := p.rule.URI(p.oldURI, p.rule.ConsistentHashKey(r.URL.Path, r.URL.RawQuery, r.Header), p.failures, p.parents)
 	p.failures++
 	newReq, err := http.NewRequest(r.Method, newURI, nil)
 	if err != nil {
 		return Remapping{}, false, fmt.Errorf("creating new request: %v\n", err)
 	}
 	web.CopyHeaderTo(r.Header, &newReq.Header)
+	newReq.Header.Del(remapdata.SiblingHeader)
 
 	log.Debugf("GetNext oldUri: %v, Host: %v\n", p.oldURI, newReq.Header.Get("Host"))
 	log.Debugf("GetNext newURI: %v, fqdn: %v\n", newURI, getFQDN(newURI))
@@ -188,6 +303,7 @@ func (p *RemappingProducer) GetNext(r *http.Request) (Remapping, bool, error) {
 	retryAllowed := *p.rule.RetryNum < p.failures
 	return Remapping{
 		Request:         newReq,
+		Parent:          parent,
 		ProxyURL:        proxyURL,
 		Name:            p.rule.Name,
 		CacheKey:        p.cacheKey,
@@ -197,21 +313,53 @@ func (p *RemappingProducer) GetNext(r *http.Request) (Remapping, bool, error) {
 		RetryCodes:      p.rule.RetryCodes,
 		Cache:           p.rule.Cache,
 		Transport:       transport,
+		CacheOverrides:  p.rule.CacheOverrides,
 	}, retryAllowed, nil
 }
 
+// siblingRemapping returns the remapping of the request to the given sibling. The sibling is requested with the client's Host, so it remaps the request with its own rule, and with the SiblingHeader, so it requests it from its parent rather than another sibling. A sibling request is never the last try, because if it fails, the parents are tried.
+func (p *RemappingProducer) siblingRemapping(r *http.Request, sibling string) (Remapping, bool, error) {
+	newReq, err := http.NewRequest(r.Method, sibling+r.RequestURI, nil)
+	if err != nil {
+		return Remapping{}, false, fmt.Errorf("creating new sibling request: %v\n", err)
+	}
+	web.CopyHeaderTo(r.Header, &newReq.Header)
+	newReq.Host = r.Host
+	newReq.Header.Set(remapdata.SiblingHeader, p.rule.Siblings.Self)
+	log.Debugf("GetNext oldUri: %v, sibling: %v, rule name: %v\n", p.oldURI, sibling, p.rule.Name)
+	return Remapping{
+		Request:         newReq,
+		Parent:          sibling,
+		Name:            p.rule.Name,
+		CacheKey:        p.cacheKey,
+		ConnectionClose: p.rule.ConnectionClose,
+		Timeout:         p.rule.Siblings.Timeout(),
+		RetryNum:        *p.rule.RetryNum,
+		RetryCodes:      p.rule.RetryCodes,
+		Cache:           p.rule.Cache,
+		Transport:       p.rule.Siblings.Transport(),
+		CacheOverrides:  p.rule.CacheOverrides,
+	}, false, nil
+}
+
 func RemapperToHTTP(r Remapper, statRules *remapdata.RemapRulesStats) HTTPRequestRemapper {
 	return simpleHTTPRequestRemapper{remapper: r, stats: statRules}
 }
 
+// NewHTTPRequestRemapper returns a remapper of the given rules. If any rule has regexes, the rules are matched with a regex remapper, otherwise by their literal prefixes.
 func NewHTTPRequestRemapper(remap []remapdata.RemapRule, plugins map[string]interface{}, statRules *remapdata.RemapRulesStats) HTTPRequestRemapper {
+	for _, rule := range remap {
+		if rule.HasRegexes() {
+			return RemapperToHTTP(NewRegexRemapper(remap, plugins), statRules)
+		}
+	}
 	return RemapperToHTTP(NewLiteralPrefixRemapper(remap, plugins), statRules)
 }
 
 // Remapper provides a function which takes strings and maps them to other strings. This is designed for URL prefix remapping, for a reverse proxy.
 type Remapper interface {
-	// Remap returns the given string remapped, the unique name of the rule found, and whether a remap rule was found
-	Remap(uri string) (remapdata.RemapRule, bool)
+	// Remap returns the rule matching the given request, whose full URI including the scheme is uri, and whether a remap rule was found
+	Remap(r *http.Request, uri string) (remapdata.RemapRule, bool)
 	// Rules returns the unique names of every remap rule.
 	Rules() []remapdata.RemapRule
 	// PluginCfg returns the global plugins, outside the individual remap rules
@@ -238,8 +386,8 @@ func (r literalPrefixRemapper) PluginSharedCfg() map[string]map[string]json.RawM
 	return cfg
 }
 
-// Remap returns the remapped string, the remap rule name, the remap rule's options, and whether a remap was found
-func (r literalPrefixRemapper) Remap(s string) (remapdata.RemapRule, bool) {
+// Remap returns the first rule whose From is a prefix of the uri, and whether a remap was found
+func (r literalPrefixRemapper) Remap(req *http.Request, s string) (remapdata.RemapRule, bool) {
 	for _, rule := range r.remap {
 		if strings.HasPrefix(s, rule.From) {
 			return rule, true
@@ -260,14 +408,42 @@ func NewLiteralPrefixRemapper(remap []remapdata.RemapRule, plugins map[string]in
 	return literalPrefixRemapper{remap: remap, plugins: plugins}
 }
 
+// regexRemapper matches rules with regexes, as well as literal prefix rules. Rules are matched in order, so regex and literal rules may be interleaved.
+type regexRemapper struct {
+	literalPrefixRemapper
+}
+
+// Remap returns the first rule matching the request, and whether a remap was found. Rules with regexes are returned as the copy for the request, with their capture groups. See RemapRule.Match.
+func (r regexRemapper) Remap(req *http.Request, s string) (remapdata.RemapRule, bool) {
+	for _, rule := range r.remap {
+		if matched, ok := rule.Match(req, s); ok {
+			return matched, true
+		}
+	}
+	return remapdata.RemapRule{}, false
+}
+
+func NewRegexRemapper(remap []remapdata.RemapRule, plugins map[string]interface{}) Remapper {
+	return regexRemapper{literalPrefixRemapper{remap: remap, plugins: plugins}}
+}
+
 type RemapRulesStatsJSON struct {
 	Allow []string `json:"allow"`
 	Deny  []string `json:"deny"`
 }
 
 type RemapRulesBase struct {
-	RetryNum      *int                       `json:"retry_num"`
-	PluginsShared map[string]json.RawMessage `json:"plugins_shared"`
+	RetryNum               *int                           `json:"retry_num"`
+	PluginsShared          map[string]json.RawMessage     `json:"plugins_shared"`
+	ParentMarkdownFailures *int                           `json:"parent_markdown_failures"`
+	ParentMarkdownMS       *int                           `json:"parent_markdown_ms"`
+	HealthCheck            *remapdata.HealthCheck         `json:"health_check"`
+	StaleWhileRevalidateMS *int                           `json:"stale_while_revalidate_ms"`
+	StaleIfErrorMS         *int                           `json:"stale_if_error_ms"`
+	Siblings               *remapdata.Siblings            `json:"siblings"`
+	RateLimit              *remapdata.RateLimit           `json:"rate_limit"`
+	CacheOverrides         *remapdata.CacheOverrides      `json:"cache_overrides"`
+	CollapsedForwarding    *remapdata.CollapsedForwarding `json:"collapsed_forwarding"`
 }
 
 type RemapRulesJSON struct {
@@ -365,6 +541,19 @@ func LoadRemapRules(path string, pluginConfigLoaders map[string]plugin.LoadFunc,
 		}
 	}
 
+	if remapRules.Siblings != nil {
+		if remapRules.Siblings, err = makeSiblings(*remapRules.Siblings, baseTransport); err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing rules siblings: %v", err)
+		}
+	}
+
+	globalRateLimiter := (*remapdata.RateLimiter)(nil)
+	if remapRules.RateLimit != nil {
+		if globalRateLimiter, err = remapdata.NewRateLimiter(*remapRules.RateLimit); err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing rules rate limit: %v", err)
+		}
+	}
+
 	remapRules.Plugins = make(map[string]interface{}, len(remapRulesJSON.Plugins))
 	for name, b := range remapRulesJSON.Plugins {
 		if loadF := pluginConfigLoaders[name]; loadF != nil {
@@ -418,6 +607,94 @@ func LoadRemapRules(path string, pluginConfigLoaders map[string]plugin.LoadFunc,
 			rule.PluginsShared = remapRules.PluginsShared
 		}
 
+		if rule.ParentMarkdownFailures == nil {
+			rule.ParentMarkdownFailures = remapRules.ParentMarkdownFailures
+		}
+		if rule.ParentMarkdownMS == nil {
+			rule.ParentMarkdownMS = remapRules.ParentMarkdownMS
+		}
+		markdownFailures, markdownMS := 0, remapdata.DefaultParentMarkdownMS
+		if rule.ParentMarkdownFailures != nil {
+			markdownFailures = *rule.ParentMarkdownFailures
+		}
+		if rule.ParentMarkdownMS != nil {
+			markdownMS = *rule.ParentMarkdownMS
+		}
+		if markdownFailures < 0 || markdownMS < 0 {
+			return nil, nil, nil, fmt.Errorf("error parsing rule %v parent markdown failures and ms must be positive: %v %v", rule.Name, markdownFailures, markdownMS)
+		}
+		rule.ParentStatus = remapdata.NewParentStatus(markdownFailures, time.Duration(markdownMS)*time.Millisecond)
+
+		if rule.HealthCheck == nil {
+			rule.HealthCheck = remapRules.HealthCheck
+		}
+		if rule.HealthCheck != nil {
+			if rule.HealthCheck, err = makeHealthCheck(*rule.HealthCheck); err != nil {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v health check: %v", rule.Name, err)
+			}
+		}
+
+		if rule.StaleWhileRevalidateMS == nil {
+			rule.StaleWhileRevalidateMS = remapRules.StaleWhileRevalidateMS
+		}
+		if rule.StaleIfErrorMS == nil {
+			rule.StaleIfErrorMS = remapRules.StaleIfErrorMS
+		}
+		if rule.StaleWhileRevalidateMS != nil {
+			if rule.StaleWhileRevalidate = time.Duration(*rule.StaleWhileRevalidateMS) * time.Millisecond; rule.StaleWhileRevalidate < 0 {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v stale while revalidate must be positive: %v", rule.Name, rule.StaleWhileRevalidate)
+			}
+		}
+		if rule.StaleIfErrorMS != nil {
+			if rule.StaleIfError = time.Duration(*rule.StaleIfErrorMS) * time.Millisecond; rule.StaleIfError < 0 {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v stale if error must be positive: %v", rule.Name, rule.StaleIfError)
+			}
+		}
+
+		if rule.CacheOverrides == nil {
+			rule.CacheOverrides = remapRules.CacheOverrides
+		}
+		if rule.CacheOverrides != nil {
+			if err := validateCacheOverrides(*rule.CacheOverrides); err != nil {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v cache overrides: %v", rule.Name, err)
+			}
+		}
+
+		if rule.CollapsedForwarding == nil {
+			rule.CollapsedForwarding = remapRules.CollapsedForwarding
+		}
+		if rule.Getter, err = makeGetter(rule.CollapsedForwarding); err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing rule %v collapsed forwarding: %v", rule.Name, err)
+		}
+
+		rule.Revalidate = append([]remapdata.RevalidateRule(nil), rule.Revalidate...) // copy, so compiling doesn't modify the JSON rule
+		for i := range rule.Revalidate {
+			if err := rule.Revalidate[i].Compile(time.Now()); err != nil {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v revalidate '%v': %v", rule.Name, rule.Revalidate[i].Regex, err)
+			}
+		}
+
+		if rule.Siblings == nil {
+			rule.Siblings = remapRules.Siblings
+		} else if rule.Siblings, err = makeSiblings(*rule.Siblings, baseTransport); err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing rule %v siblings: %v", rule.Name, err)
+		}
+
+		if err := rule.CompileRegexes(); err != nil {
+			return nil, nil, nil, fmt.Errorf("error parsing rule %v regexes: %v", rule.Name, err)
+		}
+
+		if globalRateLimiter != nil {
+			rule.RateLimiters = append(rule.RateLimiters, globalRateLimiter)
+		}
+		if rule.RateLimit != nil {
+			limiter, err := remapdata.NewRateLimiter(*rule.RateLimit)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("error parsing rule %v rate limit: %v", rule.Name, err)
+			}
+			rule.RateLimiters = append(rule.RateLimiters, limiter)
+		}
+
 		cacheName := "" // default string is the default cache
 		if jsonRule.CacheName != nil {
 			cacheName = *jsonRule.CacheName
@@ -534,6 +811,81 @@ func makeTo(tosJSON []RemapRuleToJSON, rule remapdata.RemapRule, baseTransport *
 	return tos, nil
 }
 
+// makeSiblings returns a compiled copy of the given siblings, or an error if they're invalid.
+func makeSiblings(siblings remapdata.Siblings, baseTransport *http.Transport) (*remapdata.Siblings, error) {
+	siblings.Peers = append([]string(nil), siblings.Peers...) // copy, so compiling doesn't modify the JSON rule
+	if err := siblings.Compile(baseTransport); err != nil {
+		return nil, err
+	}
+	return &siblings, nil
+}
+
+// makeHealthCheck returns a copy of the given health check, with defaults for unset fields, or an error if it's invalid.
+func makeHealthCheck(hc remapdata.HealthCheck) (*remapdata.HealthCheck, error) {
+	if hc.Path == "" {
+		hc.Path = remapdata.DefaultHealthCheckPath
+	}
+	if hc.IntervalMS == 0 {
+		hc.IntervalMS = remapdata.DefaultHealthCheckIntervalMS
+	}
+	if hc.TimeoutMS == 0 {
+		hc.TimeoutMS = remapdata.DefaultHealthCheckTimeoutMS
+	}
+	if len(hc.Codes) == 0 {
+		hc.Codes = remapdata.DefaultHealthCheckCodes
+	}
+	if !strings.HasPrefix(hc.Path, "/") {
+		return nil, fmt.Errorf("path must begin with a slash: '%v'", hc.Path)
+	}
+	if hc.IntervalMS < 0 || hc.TimeoutMS < 0 {
+		return nil, fmt.Errorf("interval and timeout must be positive: %v %v", hc.IntervalMS, hc.TimeoutMS)
+	}
+	for _, code := range hc.Codes {
+		if _, ok := ValidHTTPCodes[code]; !ok {
+			return nil, fmt.Errorf("code invalid: %v", code)
+		}
+	}
+	return &hc, nil
+}
+
+// makeGetter returns the Getter of a rule with the given collapsed forwarding config, which may be nil for the default "cacheable" mode.
+func makeGetter(cf *remapdata.CollapsedForwarding) (thread.Getter, error) {
+	if cf == nil {
+		return thread.NewGetter(), nil
+	}
+	mode := thread.CollapseModeCacheable
+	if cf.Mode != "" {
+		if mode = thread.CollapseModeFromString(cf.Mode); mode == thread.CollapseModeInvalid {
+			return nil, fmt.Errorf("mode invalid: '%v'", cf.Mode)
+		}
+	}
+	if cf.MaxWaitMS < 0 {
+		return nil, fmt.Errorf("max wait must be positive: %v", cf.MaxWaitMS)
+	}
+	if mode == thread.CollapseModeMaxWait && cf.MaxWaitMS == 0 {
+		return nil, fmt.Errorf("mode %v requires a max_wait_ms", mode)
+	}
+	return thread.NewModeGetter(mode, time.Duration(cf.MaxWaitMS)*time.Millisecond), nil
+}
+
+func validateCacheOverrides(o remapdata.CacheOverrides) error {
+	for code, ttlMS := range o.NegativeTTLMS {
+		if _, ok := ValidHTTPCodes[code]; !ok {
+			return fmt.Errorf("negative ttl code invalid: %v", code)
+		}
+		if ttlMS < 0 {
+			return fmt.Errorf("negative ttl for code %v must be positive: %v", code, ttlMS)
+		}
+	}
+	if o.MinTTLMS < 0 || o.MaxTTLMS < 0 {
+		return fmt.Errorf("min and max ttl must be positive: %v %v", o.MinTTLMS, o.MaxTTLMS)
+	}
+	if o.MaxTTLMS != 0 && o.MinTTLMS > o.MaxTTLMS {
+		return fmt.Errorf("min ttl %v greater than max ttl %v", o.MinTTLMS, o.MaxTTLMS)
+	}
+	return nil
+}
+
 func makeIPNets(netStrs []string) ([]*net.IPNet, error) {
 	nets := make([]*net.IPNet, 0, len(netStrs))
 	for _, netStr := range netStrs {
@@ -579,6 +931,7 @@ func RemapRulesToJSON(r RemapRules) (RemapRulesJSON, error) {
 		for code := range r.RetryCodes {
 			*j.RetryCodes = append(*j.RetryCodes, code)
 		}
+		sort.Ints(*j.RetryCodes) // sorted, so the JSON of the same rules is always the same
 	}
 	if r.ParentSelection != nil {
 		s := ""
@@ -633,6 +986,7 @@ func buildRemapRuleToJSON(r remapdata.RemapRule) RemapRuleJSON {
 		for retryCode := range r.RetryCodes {
 			*j.RetryCodes = append(*j.RetryCodes, retryCode)
 		}
+		sort.Ints(*j.RetryCodes)
 	}
 	j.Plugins = make(map[string]json.RawMessage)
 	for name, plugin := range r.Plugins {
@@ -660,6 +1014,7 @@ func RemapRuleToToJSON(r remapdata.RemapRuleTo) RemapRuleToJSON {
 		for retryCode := range r.RetryCodes {
 			*j.RetryCodes = append(*j.RetryCodes, retryCode)
 		}
+		sort.Ints(*j.RetryCodes)
 	}
 	return j
 }
@@ -0,0 +1,62 @@
+package remap
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+)
+
+func TestRemappingProducerRewritten(t *testing.T) {
+	rule := remapdata.RemapRule{
+		RemapRuleBase: remapdata.RemapRuleBase{Name: "foo", From: "http://foo.example.net"},
+		To:            []remapdata.RemapRuleTo{{RemapRuleToBase: remapdata.RemapRuleToBase{URL: "http://origin.example.net"}}},
+	}
+	remapper := NewHTTPRequestRemapper([]remapdata.RemapRule{rule}, nil, &remapdata.RemapRulesStats{})
+	producer := func(uri string) *RemappingProducer {
+		r := httptest.NewRequest(http.MethodGet, uri, nil)
+		r.RequestURI = r.URL.RequestURI()
+		p, err := remapper.UncheckedRemappingProducer(r, "http")
+		if err != nil {
+			t.Fatalf("remapping '%v': %v", uri, err)
+		}
+		return p
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://foo.example.net/a?x=1&S=sig", nil)
+	r.RequestURI = r.URL.RequestURI()
+	p, err := remapper.UncheckedRemappingProducer(r, "http")
+	if err != nil {
+		t.Fatalf("remapping: %v", err)
+	}
+	signedKey := p.CacheKey()
+	p.Rewritten(r, "http")
+	if p.CacheKey() != signedKey {
+		t.Errorf("Rewritten of unmodified request expected cache key '%v', actual '%v'", signedKey, p.CacheKey())
+	}
+
+	r.URL.RawQuery = "x=1"
+	r.RequestURI = "/a?x=1"
+	p.Rewritten(r, "http")
+	if expected := producer("http://foo.example.net/a?x=1").CacheKey(); p.CacheKey() != expected {
+		t.Errorf("Rewritten of modified request expected cache key '%v', actual '%v'", expected, p.CacheKey())
+	}
+	if expected := "http://foo.example.net/a?x=1"; p.oldURI != expected {
+		t.Errorf("Rewritten of modified request expected URI '%v', actual '%v'", expected, p.oldURI)
+	}
+}
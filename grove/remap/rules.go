@@ -114,23 +114,26 @@ func codeUnderstood(code int) bool {
 }
 
 // CanCache returns whether an object can be cached per RFC 7234, based on the request headers, response headers, and response code. If strictRFC is false, this ignores request headers denying cacheability such as `no-cache`, in order to protect origins.
+// The rule's cache overrides are applied to the response, see OverrideCacheControl. The overrides may be nil.
 // TODO add options to ignore/violate request cache-control (to protect origins)
-func CanCache(reqMethod string, reqHeaders http.Header, respCode int, respHeaders http.Header, strictRFC bool) bool {
+func CanCache(reqMethod string, reqHeaders http.Header, respCode int, respHeaders http.Header, strictRFC bool, overrides *remapdata.CacheOverrides) bool {
 	log.Debugf("CanCache start\n")
 	if reqMethod != http.MethodGet {
 		return false // for now, we only support GET as a cacheable method.
 	}
 	reqCacheControl := web.ParseCacheControl(reqHeaders)
-	respCacheControl := web.ParseCacheControl(respHeaders)
+	respCacheControl, _ := OverrideCacheControl(respCode, respHeaders, web.ParseCacheControl(respHeaders), overrides)
 	log.Debugf("CanCache reqCacheControl %+v respCacheControl %+v\n", reqCacheControl, respCacheControl)
 	return canStoreResponse(respCode, respHeaders, reqCacheControl, respCacheControl, strictRFC) && canStoreAuthenticated(reqCacheControl, respCacheControl)
 }
 
-// CanReuseStored checks the constraints in RFC7234§4
-func CanReuseStored(reqHeaders http.Header, respHeaders http.Header, reqCacheControl web.CacheControl, respCacheControl web.CacheControl, respReqHeaders http.Header, respReqTime time.Time, respRespTime time.Time, strictRFC bool) remapdata.Reuse {
+// CanReuseStored checks the constraints in RFC7234§4. The rule's cache overrides are applied to the stored response, see OverrideCacheControl. The overrides may be nil.
+func CanReuseStored(reqHeaders http.Header, respCode int, respHeaders http.Header, reqCacheControl web.CacheControl, respCacheControl web.CacheControl, respReqHeaders http.Header, respReqTime time.Time, respRespTime time.Time, strictRFC bool, overrides *remapdata.CacheOverrides) remapdata.Reuse {
+	respCacheControl, _ = OverrideCacheControl(respCode, respHeaders, respCacheControl, overrides)
+
 	// TODO: remove allowed_stale, check in cache manager after revalidate fails? (since RFC7234§4.2.4 prohibits serving stale response unless disconnected).
 
-	if !selectedHeadersMatch(reqHeaders, respReqHeaders, strictRFC) {
+	if !selectedHeadersMatch(reqHeaders, respHeaders, respReqHeaders) {
 		log.Debugf("CanReuseStored false - selected headers don't match\n") // debug
 		return remapdata.ReuseCannot
 	}
@@ -165,11 +168,101 @@ func CanReuseStored(reqHeaders http.Header, respHeaders http.Header, reqCacheCon
 }
 
 // CanReuse is a helper wrapping CanReuseStored, returning a boolean rather than an enum, for when it's known whether MustRevalidate can be used.
-func CanReuse(reqHeader http.Header, reqCacheControl web.CacheControl, cacheObj *cacheobj.CacheObj, strictRFC bool, revalidateCanReuse bool) bool {
-	canReuse := CanReuseStored(reqHeader, cacheObj.RespHeaders, reqCacheControl, cacheObj.RespCacheControl, cacheObj.ReqHeaders, cacheObj.ReqRespTime, cacheObj.RespRespTime, strictRFC)
+func CanReuse(reqHeader http.Header, reqCacheControl web.CacheControl, cacheObj *cacheobj.CacheObj, strictRFC bool, revalidateCanReuse bool, overrides *remapdata.CacheOverrides) bool {
+	canReuse := CanReuseStored(reqHeader, cacheObj.Code, cacheObj.RespHeaders, reqCacheControl, cacheObj.RespCacheControl, cacheObj.ReqHeaders, cacheObj.ReqRespTime, cacheObj.RespRespTime, strictRFC, overrides)
 	return canReuse == remapdata.ReuseCan || (canReuse == remapdata.ReuseMustRevalidate && revalidateCanReuse)
 }
 
+// CanShare returns whether the response to another concurrent request for the same key may be used for a request with the given method and headers, without checking whether it's fresh or storable. Only GET responses are shared, and never private responses, nor responses to authenticated requests which don't allow it per RFC7234§3.2. The rule's cache overrides are applied to the response, see OverrideCacheControl. The overrides may be nil.
+func CanShare(reqMethod string, reqHeaders http.Header, cacheObj *cacheobj.CacheObj, overrides *remapdata.CacheOverrides) bool {
+	if reqMethod != http.MethodGet {
+		return false
+	}
+	respCacheControl, _ := OverrideCacheControl(cacheObj.Code, cacheObj.RespHeaders, cacheObj.RespCacheControl, overrides)
+	if _, ok := respCacheControl["private"]; ok {
+		log.Debugf("CanShare false: has private\n")
+		return false
+	}
+	if reqHeaders.Get("Authorization") == "" && cacheObj.ReqHeaders.Get("Authorization") == "" {
+		return true
+	}
+	for _, allowed := range []string{"must-revalidate", "public", "s-maxage"} {
+		if _, ok := respCacheControl[allowed]; ok {
+			return true
+		}
+	}
+	log.Debugf("CanShare false: has authorization, and no must-revalidate/public/s-maxage\n")
+	return false
+}
+
+// OverrideCacheControl returns the response Cache-Control with the given rule cache overrides applied, and the JSON names of the overrides which changed it. If none did, the given respCacheControl is returned, otherwise a modified copy.
+// Freshness overrides replace the s-maxage if the response has one, and otherwise the max-age. Cache-Control ages are whole seconds, so override TTLs are rounded up to the second.
+func OverrideCacheControl(respCode int, respHeaders http.Header, respCacheControl web.CacheControl, overrides *remapdata.CacheOverrides) (web.CacheControl, []string) {
+	if overrides == nil {
+		return respCacheControl, nil
+	}
+	cc := web.CacheControl{}
+	for k, v := range respCacheControl {
+		cc[k] = v
+	}
+	applied := []string{}
+
+	if _, ok := cc["no-cache"]; ok && overrides.IgnoreNoCache {
+		delete(cc, "no-cache")
+		applied = append(applied, "ignore_no_cache")
+	}
+	if _, ok := cc["private"]; ok && overrides.IgnorePrivate {
+		delete(cc, "private")
+		applied = append(applied, "ignore_private")
+	}
+
+	ttl := time.Duration(-1)
+	if negativeTTLMS, ok := overrides.NegativeTTLMS[respCode]; ok && !hasExplicitFreshness(respHeaders, cc) {
+		ttl = time.Duration(negativeTTLMS) * time.Millisecond
+		applied = append(applied, "negative_ttl_ms")
+	} else if (overrides.MinTTLMS > 0 || overrides.MaxTTLMS > 0) && cacheControlAllows(respCode, respHeaders, cc) {
+		lifetime := getFreshnessLifetime(respHeaders, cc)
+		if minTTL := time.Duration(overrides.MinTTLMS) * time.Millisecond; lifetime < minTTL {
+			ttl = minTTL
+			applied = append(applied, "min_ttl_ms")
+		}
+		if maxTTL := time.Duration(overrides.MaxTTLMS) * time.Millisecond; maxTTL > 0 && lifetime > maxTTL {
+			ttl = maxTTL
+			applied = append(applied, "max_ttl_ms")
+		}
+	}
+	if ttl >= 0 {
+		key := "max-age"
+		if _, ok := cc["s-maxage"]; ok {
+			key = "s-maxage"
+		}
+		cc[key] = strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10)
+	}
+
+	if len(applied) == 0 {
+		return respCacheControl, nil
+	}
+	return cc, applied
+}
+
+// hasExplicitFreshness returns whether the response has an explicit expiration time, per RFC7234§4.2.1.
+func hasExplicitFreshness(respHeaders http.Header, respCacheControl web.CacheControl) bool {
+	if _, ok := respCacheControl["s-maxage"]; ok {
+		return true
+	}
+	if _, ok := respCacheControl["max-age"]; ok {
+		return true
+	}
+	_, ok := respHeaders["Expires"]
+	return ok
+}
+
+// FreshnessLifetime returns the freshness lifetime of the response per RFC7234§4.2.1, with the given rule cache overrides applied, and the names of the overrides which changed it, as returned by OverrideCacheControl. The overrides may be nil.
+func FreshnessLifetime(respCode int, respHeaders http.Header, respCacheControl web.CacheControl, overrides *remapdata.CacheOverrides) (time.Duration, []string) {
+	respCacheControl, applied := OverrideCacheControl(respCode, respHeaders, respCacheControl, overrides)
+	return getFreshnessLifetime(respHeaders, respCacheControl), applied
+}
+
 // canStoreAuthenticated checks the constraints in RFC7234§3.2
 // TODO: ensure RFC7234§3.2 requirements that max-age=0, must-revlaidate, s-maxage=0 are revalidated
 func canStoreAuthenticated(reqCacheControl, respCacheControl web.CacheControl) bool {
@@ -217,6 +310,10 @@ func canStoreResponse(
 		log.Debugf("CanStoreResponse false: has authorization\n")
 		return false
 	}
+	if _, varyAll := web.ParseVary(respHeaders); varyAll {
+		log.Debugf("CanStoreResponse false: response has Vary *\n") // RFC7234§4.1
+		return false
+	}
 	if !cacheControlAllows(respCode, respHeaders, respCacheControl) {
 		log.Debugf("CanStoreResponse false: CacheControlAllows false\n")
 		return false
@@ -464,25 +561,66 @@ func inMaxStale(respHeaders http.Header, respCacheControl web.CacheControl, resp
 	return inMaxStale
 }
 
-// SelectedHeadersMatch checks the constraints in RFC7234§4.1
-// TODO: change caching to key on URL+headers, so multiple requests for the same URL with different vary headers can be cached?
-func selectedHeadersMatch(reqHeaders http.Header, respReqHeaders http.Header, strictRFC bool) bool {
-	varyHeaders, ok := reqHeaders["vary"]
-	if !strictRFC && !ok {
-		return true
+// staleness returns how long the given response has been stale, which is negative if it's fresh.
+func staleness(respHeaders http.Header, respCacheControl web.CacheControl, respReqTime time.Time, respRespTime time.Time) time.Duration {
+	return getCurrentAge(respHeaders, respReqTime, respRespTime) - getFreshnessLifetime(respHeaders, respCacheControl)
+}
+
+// CanStaleWhileRevalidate returns whether the given stale response may be served while it's revalidated asynchronously, per the RFC5861§3 stale-while-revalidate Cache-Control extension. If the response has no stale-while-revalidate, the given rule default is used.
+// Responses which must be revalidated by RFC7234 are never served stale. If strictRFC, requests with no-cache or max-age aren't either.
+func CanStaleWhileRevalidate(respHeaders http.Header, reqCacheControl web.CacheControl, respCacheControl web.CacheControl, respReqTime time.Time, respRespTime time.Time, ruleDefault time.Duration, strictRFC bool) bool {
+	for _, directive := range []string{"must-revalidate", "proxy-revalidate", "no-cache", "no-store"} {
+		if _, ok := respCacheControl[directive]; ok {
+			log.Debugf("CanStaleWhileRevalidate false - response has %v\n", directive)
+			return false
+		}
 	}
-	if len(varyHeaders) == 0 {
-		return true
+	if strictRFC {
+		for _, directive := range []string{"no-cache", "max-age"} {
+			if _, ok := reqCacheControl[directive]; ok {
+				log.Debugf("CanStaleWhileRevalidate false - strictRFC and request has %v\n", directive)
+				return false
+			}
+		}
+	}
+	window, ok := getHTTPDeltaSecondsCacheControl(respCacheControl, "stale-while-revalidate")
+	if !ok {
+		window = ruleDefault
+	}
+	stale := staleness(respHeaders, respCacheControl, respReqTime, respRespTime)
+	log.Debugf("CanStaleWhileRevalidate stale %v stale-while-revalidate %v\n", stale, window)
+	return stale >= 0 && stale < window
+}
+
+// CanStaleIfError returns whether the given stale response may be served when revalidating it fails, per the RFC5861§4 stale-if-error Cache-Control extension. If the response has no stale-if-error, the given rule default is used. A request stale-if-error also allows the response to be served within its window.
+// Per RFC5861§4, this applies regardless of other freshness information, such as must-revalidate.
+func CanStaleIfError(respHeaders http.Header, reqCacheControl web.CacheControl, respCacheControl web.CacheControl, respReqTime time.Time, respRespTime time.Time, ruleDefault time.Duration) bool {
+	window, ok := getHTTPDeltaSecondsCacheControl(respCacheControl, "stale-if-error")
+	if !ok {
+		window = ruleDefault
+	}
+	if reqWindow, ok := getHTTPDeltaSecondsCacheControl(reqCacheControl, "stale-if-error"); ok && reqWindow > window {
+		window = reqWindow
 	}
-	varyHeader := varyHeaders[0]
+	stale := staleness(respHeaders, respCacheControl, respReqTime, respRespTime)
+	log.Debugf("CanStaleIfError stale %v stale-if-error %v\n", stale, window)
+	return stale < window
+}
+
+// IsStaleIfErrorCode returns whether the given response code is an error which allows a stale response to be served, per RFC5861§4.
+func IsStaleIfErrorCode(code int) bool {
+	return code == http.StatusInternalServerError || code == http.StatusBadGateway || code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
 
-	if varyHeader == "*" {
+// SelectedHeadersMatch checks the constraints in RFC7234§4.1, that the normalized values of the request headers the stored response varies on match the values in the request which obtained it.
+// Responses which vary are cached per-variant, so this only fails for a response which was just received for a different request, such as a collapsed request in the Getter.
+func selectedHeadersMatch(reqHeaders http.Header, respHeaders http.Header, respReqHeaders http.Header) bool {
+	varyHeaders, varyAll := web.ParseVary(respHeaders)
+	if varyAll {
 		return false
 	}
-	varyHeader = strings.ToLower(varyHeader)
-	varyHeaderHeaders := strings.Split(varyHeader, ",")
-	for _, header := range varyHeaderHeaders {
-		if _, ok := respReqHeaders[header]; !ok {
+	for _, header := range varyHeaders {
+		if web.NormalizeVaryValue(reqHeaders, header) != web.NormalizeVaryValue(respReqHeaders, header) {
 			return false
 		}
 	}
@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
 
@@ -36,7 +37,7 @@ func TestRules(t *testing.T) {
 		respHdr := http.Header{}
 		strictRFC := true
 
-		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned true for no-store request and strict RFC")
 		}
 	}
@@ -50,7 +51,7 @@ func TestRules(t *testing.T) {
 		respHdr := http.Header{}
 		strictRFC := false
 
-		if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned false for no-store request and strict RFC disabled")
 		}
 	}
@@ -64,7 +65,7 @@ func TestRules(t *testing.T) {
 		respHdr := http.Header{}
 		strictRFC := false
 
-		if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned false for no-store request and strict RFC disabled")
 		}
 	}
@@ -85,7 +86,7 @@ func TestRules(t *testing.T) {
 
 		strictRFC := false
 
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored for no-cache request and strict RFC disabled: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -107,7 +108,7 @@ func TestRules(t *testing.T) {
 
 		strictRFC := false
 
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored for no-cache request and strict RFC disabled: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -129,7 +130,7 @@ func TestRules(t *testing.T) {
 
 		strictRFC := false
 
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored for no-cache request and strict RFC disabled: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -143,7 +144,7 @@ func TestRules(t *testing.T) {
 		}
 		strictRFC := false
 
-		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned true for no-cache request and strict RFC disabled")
 		}
 	}
@@ -157,7 +158,7 @@ func TestRules(t *testing.T) {
 		}
 		strictRFC := false
 
-		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned true for no-cache request and strict RFC disabled")
 		}
 	}
@@ -171,7 +172,7 @@ func TestRules(t *testing.T) {
 		}
 		strictRFC := false
 
-		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned true for no-cache request and strict RFC enabled")
 		}
 	}
@@ -185,7 +186,7 @@ func TestRules(t *testing.T) {
 		}
 		strictRFC := true
 
-		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 			t.Errorf("CanCache returned true for no-cache request and strict RFC enabled")
 		}
 	}
@@ -205,7 +206,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinsBeforeExpires
 		respRespTime := tenMinsBeforeExpires
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request after expires: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -226,7 +227,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after expires: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -249,7 +250,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidate {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidate {
 			t.Errorf("CanReuseStored request after expires and response must-revalidate: expected ReuseMustRevalidate, actual %v", reuse)
 		}
 	}
@@ -272,7 +273,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidate {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidate {
 			t.Errorf("CanReuseStored request after expires and response must-revalidate: expected ReuseMustRevalidate, actual %v", reuse)
 		}
 	}
@@ -295,7 +296,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidate {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidate {
 			t.Errorf("CanReuseStored request after expires and response must-revalidate: expected ReuseMustRevalidate, actual %v", reuse)
 		}
 	}
@@ -316,7 +317,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request after expires and response must-revalidate: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -337,7 +338,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after response max-age: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -358,7 +359,7 @@ func TestRules(t *testing.T) {
 		respReqTime := now
 		respRespTime := now
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request before s-maxage: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -379,7 +380,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after response s-maxage: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -402,7 +403,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request before s-maxage but after max-age: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -425,7 +426,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request before s-maxage but after max-age: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -450,7 +451,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request before max-age but after Expires: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -475,7 +476,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after max-age but before Expires: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -500,7 +501,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request before s-maxage but after Expires: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -525,7 +526,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after max-age but before Expires: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -551,7 +552,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request before s-maxage but after Expires: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -577,7 +578,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidateCanStale {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidateCanStale {
 			t.Errorf("CanReuseStored request after s-maxage but before Expires: expected ReuseMustRevalidateCanStale, actual %v", reuse)
 		}
 	}
@@ -603,7 +604,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := true
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseMustRevalidate {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseMustRevalidate {
 			t.Errorf("CanReuseStored request with strictRFC min-fresh 300 with 600 remaining: expected ReuseMustRevalidate, actual %v", reuse)
 		}
 	}
@@ -629,7 +630,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request with strictRFC min-fresh 1200 with 600 remaining: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -655,7 +656,7 @@ func TestRules(t *testing.T) {
 		respReqTime := tenMinutesAgo
 		respRespTime := tenMinutesAgo
 		strictRFC := false
-		if reuse := CanReuseStored(reqHdr, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC); reuse != remapdata.ReuseCan {
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
 			t.Errorf("CanReuseStored request with strictRFC min-fresh 1200 with 600 remaining: expected ReuseCan, actual %v", reuse)
 		}
 	}
@@ -669,7 +670,7 @@ func TestRules(t *testing.T) {
 			respHdr := http.Header{}
 			strictRFC := true
 
-			if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+			if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 				t.Errorf("CanCache returned false for request with no cache control and default-cacheable response code %v", code)
 			}
 		}
@@ -689,7 +690,7 @@ func TestRules(t *testing.T) {
 			respHdr := http.Header{}
 			strictRFC := true
 
-			if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+			if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 				t.Errorf("CanCache returned true for request with no cache control and non-default-cacheable response code %v", code)
 			}
 		}
@@ -716,12 +717,203 @@ func TestRules(t *testing.T) {
 				respHdr := hdr
 				strictRFC := true
 
-				if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC) {
+				if !CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
 					t.Errorf("CanCache returned false for request with non-default-cacheable response code %v and cacheable header %v", respCode, respHdr)
 				}
 			}
 		}
 	}
 
+	// test Vary * is never cached. Tests RFC7234§4.1 compliance
+	{
+		reqHdr := http.Header{}
+		respCode := 200
+		respHdr := http.Header{"Vary": {"Accept-Language, *"}}
+		strictRFC := false
+
+		if CanCache(http.MethodGet, reqHdr, respCode, respHdr, strictRFC, nil) {
+			t.Errorf("CanCache returned true for response with Vary *")
+		}
+	}
+
+	// test a response is reused for requests whose Vary headers normalize the same, and not for others. Tests RFC7234§4.1 compliance
+	{
+		respHdr := http.Header{"Vary": {"accept-encoding"}}
+		reqCC := web.CacheControl{}
+		respCC := web.CacheControl{}
+		respReqHdrs := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+		respReqTime := time.Now()
+		respRespTime := time.Now()
+		strictRFC := false
+
+		if reuse := CanReuseStored(http.Header{"Accept-Encoding": {"deflate,gzip;q=0.5"}}, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCan {
+			t.Errorf("CanReuseStored for matching normalized Vary header: expected ReuseCan, actual %v", reuse)
+		}
+		if reuse := CanReuseStored(http.Header{"Accept-Encoding": {"identity"}}, http.StatusOK, respHdr, reqCC, respCC, respReqHdrs, respReqTime, respRespTime, strictRFC, nil); reuse != remapdata.ReuseCannot {
+			t.Errorf("CanReuseStored for mismatched Vary header: expected ReuseCannot, actual %v", reuse)
+		}
+	}
+
+	// test a response stale for 10 minutes is served while revalidating within its stale-while-revalidate, and not after. Tests RFC5861§3 compliance
+	{
+		now := time.Now()
+		tenMinutesAgo := now.Add(time.Minute * -10).Format(time.RFC1123)
+		respHdr := http.Header{"Date": {tenMinutesAgo}}
+		reqCC := web.CacheControl{}
+		strictRFC := false
+
+		if !CanStaleWhileRevalidate(respHdr, reqCC, web.CacheControl{"max-age": "0", "stale-while-revalidate": "900"}, now, now, 0, strictRFC) {
+			t.Errorf("CanStaleWhileRevalidate within stale-while-revalidate: expected true, actual false")
+		}
+		if CanStaleWhileRevalidate(respHdr, reqCC, web.CacheControl{"max-age": "0", "stale-while-revalidate": "300"}, now, now, 0, strictRFC) {
+			t.Errorf("CanStaleWhileRevalidate after stale-while-revalidate: expected false, actual true")
+		}
+		if !CanStaleWhileRevalidate(respHdr, reqCC, web.CacheControl{"max-age": "0"}, now, now, time.Hour, strictRFC) {
+			t.Errorf("CanStaleWhileRevalidate within rule default: expected true, actual false")
+		}
+		if CanStaleWhileRevalidate(respHdr, reqCC, web.CacheControl{"max-age": "0", "must-revalidate": "", "stale-while-revalidate": "900"}, now, now, 0, strictRFC) {
+			t.Errorf("CanStaleWhileRevalidate with must-revalidate: expected false, actual true")
+		}
+		if CanStaleWhileRevalidate(respHdr, reqCC, web.CacheControl{"max-age": "3600", "stale-while-revalidate": "900"}, now, now, 0, strictRFC) {
+			t.Errorf("CanStaleWhileRevalidate for fresh response: expected false, actual true")
+		}
+	}
+
+	// test a response stale for 10 minutes is served on error within its or the request's stale-if-error, and not after, even with must-revalidate. Tests RFC5861§4 compliance
+	{
+		now := time.Now()
+		tenMinutesAgo := now.Add(time.Minute * -10).Format(time.RFC1123)
+		respHdr := http.Header{"Date": {tenMinutesAgo}}
+		reqCC := web.CacheControl{}
+
+		if !CanStaleIfError(respHdr, reqCC, web.CacheControl{"max-age": "0", "must-revalidate": "", "stale-if-error": "900"}, now, now, 0) {
+			t.Errorf("CanStaleIfError within stale-if-error: expected true, actual false")
+		}
+		if CanStaleIfError(respHdr, reqCC, web.CacheControl{"max-age": "0", "stale-if-error": "300"}, now, now, 0) {
+			t.Errorf("CanStaleIfError after stale-if-error: expected false, actual true")
+		}
+		if CanStaleIfError(respHdr, reqCC, web.CacheControl{"max-age": "0"}, now, now, 0) {
+			t.Errorf("CanStaleIfError with no stale-if-error: expected false, actual true")
+		}
+		if !CanStaleIfError(respHdr, reqCC, web.CacheControl{"max-age": "0"}, now, now, time.Hour) {
+			t.Errorf("CanStaleIfError within rule default: expected true, actual false")
+		}
+		if !CanStaleIfError(respHdr, web.CacheControl{"stale-if-error": "900"}, web.CacheControl{"max-age": "0"}, now, now, 0) {
+			t.Errorf("CanStaleIfError within request stale-if-error: expected true, actual false")
+		}
+	}
+
 	log.Init(log.NopCloser(os.Stdout), log.NopCloser(os.Stdout), log.NopCloser(os.Stdout), log.NopCloser(os.Stdout), log.NopCloser(os.Stdout))
 }
+
+func TestCacheOverrides(t *testing.T) {
+	now := time.Now()
+	date := now.Format(time.RFC1123)
+	reqHdr := http.Header{}
+	reqCC := web.CacheControl{}
+
+	// test a failure with no cache headers is cached for its negative TTL, and then must be revalidated
+	{
+		overrides := &remapdata.CacheOverrides{NegativeTTLMS: map[int]int{http.StatusServiceUnavailable: 10000}}
+		respHdr := http.Header{"Date": {date}}
+		if CanCache(http.MethodGet, reqHdr, http.StatusServiceUnavailable, respHdr, false, nil) {
+			t.Errorf("CanCache 503 without overrides: expected false, actual true")
+		}
+		if !CanCache(http.MethodGet, reqHdr, http.StatusServiceUnavailable, respHdr, false, overrides) {
+			t.Errorf("CanCache 503 with negative ttl: expected true, actual false")
+		}
+		if reuse := CanReuseStored(reqHdr, http.StatusServiceUnavailable, respHdr, reqCC, web.CacheControl{}, http.Header{}, now, now, false, overrides); reuse != remapdata.ReuseCan {
+			t.Errorf("CanReuseStored 503 within negative ttl: expected ReuseCan, actual %v", reuse)
+		}
+		oldHdr := http.Header{"Date": {now.Add(-time.Minute).Format(time.RFC1123)}}
+		if reuse := CanReuseStored(reqHdr, http.StatusServiceUnavailable, oldHdr, reqCC, web.CacheControl{}, http.Header{}, now, now, false, overrides); reuse != remapdata.ReuseMustRevalidateCanStale {
+			t.Errorf("CanReuseStored 503 after negative ttl: expected ReuseMustRevalidateCanStale, actual %v", reuse)
+		}
+		if lifetime, applied := FreshnessLifetime(http.StatusServiceUnavailable, respHdr, web.CacheControl{}, overrides); lifetime != 10*time.Second || len(applied) != 1 || applied[0] != "negative_ttl_ms" {
+			t.Errorf("FreshnessLifetime 503 with negative ttl: expected 10s [negative_ttl_ms], actual %v %v", lifetime, applied)
+		}
+	}
+
+	// test the negative TTL doesn't override the response's own freshness, or no-store
+	{
+		overrides := &remapdata.CacheOverrides{NegativeTTLMS: map[int]int{http.StatusNotFound: 10000}}
+		if lifetime, applied := FreshnessLifetime(http.StatusNotFound, http.Header{}, web.CacheControl{"max-age": "60"}, overrides); lifetime != time.Minute || applied != nil {
+			t.Errorf("FreshnessLifetime 404 with max-age and negative ttl: expected 1m0s [], actual %v %v", lifetime, applied)
+		}
+		if CanCache(http.MethodGet, reqHdr, http.StatusNotFound, http.Header{"Cache-Control": {"no-store"}}, false, overrides) {
+			t.Errorf("CanCache 404 no-store with negative ttl: expected false, actual true")
+		}
+	}
+
+	// test the min and max TTL clamp the freshness lifetime, and don't make uncacheable responses cacheable
+	{
+		overrides := &remapdata.CacheOverrides{MinTTLMS: 60000, MaxTTLMS: 3600000}
+		if lifetime, _ := FreshnessLifetime(http.StatusOK, http.Header{}, web.CacheControl{"max-age": "0"}, overrides); lifetime != time.Minute {
+			t.Errorf("FreshnessLifetime max-age=0 with min ttl: expected 1m0s, actual %v", lifetime)
+		}
+		if lifetime, _ := FreshnessLifetime(http.StatusOK, http.Header{}, web.CacheControl{"s-maxage": "86400", "max-age": "30"}, overrides); lifetime != time.Hour {
+			t.Errorf("FreshnessLifetime s-maxage=86400 with max ttl: expected 1h0m0s, actual %v", lifetime)
+		}
+		if lifetime, applied := FreshnessLifetime(http.StatusOK, http.Header{}, web.CacheControl{"max-age": "600"}, overrides); lifetime != 10*time.Minute || applied != nil {
+			t.Errorf("FreshnessLifetime max-age=600 within min and max ttl: expected 10m0s [], actual %v %v", lifetime, applied)
+		}
+		if CanCache(http.MethodGet, reqHdr, http.StatusInternalServerError, http.Header{}, false, overrides) {
+			t.Errorf("CanCache 500 with min ttl: expected false, actual true")
+		}
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, http.Header{"Date": {date}}, reqCC, web.CacheControl{"max-age": "0"}, http.Header{}, now, now, false, overrides); reuse != remapdata.ReuseCan {
+			t.Errorf("CanReuseStored max-age=0 with min ttl: expected ReuseCan, actual %v", reuse)
+		}
+	}
+
+	// test no-cache and private are only ignored when configured
+	{
+		for _, cc := range []string{"no-cache", "private"} {
+			respHdr := http.Header{"Cache-Control": {cc}}
+			if CanCache(http.MethodGet, reqHdr, http.StatusOK, respHdr, false, &remapdata.CacheOverrides{}) {
+				t.Errorf("CanCache %v with no ignore overrides: expected false, actual true", cc)
+			}
+			ignore := &remapdata.CacheOverrides{IgnoreNoCache: true, IgnorePrivate: true}
+			if !CanCache(http.MethodGet, reqHdr, http.StatusOK, respHdr, false, ignore) {
+				t.Errorf("CanCache %v with ignore overrides: expected true, actual false", cc)
+			}
+		}
+		respCC := web.CacheControl{"no-cache": ""}
+		if reuse := CanReuseStored(reqHdr, http.StatusOK, http.Header{"Date": {date}}, reqCC, respCC, http.Header{}, now, now, false, &remapdata.CacheOverrides{IgnoreNoCache: true}); reuse != remapdata.ReuseCan {
+			t.Errorf("CanReuseStored no-cache with ignore no-cache: expected ReuseCan, actual %v", reuse)
+		}
+		if _, ok := respCC["no-cache"]; !ok {
+			t.Errorf("OverrideCacheControl modified the stored response Cache-Control")
+		}
+	}
+}
+
+func TestCanShare(t *testing.T) {
+	now := time.Now()
+	newObj := func(reqHdr http.Header, cacheControl string) *cacheobj.CacheObj {
+		return cacheobj.New(reqHdr, nil, http.StatusOK, http.StatusOK, "", http.Header{"Cache-Control": {cacheControl}}, now, now, now, now)
+	}
+	auth := http.Header{"Authorization": {"Basic Zm9vOmJhcg=="}}
+
+	tests := []struct {
+		name      string
+		method    string
+		reqHdr    http.Header
+		obj       *cacheobj.CacheObj
+		overrides *remapdata.CacheOverrides
+		expected  bool
+	}{
+		{"uncacheable", http.MethodGet, http.Header{}, newObj(http.Header{}, "no-store"), nil, true},
+		{"stale", http.MethodGet, http.Header{}, newObj(http.Header{}, "max-age=0"), nil, true},
+		{"POST", http.MethodPost, http.Header{}, newObj(http.Header{}, "max-age=60"), nil, false},
+		{"private", http.MethodGet, http.Header{}, newObj(http.Header{}, "private, max-age=60"), nil, false},
+		{"private ignored", http.MethodGet, http.Header{}, newObj(http.Header{}, "private, max-age=60"), &remapdata.CacheOverrides{IgnorePrivate: true}, true},
+		{"authenticated author", http.MethodGet, http.Header{}, newObj(auth, "max-age=60"), nil, false},
+		{"authenticated waiter", http.MethodGet, auth, newObj(http.Header{}, "max-age=60"), nil, false},
+		{"authenticated public", http.MethodGet, auth, newObj(auth, "public, max-age=60"), nil, true},
+	}
+	for _, test := range tests {
+		if actual := CanShare(test.method, test.reqHdr, test.obj, test.overrides); actual != test.expected {
+			t.Errorf("CanShare %v: expected %v, actual %v", test.name, test.expected, actual)
+		}
+	}
+}
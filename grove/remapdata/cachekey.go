@@ -0,0 +1,139 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CacheKeyPartSeparator separates a cache key's URL from the request headers and cookies included in it by a CacheKeyPolicy. Like VariantKeySeparator, it contains a space, so it can't occur in a URL.
+const CacheKeyPartSeparator = " key:"
+
+// CacheKeyPolicy customizes a cache key or consistent hash key, like the ATS cachekey plugin. Query parameters are only filtered if the key includes the query string.
+type CacheKeyPolicy struct {
+	// IncludeParams are the only query parameters in the key. If empty, all parameters are included, except ExcludeParams.
+	IncludeParams []string `json:"include_params"`
+	ExcludeParams []string `json:"exclude_params"`
+	// SortParams sorts the query parameters, so requests with the same parameters in different orders have the same key.
+	SortParams bool `json:"sort_params"`
+	// LowercasePath lowercases the path, so requests differing only in case have the same key.
+	LowercasePath bool `json:"lowercase_path"`
+	// IncludeHeaders and IncludeCookies are request headers and cookies whose values are added to the key, so requests with different values have different keys.
+	IncludeHeaders []string `json:"include_headers"`
+	IncludeCookies []string `json:"include_cookies"`
+}
+
+// apply returns the key of the given URI, which may be a full URL or a path and query. If includeQuery is false, the query is removed. A nil policy only removes the query.
+func (p *CacheKeyPolicy) apply(uri string, includeQuery bool, reqHdr http.Header) string {
+	query := ""
+	if i := strings.Index(uri, "?"); i != -1 {
+		uri, query = uri[:i], uri[i+1:]
+	}
+	if p == nil {
+		if includeQuery && query != "" {
+			return uri + "?" + query
+		}
+		return uri
+	}
+
+	if p.LowercasePath {
+		pathStart := 0
+		if i := strings.Index(uri, "://"); i != -1 {
+			if slash := strings.Index(uri[i+len("://"):], "/"); slash != -1 {
+				pathStart = i + len("://") + slash
+			} else {
+				pathStart = len(uri)
+			}
+		}
+		uri = uri[:pathStart] + strings.ToLower(uri[pathStart:])
+	}
+
+	if includeQuery {
+		if query = p.filterQuery(query); query != "" {
+			uri += "?" + query
+		}
+	}
+
+	parts := []string{}
+	for _, name := range p.IncludeHeaders {
+		if val := reqHdr.Get(name); val != "" {
+			parts = append(parts, "header."+url.QueryEscape(strings.ToLower(name))+"="+url.QueryEscape(val))
+		}
+	}
+	if len(p.IncludeCookies) > 0 {
+		req := &http.Request{Header: reqHdr}
+		for _, name := range p.IncludeCookies {
+			if cookie, err := req.Cookie(name); err == nil {
+				parts = append(parts, "cookie."+url.QueryEscape(name)+"="+url.QueryEscape(cookie.Value))
+			}
+		}
+	}
+	if len(parts) > 0 {
+		uri += CacheKeyPartSeparator + strings.Join(parts, "&")
+	}
+	return uri
+}
+
+// filterQuery returns the given raw query, with only the policy's parameters, sorted if the policy sorts them. Parameters keep their original encoding.
+func (p *CacheKeyPolicy) filterQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	params := []string{}
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		name := param
+		if i := strings.Index(param, "="); i != -1 {
+			name = param[:i]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if len(p.IncludeParams) > 0 && !containsString(p.IncludeParams, name) {
+			continue
+		}
+		if containsString(p.ExcludeParams, name) {
+			continue
+		}
+		params = append(params, param)
+	}
+	if p.SortParams {
+		sort.Strings(params)
+	}
+	return strings.Join(params, "&")
+}
+
+func containsString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsistentHashKey returns the key the request is consistent hashed to a parent by, the request path and query, if the rule remaps the query string, with the rule's ConsistentHashKeyPolicy applied.
+func (r RemapRule) ConsistentHashKey(path string, query string, reqHdr http.Header) string {
+	key := path
+	if query != "" {
+		key += "?" + query
+	}
+	return r.ConsistentHashKeyPolicy.apply(key, r.QueryString.Remap, reqHdr)
+}
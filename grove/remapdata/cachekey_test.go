@@ -0,0 +1,72 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheKeyPolicy(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeConsistentHash, 1)
+	rule.To[0].URL = "http://origin.example.net"
+	rule.QueryString = QueryStringRule{Remap: true, Cache: true}
+	fromURI := "http://from.example.net/Images/A.png?b=2&utm=x&a=1&c%20d=3"
+	hdr := http.Header{"X-Device": {"tv"}, "Cookie": {"tier=gold; session=abc"}}
+
+	tests := []struct {
+		policy   *CacheKeyPolicy
+		expected string
+	}{
+		{nil, "GET:http://origin.example.net/Images/A.png?b=2&utm=x&a=1&c%20d=3"},
+		{&CacheKeyPolicy{ExcludeParams: []string{"utm", "c d"}}, "GET:http://origin.example.net/Images/A.png?b=2&a=1"},
+		{&CacheKeyPolicy{IncludeParams: []string{"a", "b"}, SortParams: true}, "GET:http://origin.example.net/Images/A.png?a=1&b=2"},
+		{&CacheKeyPolicy{IncludeParams: []string{"missing"}}, "GET:http://origin.example.net/Images/A.png"},
+		{&CacheKeyPolicy{LowercasePath: true, ExcludeParams: []string{"utm", "c d", "a", "b"}}, "GET:http://origin.example.net/images/a.png"},
+		{&CacheKeyPolicy{IncludeParams: []string{"a"}, IncludeHeaders: []string{"x-device", "X-Missing"}, IncludeCookies: []string{"tier"}}, "GET:http://origin.example.net/Images/A.png?a=1" + CacheKeyPartSeparator + "header.x-device=tv&cookie.tier=gold"},
+	}
+	for _, test := range tests {
+		rule.CacheKeyPolicy = test.policy
+		if actual := rule.CacheKey("GET", fromURI, hdr); actual != test.expected {
+			t.Errorf("cache key with policy %+v expected '%v', actual '%v'", test.policy, test.expected, actual)
+		}
+	}
+
+	key := rule.CacheKey("HEAD", fromURI, hdr)
+	if expected := "http://origin.example.net/Images/A.png?a=1"; CacheKeyURL(key) != expected {
+		t.Errorf("cache key URL expected '%v', actual '%v'", expected, CacheKeyURL(key))
+	}
+
+	rule.QueryString.Cache = false
+	rule.CacheKeyPolicy = &CacheKeyPolicy{IncludeParams: []string{"a"}}
+	if expected, actual := "GET:http://origin.example.net/Images/A.png", rule.CacheKey("GET", fromURI, hdr); actual != expected {
+		t.Errorf("cache key without the query expected '%v', actual '%v'", expected, actual)
+	}
+}
+
+func TestConsistentHashKeyPolicy(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeConsistentHash, 1)
+	rule.QueryString = QueryStringRule{Remap: true, Cache: true}
+	rule.CacheKeyPolicy = &CacheKeyPolicy{IncludeHeaders: []string{"X-Device"}}
+	hdr := http.Header{"X-Device": {"tv"}}
+
+	if expected, actual := "/a.png?x=1", rule.ConsistentHashKey("/a.png", "x=1", hdr); actual != expected {
+		t.Errorf("consistent hash key without a policy expected '%v', actual '%v'", expected, actual)
+	}
+	rule.ConsistentHashKeyPolicy = &CacheKeyPolicy{ExcludeParams: []string{"x"}, LowercasePath: true}
+	if expected, actual := "/a.png", rule.ConsistentHashKey("/A.png", "x=1", hdr); actual != expected {
+		t.Errorf("consistent hash key with a policy expected '%v', actual '%v'", expected, actual)
+	}
+}
@@ -0,0 +1,27 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// CacheOverrides are a rule's overrides of the RFC7234 cacheability and freshness of parent responses, for origins which return failures without cache headers, or incorrect Cache-Control. They're applied by the remap package CanCache and CanReuseStored.
+type CacheOverrides struct {
+	// NegativeTTLMS are the freshness lifetimes of responses with the given codes, which have no s-maxage, max-age, or Expires of their own. Responses with these codes are cached even if they aren't cacheable by default, unless their Cache-Control forbids it.
+	NegativeTTLMS map[int]int `json:"negative_ttl_ms"`
+	// MinTTLMS and MaxTTLMS force the freshness lifetime of cacheable responses into the given range. If 0, the lifetime has no minimum or maximum. They don't apply to negatively cached responses.
+	MinTTLMS int `json:"min_ttl_ms"`
+	MaxTTLMS int `json:"max_ttl_ms"`
+	// IgnoreNoCache and IgnorePrivate ignore the parent response Cache-Control no-cache and private directives.
+	IgnoreNoCache bool `json:"ignore_no_cache"`
+	IgnorePrivate bool `json:"ignore_private"`
+}
@@ -0,0 +1,114 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+const DefaultHealthCheckPath = "/"
+const DefaultHealthCheckIntervalMS = 10000
+const DefaultHealthCheckTimeoutMS = 5000
+
+var DefaultHealthCheckCodes = []int{http.StatusOK}
+
+// HealthCheck is the config of a rule's active parent health check. The Path is requested from each parent every IntervalMS, and the parent is healthy if it responds with one of the Codes within TimeoutMS.
+type HealthCheck struct {
+	Path       string `json:"path"`
+	IntervalMS int    `json:"interval_ms"`
+	TimeoutMS  int    `json:"timeout_ms"`
+	Codes      []int  `json:"codes"`
+}
+
+// StartHealthCheck starts checking the rule's parents in the background, if the rule has a HealthCheck, and sets their health in the rule's ParentStatus. It does nothing if the rule's health check is already running.
+// Parents whose URLs reference regex capture groups aren't checked, because their host isn't known until a request matches, and they're never marked unhealthy.
+// The health check runs until StopHealthCheck is called. Because the ParentStatus is shared by all copies of a rule, it may be stopped via any copy.
+func (r RemapRule) StartHealthCheck() {
+	if r.HealthCheck == nil || r.ParentStatus == nil {
+		return
+	}
+	s := r.ParentStatus
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stopHealthCheck != nil {
+		return
+	}
+	s.stopHealthCheck = make(chan struct{})
+	for _, to := range r.To {
+		if r.ExpandsCaptures(to.URL) {
+			log.Infof("RemapRule.StartHealthCheck: Rule '%v': not health checking parent %v, which references regex captures\n", r.Name, to.URL)
+			continue
+		}
+		go r.healthCheckParent(to, s.stopHealthCheck)
+	}
+}
+
+// StopHealthCheck stops the rule's health check, if it's running.
+func (r RemapRule) StopHealthCheck() {
+	if r.ParentStatus == nil {
+		return
+	}
+	s := r.ParentStatus
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stopHealthCheck == nil {
+		return
+	}
+	close(s.stopHealthCheck)
+	s.stopHealthCheck = nil
+}
+
+// healthCheckParent checks the given parent immediately, and then every health check interval, until stop is closed.
+func (r RemapRule) healthCheckParent(to RemapRuleTo, stop <-chan struct{}) {
+	client := &http.Client{Timeout: time.Duration(r.HealthCheck.TimeoutMS) * time.Millisecond}
+	if to.Transport != nil {
+		client.Transport = to.Transport
+	}
+	uri := strings.TrimSuffix(r.Expand(to.URL), "/") + r.HealthCheck.Path
+	ticker := time.NewTicker(time.Duration(r.HealthCheck.IntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.ParentStatus.SetHealthy(to.URL, r.checkParent(client, uri))
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkParent requests the given health check URI, and returns whether the parent responded with one of the rule's health check codes.
+func (r RemapRule) checkParent(client *http.Client, uri string) bool {
+	resp, err := client.Get(uri)
+	if err != nil {
+		log.Debugf("RemapRule.checkParent: Rule '%v': health check %v failed: %v\n", r.Name, uri, err)
+		return false
+	}
+	io.Copy(ioutil.Discard, resp.Body) // read the body, so the connection can be reused
+	resp.Body.Close()
+	for _, code := range r.HealthCheck.Codes {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	log.Debugf("RemapRule.checkParent: Rule '%v': health check %v returned unexpected code %v\n", r.Name, uri, resp.StatusCode)
+	return false
+}
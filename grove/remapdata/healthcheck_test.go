@@ -0,0 +1,115 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	healthyB := int32(1)
+	parentA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer parentA.Close()
+	parentB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthyB) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer parentB.Close()
+
+	rule := makeParentsRule(ParentSelectionTypeConsistentHash, 1, 1)
+	rule.ParentStatus = NewParentStatus(0, time.Hour)
+	rule.To[0].URL = parentA.URL
+	rule.To[1].URL = parentB.URL + "/"
+	rule.HealthCheck = &HealthCheck{Path: "/health", IntervalMS: 10, TimeoutMS: 1000, Codes: []int{http.StatusOK}}
+
+	rule.StartHealthCheck()
+	defer rule.StopHealthCheck()
+	rule.StartHealthCheck() // starting a running health check does nothing
+
+	waitHealthy := func(parent string, expected bool) {
+		for i := 0; i < 100 && rule.ParentStatus.Healthy(parent) != expected; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if actual := rule.ParentStatus.Healthy(parent); actual != expected {
+			t.Fatalf("parent %v health expected %v, actual %v", parent, expected, actual)
+		}
+	}
+
+	waitHealthy(rule.To[0].URL, true)
+	waitHealthy(rule.To[1].URL, true)
+
+	atomic.StoreInt32(&healthyB, 0)
+	waitHealthy(rule.To[1].URL, false)
+	if !rule.ParentStatus.Down(rule.To[1].URL) {
+		t.Errorf("unhealthy parent expected down, actual up")
+	}
+	if !rule.ParentStatus.Healthy(rule.To[0].URL) {
+		t.Errorf("parent responding to health check path expected healthy, actual unhealthy")
+	}
+
+	atomic.StoreInt32(&healthyB, 1)
+	waitHealthy(rule.To[1].URL, true)
+	if rule.ParentStatus.Down(rule.To[1].URL) {
+		t.Errorf("recovered parent expected up, actual down")
+	}
+
+	rule.StopHealthCheck()
+	time.Sleep(50 * time.Millisecond) // let any in-flight check finish
+	atomic.StoreInt32(&healthyB, 0)
+	time.Sleep(50 * time.Millisecond)
+	if !rule.ParentStatus.Healthy(rule.To[1].URL) {
+		t.Errorf("parent after stopping health check expected unchanged, actual unhealthy")
+	}
+}
+
+func TestHealthCheckCaptureParent(t *testing.T) {
+	checked := int32(0)
+	parent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checked, 1)
+	}))
+	defer parent.Close()
+
+	rule := makeParentsRule(ParentSelectionTypeFirstAvailable, 1, 1)
+	rule.ParentStatus = NewParentStatus(0, time.Hour)
+	rule.To[0].URL = "http://$1.origin.example.invalid"
+	rule.To[1].URL = parent.URL
+	rule.HostRegex = `^(\w+)\.example\.net$`
+	if err := rule.CompileRegexes(); err != nil {
+		t.Fatalf("compiling regexes expected no error, actual %v", err)
+	}
+	rule.HealthCheck = &HealthCheck{Path: "/", IntervalMS: 10, TimeoutMS: 1000, Codes: []int{http.StatusOK}}
+
+	rule.StartHealthCheck()
+	defer rule.StopHealthCheck()
+
+	for i := 0; i < 100 && atomic.LoadInt32(&checked) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&checked) < 3 {
+		t.Fatalf("parent without captures expected health checked, actual not checked")
+	}
+	if !rule.ParentStatus.Healthy(rule.To[0].URL) {
+		t.Errorf("parent referencing regex captures expected not checked and healthy, actual unhealthy")
+	}
+}
@@ -0,0 +1,199 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+)
+
+// DefaultParentMarkdownMS is the time a parent is marked down, if a rule has parent_markdown_failures but no parent_markdown_ms.
+const DefaultParentMarkdownMS = 10000
+
+// ParentStatus tracks the consecutive failures of a rule's parents, and marks a parent down for the markdown time once it fails markdownFailures consecutive times. Parents which are down are skipped by parent selection, unless all of the rule's parents are down.
+// After the markdown time, the parent is tried again, and a single failure marks it down again, until it succeeds.
+// Parents which fail the rule's health check are also down, regardless of markdownFailures, until they pass it again.
+// The ParentStatus is shared by all copies of a rule, and is safe for concurrent use. A nil ParentStatus never marks parents down.
+type ParentStatus struct {
+	markdownFailures int
+	markdownTime     time.Duration
+	roundRobin       uint64 // Atomic - DO NOT access or modify without atomic operations
+	parents          map[string]*parentHealth
+	unhealthy        map[string]struct{}
+	stopHealthCheck  chan struct{}
+	m                sync.Mutex
+}
+
+type parentHealth struct {
+	failures  int
+	downUntil time.Time
+}
+
+// NewParentStatus creates a new ParentStatus, which marks parents down for markdownTime after markdownFailures consecutive failures. If markdownFailures is 0, parents are never marked down.
+func NewParentStatus(markdownFailures int, markdownTime time.Duration) *ParentStatus {
+	return &ParentStatus{markdownFailures: markdownFailures, markdownTime: markdownTime, parents: map[string]*parentHealth{}, unhealthy: map[string]struct{}{}}
+}
+
+// Down returns whether the given parent is marked down, or unhealthy.
+func (s *ParentStatus) Down(parent string) bool {
+	if s == nil {
+		return false
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, unhealthy := s.unhealthy[parent]; unhealthy {
+		return true
+	}
+	if s.markdownFailures == 0 {
+		return false
+	}
+	health, ok := s.parents[parent]
+	return ok && time.Now().Before(health.downUntil)
+}
+
+// Failed records a failed request to the given parent, marking it down if it's failed too many consecutive times.
+func (s *ParentStatus) Failed(parent string) {
+	if s == nil || s.markdownFailures == 0 {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	health, ok := s.parents[parent]
+	if !ok {
+		health = &parentHealth{}
+		s.parents[parent] = health
+	}
+	now := time.Now()
+	if now.Before(health.downUntil) {
+		return // already down, e.g. a request which started before it was marked down
+	}
+	if health.failures < s.markdownFailures {
+		health.failures++
+	}
+	if health.failures >= s.markdownFailures {
+		health.downUntil = now.Add(s.markdownTime)
+		log.Warnf("parent %v failed %v consecutive times, marking down for %v\n", parent, health.failures, s.markdownTime)
+	}
+}
+
+// Succeeded records a successful request to the given parent, resetting its consecutive failures.
+func (s *ParentStatus) Succeeded(parent string) {
+	if s == nil || s.markdownFailures == 0 {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if health, ok := s.parents[parent]; ok {
+		if health.failures >= s.markdownFailures {
+			log.Infof("parent %v succeeded, marking up\n", parent)
+		}
+		delete(s.parents, parent)
+	}
+}
+
+// Healthy returns whether the given parent passed its last health check. Parents which haven't been health checked are healthy.
+func (s *ParentStatus) Healthy(parent string) bool {
+	if s == nil {
+		return true
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, unhealthy := s.unhealthy[parent]
+	return !unhealthy
+}
+
+// SetHealthy records the result of a health check of the given parent. Unhealthy parents are down until they're set healthy again.
+func (s *ParentStatus) SetHealthy(parent string, healthy bool) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, wasUnhealthy := s.unhealthy[parent]
+	if healthy && wasUnhealthy {
+		log.Infof("parent %v passed health check, marking healthy\n", parent)
+		delete(s.unhealthy, parent)
+	} else if !healthy && !wasUnhealthy {
+		log.Warnf("parent %v failed health check, marking unhealthy\n", parent)
+		s.unhealthy[parent] = struct{}{}
+	}
+}
+
+// nextRoundRobin returns the next value of the rule's round robin counter.
+func (s *ParentStatus) nextRoundRobin() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.AddUint64(&s.roundRobin, 1) - 1
+}
+
+// ParentOrder returns the indices of the rule's To parents, in the order a request should try them, for parent selection types other than consistent hash. The order is created once per request, and its first parent which isn't down is requested, then the next on failure, and so on.
+// Consistent hash rules return nil, because their order is determined by the request URI's position in the hash ring.
+func (r RemapRule) ParentOrder() []int {
+	order := make([]int, len(r.To))
+	switch *r.ParentSelection {
+	case ParentSelectionTypeRoundRobin:
+		start := int(r.ParentStatus.nextRoundRobin() % uint64(len(r.To)))
+		for i := range order {
+			order[i] = (start + i) % len(r.To)
+		}
+	case ParentSelectionTypeWeightedRandom:
+		// Weighted random sampling without replacement: each parent's key is an exponential random variable with its weight as the rate, so lower keys are proportionally more likely for higher weights. Parents with a weight of 0 are only tried last.
+		keys := make([]float64, len(r.To))
+		for i, to := range r.To {
+			order[i] = i
+			keys[i] = math.Inf(1)
+			if to.Weight != nil && *to.Weight > 0 {
+				keys[i] = rand.ExpFloat64() / *to.Weight
+			}
+		}
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	case ParentSelectionTypeFirstAvailable:
+		for i := range order {
+			order[i] = i
+		}
+	default:
+		return nil
+	}
+	return order
+}
+
+// uriGetToOrdered is a helper func for URI, uriGetTo. It returns the To URL at the given number of failures into the given parent order, skipping parents which are marked down. If all parents are down, none are skipped. Also returns the Proxy URI (if any).
+func (r RemapRule) uriGetToOrdered(order []int, failures int) (string, *url.URL, *http.Transport) {
+	if len(order) == 0 {
+		log.Errorf("RemapRule.URI: Rule '%v': Parent Selection Type %v, but no parent order! Using first parent\n", r.Name, *r.ParentSelection)
+		return r.To[0].URL, r.To[0].ProxyURL, r.To[0].Transport
+	}
+	up := make([]int, 0, len(order))
+	for _, i := range order {
+		if !r.ParentStatus.Down(r.To[i].URL) {
+			up = append(up, i)
+		}
+	}
+	if len(up) == 0 {
+		log.Warnf("RemapRule.URI: Rule '%v': all parents are marked down, trying them anyway\n", r.Name)
+		up = order
+	}
+	to := r.To[up[failures%len(up)]]
+	return to.URL, to.ProxyURL, to.Transport
+}
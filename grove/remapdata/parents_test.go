@@ -0,0 +1,121 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+func makeParentsRule(selection ParentSelectionType, weights ...float64) RemapRule {
+	rule := RemapRule{ParentSelection: &selection, ParentStatus: NewParentStatus(2, time.Hour)}
+	rule.From = "http://from.example.net"
+	for i, weight := range weights {
+		w := weight
+		to := RemapRuleTo{}
+		to.URL = "http://parent" + string('a'+rune(i)) + ".example.net"
+		to.Weight = &w
+		rule.To = append(rule.To, to)
+	}
+	return rule
+}
+
+func uriParent(rule RemapRule, order []int, failures int) string {
+	_, parent, _, _ := rule.URI("http://from.example.net/foo", "/foo", failures, order)
+	return parent
+}
+
+func TestParentSelectionFirstAvailable(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeFirstAvailable, 1, 1, 1)
+	order := rule.ParentOrder()
+	for failures, expected := range []string{"http://parenta.example.net", "http://parentb.example.net", "http://parentc.example.net", "http://parenta.example.net"} {
+		if actual := uriParent(rule, order, failures); actual != expected {
+			t.Errorf("first-available parent after %v failures expected %v, actual %v", failures, expected, actual)
+		}
+	}
+}
+
+func TestParentSelectionRoundRobin(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeRoundRobin, 1, 1, 1)
+	for i, expected := range []string{"http://parenta.example.net", "http://parentb.example.net", "http://parentc.example.net", "http://parenta.example.net"} {
+		if actual := uriParent(rule, rule.ParentOrder(), 0); actual != expected {
+			t.Errorf("round-robin request %v parent expected %v, actual %v", i, expected, actual)
+		}
+	}
+
+	// retries go to the next parent, not the next request's parent
+	order := rule.ParentOrder()
+	if first, retry := uriParent(rule, order, 0), uriParent(rule, order, 1); first != "http://parentb.example.net" || retry != "http://parentc.example.net" {
+		t.Errorf("round-robin retry expected parentb then parentc, actual %v then %v", first, retry)
+	}
+}
+
+func TestParentSelectionWeightedRandom(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeWeightedRandom, 3, 1, 0)
+	counts := map[string]int{}
+	const requests = 10000
+	for i := 0; i < requests; i++ {
+		order := rule.ParentOrder()
+		if len(order) != 3 || order[2] != 2 {
+			t.Fatalf("weighted-random order expected all parents with zero weight last, actual %v", order)
+		}
+		counts[uriParent(rule, order, 0)]++
+	}
+	if a := counts["http://parenta.example.net"]; a < requests*70/100 || a > requests*80/100 {
+		t.Errorf("weighted-random parent with 3/4 of the weight expected about %v requests, actual %v", requests*3/4, a)
+	}
+	if c := counts["http://parentc.example.net"]; c != 0 {
+		t.Errorf("weighted-random parent with weight 0 expected no first requests, actual %v", c)
+	}
+}
+
+func TestParentMarkdown(t *testing.T) {
+	rule := makeParentsRule(ParentSelectionTypeFirstAvailable, 1, 1)
+	parentA, parentB := rule.To[0].URL, rule.To[1].URL
+
+	rule.ParentStatus.Failed(parentA)
+	if uriParent(rule, rule.ParentOrder(), 0) != parentA {
+		t.Errorf("parent with fewer than markdown failures expected up, actual skipped")
+	}
+	rule.ParentStatus.Failed(parentA)
+	if actual := uriParent(rule, rule.ParentOrder(), 0); actual != parentB {
+		t.Errorf("parent with markdown failures expected skipped for %v, actual %v", parentB, actual)
+	}
+
+	rule.ParentStatus.Failed(parentB)
+	rule.ParentStatus.Failed(parentB)
+	if actual := uriParent(rule, rule.ParentOrder(), 0); actual != parentA {
+		t.Errorf("all parents down expected first parent %v, actual %v", parentA, actual)
+	}
+
+	rule.ParentStatus.Succeeded(parentA)
+	rule.ParentStatus.Succeeded(parentB)
+	if actual := uriParent(rule, rule.ParentOrder(), 1); actual != parentB {
+		t.Errorf("parent after success expected up %v, actual %v", parentB, actual)
+	}
+
+	// after the markdown time, a single failure marks the parent down again
+	rule.ParentStatus = NewParentStatus(2, 10*time.Millisecond)
+	rule.ParentStatus.Failed(parentA)
+	rule.ParentStatus.Failed(parentA)
+	time.Sleep(20 * time.Millisecond)
+	if rule.ParentStatus.Down(parentA) {
+		t.Errorf("parent after markdown time expected up, actual down")
+	}
+	rule.ParentStatus.Failed(parentA)
+	if !rule.ParentStatus.Down(parentA) {
+		t.Errorf("parent failing after markdown time expected down, actual up")
+	}
+}
@@ -0,0 +1,195 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyCIDR   = "cidr"
+	RateLimitKeyHeader = "header"
+)
+
+const DefaultRateLimitCIDRv4 = 24
+const DefaultRateLimitCIDRv6 = 64
+
+// RateLimit is the config of a token bucket request rate limit. Each client, identified by the Key, may make Burst requests at once, and RequestsPerSecond requests per second after that.
+type RateLimit struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Burst is the size of each client's bucket. Defaults to RequestsPerSecond, rounded up.
+	Burst int `json:"burst"`
+	// Key identifies clients. It's "ip" for each client IP, "cidr" for each network of CIDRv4 or CIDRv6 bits, or "header" for each value of the request Header. Requests without the header are limited by their IP. Defaults to "ip".
+	Key    string `json:"key"`
+	CIDRv4 int    `json:"cidr_v4"`
+	CIDRv6 int    `json:"cidr_v6"`
+	Header string `json:"header"`
+}
+
+// RateLimiter limits the request rate of each client, with a token bucket per client. It's safe for concurrent use.
+type RateLimiter struct {
+	limit     RateLimit
+	v4Mask    net.IPMask
+	v6Mask    net.IPMask
+	refill    time.Duration // the time for an empty bucket to fill
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	m         sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with the given limit, with defaults for unset fields, or an error if the limit is invalid.
+func NewRateLimiter(limit RateLimit) (*RateLimiter, error) {
+	if limit.RequestsPerSecond <= 0 {
+		return nil, errors.New("requests per second must be positive")
+	}
+	if limit.Burst < 0 {
+		return nil, errors.New("burst must be positive")
+	}
+	if limit.Burst == 0 {
+		limit.Burst = int(limit.RequestsPerSecond)
+		if float64(limit.Burst) < limit.RequestsPerSecond {
+			limit.Burst++
+		}
+	}
+	if limit.Key == "" {
+		limit.Key = RateLimitKeyIP
+	}
+	if limit.CIDRv4 == 0 {
+		limit.CIDRv4 = DefaultRateLimitCIDRv4
+	}
+	if limit.CIDRv6 == 0 {
+		limit.CIDRv6 = DefaultRateLimitCIDRv6
+	}
+	switch limit.Key {
+	case RateLimitKeyIP, RateLimitKeyCIDR:
+	case RateLimitKeyHeader:
+		if limit.Header == "" {
+			return nil, errors.New("header key must have a header")
+		}
+	default:
+		return nil, errors.New("key must be ip, cidr, or header")
+	}
+	if limit.CIDRv4 < 0 || limit.CIDRv4 > 32 || limit.CIDRv6 < 0 || limit.CIDRv6 > 128 {
+		return nil, errors.New("cidr bits out of range")
+	}
+	return &RateLimiter{
+		limit:   limit,
+		v4Mask:  net.CIDRMask(limit.CIDRv4, 32),
+		v6Mask:  net.CIDRMask(limit.CIDRv6, 128),
+		refill:  time.Duration(float64(limit.Burst) / limit.RequestsPerSecond * float64(time.Second)),
+		buckets: map[string]*tokenBucket{},
+	}, nil
+}
+
+// key returns the client of the request.
+func (l *RateLimiter) key(r *http.Request) string {
+	if l.limit.Key == RateLimitKeyHeader {
+		if val := r.Header.Get(l.limit.Header); val != "" {
+			return "header:" + val
+		}
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if l.limit.Key != RateLimitKeyCIDR {
+		return "ip:" + ip
+	}
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return "ip:" + ip
+	}
+	if v4 := parsedIP.To4(); v4 != nil {
+		return "cidr:" + v4.Mask(l.v4Mask).String()
+	}
+	return "cidr:" + parsedIP.Mask(l.v6Mask).String()
+}
+
+// Allow takes a token from the request's client's bucket, and returns whether the request is allowed. If it isn't, it also returns the time until the client's next request is allowed.
+func (l *RateLimiter) Allow(r *http.Request, now time.Time) (bool, time.Duration) {
+	key := l.key(r)
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.sweep(now)
+	burst := float64(l.limit.Burst)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * l.limit.RequestsPerSecond
+		if bucket.tokens > burst {
+			bucket.tokens = burst
+		}
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / l.limit.RequestsPerSecond * float64(time.Second))
+}
+
+// refund returns a token taken by Allow to the request's client's bucket. It's used when another limiter rejects the request, so requests which weren't served don't count against this limit.
+func (l *RateLimiter) refund(r *http.Request) {
+	key := l.key(r)
+	l.m.Lock()
+	defer l.m.Unlock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		return
+	}
+	bucket.tokens++
+	if burst := float64(l.limit.Burst); bucket.tokens > burst {
+		bucket.tokens = burst
+	}
+}
+
+// sweep removes the buckets which have refilled, at most once per refill time, so clients which stop requesting don't use memory forever. It must be called with the lock held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.refill {
+		return
+	}
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= l.refill {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// RateLimited returns whether any of the rule's rate limiters limit the request, and if so, the time until the client's next request is allowed. A limited request takes no tokens, from any limiter.
+func (r RemapRule) RateLimited(req *http.Request, now time.Time) (time.Duration, bool) {
+	for i, limiter := range r.RateLimiters {
+		if ok, retryAfter := limiter.Allow(req, now); !ok {
+			for _, allowed := range r.RateLimiters[:i] {
+				allowed.refund(req)
+			}
+			return retryAfter, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,141 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	limiter, err := NewRateLimiter(RateLimit{RequestsPerSecond: 2, Burst: 3})
+	if err != nil {
+		t.Fatalf("NewRateLimiter error expected nil, actual %v", err)
+	}
+	r := httptest.NewRequest("GET", "/foo", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	now := time.Unix(1500000000, 0)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow(r, now); !ok {
+			t.Fatalf("request %v within burst expected allowed, actual limited", i)
+		}
+	}
+	ok, retryAfter := limiter.Allow(r, now)
+	if ok {
+		t.Fatalf("request exceeding burst expected limited, actual allowed")
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("limited request retry after expected %v, actual %v", 500*time.Millisecond, retryAfter)
+	}
+
+	other := httptest.NewRequest("GET", "/foo", nil)
+	other.RemoteAddr = "192.0.2.2:12345"
+	if ok, _ := limiter.Allow(other, now); !ok {
+		t.Errorf("other client expected allowed, actual limited")
+	}
+
+	if ok, _ := limiter.Allow(r, now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("request after refill expected allowed, actual limited")
+	}
+	if ok, _ := limiter.Allow(r, now.Add(500*time.Millisecond)); ok {
+		t.Errorf("request after refill of one token expected limited, actual allowed")
+	}
+
+	limiter.Allow(r, now.Add(time.Hour))
+	if len(limiter.buckets) != 1 {
+		t.Errorf("buckets after refill time expected swept to 1, actual %v", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	tests := []struct {
+		limit    RateLimit
+		addrA    string
+		addrB    string
+		headerA  string
+		headerB  string
+		expected bool // whether A and B share a bucket
+	}{
+		{RateLimit{}, "192.0.2.1:1", "192.0.2.1:2", "", "", true},
+		{RateLimit{}, "192.0.2.1:1", "192.0.2.2:1", "", "", false},
+		{RateLimit{Key: RateLimitKeyCIDR}, "192.0.2.1:1", "192.0.2.200:1", "", "", true},
+		{RateLimit{Key: RateLimitKeyCIDR}, "192.0.2.1:1", "192.0.3.1:1", "", "", false},
+		{RateLimit{Key: RateLimitKeyCIDR, CIDRv6: 48}, "[2001:db8:0:1::1]:1", "[2001:db8:0:2::1]:1", "", "", true},
+		{RateLimit{Key: RateLimitKeyHeader, Header: "X-Key"}, "192.0.2.1:1", "192.0.2.2:1", "a", "a", true},
+		{RateLimit{Key: RateLimitKeyHeader, Header: "X-Key"}, "192.0.2.1:1", "192.0.2.1:1", "a", "b", false},
+		{RateLimit{Key: RateLimitKeyHeader, Header: "X-Key"}, "192.0.2.1:1", "192.0.2.1:2", "", "", true},
+	}
+	for i, test := range tests {
+		test.limit.RequestsPerSecond = 1
+		limiter, err := NewRateLimiter(test.limit)
+		if err != nil {
+			t.Fatalf("test %v NewRateLimiter error expected nil, actual %v", i, err)
+		}
+		a, b := httptest.NewRequest("GET", "/", nil), httptest.NewRequest("GET", "/", nil)
+		a.RemoteAddr, b.RemoteAddr = test.addrA, test.addrB
+		if test.headerA != "" {
+			a.Header.Set("X-Key", test.headerA)
+		}
+		if test.headerB != "" {
+			b.Header.Set("X-Key", test.headerB)
+		}
+		if actual := limiter.key(a) == limiter.key(b); actual != test.expected {
+			t.Errorf("test %v clients %v and %v expected same key %v, actual %v", i, limiter.key(a), limiter.key(b), test.expected, actual)
+		}
+	}
+
+	for _, invalid := range []RateLimit{{}, {RequestsPerSecond: 1, Key: "foo"}, {RequestsPerSecond: 1, Key: RateLimitKeyHeader}, {RequestsPerSecond: 1, CIDRv4: 33}} {
+		if _, err := NewRateLimiter(invalid); err == nil {
+			t.Errorf("NewRateLimiter %+v expected error, actual nil", invalid)
+		}
+	}
+}
+
+func TestRemapRuleRateLimitedRefund(t *testing.T) {
+	global, err := NewRateLimiter(RateLimit{RequestsPerSecond: 1, Burst: 3})
+	if err != nil {
+		t.Fatalf("NewRateLimiter error expected nil, actual %v", err)
+	}
+	ruleLimiter, err := NewRateLimiter(RateLimit{RequestsPerSecond: 1, Burst: 1})
+	if err != nil {
+		t.Fatalf("NewRateLimiter error expected nil, actual %v", err)
+	}
+	limited := RemapRule{RateLimiters: []*RateLimiter{global, ruleLimiter}}
+	other := RemapRule{RateLimiters: []*RateLimiter{global}}
+	r := httptest.NewRequest("GET", "/foo", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	now := time.Unix(1500000000, 0)
+
+	if _, limited := limited.RateLimited(r, now); limited {
+		t.Fatalf("request within both limits expected allowed, actual limited")
+	}
+	for i := 0; i < 5; i++ {
+		if _, limited := limited.RateLimited(r, now); !limited {
+			t.Fatalf("request %v exceeding rule limit expected limited, actual allowed", i)
+		}
+	}
+
+	// The requests rejected by the rule's limiter didn't take the global limiter's tokens, so the client may still make 2 requests to other rules.
+	for i := 0; i < 2; i++ {
+		if _, limited := other.RateLimited(r, now); limited {
+			t.Errorf("request %v to other rule within global limit expected allowed, actual limited", i)
+		}
+	}
+	if _, limited := other.RateLimited(r, now); !limited {
+		t.Errorf("request to other rule exceeding global limit expected limited, actual allowed")
+	}
+}
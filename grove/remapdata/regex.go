@@ -0,0 +1,168 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ruleRegexes are the compiled regexes of a rule, and the names of their capture groups.
+type ruleRegexes struct {
+	host   *regexp.Regexp
+	path   *regexp.Regexp
+	header *regexp.Regexp
+	names  map[string]int // the index in the captures of each named group
+}
+
+// CompileRegexes compiles the rule's HostRegex, PathRegex, and HeaderRegex, and must be called before Match. Rules without regexes are matched by their From prefix alone.
+func (r *RemapRule) CompileRegexes() error {
+	if r.HostRegex == "" && r.PathRegex == "" && r.HeaderRegex == "" {
+		r.regexes = nil
+		return nil
+	}
+	if (r.Header == "") != (r.HeaderRegex == "") {
+		return errors.New("header and header regex must both be set")
+	}
+	regexes := &ruleRegexes{names: map[string]int{}}
+	numCaptures := 0
+	compile := func(name string, s string) (*regexp.Regexp, error) {
+		if s == "" {
+			return nil, nil
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, errors.New("compiling " + name + " regex: " + err.Error())
+		}
+		for i, groupName := range re.SubexpNames()[1:] {
+			if groupName != "" {
+				regexes.names[groupName] = numCaptures + i
+			}
+		}
+		numCaptures += re.NumSubexp()
+		return re, nil
+	}
+	err := error(nil)
+	if regexes.host, err = compile("host", r.HostRegex); err != nil {
+		return err
+	}
+	if regexes.path, err = compile("path", r.PathRegex); err != nil {
+		return err
+	}
+	if regexes.header, err = compile("header", r.HeaderRegex); err != nil {
+		return err
+	}
+	r.regexes = regexes
+	return nil
+}
+
+// HasRegexes returns whether the rule has compiled regexes, and so must be matched with Match rather than by its From prefix.
+func (r RemapRule) HasRegexes() bool {
+	return r.regexes != nil
+}
+
+// Match returns whether the request, whose full URI is uri, matches the rule. A request matches if its URI starts with the rule's From, and its host, path, and Header match each of the rule's regexes.
+// For rules with regexes, the returned rule is a copy for the request, whose From is the request's scheme and host, so the request's whole path is appended to the To URL, and whose To URLs are expanded with the regexes' capture groups by Expand.
+func (r RemapRule) Match(req *http.Request, uri string) (RemapRule, bool) {
+	if !strings.HasPrefix(uri, r.From) {
+		return RemapRule{}, false
+	}
+	if r.regexes == nil {
+		return r, true
+	}
+	captures := []string{}
+	match := func(re *regexp.Regexp, s string) bool {
+		if re == nil {
+			return true
+		}
+		submatches := re.FindStringSubmatch(s)
+		if submatches == nil {
+			return false
+		}
+		captures = append(captures, submatches[1:]...)
+		return true
+	}
+	if !match(r.regexes.host, req.Host) || !match(r.regexes.path, req.URL.Path) {
+		return RemapRule{}, false
+	}
+	if r.regexes.header != nil && !match(r.regexes.header, req.Header.Get(r.Header)) {
+		return RemapRule{}, false
+	}
+	r.From = uri[:strings.Index(uri, "://")+len("://")] + req.Host
+	r.captures = captures
+	return r, true
+}
+
+// ExpandsCaptures returns whether the given To URL references the rule's regex capture groups, and so has no URL of its own outside a request.
+func (r RemapRule) ExpandsCaptures(to string) bool {
+	if r.regexes == nil {
+		return false
+	}
+	return r.Expand(to) != strings.Replace(to, "$$", "$", -1)
+}
+
+// Expand returns the given To URL, with the capture group references of the request's regex matches replaced with their values. References are `$1` or `${1}` for numbered groups, which are numbered across the host, path, and header regexes in that order, and `${name}` for named groups. `$$` is a literal `$`. Rules without regexes return the URL unchanged.
+func (r RemapRule) Expand(to string) string {
+	if r.regexes == nil || !strings.Contains(to, "$") {
+		return to
+	}
+	capture := func(ref string) string {
+		i, err := strconv.Atoi(ref)
+		if err != nil {
+			named, ok := r.regexes.names[ref]
+			if !ok {
+				return ""
+			}
+			i = named + 1
+		}
+		if i < 1 || i > len(r.captures) {
+			return ""
+		}
+		return r.captures[i-1]
+	}
+	expanded := make([]byte, 0, len(to))
+	for i := 0; i < len(to); i++ {
+		if to[i] != '$' || i+1 == len(to) {
+			expanded = append(expanded, to[i])
+			continue
+		}
+		switch next := to[i+1]; {
+		case next == '$':
+			expanded = append(expanded, '$')
+			i++
+		case next == '{':
+			end := strings.Index(to[i:], "}")
+			if end == -1 {
+				expanded = append(expanded, to[i:]...)
+				return string(expanded)
+			}
+			expanded = append(expanded, capture(to[i+2:i+end])...)
+			i += end
+		case next >= '0' && next <= '9':
+			end := i + 1
+			for end < len(to) && to[end] >= '0' && to[end] <= '9' {
+				end++
+			}
+			expanded = append(expanded, capture(to[i+1:end])...)
+			i = end - 1
+		default:
+			expanded = append(expanded, to[i])
+		}
+	}
+	return string(expanded)
+}
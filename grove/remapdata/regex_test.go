@@ -0,0 +1,140 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func makeRegexRule(t *testing.T, from string, to string, hostRegex string, pathRegex string) RemapRule {
+	rule := makeParentsRule(ParentSelectionTypeFirstAvailable, 1)
+	rule.From = from
+	rule.To[0].URL = to
+	rule.HostRegex = hostRegex
+	rule.PathRegex = pathRegex
+	if err := rule.CompileRegexes(); err != nil {
+		t.Fatalf("compiling regexes expected no error, actual %v", err)
+	}
+	return rule
+}
+
+func TestRegexMatch(t *testing.T) {
+	rule := makeRegexRule(t, "http://", "http://origin-$1.example.net/${dir}", `^([a-z]+)\.cdn\.example\.net$`, `^/(?P<dir>[a-z]+)/`)
+
+	r := httptest.NewRequest("GET", "/images/a.png?x=1", nil)
+	r.Host = "foo.cdn.example.net"
+	matched, ok := rule.Match(r, "http://foo.cdn.example.net/images/a.png?x=1")
+	if !ok {
+		t.Fatalf("request matching host and path regexes expected match, actual no match")
+	}
+	if expected := "http://foo.cdn.example.net"; matched.From != expected {
+		t.Errorf("matched rule from expected %v, actual %v", expected, matched.From)
+	}
+	uri, parent, _, _ := matched.URI("http://foo.cdn.example.net/images/a.png?x=1", "/images/a.png?x=1", 0, matched.ParentOrder())
+	if expected := "http://origin-foo.example.net/images/images/a.png"; uri != expected {
+		t.Errorf("matched rule URI expected %v, actual %v", expected, uri)
+	}
+	if parent != rule.To[0].URL {
+		t.Errorf("matched rule parent expected the unexpanded to %v, actual %v", rule.To[0].URL, parent)
+	}
+	if expected := "GET:http://origin-foo.example.net/images/images/a.png"; matched.CacheKey("GET", "http://foo.cdn.example.net/images/a.png?x=1", nil) != expected {
+		t.Errorf("matched rule cache key expected %v, actual %v", expected, matched.CacheKey("GET", "http://foo.cdn.example.net/images/a.png?x=1", nil))
+	}
+
+	noMatch := map[string]string{
+		"https://foo.cdn.example.net/images/a.png": "foo.cdn.example.net", // from prefix
+		"http://foo.cdn.example.org/images/a.png":  "foo.cdn.example.org", // host
+		"http://foo.cdn.example.net/IMAGES/a.png":  "foo.cdn.example.net", // path
+	}
+	for uri, host := range noMatch {
+		r := httptest.NewRequest("GET", uri, nil)
+		r.Host = host
+		if _, ok := rule.Match(r, uri); ok {
+			t.Errorf("request %v expected no match, actual match", uri)
+		}
+	}
+
+	rule.Header = "X-Tenant"
+	rule.HeaderRegex = "^(a|b)$"
+	if err := rule.CompileRegexes(); err != nil {
+		t.Fatalf("compiling header regex expected no error, actual %v", err)
+	}
+	if _, ok := rule.Match(r, "http://foo.cdn.example.net/images/a.png?x=1"); ok {
+		t.Errorf("request without the header expected no match, actual match")
+	}
+	r.Header.Set("X-Tenant", "b")
+	if matched, ok := rule.Match(r, "http://foo.cdn.example.net/images/a.png?x=1"); !ok {
+		t.Errorf("request with the header expected match, actual no match")
+	} else if expected := "b"; matched.Expand("$3") != expected {
+		t.Errorf("header capture expected numbered after host and path captures %v, actual %v", expected, matched.Expand("$3"))
+	}
+
+	literal := makeRegexRule(t, "http://foo.example.net", "http://origin.example.net", "", "")
+	if literal.HasRegexes() {
+		t.Errorf("rule without regexes expected no regexes, actual regexes")
+	}
+	if matched, ok := literal.Match(r, "http://foo.example.net/a"); !ok || matched.From != "http://foo.example.net" {
+		t.Errorf("literal rule expected prefix match with unchanged from, actual %v %v", ok, matched.From)
+	}
+}
+
+func TestRegexExpand(t *testing.T) {
+	rule := makeRegexRule(t, "http://", "", `^(a)(b)`, `(?P<c>c)`)
+	r := httptest.NewRequest("GET", "/c", nil)
+	r.Host = "ab"
+	matched, ok := rule.Match(r, "http://ab/c")
+	if !ok {
+		t.Fatalf("expected match, actual no match")
+	}
+	tests := map[string]string{
+		"$1$2":          "ab",
+		"${1}0":         "a0",
+		"$10":           "",
+		"${c}-$3":       "c-c",
+		"$$1":           "$1",
+		"${missing}x":   "x",
+		"x$":            "x$",
+		"${1":           "${1",
+		"http://$2.net": "http://b.net",
+	}
+	for to, expected := range tests {
+		if actual := matched.Expand(to); actual != expected {
+			t.Errorf("expand '%v' expected '%v', actual '%v'", to, expected, actual)
+		}
+	}
+}
+
+func TestRegexExpandsCaptures(t *testing.T) {
+	rule := makeRegexRule(t, "http://", "", `^(a)(b)`, `(?P<c>c)`)
+	tests := map[string]bool{
+		"http://$1.net":         true,
+		"http://${c}.net":       true,
+		"http://x.net/${1":      false,
+		"http://x.net/$$1":      false,
+		"http://x.net/$$$1":     true,
+		"http://x.net/$x":       false,
+		"http://origin.net":     false,
+		"http://${missing}.net": true,
+	}
+	for to, expected := range tests {
+		if actual := rule.ExpandsCaptures(to); actual != expected {
+			t.Errorf("expands captures '%v' expected %v, actual %v", to, expected, actual)
+		}
+	}
+	if noRegexes := makeParentsRule(ParentSelectionTypeFirstAvailable, 1); noRegexes.ExpandsCaptures("http://$1.net") {
+		t.Errorf("expands captures of rule without regexes expected false, actual true")
+	}
+}
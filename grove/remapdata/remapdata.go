@@ -26,6 +26,8 @@ import (
 
 	"github.com/apache/incubator-trafficcontrol/grove/chash"
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
+	"github.com/apache/incubator-trafficcontrol/grove/thread"
+	"github.com/apache/incubator-trafficcontrol/grove/web"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
 )
@@ -46,6 +48,8 @@ type ParentSelectionType string
 const (
 	ParentSelectionTypeConsistentHash = ParentSelectionType("consistent-hash")
 	ParentSelectionTypeRoundRobin     = ParentSelectionType("round-robin")
+	ParentSelectionTypeWeightedRandom = ParentSelectionType("weighted-random")
+	ParentSelectionTypeFirstAvailable = ParentSelectionType("first-available")
 	ParentSelectionTypeInvalid        = ParentSelectionType("")
 )
 
@@ -55,6 +59,10 @@ func (t ParentSelectionType) String() string {
 		return "consistent-hash"
 	case ParentSelectionTypeRoundRobin:
 		return "round-robin"
+	case ParentSelectionTypeWeightedRandom:
+		return "weighted-random"
+	case ParentSelectionTypeFirstAvailable:
+		return "first-available"
 	default:
 		return "invalid"
 	}
@@ -68,6 +76,12 @@ func ParentSelectionTypeFromString(s string) ParentSelectionType {
 	if s == "round-robin" {
 		return ParentSelectionTypeRoundRobin
 	}
+	if s == "weighted-random" {
+		return ParentSelectionTypeWeightedRandom
+	}
+	if s == "first-available" {
+		return ParentSelectionTypeFirstAvailable
+	}
 	return ParentSelectionTypeInvalid
 }
 
@@ -108,6 +122,40 @@ type RemapRuleBase struct {
 	RetryNum               *int                       `json:"retry_num"`
 	DSCP                   int                        `json:"dscp"`
 	PluginsShared          map[string]json.RawMessage `json:"plugins_shared"`
+	// ParentMarkdownFailures is the number of consecutive failures after which a parent is marked down, and skipped for ParentMarkdownMS. If this is nil, the rules config is used. If it's 0, parents are never marked down.
+	ParentMarkdownFailures *int `json:"parent_markdown_failures"`
+	ParentMarkdownMS       *int `json:"parent_markdown_ms"`
+	// HealthCheck is the active health check of the rule's parents. If this is nil, the rules config is used. If both are nil, parents are not health checked.
+	HealthCheck *HealthCheck `json:"health_check"`
+	// StaleWhileRevalidateMS and StaleIfErrorMS are the RFC5861 stale-while-revalidate and stale-if-error windows, for responses without the Cache-Control directives. If these are nil, the rules config is used. If both are nil, responses without the directives are never served stale by them.
+	StaleWhileRevalidateMS *int `json:"stale_while_revalidate_ms"`
+	StaleIfErrorMS         *int `json:"stale_if_error_ms"`
+	// Revalidate are the rule's invalidations, such as Traffic Ops invalidation jobs.
+	Revalidate []RevalidateRule `json:"revalidate"`
+	// Siblings are the peer caches to request cache misses from, before the parent. If this is nil, the rules config is used. If both are nil, misses are requested from the parent.
+	Siblings *Siblings `json:"siblings"`
+	// RateLimit is the request rate limit of each client of the rule. It's in addition to the rules config limit, which limits each client across all rules.
+	RateLimit *RateLimit `json:"rate_limit"`
+	// HostRegex, PathRegex, and HeaderRegex are regexes the request's Host, path, and Header value must match, in addition to the From prefix. Their capture groups may be referenced in the To URLs. See Match.
+	HostRegex   string `json:"host_regex"`
+	PathRegex   string `json:"path_regex"`
+	Header      string `json:"header"`
+	HeaderRegex string `json:"header_regex"`
+	// CacheKeyPolicy customizes the rule's cache keys, and ConsistentHashKeyPolicy the keys its requests are consistent hashed to parents by. If nil, keys are the URL, with the query string if the QueryString rule caches or remaps it.
+	CacheKeyPolicy          *CacheKeyPolicy `json:"cache_key"`
+	ConsistentHashKeyPolicy *CacheKeyPolicy `json:"consistent_hash_key"`
+	// PrefetchSegments is the number of segments after each requested HLS or DASH segment to prefetch into the cache, from the manifests requested through the rule which list the segment. If 0, segments aren't prefetched.
+	PrefetchSegments int `json:"prefetch_segments"`
+
+	CacheOverrides *CacheOverrides `json:"cache_overrides"`
+
+	CollapsedForwarding *CollapsedForwarding `json:"collapsed_forwarding"`
+}
+
+// CollapsedForwarding configures how a rule's concurrent requests for the same object are collapsed into a single parent request. The Mode is a thread.CollapseMode, and MaxWaitMS is the max wait of the "max-wait" mode.
+type CollapsedForwarding struct {
+	Mode      string `json:"mode"`
+	MaxWaitMS int    `json:"max_wait_ms"`
 }
 
 type RemapRule struct {
@@ -119,8 +167,19 @@ type RemapRule struct {
 	Deny            []*net.IPNet
 	RetryCodes      map[int]struct{}
 	ConsistentHash  chash.ATSConsistentHash
-	Cache           icache.Cache
-	Plugins         map[string]interface{}
+	ParentStatus    *ParentStatus
+	// StaleWhileRevalidate and StaleIfError are the default RFC5861 windows, for responses without the Cache-Control directives.
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	Cache                icache.Cache
+	Plugins              map[string]interface{}
+	// RateLimiters are the rate limiters of the rule's requests, the global limiter shared by every rule, and the rule's own.
+	RateLimiters []*RateLimiter
+	regexes      *ruleRegexes
+	// captures are the values of the regex capture groups of the request, in the copy of a rule returned by Match.
+	captures []string
+	// Getter collapses the rule's concurrent parent requests for the same cache key. It's shared by all copies of the rule.
+	Getter thread.Getter
 }
 
 func (r *RemapRule) Allowed(ip net.IP) bool {
@@ -143,29 +202,25 @@ func (r *RemapRule) Allowed(ip net.IP) bool {
 	return false
 }
 
-// URI takes a request URI and maps it to the real URI to proxy-and-cache. The `failures` parameter indicates how many parents have tried and failed, indicating to skip to the nth hashed parent. Returns the URI to request, and the proxy URL (if any)
-func (r RemapRule) URI(fromURI string, path string, query string, failures int) (string, *url.URL, *http.Transport) {
-	fromHash := path
-	if r.QueryString.Remap && query != "" {
-		fromHash += "?" + query
-	}
-
-	// fmt.Println("RemapRule.URI fromURI " + fromHash)
-	to, proxyURI, transport := r.uriGetTo(fromHash, failures)
-	uri := to + fromURI[len(r.From):]
+// URI takes a request URI and maps it to the real URI to proxy-and-cache. For rules with regexes, the rule must be the copy returned by Match, and the To URL is expanded with the request's capture groups. The `hashKey` is the request's ConsistentHashKey. The `failures` parameter indicates how many parents have tried and failed, indicating to skip to the nth hashed or ordered parent. The `order` is the request's parent order from ParentOrder, which is nil for consistent hash rules. Parents marked down by the rule's ParentStatus are skipped. Returns the URI to request, the To URL of the parent, and the proxy URL (if any)
+func (r RemapRule) URI(fromURI string, hashKey string, failures int, order []int) (string, string, *url.URL, *http.Transport) {
+	to, proxyURI, transport := r.uriGetTo(hashKey, failures, order)
+	uri := r.Expand(to) + fromURI[len(r.From):]
 	if !r.QueryString.Remap {
 		if i := strings.Index(uri, "?"); i != -1 {
 			uri = uri[:i]
 		}
 	}
-	return uri, proxyURI, transport
+	return uri, to, proxyURI, transport
 }
 
 // uriGetTo is a helper func for URI. It returns the To URL, based on the Parent Selection type. In the event of failure, it logs the error and returns the first parent. Also returns the URL's Proxy URI (if any).
-func (r RemapRule) uriGetTo(fromURI string, failures int) (string, *url.URL, *http.Transport) {
+func (r RemapRule) uriGetTo(fromURI string, failures int, order []int) (string, *url.URL, *http.Transport) {
 	switch *r.ParentSelection {
 	case ParentSelectionTypeConsistentHash:
 		return r.uriGetToConsistentHash(fromURI, failures)
+	case ParentSelectionTypeRoundRobin, ParentSelectionTypeWeightedRandom, ParentSelectionTypeFirstAvailable:
+		return r.uriGetToOrdered(order, failures)
 	default:
 		log.Errorf("RemapRule.URI: Rule '%v': Unknown Parent Selection type %v - using first URI in rule\n", r.Name, r.ParentSelection)
 		return r.To[0].URL, r.To[0].ProxyURL, r.To[0].Transport
@@ -195,19 +250,25 @@ func (r RemapRule) uriGetToConsistentHash(fromURI string, failures int) (string,
 		iter = iter.NextWrap()
 	}
 
+	// skip parents which are marked down, unless the ring wraps around to this parent, meaning all parents are down
+	for start := iter.Index(); r.ParentStatus.Down(iter.Val().Name); {
+		next := iter.NextWrap()
+		if next.Index() == start {
+			log.Warnf("RemapRule.URI: Rule '%v': all parents are marked down, trying them anyway\n", r.Name)
+			break
+		}
+		iter = next
+	}
+
 	return iter.Val().Name, iter.Val().ProxyURL, iter.Val().Transport
 }
 
-func (r RemapRule) CacheKey(method string, fromURI string) string {
+// CacheKey returns the cache key of the request with the given method, full URI, and headers. The key is the method and the URL of the rule's first parent, with the rule's CacheKeyPolicy applied.
+func (r RemapRule) CacheKey(method string, fromURI string, reqHdr http.Header) string {
 	// TODO don't cache on `to`, since it's affected by Parent Selection
 	// TODO add parent selection
-	to := r.To[0].URL
-	uri := to + fromURI[len(r.From):]
-	if !r.QueryString.Cache {
-		if i := strings.Index(uri, "?"); i != -1 {
-			uri = uri[:i]
-		}
-	}
+	to := r.Expand(r.To[0].URL)
+	uri := r.CacheKeyPolicy.apply(to+fromURI[len(r.From):], r.QueryString.Cache, reqHdr)
 	if method == http.MethodHead { // HEAD uses the same key as GET
 		method = http.MethodGet
 	}
@@ -215,6 +276,47 @@ func (r RemapRule) CacheKey(method string, fromURI string) string {
 	return key
 }
 
+// CacheKeyURL returns the URL of the given cache key, as created by CacheKey or VariantCacheKey, without its method, variant, or the headers and cookies of its CacheKeyPolicy.
+func CacheKeyURL(key string) string {
+	key = CacheKeyBase(key)
+	if i := strings.Index(key, CacheKeyPartSeparator); i != -1 {
+		key = key[:i]
+	}
+	if i := strings.Index(key, ":"); i != -1 && !strings.HasPrefix(key[i:], "://") {
+		return key[i+1:]
+	}
+	return key
+}
+
+// VariantKeySeparator separates a cache key from the request header values of a variant, in keys created by VariantCacheKey. It contains a space, which can't occur in a request URI, so variant keys never collide with the keys of URLs.
+const VariantKeySeparator = " vary:"
+
+// VariantCacheKey returns the cache key of the variant of the object at the given key, for a response which varies on the given request headers. The key contains the normalized values of the headers in the given request, so requests whose values normalize the same share a variant, per RFC 7234§4.1.
+func VariantCacheKey(key string, reqHdr http.Header, varyHeaders []string) string {
+	vals := make([]string, 0, len(varyHeaders))
+	for _, name := range varyHeaders {
+		vals = append(vals, url.QueryEscape(name)+"="+url.QueryEscape(web.NormalizeVaryValue(reqHdr, name)))
+	}
+	return CacheKeyBase(key) + VariantKeySeparator + strings.Join(vals, "&")
+}
+
+// EncodingCacheKey returns the cache key of the representation of the object at the given key with the given content coding, such as a compressed copy of an identity response. The given key may be a base or variant key. Encodings are variants, so CacheKeyBase returns the base key of their object.
+func EncodingCacheKey(key string, encoding string) string {
+	encodingVal := "content-encoding=" + url.QueryEscape(encoding)
+	if strings.Contains(key, VariantKeySeparator) {
+		return key + "&" + encodingVal
+	}
+	return key + VariantKeySeparator + encodingVal
+}
+
+// CacheKeyBase returns the key of the object a variant key, created by VariantCacheKey, is a variant of. Keys which aren't variants are returned unchanged.
+func CacheKeyBase(key string) string {
+	if i := strings.Index(key, VariantKeySeparator); i != -1 {
+		return key[:i]
+	}
+	return key
+}
+
 type RemapRuleToBase struct {
 	URL      string   `json:"url"`
 	Weight   *float64 `json:"weight"`
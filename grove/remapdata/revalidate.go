@@ -0,0 +1,61 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"regexp"
+	"time"
+)
+
+// RevalidateRule invalidates cached objects, like the ATS regex_revalidate plugin. From Start until Expires, objects whose request path and query match the Regex, and which were fetched from the parent before Start, are stale, and revalidated with the parent. Objects fetched after Start are unaffected, so each object is only revalidated once.
+type RevalidateRule struct {
+	// Regex is matched against the request path and query, for example `^/images/.*\.png`.
+	Regex   string    `json:"regex"`
+	Start   time.Time `json:"start"`
+	Expires time.Time `json:"expires"`
+	regex   *regexp.Regexp
+}
+
+// Compile compiles the rule's Regex, and must be called before Invalidates. If the rule has no Start, it starts at the given time.
+func (r *RevalidateRule) Compile(now time.Time) error {
+	if r.Expires.IsZero() {
+		return errors.New("must have an expires")
+	}
+	if r.Start.IsZero() {
+		r.Start = now
+	}
+	regex, err := regexp.Compile(r.Regex)
+	if err != nil {
+		return errors.New("compiling regex: " + err.Error())
+	}
+	r.regex = regex
+	return nil
+}
+
+// Invalidates returns whether the rule makes the object with the given request path and query, fetched from the parent at the given time, stale.
+func (r RevalidateRule) Invalidates(pathQuery string, fetched time.Time, now time.Time) bool {
+	return r.regex != nil && !now.Before(r.Start) && now.Before(r.Expires) && fetched.Before(r.Start) && r.regex.MatchString(pathQuery)
+}
+
+// Invalidated returns whether any of the rule's revalidate rules make the object with the given request path and query, fetched from the parent at the given time, stale.
+func (r RemapRule) Invalidated(pathQuery string, fetched time.Time, now time.Time) bool {
+	for _, reval := range r.Revalidate {
+		if reval.Invalidates(pathQuery, fetched, now) {
+			return true
+		}
+	}
+	return false
+}
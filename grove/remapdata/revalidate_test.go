@@ -0,0 +1,60 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevalidateRule(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	reval := RevalidateRule{Regex: `^/images/.*\.png`, Start: start, Expires: start.Add(time.Hour)}
+	if err := reval.Compile(time.Now()); err != nil {
+		t.Fatalf("RevalidateRule.Compile expected nil error, actual %v", err)
+	}
+
+	before, after := start.Add(-time.Minute), start.Add(time.Minute)
+	tests := []struct {
+		path     string
+		fetched  time.Time
+		now      time.Time
+		expected bool
+	}{
+		{"/images/a.png", before, after, true},
+		{"/images/a.png?x=1", before, after, true},
+		{"/images/a.png", after, after, false},                     // fetched after the rule started
+		{"/images/a.png", before, start.Add(2 * time.Hour), false}, // expired
+		{"/images/a.png", before.Add(-time.Hour), before, false},   // not yet started
+		{"/other/images/a.png", before, after, false},
+		{"/images/a.jpg", before, after, false},
+	}
+	for _, test := range tests {
+		if actual := reval.Invalidates(test.path, test.fetched, test.now); actual != test.expected {
+			t.Errorf("RevalidateRule.Invalidates(%v, %v, %v) expected %v, actual %v", test.path, test.fetched, test.now, test.expected, actual)
+		}
+	}
+
+	if err := (&RevalidateRule{Regex: `^/`}).Compile(time.Now()); err == nil {
+		t.Errorf("RevalidateRule.Compile without expires expected error, actual nil")
+	}
+	if err := (&RevalidateRule{Regex: `(`, Expires: start}).Compile(time.Now()); err == nil {
+		t.Errorf("RevalidateRule.Compile invalid regex expected error, actual nil")
+	}
+	noStart := RevalidateRule{Regex: `^/`, Expires: start.Add(time.Hour)}
+	if noStart.Compile(start); !noStart.Start.Equal(start) {
+		t.Errorf("RevalidateRule.Compile without start expected start %v, actual %v", start, noStart.Start)
+	}
+}
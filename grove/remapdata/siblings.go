@@ -0,0 +1,120 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/chash"
+)
+
+// SiblingHeader is set on requests to a sibling, to the requesting sibling's Self. Requests with it are never sent to another sibling, so a sibling which disagrees about the owner of a key can't loop.
+const SiblingHeader = "X-Grove-Sibling"
+
+// DefaultSiblingTimeoutMS is the timeout of sibling requests, if the Siblings have no timeout.
+const DefaultSiblingTimeoutMS = 1000
+
+// SiblingReplicas is the number of points of each peer in the sibling hash ring.
+const SiblingReplicas = 1024
+
+// Siblings are the peer caches of a tier, such as a cachegroup, which share their caches. On a cache miss, the cache key is consistent-hashed over the Peers, and if the owning peer isn't Self, the object is requested from it, rather than the parent. Thus each object is only requested from the parent by its owner, and the tier acts as one distributed cache.
+type Siblings struct {
+	// Self is the URL of this cache, as it appears in Peers.
+	Self string `json:"self"`
+	// Peers are the URLs of every sibling, including Self, such as `http://grove1.example.net:8080`. All peers must have the same scheme.
+	Peers []string `json:"peers"`
+	// TimeoutMS is the timeout of requests to siblings. Defaults to DefaultSiblingTimeoutMS.
+	TimeoutMS int `json:"timeout_ms"`
+
+	scheme    string
+	timeout   time.Duration
+	hash      chash.ATSConsistentHash
+	transport *http.Transport
+}
+
+// Compile validates the siblings and creates their hash ring, and must be called before Owner. Requests to siblings use the given transport.
+func (s *Siblings) Compile(transport *http.Transport) error {
+	if s.TimeoutMS < 0 {
+		return errors.New("timeout must be positive")
+	}
+	s.timeout = time.Duration(DefaultSiblingTimeoutMS) * time.Millisecond
+	if s.TimeoutMS > 0 {
+		s.timeout = time.Duration(s.TimeoutMS) * time.Millisecond
+	}
+	s.Self = strings.TrimSuffix(s.Self, "/")
+	s.hash = chash.NewSimpleATSConsistentHash(SiblingReplicas)
+	s.transport = transport
+	s.scheme = ""
+	hasSelf := false
+	for i, peer := range s.Peers {
+		peer = strings.TrimSuffix(peer, "/")
+		s.Peers[i] = peer
+		peerURL, err := url.Parse(peer)
+		if err != nil || peerURL.Host == "" || (peerURL.Scheme != "http" && peerURL.Scheme != "https") {
+			return errors.New("peer '" + peer + "' must be an http or https URL")
+		}
+		if peerURL.Path != "" || peerURL.RawQuery != "" {
+			return errors.New("peer '" + peer + "' must not have a path")
+		}
+		if s.scheme == "" {
+			s.scheme = peerURL.Scheme
+		} else if peerURL.Scheme != s.scheme {
+			return errors.New("peers must all have the same scheme")
+		}
+		hasSelf = hasSelf || peer == s.Self
+		if err := s.hash.Insert(&chash.ATSConsistentHashNode{Name: peer, Transport: transport}, 1); err != nil {
+			return errors.New("adding peer '" + peer + "': " + err.Error())
+		}
+	}
+	if !hasSelf {
+		return errors.New("self '" + s.Self + "' must be in peers")
+	}
+	return nil
+}
+
+// Owner returns the peer which owns the given cache key, and false if Self owns it, or the siblings haven't been compiled.
+func (s *Siblings) Owner(cacheKey string) (string, bool) {
+	if s == nil || s.hash == nil {
+		return "", false
+	}
+	iter, _, err := s.hash.Lookup(cacheKey)
+	if err != nil {
+		return "", false
+	}
+	owner := iter.Val().Name
+	return owner, owner != s.Self
+}
+
+// Timeout returns the timeout of requests to siblings.
+func (s *Siblings) Timeout() time.Duration { return s.timeout }
+
+// Transport returns the transport of requests to siblings.
+func (s *Siblings) Transport() *http.Transport { return s.transport }
+
+// Sibling returns the sibling to request the object at the given cache key from, and whether to request it from a sibling rather than the parent. Requests aren't sent to a sibling if they came from a sibling, if this cache owns the key, if the owner is marked down, or if the rule's scheme isn't the siblings' scheme, because a sibling's rules match requests by scheme.
+func (r RemapRule) Sibling(cacheKey string, reqHdr http.Header) (string, bool) {
+	if r.Siblings == nil || reqHdr.Get(SiblingHeader) != "" || !strings.HasPrefix(r.From, r.Siblings.scheme+"://") {
+		return "", false
+	}
+	owner, ok := r.Siblings.Owner(cacheKey)
+	if !ok || r.ParentStatus.Down(owner) {
+		return "", false
+	}
+	return owner, true
+}
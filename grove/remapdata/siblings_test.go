@@ -0,0 +1,110 @@
+package remapdata
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func makeSiblingsRule(t *testing.T, self string, peers ...string) RemapRule {
+	siblings := &Siblings{Self: self, Peers: peers}
+	if err := siblings.Compile(&http.Transport{}); err != nil {
+		t.Fatalf("compiling siblings expected no error, actual %v", err)
+	}
+	rule := RemapRule{ParentStatus: NewParentStatus(1, time.Hour)}
+	rule.From = "http://from.example.net"
+	rule.Siblings = siblings
+	return rule
+}
+
+func TestSiblingsOwner(t *testing.T) {
+	peers := []string{"http://grovea.example.net", "http://groveb.example.net/", "http://grovec.example.net"}
+	rules := []RemapRule{}
+	for _, self := range []string{"http://grovea.example.net", "http://groveb.example.net", "http://grovec.example.net"} {
+		rules = append(rules, makeSiblingsRule(t, self, append([]string(nil), peers...)...))
+	}
+
+	counts := map[string]int{}
+	const keys = 3000
+	for i := 0; i < keys; i++ {
+		key := "GET:http://from.example.net/" + strconv.Itoa(i)
+		owners := 0
+		owner := ""
+		for _, rule := range rules {
+			sibling, ok := rule.Sibling(key, http.Header{})
+			if !ok {
+				owners++
+				owner = rule.Siblings.Self
+			} else if owner != "" && sibling != owner {
+				t.Fatalf("sibling of key %v expected %v, actual %v", key, owner, sibling)
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("key %v expected exactly one sibling to own it, actual %v", key, owners)
+		}
+		counts[owner]++
+	}
+	for owner, count := range counts {
+		if count < keys/3*70/100 || count > keys/3*130/100 {
+			t.Errorf("sibling %v expected about %v keys, actual %v", owner, keys/3, count)
+		}
+	}
+}
+
+func TestSiblingsSkipped(t *testing.T) {
+	rule := makeSiblingsRule(t, "http://grovea.example.net", "http://grovea.example.net", "http://groveb.example.net")
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := rule.Sibling(strconv.Itoa(i), http.Header{}); ok {
+			key = strconv.Itoa(i)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/foo", nil)
+	r.Header.Set(SiblingHeader, "http://groveb.example.net")
+	if sibling, ok := rule.Sibling(key, r.Header); ok {
+		t.Errorf("request from a sibling expected not sent to a sibling, actual %v", sibling)
+	}
+
+	httpsRule := rule
+	httpsRule.From = "https://from.example.net"
+	if sibling, ok := httpsRule.Sibling(key, http.Header{}); ok {
+		t.Errorf("rule with a scheme other than the siblings' expected not sent to a sibling, actual %v", sibling)
+	}
+
+	rule.ParentStatus.Failed("http://groveb.example.net")
+	if sibling, ok := rule.Sibling(key, http.Header{}); ok {
+		t.Errorf("sibling marked down expected skipped, actual %v", sibling)
+	}
+}
+
+func TestSiblingsCompileErrors(t *testing.T) {
+	tests := map[string]Siblings{
+		"self not in peers": {Self: "http://grovec.example.net", Peers: []string{"http://grovea.example.net"}},
+		"mixed schemes":     {Self: "http://grovea.example.net", Peers: []string{"http://grovea.example.net", "https://groveb.example.net"}},
+		"path":              {Self: "http://grovea.example.net", Peers: []string{"http://grovea.example.net", "http://groveb.example.net/foo"}},
+		"not a URL":         {Self: "grovea", Peers: []string{"grovea"}},
+		"negative timeout":  {Self: "http://grovea.example.net", Peers: []string{"http://grovea.example.net"}, TimeoutMS: -1},
+	}
+	for name, siblings := range tests {
+		if err := siblings.Compile(&http.Transport{}); err == nil {
+			t.Errorf("compiling siblings with %v expected error, actual nil", name)
+		}
+	}
+}
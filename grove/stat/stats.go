@@ -23,6 +23,7 @@ import (
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
 	"github.com/apache/incubator-trafficcontrol/grove/remapdata"
+	"github.com/apache/incubator-trafficcontrol/grove/thread"
 	"github.com/apache/incubator-trafficcontrol/grove/web"
 
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
@@ -57,21 +58,30 @@ type Stats interface {
 	CacheSize() uint64
 	CacheCapacity() uint64
 
+	// ParentHealth returns whether each parent passed its last health check, by rule name and parent URL. Only rules with a health check are included.
+	ParentHealth() map[string]map[string]bool
+	// CachePolicyStats returns the eviction policy, hits, and misses of each cache, by cache name.
+	CachePolicyStats() map[string]icache.PolicyStats
+	// CollapseStats returns the collapsed forwarding statistics of each rule, by rule name.
+	CollapseStats() map[string]thread.GetterStats
+
 	// Write writes to the remapRuleStats of s, and returns the bytes written to the connection
-	Write(w http.ResponseWriter, conn *web.InterceptConn, reqFQDN string, remoteAddr string, code int, bytesWritten uint64, cacheHit bool) uint64
+	Write(w http.ResponseWriter, reqFQDN string, remoteAddr string, code int, bytesRead uint64, bytesWritten uint64, cacheHit bool) uint64
 
 	CacheKeys(string) []string
 	CacheSizeByName(string) (uint64, bool)
 	CacheCapacityByName(string) (uint64, bool)
 	CacheNames() []string
 	CachePeek(string, string) (*cacheobj.CacheObj, bool)
+	CacheRemove(string, string) bool
 }
 
-func New(remapRules []remapdata.RemapRule, caches map[string]icache.Cache, cacheCapacityBytes uint64, httpConns *web.ConnMap, httpsConns *web.ConnMap, version string) Stats {
+// New returns a new Stats. The system stats are given, rather than created, so they may be kept across config reloads, which create new Stats for the new remap rules.
+func New(remapRules []remapdata.RemapRule, caches map[string]icache.Cache, cacheCapacityBytes uint64, httpConns *web.ConnMap, httpsConns *web.ConnMap, system StatsSystem) Stats {
 	cacheHits := uint64(0)
 	cacheMisses := uint64(0)
 	return &stats{
-		system:             NewStatsSystem(version),
+		system:             system,
 		remap:              NewStatsRemaps(remapRules),
 		cacheHits:          &cacheHits,
 		cacheMisses:        &cacheMisses,
@@ -79,32 +89,20 @@ func New(remapRules []remapdata.RemapRule, caches map[string]icache.Cache, cache
 		cacheCapacityBytes: cacheCapacityBytes,
 		httpConns:          httpConns,
 		httpsConns:         httpsConns,
+		remapRules:         remapRules,
 	}
 }
 
-// Write writes to the remapRuleStats of s, and returns the bytes written to the connection
-func (stats *stats) Write(w http.ResponseWriter, conn *web.InterceptConn, reqFQDN string, remoteAddr string, code int, bytesWritten uint64, cacheHit bool) uint64 {
+// Write writes to the remapRuleStats of s, and returns the bytes written to the connection. The bytes should be those taken from the client's web.InterceptConn, if it has one.
+func (stats *stats) Write(w http.ResponseWriter, reqFQDN string, remoteAddr string, code int, bytesRead uint64, bytesWritten uint64, cacheHit bool) uint64 {
 	remapRuleStats, ok := stats.Remap().Stats(reqFQDN)
 	if !ok {
 		log.Errorf("Remap rule %v not in Stats\n", reqFQDN)
 		return bytesWritten
 	}
 
-	if wFlusher, ok := w.(http.Flusher); !ok {
-		log.Errorf("ResponseWriter is not a Flusher, could not flush written bytes, stat out_bytes will be inaccurate!\n")
-	} else {
-		wFlusher.Flush()
-	}
-
-	bytesRead := 0 // TODO get somehow? Count body? Sum header?
-	if conn != nil {
-		bytesRead = conn.BytesRead()
-		bytesWritten = uint64(conn.BytesWritten()) // get the more accurate interceptConn bytes written, if we can
-		// Don't log - the Handler has already logged the failure to get the conn
-	}
-
 	// bytesRead, bytesWritten := getConnInfoAndDestroyWriter(w, stats, remapRuleName)
-	remapRuleStats.AddInBytes(uint64(bytesRead))
+	remapRuleStats.AddInBytes(bytesRead)
 	remapRuleStats.AddOutBytes(uint64(bytesWritten))
 
 	if cacheHit {
@@ -142,6 +140,7 @@ type stats struct {
 	cacheCapacityBytes uint64
 	httpConns          *web.ConnMap
 	httpsConns         *web.ConnMap
+	remapRules         []remapdata.RemapRule
 }
 
 func (s stats) Connections() uint64 {
@@ -187,14 +186,31 @@ func (s stats) CacheNames() []string {
 	return cNames
 }
 
-// CacheKeys returns an array of all the cache keys for the cache cacheName
+// CacheKeys returns an array of all the cache keys for the cache cacheName, or an empty array if no such cache exists.
 func (s stats) CacheKeys(cacheName string) []string {
-	return s.caches[cacheName].Keys()
+	cache, ok := s.caches[cacheName]
+	if !ok || cache == nil {
+		return []string{}
+	}
+	return cache.Keys()
 }
 
 // CachePeek returns the cached object *without* changing the recent-used-ness.
 func (s stats) CachePeek(key, cacheName string) (*cacheobj.CacheObj, bool) {
-	return s.caches[cacheName].Peek(key)
+	cache, ok := s.caches[cacheName]
+	if !ok || cache == nil {
+		return nil, false
+	}
+	return cache.Peek(key)
+}
+
+// CacheRemove removes the key from the cache cacheName, returning whether it existed.
+func (s stats) CacheRemove(key, cacheName string) bool {
+	cache, ok := s.caches[cacheName]
+	if !ok || cache == nil {
+		return false
+	}
+	return cache.Remove(key)
 }
 
 func (s stats) CacheCapacityByName(cName string) (uint64, bool) {
@@ -206,6 +222,40 @@ func (s stats) CacheCapacityByName(cName string) (uint64, bool) {
 
 func (s stats) CacheCapacity() uint64 { return s.cacheCapacityBytes }
 
+func (s stats) ParentHealth() map[string]map[string]bool {
+	health := map[string]map[string]bool{}
+	for _, rule := range s.remapRules {
+		if rule.HealthCheck == nil {
+			continue
+		}
+		parents := make(map[string]bool, len(rule.To))
+		for _, to := range rule.To {
+			parents[to.URL] = rule.ParentStatus.Healthy(to.URL)
+		}
+		health[rule.Name] = parents
+	}
+	return health
+}
+
+func (s stats) CachePolicyStats() map[string]icache.PolicyStats {
+	policyStats := make(map[string]icache.PolicyStats, len(s.caches))
+	for name, cache := range s.caches {
+		policyStats[name] = cache.PolicyStats()
+	}
+	return policyStats
+}
+
+func (s stats) CollapseStats() map[string]thread.GetterStats {
+	collapseStats := make(map[string]thread.GetterStats, len(s.remapRules))
+	for _, rule := range s.remapRules {
+		if rule.Getter == nil {
+			continue
+		}
+		collapseStats[rule.Name] = rule.Getter.Stats()
+	}
+	return collapseStats
+}
+
 type StatsRemaps interface {
 	Stats(fqdn string) (StatsRemap, bool)
 	Rules() []string
@@ -231,7 +281,11 @@ type StatsRemap interface {
 	AddCacheMiss()
 }
 
+// getFromFQDN returns the name of the rule's stats, the FQDN of its From, or its name if it's matched by regexes, because its From doesn't have the FQDN of the requests it matches.
 func getFromFQDN(r remapdata.RemapRule) string {
+	if r.HasRegexes() {
+		return r.Name
+	}
 	path := r.From
 	schemeEnd := `://`
 	if i := strings.Index(path, schemeEnd); i != -1 {
@@ -261,7 +315,7 @@ func (s statsRemaps) Stats(rule string) (StatsRemap, bool) {
 }
 
 func (s statsRemaps) Rules() []string {
-	rules := make([]string, len(s))
+	rules := make([]string, 0, len(s))
 	for rule := range s {
 		rules = append(rules, rule)
 	}
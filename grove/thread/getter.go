@@ -15,13 +15,67 @@ package thread
 */
 
 import (
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	cacheobj "github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 )
 
 type Getter interface {
-	Get(key string, actualGet func() *cacheobj.CacheObj, canUse func(*cacheobj.CacheObj) bool, reqID uint64) (*cacheobj.CacheObj, uint64)
+	// Get returns the object of the key, from another requestor's concurrent request if the mode allows it, or by calling actualGet. The canShare func returns whether another requestor's object may be used at all, such as its method and privacy, and canUse whether it may be used as a fresh cacheable response. The canUse must imply canShare.
+	Get(key string, actualGet func() *cacheobj.CacheObj, canUse func(*cacheobj.CacheObj) bool, canShare func(*cacheobj.CacheObj) bool, reqID uint64) (*cacheobj.CacheObj, uint64)
+	// Stats returns the collapsed forwarding statistics of the getter.
+	Stats() GetterStats
+}
+
+// CollapseMode is the collapsed forwarding strategy of a Getter, which determines when concurrent requests for the same key share a single request.
+type CollapseMode string
+
+const (
+	// CollapseModeCacheable shares the Author response with Waiters which can use it. If they can't, they make their own requests.
+	CollapseModeCacheable = CollapseMode("cacheable")
+	// CollapseModeReadWhileWriter shares the Author response with all Waiters which may share it, even if it's uncacheable or stale.
+	CollapseModeReadWhileWriter = CollapseMode("read-while-writer")
+	// CollapseModeMaxWait is CollapseModeCacheable, except Waiters make their own requests if the Author response takes longer than the max wait.
+	CollapseModeMaxWait = CollapseMode("max-wait")
+	CollapseModeInvalid = CollapseMode("")
+)
+
+func CollapseModeFromString(s string) CollapseMode {
+	switch strings.ToLower(s) {
+	case "cacheable":
+		return CollapseModeCacheable
+	case "read-while-writer":
+		return CollapseModeReadWhileWriter
+	case "max-wait":
+		return CollapseModeMaxWait
+	default:
+		return CollapseModeInvalid
+	}
+}
+
+// GetterStats are the collapsed forwarding statistics of a Getter.
+type GetterStats struct {
+	Mode CollapseMode
+	// Authors is the number of requests made for a key no-one else was requesting, and Waiters the number of requests which waited for an Author or were given a streaming object, rather than making their own.
+	Authors uint64
+	Waiters uint64
+	// MaxWaiters is the most Waiters of a single Author.
+	MaxWaiters uint64
+	// Unusable is the number of Waiters which couldn't use the response they were given, and made their own requests.
+	Unusable uint64
+	// Timeouts is the number of Waiters which waited longer than the max wait, and made their own requests.
+	Timeouts uint64
+}
+
+// WaitersPerKey returns the average number of Waiters of each Author request, or 0 if there have been no Authors.
+func (s GetterStats) WaitersPerKey() float64 {
+	if s.Authors == 0 {
+		return 0
+	}
+	return float64(s.Waiters) / float64(s.Authors)
 }
 
 type GetterResp struct {
@@ -29,8 +83,17 @@ type GetterResp struct {
 	GetReqID uint64
 }
 
+// NewGetter returns a Getter with the CollapseModeCacheable strategy.
 func NewGetter() Getter {
-	return &getter{waiters: map[string][]chan GetterResp{}}
+	return NewModeGetter(CollapseModeCacheable, 0)
+}
+
+// NewModeGetter returns a Getter with the given collapsed forwarding strategy. The maxWait is the longest Waiters wait for the Author, for CollapseModeMaxWait, and is ignored by other modes.
+func NewModeGetter(mode CollapseMode, maxWait time.Duration) Getter {
+	if mode != CollapseModeMaxWait {
+		maxWait = 0
+	}
+	return &getter{mode: mode, maxWait: maxWait, waiters: map[string][]chan GetterResp{}, streams: map[string]GetterResp{}}
 }
 
 // getter implements Getter, and does a fan-in so only one real request is made to the parent at any given time, and then that object is given to all concurrent requesters.
@@ -40,22 +103,45 @@ func NewGetter() Getter {
 // Then, when other requests come in, they see that waiters[key] exists, and add themselves to it, and block reading from their chan.
 // Then, when the Author gets its response, it iterates over the Waiters and sends the response to all of them, at the same time (with the same lock, atomically) clearing the waiters for the next request that comes in.
 //
-// If the Author response can't be used, all Waiters make their own requests.
-// Note this assumes an uncacheable response for one request is likely uncacheable for all, and it's faster and less load on the origin if so.
-// If it's likely the author request is uncacheable, but a different waiter is cacheable for all other waiters, this will be more network, more origin load, and more work. If that's the case for you, consider creating another type that fulfills the Getter interface, and making the Getter configurable.
+// In CollapseModeCacheable and CollapseModeMaxWait, if the Author response can't be used, all Waiters make their own requests.
+// Note this assumes an uncacheable response for one request is likely uncacheable for all, and it's faster and less load on the origin if so. In CollapseModeReadWhileWriter, Waiters use the Author response as long as it can be shared, even if it's uncacheable. Responses which can't be shared, such as private responses, are never used by Waiters in any mode.
+// In CollapseModeMaxWait, Waiters which wait longer than the maxWait stop waiting, and make their own requests.
+// If the Author response is streaming, its body is still being read from the parent when it's returned. Until the stream is complete, and the object cached, subsequent requests for the key are immediately given the streaming object, rather than making another request to the parent.
+//
+// If it's likely the author request is uncacheable, but a different waiter is cacheable for all other waiters, CollapseModeCacheable will be more network, more origin load, and more work. If that's the case for you, consider CollapseModeReadWhileWriter.
 type getter struct {
+	// The stats are first, so they're 64-bit aligned for atomic access. numMaxWaiters is only written with waitersM held.
+	numAuthors    uint64
+	numWaiters    uint64
+	numMaxWaiters uint64
+	numUnusable   uint64
+	numTimeouts   uint64
+
+	mode    CollapseMode
+	maxWait time.Duration
 	// waiters is a map of cache keys to chans for getters.
-	waiters  map[string][]chan GetterResp
+	waiters map[string][]chan GetterResp
+	// streams is a map of cache keys to Author responses whose bodies are still streaming from the parent. It's protected by waitersM.
+	streams  map[string]GetterResp
 	waitersM sync.Mutex
 }
 
-func (g *getter) Get(key string, actualGet func() *cacheobj.CacheObj, canUse func(*cacheobj.CacheObj) bool, reqID uint64) (*cacheobj.CacheObj, uint64) {
+func (g *getter) Get(key string, actualGet func() *cacheobj.CacheObj, canUse func(*cacheobj.CacheObj) bool, canShare func(*cacheobj.CacheObj) bool, reqID uint64) (*cacheobj.CacheObj, uint64) {
 	isAuthor := false
 	// Buffered for performance, so the author can iterate over all wait chans without blocking.
 	// Note this is unused if isAuthor becomes true.
 	getChan := make(chan GetterResp, 1)
 
 	g.waitersM.Lock()
+	if streamResp, ok := g.streams[key]; ok {
+		g.waitersM.Unlock()
+		atomic.AddUint64(&g.numWaiters, 1)
+		if g.canShare(streamResp.CacheObj, canUse, canShare) {
+			return streamResp.CacheObj, streamResp.GetReqID
+		}
+		atomic.AddUint64(&g.numUnusable, 1)
+		return actualGet(), reqID
+	}
 	if _, ok := g.waiters[key]; !ok {
 		isAuthor = true
 		g.waiters[key] = []chan GetterResp{}
@@ -65,23 +151,83 @@ func (g *getter) Get(key string, actualGet func() *cacheobj.CacheObj, canUse fun
 	g.waitersM.Unlock()
 
 	if isAuthor {
+		atomic.AddUint64(&g.numAuthors, 1)
 		obj := actualGet()
 		waitResp := GetterResp{CacheObj: obj, GetReqID: reqID}
 
 		g.waitersM.Lock()
-		for _, waitChan := range g.waiters[key] {
+		waitChans := g.waiters[key]
+		for _, waitChan := range waitChans {
 			waitChan <- waitResp
 		}
+		if numWaiters := uint64(len(waitChans)); numWaiters > atomic.LoadUint64(&g.numMaxWaiters) {
+			atomic.StoreUint64(&g.numMaxWaiters, numWaiters)
+		}
 		delete(g.waiters, key)
+		if obj.Streaming() {
+			g.streams[key] = waitResp
+			go g.finishStream(key, obj)
+		}
 		g.waitersM.Unlock()
 
 		return obj, reqID
 	}
 
-	if waitResp := <-getChan; canUse(waitResp.CacheObj) {
+	atomic.AddUint64(&g.numWaiters, 1)
+	waitResp, ok := g.wait(getChan)
+	if !ok {
+		atomic.AddUint64(&g.numTimeouts, 1)
+		return actualGet(), reqID
+	}
+	if g.canShare(waitResp.CacheObj, canUse, canShare) {
 		return waitResp.CacheObj, waitResp.GetReqID
 	}
 
 	// if the Author response can't be used, all Waiters make their own requests
+	atomic.AddUint64(&g.numUnusable, 1)
 	return actualGet(), reqID
 }
+
+// wait waits for the Author response on the given chan, and returns false if the getter has a maxWait and it's exceeded. The chan is buffered, so the Author never blocks sending to a Waiter which stopped waiting.
+func (g *getter) wait(getChan <-chan GetterResp) (GetterResp, bool) {
+	if g.maxWait <= 0 {
+		return <-getChan, true
+	}
+	timer := time.NewTimer(g.maxWait)
+	defer timer.Stop()
+	select {
+	case waitResp := <-getChan:
+		return waitResp, true
+	case <-timer.C:
+		return GetterResp{}, false
+	}
+}
+
+// canShare returns whether a Waiter may use the given object from another requestor, per the getter's mode. CollapseModeReadWhileWriter skips only the freshness and storability checks of canUse.
+func (g *getter) canShare(obj *cacheobj.CacheObj, canUse func(*cacheobj.CacheObj) bool, canShare func(*cacheobj.CacheObj) bool) bool {
+	if g.mode == CollapseModeReadWhileWriter {
+		return canShare(obj)
+	}
+	return canUse(obj)
+}
+
+func (g *getter) Stats() GetterStats {
+	return GetterStats{
+		Mode:       g.mode,
+		Authors:    atomic.LoadUint64(&g.numAuthors),
+		Waiters:    atomic.LoadUint64(&g.numWaiters),
+		MaxWaiters: atomic.LoadUint64(&g.numMaxWaiters),
+		Unusable:   atomic.LoadUint64(&g.numUnusable),
+		Timeouts:   atomic.LoadUint64(&g.numTimeouts),
+	}
+}
+
+// finishStream waits for the given streaming object to be filled, and then removes it from the streams, so subsequent requests get the cached object, or become the Author of a new request.
+func (g *getter) finishStream(key string, obj *cacheobj.CacheObj) {
+	<-obj.Filled()
+	g.waitersM.Lock()
+	defer g.waitersM.Unlock()
+	if streamResp, ok := g.streams[key]; ok && streamResp.CacheObj == obj {
+		delete(g.streams, key)
+	}
+}
@@ -0,0 +1,124 @@
+package thread
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
+)
+
+// notPrivate is a canShare func which doesn't share private responses.
+func notPrivate(obj *cacheobj.CacheObj) bool {
+	_, private := obj.RespCacheControl["private"]
+	return !private
+}
+
+// collapse makes an Author request for the key with the given getter, and a Waiter request while the Author is in progress, and returns the objects the Author and Waiter got. The Author's request blocks until the Waiter is waiting, and then for authorDelay. The canShare func is notPrivate, and the Author response has the given headers.
+func collapse(t *testing.T, g Getter, canUse bool, authorHdr http.Header, authorDelay time.Duration) (*cacheobj.CacheObj, *cacheobj.CacheObj) {
+	const key = "http://example.net/foo"
+	authorObj := cacheobj.New(http.Header{}, []byte("author"), http.StatusOK, http.StatusOK, "", authorHdr, time.Now(), time.Now(), time.Now(), time.Now())
+	waiterObj := cacheobj.New(http.Header{}, []byte("waiter"), http.StatusOK, http.StatusOK, "", http.Header{}, time.Now(), time.Now(), time.Now(), time.Now())
+	canUseFunc := func(obj *cacheobj.CacheObj) bool { return canUse && notPrivate(obj) }
+
+	authorGetting := make(chan struct{})
+	release := make(chan struct{})
+	authorGot := make(chan *cacheobj.CacheObj)
+	go func() {
+		obj, _ := g.Get(key, func() *cacheobj.CacheObj {
+			close(authorGetting)
+			<-release
+			time.Sleep(authorDelay)
+			return authorObj
+		}, canUseFunc, notPrivate, 1)
+		authorGot <- obj
+	}()
+	<-authorGetting
+
+	waiters := g.Stats().Waiters
+	waiterGot := make(chan *cacheobj.CacheObj)
+	go func() {
+		obj, _ := g.Get(key, func() *cacheobj.CacheObj { return waiterObj }, canUseFunc, notPrivate, 2)
+		waiterGot <- obj
+	}()
+	for start := time.Now(); g.Stats().Waiters == waiters; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Waiter never waited")
+		}
+	}
+	close(release)
+	return <-authorGot, <-waiterGot
+}
+
+func TestGetterCacheable(t *testing.T) {
+	g := NewGetter()
+	if author, waiter := collapse(t, g, true, http.Header{}, 0); string(author.Body) != "author" || waiter != author {
+		t.Errorf("cacheable usable response: expected Waiter to get the Author object, actual '%v'", string(waiter.Body))
+	}
+	if _, waiter := collapse(t, g, false, http.Header{}, 0); string(waiter.Body) != "waiter" {
+		t.Errorf("cacheable unusable response: expected Waiter to make its own request, actual '%v'", string(waiter.Body))
+	}
+	stats := g.Stats()
+	if stats.Mode != CollapseModeCacheable || stats.Authors != 2 || stats.Waiters != 2 || stats.MaxWaiters != 1 || stats.Unusable != 1 || stats.Timeouts != 0 {
+		t.Errorf("cacheable stats: expected {cacheable 2 2 1 1 0}, actual %+v", stats)
+	}
+	if perKey := stats.WaitersPerKey(); perKey != 1 {
+		t.Errorf("cacheable waiters per key: expected 1, actual %v", perKey)
+	}
+}
+
+func TestGetterReadWhileWriter(t *testing.T) {
+	g := NewModeGetter(CollapseModeReadWhileWriter, 0)
+	if author, waiter := collapse(t, g, false, http.Header{}, 0); waiter != author {
+		t.Errorf("read-while-writer unusable response: expected Waiter to get the Author object, actual '%v'", string(waiter.Body))
+	}
+	if stats := g.Stats(); stats.Unusable != 0 {
+		t.Errorf("read-while-writer unusable: expected 0, actual %v", stats.Unusable)
+	}
+	if _, waiter := collapse(t, g, false, http.Header{"Cache-Control": {"private"}}, 0); string(waiter.Body) != "waiter" {
+		t.Errorf("read-while-writer private response: expected Waiter to make its own request, actual '%v'", string(waiter.Body))
+	}
+	if stats := g.Stats(); stats.Unusable != 1 {
+		t.Errorf("read-while-writer private unusable: expected 1, actual %v", stats.Unusable)
+	}
+}
+
+func TestGetterMaxWait(t *testing.T) {
+	g := NewModeGetter(CollapseModeMaxWait, 10*time.Millisecond)
+	if _, waiter := collapse(t, g, true, http.Header{}, 100*time.Millisecond); string(waiter.Body) != "waiter" {
+		t.Errorf("max-wait slow Author: expected Waiter to make its own request, actual '%v'", string(waiter.Body))
+	}
+	if author, waiter := collapse(t, g, true, http.Header{}, 0); waiter != author {
+		t.Errorf("max-wait fast Author: expected Waiter to get the Author object, actual '%v'", string(waiter.Body))
+	}
+	if stats := g.Stats(); stats.Timeouts != 1 {
+		t.Errorf("max-wait timeouts: expected 1, actual %v", stats.Timeouts)
+	}
+}
+
+func TestCollapseModeFromString(t *testing.T) {
+	for s, expected := range map[string]CollapseMode{
+		"cacheable":         CollapseModeCacheable,
+		"Read-While-Writer": CollapseModeReadWhileWriter,
+		"max-wait":          CollapseModeMaxWait,
+		"none":              CollapseModeInvalid,
+	} {
+		if actual := CollapseModeFromString(s); actual != expected {
+			t.Errorf("CollapseModeFromString('%v'): expected '%v', actual '%v'", s, expected, actual)
+		}
+	}
+}
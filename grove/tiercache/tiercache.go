@@ -15,6 +15,8 @@ package tiercache
 */
 
 import (
+	"sync/atomic"
+
 	"github.com/apache/incubator-trafficcontrol/grove/cacheobj"
 	"github.com/apache/incubator-trafficcontrol/grove/icache"
 
@@ -29,6 +31,8 @@ import (
 type TierCache struct {
 	first  icache.Cache
 	second icache.Cache
+	hits   uint64 // atomic
+	misses uint64 // atomic
 }
 
 // New creates a new TierCache with the given first and second caches to use.
@@ -49,6 +53,11 @@ func (c *TierCache) Get(key string) (*cacheobj.CacheObj, bool) {
 		}
 		log.Debugf("TierCache.Get '"+key+"' FOUND SECOND: %+v\n", ok)
 	}
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
 	return v, ok
 }
 
@@ -69,6 +78,13 @@ func (c *TierCache) Add(key string, val *cacheobj.CacheObj) bool {
 	return aevict || bevict
 }
 
+// Remove removes the key from both internal caches. Returns whether it existed in either.
+func (c *TierCache) Remove(key string) bool {
+	aexisted := c.first.Remove(key)
+	bexisted := c.second.Remove(key)
+	return aexisted || bexisted
+}
+
 // Size returns the size of the second cache. This is because, since all objects are added to both, they are presumed to have the same content, and the second is presumed to be larger.
 //
 // For example, if the first is a memory cache and the second is a disk cache, it's most useful to report the size used on disk.
@@ -88,3 +104,8 @@ func (c *TierCache) Keys() []string {
 
 // Capacity returns the maximum size in bytes of the cache
 func (c *TierCache) Capacity() uint64 { return c.second.Capacity() }
+
+// PolicyStats returns the policy of the second cache, and the hits and misses of Get, where a hit is an object in either cache.
+func (c *TierCache) PolicyStats() icache.PolicyStats {
+	return icache.PolicyStats{Policy: c.second.PolicyStats().Policy, Hits: atomic.LoadUint64(&c.hits), Misses: atomic.LoadUint64(&c.misses)}
+}
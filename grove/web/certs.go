@@ -0,0 +1,102 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// CertStore holds the certificates of an HTTPS listener, and selects the certificate for each connection by the client's SNI server name. The certificates may be replaced with Set while the listener is serving, for example on a config reload. New connections get the new certificates, and existing connections are unaffected.
+type CertStore struct {
+	certs atomic.Value // *certSet
+}
+
+type certSet struct {
+	byName      map[string]*tls.Certificate
+	defaultCert *tls.Certificate
+}
+
+// NewCertStore returns a new CertStore with the given certificates, and the default certificate for clients without SNI, or whose server name matches no certificate.
+func NewCertStore(certs []tls.Certificate, defaultCert tls.Certificate) (*CertStore, error) {
+	s := &CertStore{}
+	if err := s.Set(certs, defaultCert); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set replaces the certificates of the store. Each certificate is served for the DNS names in its Subject Alternative Names, or its Common Name if it has none. Names may be wildcards, such as `*.example.net`, which match a single label. If multiple certificates have the same name, the first is used.
+func (s *CertStore) Set(certs []tls.Certificate, defaultCert tls.Certificate) error {
+	set := &certSet{byName: map[string]*tls.Certificate{}, defaultCert: &defaultCert}
+	for i := range certs {
+		cert := &certs[i]
+		names, err := certNames(cert)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := set.byName[name]; !ok {
+				set.byName[name] = cert
+			}
+		}
+	}
+	s.certs.Store(set)
+	return nil
+}
+
+// GetCertificate returns the certificate for the client's server name, for use as a tls.Config GetCertificate. An exact name is preferred to a wildcard.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := s.certs.Load().(*certSet)
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return set.defaultCert, nil
+	}
+	if cert, ok := set.byName[name]; ok {
+		return cert, nil
+	}
+	if dotPos := strings.Index(name, "."); dotPos != -1 {
+		if cert, ok := set.byName["*"+name[dotPos:]]; ok {
+			return cert, nil
+		}
+	}
+	return set.defaultCert, nil
+}
+
+// certNames returns the DNS names of the certificate's leaf, parsing it if necessary.
+func certNames(cert *tls.Certificate) ([]string, error) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return nil, errors.New("certificate is empty")
+		}
+		err := error(nil)
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, errors.New("parsing certificate: " + err.Error())
+		}
+		cert.Leaf = leaf
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames, nil
+	}
+	if leaf.Subject.CommonName != "" {
+		return []string{leaf.Subject.CommonName}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,161 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate with the given Common Name and DNS names.
+func testCert(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func certCommonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parsing certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertStoreGetCertificate(t *testing.T) {
+	certs := []tls.Certificate{
+		testCert(t, "exact", "www.example.net", "Other.Example.net"),
+		testCert(t, "wildcard", "*.example.net"),
+		testCert(t, "cn.example.org"),
+		testCert(t, "duplicate", "www.example.net"),
+	}
+	store, err := NewCertStore(certs, testCert(t, "default"))
+	if err != nil {
+		t.Fatalf("NewCertStore error expected nil, actual %v", err)
+	}
+
+	tests := map[string]string{
+		"www.example.net":     "exact",
+		"WWW.example.net.":    "exact",
+		"other.example.net":   "exact",
+		"foo.example.net":     "wildcard",
+		"a.foo.example.net":   "default", // wildcards match a single label
+		"example.net":         "default",
+		"cn.example.org":      "cn.example.org",
+		"unknown.example.com": "default",
+		"":                    "default",
+	}
+	for serverName, expected := range tests {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			t.Errorf("GetCertificate '%v' error expected nil, actual %v", serverName, err)
+			continue
+		}
+		if actual := certCommonName(t, cert); actual != expected {
+			t.Errorf("GetCertificate '%v' expected %v, actual %v", serverName, expected, actual)
+		}
+	}
+
+	if err := store.Set([]tls.Certificate{testCert(t, "new", "www.example.net")}, testCert(t, "new default")); err != nil {
+		t.Fatalf("Set error expected nil, actual %v", err)
+	}
+	for serverName, expected := range map[string]string{"www.example.net": "new", "foo.example.net": "new default"} {
+		cert, _ := store.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if actual := certCommonName(t, cert); actual != expected {
+			t.Errorf("GetCertificate after Set '%v' expected %v, actual %v", serverName, expected, actual)
+		}
+	}
+
+	if err := store.Set([]tls.Certificate{{}}, testCert(t, "default")); err == nil {
+		t.Errorf("Set empty certificate error expected, actual nil")
+	}
+}
+
+// TestInterceptListenTLSHTTP2 tests that HTTP/2 is negotiated, and the bytes of each request can be taken from the InterceptConn beneath the TLS conn.
+func TestInterceptListenTLSHTTP2(t *testing.T) {
+	store, err := NewCertStore(nil, testCert(t, "default", "localhost"))
+	if err != nil {
+		t.Fatalf("NewCertStore error expected nil, actual %v", err)
+	}
+	l, connMap, connState, tlsConfig, err := InterceptListenTLS("tcp", "127.0.0.1:0", store, nil)
+	if err != nil {
+		t.Fatalf("InterceptListenTLS error expected nil, actual %v", err)
+	}
+	defer l.Close()
+
+	type reqBytes struct {
+		found         bool
+		read, written uint64
+	}
+	results := make(chan reqBytes, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+		w.(http.Flusher).Flush()
+		conn, ok := connMap.Get(r.RemoteAddr)
+		if !ok {
+			results <- reqBytes{}
+			return
+		}
+		read, written := conn.(*InterceptConn).TakeBytes()
+		results <- reqBytes{found: true, read: read, written: written}
+	})
+	server := &http.Server{Handler: handler, ConnState: connState, TLSConfig: tlsConfig}
+	go server.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get("https://" + l.Addr().String() + "/")
+		if err != nil {
+			t.Fatalf("request error expected nil, actual %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 || string(body) != "hello" {
+			t.Errorf("response expected HTTP/2 'hello', actual %v '%v'", resp.Proto, string(body))
+		}
+		result := <-results
+		if !result.found {
+			t.Fatalf("request %v conn expected in conn map, actual missing", i)
+		}
+		if result.read == 0 || result.written == 0 {
+			t.Errorf("request %v bytes expected > 0, actual read %v written %v", i, result.read, result.written)
+		}
+	}
+}
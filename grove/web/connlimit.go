@@ -0,0 +1,75 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnLimiter limits the number of concurrent connections from each client IP. The limit may be changed with SetMax while listeners are using it, for example on a config reload. A nil ConnLimiter is unlimited.
+type ConnLimiter struct {
+	max   int64 // accessed atomically
+	conns map[string]int
+	m     sync.Mutex
+}
+
+// NewConnLimiter returns a ConnLimiter allowing max concurrent connections per client IP. If max is 0, connections are unlimited.
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{max: int64(max), conns: map[string]int{}}
+}
+
+// SetMax sets the maximum concurrent connections per client IP. Existing connections over the new limit aren't closed.
+func (l *ConnLimiter) SetMax(max int) {
+	atomic.StoreInt64(&l.max, int64(max))
+}
+
+// acquire returns whether a new connection from the given IP is allowed, and if so counts it. Each allowed connection must be released.
+func (l *ConnLimiter) acquire(ip string) bool {
+	if l == nil {
+		return true
+	}
+	max := int(atomic.LoadInt64(&l.max))
+	l.m.Lock()
+	defer l.m.Unlock()
+	if max > 0 && l.conns[ip] >= max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *ConnLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.conns[ip] <= 1 {
+		delete(l.conns, ip)
+		return
+	}
+	l.conns[ip]--
+}
+
+// connIP returns the IP of the conn's remote address.
+func connIP(conn net.Conn) string {
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return ip
+}
@@ -0,0 +1,80 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterceptListenConnLimit(t *testing.T) {
+	limiter := NewConnLimiter(1)
+	l, _, _, err := InterceptListen("tcp", "127.0.0.1:0", limiter)
+	if err != nil {
+		t.Fatalf("InterceptListen error expected nil, actual %v", err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("dialing error expected nil, actual %v", err)
+		}
+		return conn
+	}
+	closedByServer := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		netErr, ok := err.(net.Error)
+		return !ok || !netErr.Timeout()
+	}
+
+	first := dial()
+	defer first.Close()
+	serverFirst := <-accepted
+
+	second := dial()
+	defer second.Close()
+	if !closedByServer(second) {
+		t.Errorf("conn over the limit expected closed, actual open")
+	}
+
+	serverFirst.Close()
+	serverFirst.Close() // closing twice must only release the conn once
+	third := dial()
+	defer third.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("conn after the first was closed expected accepted, actual not accepted")
+	}
+
+	limiter.SetMax(0)
+	if !limiter.acquire("192.0.2.1") || !limiter.acquire("192.0.2.1") {
+		t.Errorf("limiter with no max expected unlimited, actual limited")
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/ipv4"
@@ -30,24 +31,36 @@ import (
 type InterceptListener struct {
 	realListener net.Listener
 	connMap      *ConnMap
+	// openConns is every open InterceptConn, including idle conns removed from the connMap, so the InterceptConn beneath a TLS conn can be found.
+	openConns *ConnMap
+	// connLimiter limits the conns of each client IP. Conns over the limit are closed as soon as they're accepted.
+	connLimiter *ConnLimiter
 }
 
-func getConnStateCallback(connMap *ConnMap) func(net.Conn, http.ConnState) {
+// getInterceptConn returns the InterceptConn of the given conn, which is either an InterceptConn, or a TLS conn wrapping one.
+func getInterceptConn(conn net.Conn, openConns *ConnMap) (*InterceptConn, bool) {
+	if iconn, ok := conn.(*InterceptConn); ok {
+		return iconn, true
+	}
+	realConn, ok := openConns.Get(conn.RemoteAddr().String())
+	if !ok {
+		return nil, false
+	}
+	iconn, ok := realConn.(*InterceptConn)
+	return iconn, ok
+}
+
+func getConnStateCallback(connMap *ConnMap, openConns *ConnMap) func(net.Conn, http.ConnState) {
 	return func(conn net.Conn, state http.ConnState) {
 		switch state {
-		case http.StateClosed:
-			fallthrough
+		case http.StateClosed, http.StateHijacked:
+			openConns.Remove(conn.RemoteAddr().String())
+			connMap.Remove(conn.RemoteAddr().String())
 		case http.StateIdle:
-			if iconn, ok := conn.(*InterceptConn); !ok {
-				log.Errorf("ConnState callback: idle conn is not a InterceptConn: '%T'\n", conn)
-			} else {
-				// MUST be zeroed when the conn moves to Idle, because the Active callback happens _after_ some/all bytes have been read
-				iconn.bytesRead = 0
-				iconn.bytesWritten = 0
-			}
+			// The bytes aren't zeroed here, because each response takes its bytes with TakeBytes, and with HTTP/2 the next request may already have been read when the conn moves to Idle.
 			connMap.Remove(conn.RemoteAddr().String())
 		case http.StateActive:
-			if iconn, ok := conn.(*InterceptConn); !ok {
+			if iconn, ok := getInterceptConn(conn, openConns); !ok {
 				log.Errorf("ConnState callback: active conn is not a InterceptConn: '%T'\n", conn)
 			} else {
 				connMap.Add(iconn)
@@ -57,39 +70,48 @@ func getConnStateCallback(connMap *ConnMap) func(net.Conn, http.ConnState) {
 }
 
 // InterceptListen creates and returns a net.Listener via net.Listen, which is wrapped with an intercepter, which counts Conn read and write bytes. If you want a `grove.NewCacheHandler` to be able to count in and out bytes per remap rule in the stats interface, it must be served with a listener created via InterceptListen or InterceptListenTLS.
-func InterceptListen(network, laddr string) (net.Listener, *ConnMap, func(net.Conn, http.ConnState), error) {
+// Conns from client IPs with the connLimiter's maximum conns already open are closed. The connLimiter may be nil, and may be shared by multiple listeners, to limit each client's conns to all of them.
+func InterceptListen(network, laddr string, connLimiter *ConnLimiter) (net.Listener, *ConnMap, func(net.Conn, http.ConnState), error) {
 	l, err := net.Listen(network, laddr)
 	if err != nil {
 		return l, nil, nil, err
 	}
 	connMap := NewConnMap()
-	return &InterceptListener{realListener: l, connMap: connMap}, connMap, getConnStateCallback(connMap), nil
+	openConns := NewConnMap()
+	return &InterceptListener{realListener: l, connMap: connMap, openConns: openConns, connLimiter: connLimiter}, connMap, getConnStateCallback(connMap, openConns), nil
 }
 
 // InterceptListenTLS is like InterceptListen but for serving HTTPS. It returns the tls.Config, which must be set on the http.Server using this listener for HTTP/2 to be set up.
-func InterceptListenTLS(network string, laddr string, certs []tls.Certificate) (net.Listener, *ConnMap, func(net.Conn, http.ConnState), *tls.Config, error) {
+// Certificates are selected from the given CertStore by the client's SNI, so they may be replaced without recreating the listener.
+func InterceptListenTLS(network string, laddr string, certs *CertStore, connLimiter *ConnLimiter) (net.Listener, *ConnMap, func(net.Conn, http.ConnState), *tls.Config, error) {
 	config := &tls.Config{}
-	config.NextProtos = []string{"h2"}
-	config.Certificates = certs
-	config.BuildNameToCertificate()
+	config.NextProtos = []string{"h2", "http/1.1"}
+	config.GetCertificate = certs.GetCertificate
 	l, err := net.Listen(network, laddr)
 	if err != nil {
 		return l, nil, nil, nil, err
 	}
 	connMap := NewConnMap()
+	openConns := NewConnMap()
 
-	interceptListener := &InterceptListener{realListener: l, connMap: connMap}
+	interceptListener := &InterceptListener{realListener: l, connMap: connMap, openConns: openConns, connLimiter: connLimiter}
 	tlsListener := tls.NewListener(interceptListener, config)
-	return tlsListener, connMap, getConnStateCallback(connMap), config, nil
+	return tlsListener, connMap, getConnStateCallback(connMap, openConns), config, nil
 }
 
 func (l *InterceptListener) Accept() (net.Conn, error) {
 	c, err := l.realListener.Accept()
+	for err == nil && !l.connLimiter.acquire(connIP(c)) {
+		log.Warnf("Accept: client %v exceeded the maximum connections, closing\n", c.RemoteAddr())
+		c.Close()
+		c, err = l.realListener.Accept()
+	}
 	if err != nil {
 		log.Errorf("Accept err: %v\n", err) // TODO stats?
 		return c, err
 	}
-	interceptConn := &InterceptConn{realConn: c}
+	interceptConn := &InterceptConn{realConn: c, connLimiter: l.connLimiter}
+	l.openConns.Add(interceptConn)
 	l.connMap.Add(interceptConn)
 	return interceptConn, nil
 }
@@ -102,31 +124,42 @@ func (l *InterceptListener) Addr() net.Addr {
 	return l.realListener.Addr()
 }
 
+// InterceptConn counts the bytes read and written to a Conn. The counts are of the bytes since the last TakeBytes, so each response can take the bytes of its request and response. With HTTP/2, responses of concurrent streams on the same conn take the bytes of all streams since the last response, so each byte is counted exactly once.
 type InterceptConn struct {
 	realConn     net.Conn
-	bytesRead    int
-	bytesWritten int
+	bytesRead    int64 // accessed atomically, because with HTTP/2 the conn is read and written by different goroutines than the handlers
+	bytesWritten int64
+	connLimiter  *ConnLimiter
+	closed       int32 // accessed atomically, so the conn is only released from the connLimiter once
 }
 
 func (c *InterceptConn) BytesRead() int {
-	return c.bytesRead
+	return int(atomic.LoadInt64(&c.bytesRead))
 }
 
 func (c *InterceptConn) BytesWritten() int {
-	return c.bytesWritten
+	return int(atomic.LoadInt64(&c.bytesWritten))
+}
+
+// TakeBytes returns the bytes read and written since the last call, and resets them.
+func (c *InterceptConn) TakeBytes() (uint64, uint64) {
+	return uint64(atomic.SwapInt64(&c.bytesRead, 0)), uint64(atomic.SwapInt64(&c.bytesWritten, 0))
 }
 
 func (c *InterceptConn) Read(b []byte) (n int, err error) {
 	n, err = c.realConn.Read(b)
-	c.bytesRead += n
+	atomic.AddInt64(&c.bytesRead, int64(n))
 	return
 }
 func (c *InterceptConn) Write(b []byte) (n int, err error) {
 	n, err = c.realConn.Write(b)
-	c.bytesWritten += n
+	atomic.AddInt64(&c.bytesWritten, int64(n))
 	return
 }
 func (c *InterceptConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.connLimiter.release(connIP(c.realConn))
+	}
 	return c.realConn.Close()
 }
 func (c *InterceptConn) LocalAddr() net.Addr {
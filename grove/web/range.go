@@ -0,0 +1,180 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// MaxByteRanges is the maximum number of ranges accepted in a single Range header. Requests with more ranges are served the full representation, to prevent clients from requesting absurd numbers of tiny ranges, per RFC 7233§6.1.
+const MaxByteRanges = 64
+
+var ErrRangeInvalid = errors.New("invalid range")
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ByteRange is a range of bytes in a representation. Start and End are absolute, inclusive offsets, as in an RFC 7233 Content-Range.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Length returns the number of bytes in the range.
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ContentRange returns the Content-Range header value for this range, in a representation of the given size.
+func (r ByteRange) ContentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.Start, 10) + "-" + strconv.FormatInt(r.End, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+// ContentRangeUnsatisfied returns the Content-Range header value to send with a 416 Range Not Satisfiable, for a representation of the given size.
+func ContentRangeUnsatisfied(size int64) string {
+	return "bytes */" + strconv.FormatInt(size, 10)
+}
+
+// ParseRange parses the given Range header value, for a representation of the given size, and returns the satisfiable ranges in the order requested. Unsatisfiable ranges are dropped, per RFC 7233§2.1.
+// If the header is malformed, uses a unit other than bytes, or has more than MaxByteRanges ranges, ErrRangeInvalid is returned, and the caller should ignore the header and serve the full representation. If the header is valid but no range is satisfiable, ErrRangeNotSatisfiable is returned.
+func ParseRange(hdr string, size int64) ([]ByteRange, error) {
+	const prefix = "bytes="
+	hdr = strings.TrimSpace(hdr)
+	if !strings.HasPrefix(hdr, prefix) {
+		return nil, ErrRangeInvalid
+	}
+	specs := strings.Split(hdr[len(prefix):], ",")
+	if len(specs) > MaxByteRanges {
+		return nil, ErrRangeInvalid
+	}
+	ranges := []ByteRange{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue // RFC 7230§7 allows empty list elements
+		}
+		dash := strings.Index(spec, "-")
+		if dash < 0 {
+			return nil, ErrRangeInvalid
+		}
+		startStr, endStr := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+		if startStr == "" {
+			// suffix-byte-range-spec, e.g. "-500" is the last 500 bytes
+			suffix, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || suffix < 0 {
+				return nil, ErrRangeInvalid
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			if suffix > size {
+				suffix = size
+			}
+			ranges = append(ranges, ByteRange{Start: size - suffix, End: size - 1})
+			continue
+		}
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return nil, ErrRangeInvalid
+		}
+		end := size - 1
+		if endStr != "" {
+			if end, err = strconv.ParseInt(endStr, 10, 64); err != nil || end < start {
+				return nil, ErrRangeInvalid
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, End: end})
+	}
+	if len(ranges) == 0 {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return ranges, nil
+}
+
+// IfRangeMatches returns whether the given If-Range header value matches the representation with the given response headers, per RFC 7233§3.2. If it doesn't match, the Range header must be ignored, and the full representation served.
+// Entity tags use the strong comparison function, and weak tags never match. Dates must exactly match the Last-Modified header.
+func IfRangeMatches(ifRange string, respHdr http.Header) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := respHdr.Get("ETag")
+		return !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && etag == ifRange
+	}
+	ifRangeDate, ok := ParseHTTPDate(ifRange)
+	if !ok {
+		return false
+	}
+	lastModified, ok := GetHTTPDate(respHdr, "Last-Modified")
+	return ok && lastModified.Equal(ifRangeDate)
+}
+
+// MultipartByteRanges builds a multipart/byteranges body of the given ranges of body, per RFC 7233§4.1. It returns the body, and the Content-Type header value containing the boundary. The contentType is the Content-Type of the full representation, and is sent in each part if it isn't empty.
+func MultipartByteRanges(body []byte, ranges []ByteRange, contentType string) ([]byte, string) {
+	size := int64(len(body))
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for _, r := range ranges {
+		partHdr := textproto.MIMEHeader{}
+		if contentType != "" {
+			partHdr.Set("Content-Type", contentType)
+		}
+		partHdr.Set("Content-Range", r.ContentRange(size))
+		part, err := w.CreatePart(partHdr)
+		if err != nil {
+			continue // should never happen, bytes.Buffer writes don't fail
+		}
+		part.Write(body[r.Start : r.End+1])
+	}
+	w.Close()
+	return buf.Bytes(), "multipart/byteranges; boundary=" + w.Boundary()
+}
+
+// NewRangeReader returns a reader of the given range of the body read from r. The bytes before the range are read and discarded on the first Read, so creating the reader doesn't block.
+func NewRangeReader(r io.Reader, rng ByteRange) io.Reader {
+	return &rangeReader{r: r, skip: rng.Start, limit: io.LimitReader(r, rng.Length())}
+}
+
+type rangeReader struct {
+	r     io.Reader
+	skip  int64
+	limit io.Reader
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if r.skip > 0 {
+		skipped, err := io.CopyN(ioutil.Discard, r.r, r.skip)
+		r.skip -= skipped
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return r.limit.Read(p)
+}
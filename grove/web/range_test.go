@@ -0,0 +1,134 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	type rangeTest struct {
+		hdr    string
+		size   int64
+		ranges []ByteRange
+		err    error
+	}
+	tests := []rangeTest{
+		{"bytes=0-499", 10000, []ByteRange{{0, 499}}, nil},
+		{"bytes=500-999", 10000, []ByteRange{{500, 999}}, nil},
+		{"bytes=-500", 10000, []ByteRange{{9500, 9999}}, nil},
+		{"bytes=9500-", 10000, []ByteRange{{9500, 9999}}, nil},
+		{"bytes=0-0,-1", 10000, []ByteRange{{0, 0}, {9999, 9999}}, nil},
+		{"bytes=500-600, 601-999", 10000, []ByteRange{{500, 600}, {601, 999}}, nil},
+		{"bytes=0-99999", 10000, []ByteRange{{0, 9999}}, nil},
+		{"bytes=-99999", 10000, []ByteRange{{0, 9999}}, nil},
+		{"bytes=10000-", 10000, nil, ErrRangeNotSatisfiable},
+		{"bytes=20000-30000", 10000, nil, ErrRangeNotSatisfiable},
+		{"bytes=20000-30000,0-1", 10000, []ByteRange{{0, 1}}, nil},
+		{"bytes=-0", 10000, nil, ErrRangeNotSatisfiable},
+		{"bytes=0-", 0, nil, ErrRangeNotSatisfiable},
+		{"bytes=5-4", 10000, nil, ErrRangeInvalid},
+		{"bytes=a-4", 10000, nil, ErrRangeInvalid},
+		{"bytes=4", 10000, nil, ErrRangeInvalid},
+		{"items=0-4", 10000, nil, ErrRangeInvalid},
+		{"", 10000, nil, ErrRangeInvalid},
+	}
+	for _, test := range tests {
+		ranges, err := ParseRange(test.hdr, test.size)
+		if err != test.err {
+			t.Errorf("ParseRange(%q, %v) expected err %v actual %v", test.hdr, test.size, test.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(ranges, test.ranges) {
+			t.Errorf("ParseRange(%q, %v) expected %+v actual %+v", test.hdr, test.size, test.ranges, ranges)
+		}
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	hdr := http.Header{}
+	hdr.Set("ETag", `"abc"`)
+	hdr.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+
+	type ifRangeTest struct {
+		ifRange string
+		match   bool
+	}
+	tests := []ifRangeTest{
+		{``, true},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`W/"abc"`, false},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"Mon, 02 Jan 2006 15:04:06 GMT", false},
+		{"not a date", false},
+	}
+	for _, test := range tests {
+		if match := IfRangeMatches(test.ifRange, hdr); match != test.match {
+			t.Errorf("IfRangeMatches(%q) expected %v actual %v", test.ifRange, test.match, match)
+		}
+	}
+}
+
+func TestMultipartByteRanges(t *testing.T) {
+	body := []byte("0123456789")
+	ranges := []ByteRange{{0, 1}, {8, 9}}
+	mpBody, contentType := MultipartByteRanges(body, ranges, "text/plain")
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("MultipartByteRanges returned bad Content-Type %q: %v", contentType, err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Fatalf("MultipartByteRanges expected media type multipart/byteranges actual %v", mediaType)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(mpBody), params["boundary"])
+	expected := []struct {
+		contentRange string
+		body         string
+	}{
+		{"bytes 0-1/10", "01"},
+		{"bytes 8-9/10", "89"},
+	}
+	for _, e := range expected {
+		part, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("MultipartByteRanges reading part: %v", err)
+		}
+		if cr := part.Header.Get("Content-Range"); cr != e.contentRange {
+			t.Errorf("MultipartByteRanges part expected Content-Range %q actual %q", e.contentRange, cr)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("MultipartByteRanges part expected Content-Type text/plain actual %q", ct)
+		}
+		partBody, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatalf("MultipartByteRanges reading part body: %v", err)
+		}
+		if string(partBody) != e.body {
+			t.Errorf("MultipartByteRanges part expected body %q actual %q", e.body, string(partBody))
+		}
+	}
+	if _, err := r.NextPart(); err == nil {
+		t.Errorf("MultipartByteRanges expected %v parts, actual more", len(expected))
+	}
+}
@@ -0,0 +1,218 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// StreamWindowBytes is the maximum number of unread bytes a StreamBuffer holds once it stops retaining its body. When exceeded, the writer blocks until the slowest reader catches up.
+const StreamWindowBytes = 1024 * 1024
+
+const streamReadBytes = 32 * 1024
+
+var ErrStreamAbandoned = errors.New("stream abandoned: all readers closed")
+var ErrStreamReaderClosed = errors.New("stream reader closed")
+
+// StreamBuffer is a body written by a single writer, typically from a parent response, and concurrently read by any number of readers. Each reader reads the body from the beginning, blocking until more is written.
+//
+// The buffer retains the entire body while it's no larger than its retain limit, so it can be cached, and so readers may be created at any time. Once the limit is exceeded, the buffer stops retaining, and bytes are discarded as soon as every reader has read them. After bytes have been discarded, no new readers may be created, and the writer blocks while more than StreamWindowBytes are unread, so a slow client holds back the parent rather than the body being buffered in memory.
+type StreamBuffer struct {
+	m          sync.Mutex
+	cond       *sync.Cond
+	buf        []byte
+	base       int64 // the offset of buf[0] in the body. This is nonzero once bytes have been discarded.
+	size       int64 // the total bytes written
+	retainMax  uint64
+	retaining  bool
+	readers    map[*StreamReader]struct{}
+	hadReaders bool
+	closed     bool
+	err        error
+}
+
+// NewStreamBuffer creates a new StreamBuffer, which retains up to retainMax bytes. If retainMax is 0, the entire body is always retained. The contentLength is the expected size of the body, or negative if unknown; if it's larger than retainMax, the buffer never retains.
+func NewStreamBuffer(retainMax uint64, contentLength int64) *StreamBuffer {
+	b := &StreamBuffer{
+		retainMax: retainMax,
+		retaining: retainMax == 0 || contentLength < 0 || uint64(contentLength) <= retainMax,
+		readers:   map[*StreamReader]struct{}{},
+	}
+	b.cond = sync.NewCond(&b.m)
+	return b
+}
+
+// Write appends p to the buffer, and wakes any readers waiting for it. If the buffer isn't retaining, it blocks while the unread window is full. It returns ErrStreamAbandoned if the buffer isn't retaining, and every reader has been closed.
+func (b *StreamBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for !b.retaining && b.size-b.base >= StreamWindowBytes && !b.abandoned() {
+		b.cond.Wait()
+	}
+	if b.abandoned() {
+		return 0, ErrStreamAbandoned
+	}
+	b.buf = append(b.buf, p...)
+	b.size += int64(len(p))
+	if b.retaining && b.retainMax != 0 && uint64(b.size) > b.retainMax {
+		b.retaining = false
+		b.trim()
+	}
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// Fill writes the body from r into the buffer until EOF or an error, and then closes the buffer with that error. It returns the number of bytes read, and any error other than EOF.
+func (b *StreamBuffer) Fill(r io.Reader) (int64, error) {
+	p := make([]byte, streamReadBytes)
+	n := int64(0)
+	for {
+		readN, readErr := r.Read(p)
+		if readN > 0 {
+			if _, err := b.Write(p[:readN]); err != nil {
+				b.Close(err)
+				return n, err
+			}
+			n += int64(readN)
+		}
+		if readErr == io.EOF {
+			b.Close(nil)
+			return n, nil
+		}
+		if readErr != nil {
+			b.Close(readErr)
+			return n, readErr
+		}
+	}
+}
+
+// Close marks the body complete. Readers will read any remaining bytes, and then get the given error, or io.EOF if err is nil.
+func (b *StreamBuffer) Close(err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// Retaining returns whether the buffer is still retaining the entire body. Once false, it never becomes true again.
+func (b *StreamBuffer) Retaining() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.retaining
+}
+
+// Bytes returns the entire body, and whether the buffer was closed without error while still retaining. If false, the body isn't complete, and must not be cached.
+func (b *StreamBuffer) Bytes() ([]byte, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if !b.closed || b.err != nil || !b.retaining {
+		return nil, false
+	}
+	return b.buf, true
+}
+
+// NewReader returns a new reader of the body from the beginning, and whether a reader could be created. Readers can't be created once bytes have been discarded. Every reader must be closed, so the buffer may discard bytes it has read.
+func (b *StreamBuffer) NewReader() (*StreamReader, bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.base != 0 {
+		return nil, false
+	}
+	r := &StreamReader{b: b}
+	b.readers[r] = struct{}{}
+	b.hadReaders = true
+	return r, true
+}
+
+// abandoned returns whether the buffer isn't retaining, and every reader it had has been closed. If so, nothing will ever read the body, and the writer should stop. This MUST be called with the lock held.
+func (b *StreamBuffer) abandoned() bool {
+	return !b.retaining && b.hadReaders && len(b.readers) == 0
+}
+
+// trim discards bytes every reader has read, if the buffer isn't retaining. This MUST be called with the lock held.
+func (b *StreamBuffer) trim() {
+	if b.retaining || !b.hadReaders {
+		return
+	}
+	min := b.size
+	for r := range b.readers {
+		if r.offset < min {
+			min = r.offset
+		}
+	}
+	if min <= b.base {
+		return
+	}
+	// Slicing leaves the discarded bytes in the old array, but the next append which exceeds its capacity copies only the unread bytes, and the old array is collected.
+	b.buf = b.buf[min-b.base:]
+	b.base = min
+}
+
+// StreamReader reads a StreamBuffer from the beginning, and implements io.ReadCloser.
+type StreamReader struct {
+	b      *StreamBuffer
+	offset int64
+	closed bool
+}
+
+// Read reads the next bytes of the body, blocking until they're written or the buffer is closed.
+func (r *StreamReader) Read(p []byte) (int, error) {
+	b := r.b
+	b.m.Lock()
+	defer b.m.Unlock()
+	for !r.closed && r.offset >= b.size && !b.closed {
+		b.cond.Wait()
+	}
+	if r.closed {
+		return 0, ErrStreamReaderClosed
+	}
+	if r.offset < b.size {
+		n := copy(p, b.buf[r.offset-b.base:])
+		r.offset += int64(n)
+		if !b.retaining {
+			b.trim()
+			b.cond.Broadcast()
+		}
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return 0, io.EOF
+}
+
+// Close closes the reader, allowing the buffer to discard bytes it hasn't read. It's safe to call Close multiple times.
+func (r *StreamReader) Close() error {
+	b := r.b
+	b.m.Lock()
+	defer b.m.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	delete(b.readers, r)
+	b.trim()
+	b.cond.Broadcast()
+	return nil
+}
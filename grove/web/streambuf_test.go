@@ -0,0 +1,140 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestStreamBufferRetaining(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	b := NewStreamBuffer(0, -1)
+
+	readers := []*StreamReader{}
+	for i := 0; i < 5; i++ {
+		r, ok := b.NewReader()
+		if !ok {
+			t.Fatalf("StreamBuffer.NewReader expected ok, actual false")
+		}
+		readers = append(readers, r)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, r := range readers {
+		wg.Add(1)
+		go func(r *StreamReader) {
+			defer wg.Done()
+			defer r.Close()
+			read, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Errorf("StreamReader.Read expected nil error, actual %v", err)
+			}
+			if !bytes.Equal(read, body) {
+				t.Errorf("StreamReader.Read expected %v bytes, actual %v", len(body), len(read))
+			}
+		}(r)
+	}
+
+	if _, err := b.Fill(bytes.NewReader(body)); err != nil {
+		t.Fatalf("StreamBuffer.Fill expected nil error, actual %v", err)
+	}
+	wg.Wait()
+
+	if got, ok := b.Bytes(); !ok || !bytes.Equal(got, body) {
+		t.Errorf("StreamBuffer.Bytes expected complete body, actual ok %v len %v", ok, len(got))
+	}
+
+	// readers created after the body is complete still read it from the beginning
+	r, ok := b.NewReader()
+	if !ok {
+		t.Fatalf("StreamBuffer.NewReader after Fill expected ok, actual false")
+	}
+	if read, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(read, body) {
+		t.Errorf("StreamReader.Read after Fill expected %v bytes, actual %v err %v", len(body), len(read), err)
+	}
+}
+
+func TestStreamBufferNotRetaining(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), StreamWindowBytes)
+	b := NewStreamBuffer(100, -1)
+
+	r, ok := b.NewReader()
+	if !ok {
+		t.Fatalf("StreamBuffer.NewReader expected ok, actual false")
+	}
+	done := make(chan []byte)
+	go func() {
+		defer r.Close()
+		read, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("StreamReader.Read expected nil error, actual %v", err)
+		}
+		done <- read
+	}()
+
+	if _, err := b.Fill(bytes.NewReader(body)); err != nil {
+		t.Fatalf("StreamBuffer.Fill expected nil error, actual %v", err)
+	}
+	if read := <-done; !bytes.Equal(read, body) {
+		t.Errorf("StreamReader.Read expected %v bytes, actual %v", len(body), len(read))
+	}
+	if b.Retaining() {
+		t.Errorf("StreamBuffer.Retaining expected false for body larger than max, actual true")
+	}
+	if _, ok := b.Bytes(); ok {
+		t.Errorf("StreamBuffer.Bytes expected false for body larger than max, actual true")
+	}
+	if _, ok := b.NewReader(); ok {
+		t.Errorf("StreamBuffer.NewReader expected false after bytes were discarded, actual true")
+	}
+}
+
+func TestStreamBufferContentLengthTooLarge(t *testing.T) {
+	b := NewStreamBuffer(100, 101)
+	if b.Retaining() {
+		t.Errorf("StreamBuffer.Retaining expected false for Content-Length larger than max, actual true")
+	}
+}
+
+func TestStreamBufferAbandoned(t *testing.T) {
+	b := NewStreamBuffer(1, -1)
+	r, _ := b.NewReader()
+	r.Close()
+
+	body := bytes.Repeat([]byte("0123456789"), StreamWindowBytes)
+	if _, err := b.Fill(bytes.NewReader(body)); err != ErrStreamAbandoned {
+		t.Errorf("StreamBuffer.Fill with all readers closed expected ErrStreamAbandoned, actual %v", err)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) { return 0, errors.New("parent failed") }
+
+func TestStreamBufferError(t *testing.T) {
+	b := NewStreamBuffer(0, -1)
+	r, _ := b.NewReader()
+	b.Fill(errReader{})
+	if _, err := ioutil.ReadAll(r); err == nil || err.Error() != "parent failed" {
+		t.Errorf("StreamReader.Read expected fill error, actual %v", err)
+	}
+	if _, ok := b.Bytes(); ok {
+		t.Errorf("StreamBuffer.Bytes expected false after error, actual true")
+	}
+}
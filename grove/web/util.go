@@ -16,6 +16,7 @@ package web
 
 import (
 	"errors"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -25,6 +26,9 @@ import (
 	"github.com/apache/incubator-trafficcontrol/lib/go-log"
 )
 
+// MethodPurge is the nonstandard HTTP method used to remove an object from the cache.
+const MethodPurge = "PURGE"
+
 type Hdr struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
@@ -130,6 +134,18 @@ func Request(transport *http.Transport, r *http.Request) (int, http.Header, []by
 	return resp.StatusCode, resp.Header, body, reqTime, respTime, nil
 }
 
+// RequestStream makes the given request and returns its response code, headers, body, the request time, response time, and any error. Unlike Request, the body is not read, and the caller must close it.
+func RequestStream(transport *http.Transport, r *http.Request) (int, http.Header, io.ReadCloser, time.Time, time.Time, error) {
+	log.Debugf("request streaming %v headers %v\n", r.RequestURI, r.Header)
+	reqTime := time.Now()
+	resp, err := transport.RoundTrip(r)
+	respTime := time.Now()
+	if err != nil {
+		return 0, nil, nil, reqTime, respTime, errors.New("request error: " + err.Error())
+	}
+	return resp.StatusCode, resp.Header, resp.Body, reqTime, respTime, nil
+}
+
 // Respond writes the given code, header, and body to the ResponseWriter. If connectionClose, a Connection: Close header is also written. Returns the bytes written, and any error.
 func Respond(w http.ResponseWriter, code int, header http.Header, body []byte, connectionClose bool) (uint64, error) {
 	// TODO move connectionClose to modhdr plugin
@@ -145,6 +161,41 @@ func Respond(w http.ResponseWriter, code int, header http.Header, body []byte, c
 	return uint64(bytesWritten), err
 }
 
+const respondStreamReadBytes = 32 * 1024
+
+// RespondStream writes the given code and header to the ResponseWriter, and then copies the body from the given reader, flushing after every read, so clients receive bytes as soon as the parent sends them. If connectionClose, a Connection: Close header is also written. Returns the bytes written, and any error.
+func RespondStream(w http.ResponseWriter, code int, header http.Header, body io.Reader, connectionClose bool) (uint64, error) {
+	dH := w.Header()
+	CopyHeaderTo(header, &dH)
+	if connectionClose {
+		dH.Add("Connection", "close")
+	}
+	w.WriteHeader(code)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, respondStreamReadBytes)
+	bytesWritten := uint64(0)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			written, err := w.Write(buf[:n])
+			bytesWritten += uint64(written)
+			if err != nil {
+				return bytesWritten, err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return bytesWritten, nil
+		}
+		if readErr != nil {
+			return bytesWritten, errors.New("reading body: " + readErr.Error())
+		}
+	}
+}
+
 // ServeReqErr writes the appropriate response to the client, via given writer, for a generic request error. Returns the code sent, the body bytes written, and any write error.
 func ServeReqErr(w http.ResponseWriter) (int, uint64, error) {
 	code := http.StatusBadRequest
@@ -159,19 +210,6 @@ func ServeErr(w http.ResponseWriter, code int) (uint64, error) {
 	return uint64(bytesWritten), err
 }
 
-// TryGetBytesWritten attempts to get the real bytes written to the given conn. It takes the bytesWritten as returned by Write(). It forcibly calls Flush() in order to force a write to the conn. Then, it attempts to get the more accurate bytes written to the Conn. If this fails, the given and less accurate bytesWritten is returned. If everything succeeds, the accurate bytes written to the Conn is returned.
-func TryGetBytesWritten(w http.ResponseWriter, conn *InterceptConn, bytesWritten uint64) uint64 {
-	if wFlusher, ok := w.(http.Flusher); !ok {
-		log.Errorln("ResponseWriter is not a Flusher, could not flush written bytes, stat out_bytes will be inaccurate!")
-	} else {
-		wFlusher.Flush()
-	}
-	if conn != nil {
-		return uint64(conn.BytesWritten()) // get the more accurate interceptConn bytes written, if we can
-	}
-	return bytesWritten
-}
-
 // GetHTTPDate is a helper function which gets an HTTP date from the given map (which is typically a `http.Header` or `CacheControl`. Returns false if the given key doesn't exist in the map, or if the value isn't a valid HTTP Date per RFC2616§3.3.
 func GetHTTPDate(headers http.Header, key string) (time.Time, bool) {
 	maybeDate := headers.Get(key)
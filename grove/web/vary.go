@@ -0,0 +1,123 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseVary returns the canonical names of the request headers listed in the given response headers' Vary, sorted and without duplicates, and whether the Vary contains `*`. A response which varies on `*` may never be reused, per RFC 7234§4.1.
+func ParseVary(respHdr http.Header) ([]string, bool) {
+	names := []string{}
+	seen := map[string]struct{}{}
+	for _, vary := range respHdr["Vary"] {
+		for _, name := range strings.Split(vary, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, true
+			}
+			name = http.CanonicalHeaderKey(name)
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, false
+}
+
+// NormalizeVaryValue returns the value of the given request header, normalized for selecting a response which varies on it, per RFC 7234§4.1. Multiple header fields are combined, and whitespace around list elements is removed. Accept-Encoding is collapsed to the single coding it selects, via NormalizeAcceptEncoding, so the many equivalent client values share one variant.
+func NormalizeVaryValue(reqHdr http.Header, name string) string {
+	vals := []string{}
+	for _, val := range reqHdr[http.CanonicalHeaderKey(name)] {
+		for _, elem := range strings.Split(val, ",") {
+			if elem = strings.TrimSpace(elem); elem != "" {
+				vals = append(vals, elem)
+			}
+		}
+	}
+	val := strings.Join(vals, ",")
+	if http.CanonicalHeaderKey(name) == "Accept-Encoding" {
+		return NormalizeAcceptEncoding(val)
+	}
+	return val
+}
+
+// NormalizeAcceptEncoding returns the content coding a parent is expected to select for the given Accept-Encoding value: "br" if brotli is acceptable, else "gzip" if gzip is acceptable, else "identity". Codings with a quality of 0 are not acceptable, per RFC 7231§5.3.4.
+func NormalizeAcceptEncoding(acceptEncoding string) string {
+	br, gzip := false, false
+	for _, elem := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(elem, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if !qualityAcceptable(params[1:]) {
+			continue
+		}
+		switch coding {
+		case "br":
+			br = true
+		case "gzip", "x-gzip":
+			gzip = true
+		case "*":
+			br, gzip = true, true
+		}
+	}
+	if br {
+		return "br"
+	}
+	if gzip {
+		return "gzip"
+	}
+	return "identity"
+}
+
+// AcceptsEncoding returns whether the given Accept-Encoding value accepts the given content coding, either explicitly or via `*`, with a quality greater than 0, per RFC 7231§5.3.4.
+func AcceptsEncoding(acceptEncoding string, coding string) bool {
+	wildcard := false
+	for _, elem := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(elem, ";")
+		elemCoding := strings.ToLower(strings.TrimSpace(params[0]))
+		if elemCoding == "x-gzip" {
+			elemCoding = "gzip"
+		}
+		if elemCoding == strings.ToLower(coding) {
+			return qualityAcceptable(params[1:]) // an explicit coding overrides `*`
+		}
+		if elemCoding == "*" {
+			wildcard = qualityAcceptable(params[1:])
+		}
+	}
+	return wildcard
+}
+
+// qualityAcceptable returns whether the given Accept header element parameters have a quality greater than 0. Elements without a `q` parameter have a quality of 1.
+func qualityAcceptable(params []string) bool {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(strings.ToLower(param), "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+		return err == nil && q > 0
+	}
+	return true
+}
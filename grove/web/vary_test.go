@@ -0,0 +1,104 @@
+package web
+
+/*
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseVary(t *testing.T) {
+	tests := []struct {
+		hdr     http.Header
+		names   []string
+		varyAll bool
+	}{
+		{http.Header{}, []string{}, false},
+		{http.Header{"Vary": {"accept-encoding"}}, []string{"Accept-Encoding"}, false},
+		{http.Header{"Vary": {"User-Agent, Accept-Encoding", "accept-encoding,,"}}, []string{"Accept-Encoding", "User-Agent"}, false},
+		{http.Header{"Vary": {"Accept-Encoding", " * "}}, nil, true},
+	}
+	for _, test := range tests {
+		names, varyAll := ParseVary(test.hdr)
+		if !reflect.DeepEqual(names, test.names) || varyAll != test.varyAll {
+			t.Errorf("ParseVary(%v) expected %v %v, actual %v %v", test.hdr, test.names, test.varyAll, names, varyAll)
+		}
+	}
+}
+
+func TestNormalizeAcceptEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                       "identity",
+		"identity":               "identity",
+		"deflate":                "identity",
+		"gzip":                   "gzip",
+		"gzip, deflate":          "gzip",
+		"x-gzip":                 "gzip",
+		"GZIP;q=0.5, identity":   "gzip",
+		"gzip;q=0":               "identity",
+		"gzip;q=0.0, deflate":    "identity",
+		"gzip, deflate, br":      "br",
+		"br;q=0, gzip":           "gzip",
+		"*":                      "br",
+		"deflate;q=1.0 , br;q=1": "br",
+	}
+	for acceptEncoding, expected := range tests {
+		if actual := NormalizeAcceptEncoding(acceptEncoding); actual != expected {
+			t.Errorf("NormalizeAcceptEncoding(%q) expected %v, actual %v", acceptEncoding, expected, actual)
+		}
+	}
+}
+
+func TestNormalizeVaryValue(t *testing.T) {
+	reqHdr := http.Header{
+		"Accept-Language": {"en-US , fr", "de"},
+		"Accept-Encoding": {"gzip, deflate"},
+	}
+	if actual := NormalizeVaryValue(reqHdr, "accept-language"); actual != "en-US,fr,de" {
+		t.Errorf("NormalizeVaryValue Accept-Language expected 'en-US,fr,de', actual '%v'", actual)
+	}
+	if actual := NormalizeVaryValue(reqHdr, "Accept-Encoding"); actual != "gzip" {
+		t.Errorf("NormalizeVaryValue Accept-Encoding expected 'gzip', actual '%v'", actual)
+	}
+	if actual := NormalizeVaryValue(reqHdr, "User-Agent"); actual != "" {
+		t.Errorf("NormalizeVaryValue absent header expected '', actual '%v'", actual)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		coding         string
+		expected       bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"x-gzip", "gzip", true},
+		{"deflate, gzip;q=0.5", "gzip", true},
+		{"deflate", "gzip", false},
+		{"gzip;q=0", "gzip", false},
+		{"*", "gzip", true},
+		{"*;q=0", "gzip", false},
+		{"*, gzip;q=0", "gzip", false},
+		{"gzip, *;q=0", "gzip", true},
+	}
+	for _, test := range tests {
+		if actual := AcceptsEncoding(test.acceptEncoding, test.coding); actual != test.expected {
+			t.Errorf("AcceptsEncoding(%q, %q) expected %v, actual %v", test.acceptEncoding, test.coding, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package tc
+
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// SigningAlgorithmURLSig is the Delivery Service signingAlgorithm of ATS url_sig signed URLs.
+const SigningAlgorithmURLSig = "url_sig"
+
+// SigningAlgorithmURISigning is the Delivery Service signingAlgorithm of IETF URI Signing tokens.
+const SigningAlgorithmURISigning = "uri_signing"
+
+// DeliveryServiceURLSigKeysResponse ...
+type DeliveryServiceURLSigKeysResponse struct {
+	Response DeliveryServiceURLSigKeys `json:"response"`
+}
+
+// DeliveryServiceURLSigKeys are the url_sig keys of a Delivery Service, by name, key0 through key15.
+type DeliveryServiceURLSigKeys map[string]string
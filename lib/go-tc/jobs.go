@@ -0,0 +1,36 @@
+package tc
+
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// JobKeywordPurge is the keyword of invalidation jobs, which invalidate the content matching their asset URL regex.
+const JobKeywordPurge = "PURGE"
+
+// JobsResponse ...
+type JobsResponse struct {
+	Response []Job `json:"response"`
+}
+
+// Job is a Traffic Ops job, such as a content invalidation.
+type Job struct {
+	ID              int    `json:"id"`
+	AssetURL        string `json:"assetUrl"`
+	DeliveryService string `json:"deliveryService"`
+	Keyword         string `json:"keyword"`
+	// Parameters are the job parameters, for example `TTL:48h` for an invalidation.
+	Parameters string `json:"parameters"`
+	StartTime  string `json:"startTime"`
+	CreatedBy  string `json:"createdBy"`
+}
@@ -0,0 +1,81 @@
+package v13
+
+import tc "github.com/apache/incubator-trafficcontrol/lib/go-tc"
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// CacheConfigResponse ...
+type CacheConfigResponse struct {
+	Response CacheConfig `json:"response"`
+}
+
+// CacheConfig is the data needed to generate a single cache server's remap config, so config generators don't need every server, delivery service, and parameter in the CDN.
+type CacheConfig struct {
+	Server    CacheConfigServer `json:"server"`
+	CDNDomain string            `json:"cdnDomain"`
+	// Parameters are the parameters of the server's profile.
+	Parameters []CacheConfigParameter `json:"parameters"`
+	// Parents are the servers in the CDN in the server's parent cachegroup, of any status.
+	Parents []CacheConfigServer `json:"parents"`
+	// Siblings are the servers in the CDN in the server's cachegroup with the server's type, of any status, including the server itself.
+	Siblings         []CacheConfigServer          `json:"siblings"`
+	DeliveryServices []CacheConfigDeliveryService `json:"deliveryServices"`
+	// Jobs are the jobs of the server's delivery services, such as content invalidations.
+	Jobs []tc.Job `json:"jobs"`
+}
+
+// CacheConfigServer ...
+type CacheConfigServer struct {
+	ID         int    `json:"id"`
+	HostName   string `json:"hostName"`
+	DomainName string `json:"domainName"`
+	TCPPort    int    `json:"tcpPort"`
+	IPAddress  string `json:"ipAddress"`
+	IP6Address string `json:"ip6Address"`
+	Status     string `json:"status"`
+	Cachegroup string `json:"cachegroup"`
+	Type       string `json:"type"`
+	Profile    string `json:"profile"`
+	CDNName    string `json:"cdnName"`
+}
+
+// CacheConfigParameter ...
+type CacheConfigParameter struct {
+	Name       string `json:"name"`
+	ConfigFile string `json:"configFile"`
+	Value      string `json:"value"`
+}
+
+// CacheConfigDeliveryService ...
+type CacheConfigDeliveryService struct {
+	ID                int                       `json:"id"`
+	XMLID             string                    `json:"xmlId"`
+	Type              string                    `json:"type"`
+	CDNName           string                    `json:"cdnName"`
+	CDNDomain         string                    `json:"cdnDomain"`
+	Protocol          int                       `json:"protocol"`
+	QStringIgnore     int                       `json:"qstringIgnore"`
+	OrgServerFQDN     string                    `json:"orgServerFqdn"`
+	DSCP              int                       `json:"dscp"`
+	EdgeHeaderRewrite string                    `json:"edgeHeaderRewrite"`
+	RemapText         string                    `json:"remapText"`
+	SigningAlgorithm  string                    `json:"signingAlgorithm"`
+	Regexes           []tc.DeliveryServiceRegex `json:"regexes"`
+}
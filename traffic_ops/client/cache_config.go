@@ -0,0 +1,36 @@
+/*
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+   http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"net/url"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc/v13"
+)
+
+func cacheConfigEp(hostName string) string {
+	return "/api/1.3/servers/" + url.PathEscape(hostName) + "/configs/cache"
+}
+
+// GetCacheConfig gets the data needed to generate the given cache server's config. Requires Traffic Ops 1.3.
+func (to *Session) GetCacheConfig(hostName string) (v13.CacheConfig, ReqInf, error) {
+	var data v13.CacheConfigResponse
+	reqInf, err := get(to, cacheConfigEp(hostName), &data)
+	if err != nil {
+		return v13.CacheConfig{}, reqInf, err
+	}
+	return data.Response, reqInf, nil
+}
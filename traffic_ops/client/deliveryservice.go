@@ -249,3 +249,25 @@ func (to *Session) GetDeliveryServiceSSLKeysByHostname(hostname string) (*tc.Del
 
 	return &data.Response, reqInf, nil
 }
+
+// GetDeliveryServiceURLSigKeys gets the url_sig keys of the Delivery Service with the given XMLID.
+func (to *Session) GetDeliveryServiceURLSigKeys(xmlID string) (tc.DeliveryServiceURLSigKeys, ReqInf, error) {
+	var data tc.DeliveryServiceURLSigKeysResponse
+	reqInf, err := get(to, deliveryServiceURLSigKeysEp(xmlID), &data)
+	if err != nil {
+		return nil, reqInf, err
+	}
+
+	return data.Response, reqInf, nil
+}
+
+// GetDeliveryServiceURISigningKeys gets the URI Signing keys of the Delivery Service with the given XMLID. The keys are returned as the raw JSON object of keysets by issuer, as stored in Traffic Ops, because their contents are JSON Web Keys.
+func (to *Session) GetDeliveryServiceURISigningKeys(xmlID string) (json.RawMessage, ReqInf, error) {
+	var data json.RawMessage
+	reqInf, err := get(to, deliveryServiceURISigningKeysEp(xmlID), &data)
+	if err != nil {
+		return nil, reqInf, err
+	}
+
+	return data, reqInf, nil
+}
@@ -64,3 +64,12 @@ func deliveryServiceSSLKeysByIDEp(id string) string {
 func deliveryServiceSSLKeysByHostnameEp(hostname string) string {
 	return apiBase + dsPath + "/hostname/" + hostname + "/sslkeys.json"
 }
+
+func deliveryServiceURLSigKeysEp(xmlID string) string {
+	return apiBase + dsPath + "/xmlId/" + xmlID + "/urlkeys.json"
+}
+
+// deliveryServiceURISigningKeysEp is only in API 1.3.
+func deliveryServiceURISigningKeysEp(xmlID string) string {
+	return "/api/1.3" + dsPath + "/" + xmlID + "/urisignkeys"
+}
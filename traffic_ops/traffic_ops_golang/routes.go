@@ -229,6 +229,7 @@ func Routes(d ServerData) ([]Route, []RawRoute, http.Handler, error) {
 		//Servers
 		{1.3, http.MethodPost, `servers/{id}/deliveryservices$`, server.AssignDeliveryServicesToServerHandler(d.DB), auth.PrivLevelOperations, Authenticated, nil},
 		{1.3, http.MethodGet, `servers/{host_name}/update_status$`, server.GetServerUpdateStatusHandler(d.DB), auth.PrivLevelReadOnly, Authenticated, nil},
+		{1.3, http.MethodGet, `servers/{host_name}/configs/cache$`, server.GetCacheConfigHandler(d.DB), auth.PrivLevelReadOnly, Authenticated, nil},
 
 		//IPs
 		{1.3, http.MethodGet, `ips/?(\.json)?$`, api.ReadHandler(ip.GetRefType(), d.DB), auth.PrivLevelReadOnly, Authenticated, nil},
@@ -0,0 +1,273 @@
+package server
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-log"
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc"
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc/v13"
+	"github.com/apache/incubator-trafficcontrol/traffic_ops/traffic_ops_golang/api"
+	"github.com/apache/incubator-trafficcontrol/traffic_ops/traffic_ops_golang/auth"
+	"github.com/apache/incubator-trafficcontrol/traffic_ops/traffic_ops_golang/parameter"
+	"github.com/jmoiron/sqlx"
+)
+
+// GetCacheConfigHandler returns the data needed to generate the config of the cache server with the host_name path parameter. Unlike generating it from the servers, delivery services, and parameters endpoints, only the data of the server's cachegroups and delivery services is queried. As with the parameters endpoint, secure parameter values are hidden from non-admin users.
+func GetCacheConfigHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleErrs := tc.GetHandleErrorsFunc(w, r)
+		ctx := r.Context()
+
+		params, err := api.GetCombinedParams(r)
+		if err != nil {
+			log.Errorf("unable to get parameters from request: %s", err)
+			handleErrs(http.StatusInternalServerError, err)
+			return
+		}
+		hostName := params["host_name"]
+
+		user, err := auth.GetCurrentUser(ctx)
+		if err != nil {
+			log.Errorf("unable to retrieve current user from context: %s", err)
+			handleErrs(http.StatusInternalServerError, err)
+			return
+		}
+
+		cfg, ok, err := getCacheConfig(hostName, user.PrivLevel, db)
+		if err != nil {
+			log.Errorf("getting cache config for server '%s': %s\n", hostName, err)
+			handleErrs(http.StatusInternalServerError, tc.DBError)
+			return
+		}
+		if !ok {
+			handleErrs(http.StatusNotFound, errors.New("server not found"))
+			return
+		}
+
+		respBts, err := json.Marshal(v13.CacheConfigResponse{Response: cfg})
+		if err != nil {
+			handleErrs(http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set(tc.ContentType, tc.ApplicationJson)
+		fmt.Fprintf(w, "%s", respBts)
+	}
+}
+
+// getCacheConfig returns the cache config of the given server, whether the server exists, and any error. Secure parameter values are hidden if privLevel is less than admin.
+func getCacheConfig(hostName string, privLevel int, db *sqlx.DB) (v13.CacheConfig, bool, error) {
+	cfg := v13.CacheConfig{}
+	profileID := 0
+	cdnID := 0
+	cachegroupID := 0
+	typeID := 0
+	parentCachegroupID := sql.NullInt64{}
+	qry := `
+SELECT s.id, s.host_name, s.domain_name, s.tcp_port, COALESCE(s.ip_address, ''), COALESCE(s.ip6_address, ''), st.name, cg.name, t.name, p.name, cdn.name, cdn.domain_name, s.profile, s.cdn_id, s.cachegroup, s.type, cg.parent_cachegroup_id
+FROM server AS s
+JOIN status AS st ON st.id = s.status
+JOIN cachegroup AS cg ON cg.id = s.cachegroup
+JOIN type AS t ON t.id = s.type
+JOIN profile AS p ON p.id = s.profile
+JOIN cdn ON cdn.id = s.cdn_id
+WHERE s.host_name = $1
+`
+	srv := &cfg.Server
+	if err := db.QueryRow(qry, hostName).Scan(&srv.ID, &srv.HostName, &srv.DomainName, &srv.TCPPort, &srv.IPAddress, &srv.IP6Address, &srv.Status, &srv.Cachegroup, &srv.Type, &srv.Profile, &srv.CDNName, &cfg.CDNDomain, &profileID, &cdnID, &cachegroupID, &typeID, &parentCachegroupID); err != nil {
+		if err == sql.ErrNoRows {
+			return v13.CacheConfig{}, false, nil
+		}
+		return v13.CacheConfig{}, false, errors.New("querying server: " + err.Error())
+	}
+
+	err := error(nil)
+	if cfg.Parameters, err = getCacheConfigParameters(profileID, privLevel, db); err != nil {
+		return v13.CacheConfig{}, false, errors.New("querying parameters: " + err.Error())
+	}
+
+	cfg.Parents = []v13.CacheConfigServer{}
+	if parentCachegroupID.Valid {
+		if cfg.Parents, err = getCacheConfigServers(db, `s.cdn_id = $1 AND s.cachegroup = $2`, cdnID, parentCachegroupID.Int64); err != nil {
+			return v13.CacheConfig{}, false, errors.New("querying parents: " + err.Error())
+		}
+	}
+	if cfg.Siblings, err = getCacheConfigServers(db, `s.cdn_id = $1 AND s.cachegroup = $2 AND s.type = $3`, cdnID, cachegroupID, typeID); err != nil {
+		return v13.CacheConfig{}, false, errors.New("querying siblings: " + err.Error())
+	}
+	if cfg.DeliveryServices, err = getCacheConfigDeliveryServices(srv.ID, db); err != nil {
+		return v13.CacheConfig{}, false, errors.New("querying delivery services: " + err.Error())
+	}
+	if cfg.Jobs, err = getCacheConfigJobs(srv.ID, db); err != nil {
+		return v13.CacheConfig{}, false, errors.New("querying jobs: " + err.Error())
+	}
+	return cfg, true, nil
+}
+
+func getCacheConfigParameters(profileID int, privLevel int, db *sqlx.DB) ([]v13.CacheConfigParameter, error) {
+	qry := `
+SELECT p.name, p.config_file, p.value, p.secure
+FROM parameter AS p
+JOIN profile_parameter AS pp ON pp.parameter = p.id
+WHERE pp.profile = $1
+`
+	rows, err := db.Query(qry, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	params := []v13.CacheConfigParameter{}
+	for rows.Next() {
+		param := v13.CacheConfigParameter{}
+		isSecure := false
+		if err := rows.Scan(&param.Name, &param.ConfigFile, &param.Value, &isSecure); err != nil {
+			return nil, err
+		}
+		if isSecure && (privLevel < auth.PrivLevelAdmin) {
+			param.Value = parameter.HiddenField
+		}
+		params = append(params, param)
+	}
+	return params, rows.Err()
+}
+
+// getCacheConfigServers returns the servers matching the given where clause, whose args start at $1.
+func getCacheConfigServers(db *sqlx.DB, where string, args ...interface{}) ([]v13.CacheConfigServer, error) {
+	qry := `
+SELECT s.id, s.host_name, s.domain_name, s.tcp_port, COALESCE(s.ip_address, ''), COALESCE(s.ip6_address, ''), st.name, cg.name, t.name, p.name, cdn.name
+FROM server AS s
+JOIN status AS st ON st.id = s.status
+JOIN cachegroup AS cg ON cg.id = s.cachegroup
+JOIN type AS t ON t.id = s.type
+JOIN profile AS p ON p.id = s.profile
+JOIN cdn ON cdn.id = s.cdn_id
+WHERE ` + where + `
+ORDER BY s.host_name
+`
+	rows, err := db.Query(qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := []v13.CacheConfigServer{}
+	for rows.Next() {
+		s := v13.CacheConfigServer{}
+		if err := rows.Scan(&s.ID, &s.HostName, &s.DomainName, &s.TCPPort, &s.IPAddress, &s.IP6Address, &s.Status, &s.Cachegroup, &s.Type, &s.Profile, &s.CDNName); err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	return servers, rows.Err()
+}
+
+func getCacheConfigDeliveryServices(serverID int, db *sqlx.DB) ([]v13.CacheConfigDeliveryService, error) {
+	qry := `
+SELECT ds.id, ds.xml_id, t.name, cdn.name, cdn.domain_name, COALESCE(ds.protocol, 0), COALESCE(ds.qstring_ignore, 0), COALESCE(ds.org_server_fqdn, ''), ds.dscp, COALESCE(ds.edge_header_rewrite, ''), COALESCE(ds.remap_text, ''), COALESCE(ds.signing_algorithm, '')
+FROM deliveryservice AS ds
+JOIN deliveryservice_server AS dss ON dss.deliveryservice = ds.id
+JOIN type AS t ON t.id = ds.type
+JOIN cdn ON cdn.id = ds.cdn_id
+WHERE dss.server = $1
+ORDER BY ds.xml_id
+`
+	rows, err := db.Query(qry, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dses := []v13.CacheConfigDeliveryService{}
+	dsIdx := map[int]int{}
+	for rows.Next() {
+		ds := v13.CacheConfigDeliveryService{Regexes: []tc.DeliveryServiceRegex{}}
+		if err := rows.Scan(&ds.ID, &ds.XMLID, &ds.Type, &ds.CDNName, &ds.CDNDomain, &ds.Protocol, &ds.QStringIgnore, &ds.OrgServerFQDN, &ds.DSCP, &ds.EdgeHeaderRewrite, &ds.RemapText, &ds.SigningAlgorithm); err != nil {
+			return nil, err
+		}
+		dsIdx[ds.ID] = len(dses)
+		dses = append(dses, ds)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	regexQry := `
+SELECT dsr.deliveryservice, t.name, COALESCE(dsr.set_number, 0), r.pattern
+FROM deliveryservice_regex AS dsr
+JOIN regex AS r ON r.id = dsr.regex
+JOIN type AS t ON t.id = r.type
+JOIN deliveryservice_server AS dss ON dss.deliveryservice = dsr.deliveryservice
+WHERE dss.server = $1
+ORDER BY dsr.set_number
+`
+	regexRows, err := db.Query(regexQry, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer regexRows.Close()
+
+	for regexRows.Next() {
+		dsID := 0
+		regex := tc.DeliveryServiceRegex{}
+		if err := regexRows.Scan(&dsID, &regex.Type, &regex.SetNumber, &regex.Pattern); err != nil {
+			return nil, err
+		}
+		if i, ok := dsIdx[dsID]; ok {
+			dses[i].Regexes = append(dses[i].Regexes, regex)
+		}
+	}
+	return dses, regexRows.Err()
+}
+
+func getCacheConfigJobs(serverID int, db *sqlx.DB) ([]tc.Job, error) {
+	qry := `
+SELECT j.id, j.asset_url, ds.xml_id, j.keyword, COALESCE(j.parameters, ''), j.start_time
+FROM job AS j
+JOIN deliveryservice AS ds ON ds.id = j.job_deliveryservice
+JOIN deliveryservice_server AS dss ON dss.deliveryservice = ds.id
+WHERE dss.server = $1
+ORDER BY j.start_time DESC
+`
+	rows, err := db.Query(qry, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []tc.Job{}
+	for rows.Next() {
+		job := tc.Job{}
+		startTime := time.Time{}
+		if err := rows.Scan(&job.ID, &job.AssetURL, &job.DeliveryService, &job.Keyword, &job.Parameters, &startTime); err != nil {
+			return nil, err
+		}
+		job.StartTime = startTime.Format(time.RFC3339Nano)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,161 @@
+package server
+
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc"
+	"github.com/apache/incubator-trafficcontrol/lib/go-tc/v13"
+	"github.com/apache/incubator-trafficcontrol/traffic_ops/traffic_ops_golang/auth"
+	"github.com/apache/incubator-trafficcontrol/traffic_ops/traffic_ops_golang/parameter"
+	"github.com/jmoiron/sqlx"
+	sqlmock "gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+func TestGetCacheConfig(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	defer db.Close()
+
+	serverCols := []string{"id", "host_name", "domain_name", "tcp_port", "ip_address", "ip6_address", "status", "cachegroup", "type", "profile", "cdn"}
+
+	serverRows := sqlmock.NewRows(append(serverCols, "cdn_domain", "profile_id", "cdn_id", "cachegroup_id", "type_id", "parent_cachegroup_id"))
+	serverRows.AddRow(1, "edge0", "example.net", 80, "192.0.2.1", "", "REPORTED", "edge-cg", "EDGE", "EDGE_GROVE", "cdn0", "cdn.example.net", 10, 20, 30, 40, 31)
+	mock.ExpectQuery("SELECT").WithArgs("edge0").WillReturnRows(serverRows)
+
+	paramRows := sqlmock.NewRows([]string{"name", "config_file", "value", "secure"})
+	paramRows.AddRow("allow_ip", "astats.config", "127.0.0.1", false)
+	paramRows.AddRow("api_key", "grove.config", "secret", true)
+	mock.ExpectQuery("SELECT").WithArgs(10).WillReturnRows(paramRows)
+
+	parentRows := sqlmock.NewRows(serverCols)
+	parentRows.AddRow(2, "mid0", "example.net", 80, "192.0.2.2", "", "REPORTED", "mid-cg", "MID", "MID_GROVE", "cdn0")
+	mock.ExpectQuery("SELECT").WithArgs(20, 31).WillReturnRows(parentRows)
+
+	siblingRows := sqlmock.NewRows(serverCols)
+	siblingRows.AddRow(1, "edge0", "example.net", 80, "192.0.2.1", "", "REPORTED", "edge-cg", "EDGE", "EDGE_GROVE", "cdn0")
+	mock.ExpectQuery("SELECT").WithArgs(20, 30, 40).WillReturnRows(siblingRows)
+
+	dsRows := sqlmock.NewRows([]string{"id", "xml_id", "type", "cdn", "cdn_domain", "protocol", "qstring_ignore", "org_server_fqdn", "dscp", "edge_header_rewrite", "remap_text", "signing_algorithm"})
+	dsRows.AddRow(5, "ds0", "HTTP", "cdn0", "cdn.example.net", 0, 1, "http://origin.example.net", 0, "", "", "")
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(dsRows)
+
+	regexRows := sqlmock.NewRows([]string{"deliveryservice", "type", "set_number", "pattern"})
+	regexRows.AddRow(5, "HOST_REGEXP", 0, `.*\.ds0\..*`)
+	regexRows.AddRow(6, "HOST_REGEXP", 0, `.*\.unassigned\..*`)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(regexRows)
+
+	startTime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	jobRows := sqlmock.NewRows([]string{"id", "asset_url", "xml_id", "keyword", "parameters", "start_time"})
+	jobRows.AddRow(7, "http://origin.example.net/foo", "ds0", "PURGE", "TTL:48h", startTime)
+	mock.ExpectQuery("SELECT").WithArgs(1).WillReturnRows(jobRows)
+
+	cfg, ok, err := getCacheConfig("edge0", auth.PrivLevelAdmin, db)
+	if err != nil {
+		t.Fatalf("getCacheConfig: %v", err)
+	}
+	if !ok {
+		t.Fatalf("getCacheConfig expected server to exist, actual not found")
+	}
+
+	edge := v13.CacheConfigServer{ID: 1, HostName: "edge0", DomainName: "example.net", TCPPort: 80, IPAddress: "192.0.2.1", Status: "REPORTED", Cachegroup: "edge-cg", Type: "EDGE", Profile: "EDGE_GROVE", CDNName: "cdn0"}
+	expected := v13.CacheConfig{
+		Server:     edge,
+		CDNDomain:  "cdn.example.net",
+		Parameters: []v13.CacheConfigParameter{{Name: "allow_ip", ConfigFile: "astats.config", Value: "127.0.0.1"}, {Name: "api_key", ConfigFile: "grove.config", Value: "secret"}},
+		Parents:    []v13.CacheConfigServer{{ID: 2, HostName: "mid0", DomainName: "example.net", TCPPort: 80, IPAddress: "192.0.2.2", Status: "REPORTED", Cachegroup: "mid-cg", Type: "MID", Profile: "MID_GROVE", CDNName: "cdn0"}},
+		Siblings:   []v13.CacheConfigServer{edge},
+		DeliveryServices: []v13.CacheConfigDeliveryService{{
+			ID:            5,
+			XMLID:         "ds0",
+			Type:          "HTTP",
+			CDNName:       "cdn0",
+			CDNDomain:     "cdn.example.net",
+			QStringIgnore: 1,
+			OrgServerFQDN: "http://origin.example.net",
+			Regexes:       []tc.DeliveryServiceRegex{{Type: "HOST_REGEXP", Pattern: `.*\.ds0\..*`}},
+		}},
+		Jobs: []tc.Job{{ID: 7, AssetURL: "http://origin.example.net/foo", DeliveryService: "ds0", Keyword: "PURGE", Parameters: "TTL:48h", StartTime: startTime.Format(time.RFC3339Nano)}},
+	}
+	if !reflect.DeepEqual(expected, cfg) {
+		t.Errorf("getCacheConfig expected %+v, actual %+v", expected, cfg)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected queries not run: %v", err)
+	}
+}
+
+func TestGetCacheConfigNotFound(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT").WithArgs("nonexistent").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if _, ok, err := getCacheConfig("nonexistent", auth.PrivLevelAdmin, db); err != nil {
+		t.Errorf("getCacheConfig: %v", err)
+	} else if ok {
+		t.Errorf("getCacheConfig expected nonexistent server not found, actual found")
+	}
+}
+
+func TestGetCacheConfigParametersSecure(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+
+	db := sqlx.NewDb(mockDB, "sqlmock")
+	defer db.Close()
+
+	paramRows := sqlmock.NewRows([]string{"name", "config_file", "value", "secure"})
+	paramRows.AddRow("allow_ip", "astats.config", "127.0.0.1", false)
+	paramRows.AddRow("api_key", "grove.config", "secret", true)
+	mock.ExpectQuery("SELECT").WithArgs(10).WillReturnRows(paramRows)
+
+	params, err := getCacheConfigParameters(10, auth.PrivLevelReadOnly, db)
+	if err != nil {
+		t.Fatalf("getCacheConfigParameters: %v", err)
+	}
+	expected := []v13.CacheConfigParameter{{Name: "allow_ip", ConfigFile: "astats.config", Value: "127.0.0.1"}, {Name: "api_key", ConfigFile: "grove.config", Value: parameter.HiddenField}}
+	if !reflect.DeepEqual(expected, params) {
+		t.Errorf("getCacheConfigParameters for read-only user expected %+v, actual %+v", expected, params)
+	}
+	if params[1].Value != "********" {
+		t.Errorf("getCacheConfigParameters for read-only user expected secure value '********', actual '%v'", params[1].Value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected queries not run: %v", err)
+	}
+}